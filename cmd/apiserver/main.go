@@ -1,14 +1,21 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	bolt "go.etcd.io/bbolt"
 
+	"miniku/pkg/admission"
 	"miniku/pkg/api"
+	"miniku/pkg/auth"
+	"miniku/pkg/pki"
 	"miniku/pkg/store"
 	"miniku/pkg/types"
 )
@@ -16,8 +23,40 @@ import (
 func main() {
 	port := flag.Int("port", 8080, "port to listen on")
 	dbPath := flag.String("db", "miniku.db", "path to BoltDB file")
+	serviceCIDR := flag.String("service-cidr", api.DefaultServiceCIDR, "range service cluster IPs are allocated from")
+	allowedImages := flag.String("allowed-images", "", "comma separated glob patterns of the images pods may run, e.g. registry.example.com/*, empty allows any")
+	defaultLabels := flag.String("default-labels", "", "comma separated key=value labels given to pods that don't set them")
+	admissionWebhooks := flag.String("admission-webhooks", "", "path to a JSON list of admission webhooks to call on every write")
+	tokenAuthFile := flag.String("token-auth-file", "", "path to a CSV file of bearer tokens: token,user,uid[,\"group1,group2\"]")
+	authorizationMode := flag.String("authorization-mode", "AlwaysAllow", "how requests are authorized: AlwaysAllow or RBAC")
+	certDir := flag.String("cert-dir", "", "directory of the cluster CA (ca.crt, ca.key, created if missing) and the API server's key pairs; when set it serves HTTPS, accepts client certificates the CA signed and signs node certificate requests")
+	tlsSANs := flag.String("tls-san", "localhost,127.0.0.1", "comma separated DNS names and IPs of the API server's serving certificate, only used when it is created")
+	joinTokens := flag.String("join-tokens", "", "comma separated tokens, e.g. abcdef.0123456789abcdef, new nodes may join with to get their certificate")
 	flag.Parse()
 
+	var chain admission.Chain
+	if *defaultLabels != "" {
+		labels := make(map[string]string)
+		for _, kv := range strings.Split(*defaultLabels, ",") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				log.Fatalf("--default-labels: %q is not key=value", kv)
+			}
+			labels[k] = v
+		}
+		chain = append(chain, admission.NewDefaultLabels(labels))
+	}
+	if *allowedImages != "" {
+		chain = append(chain, admission.NewImageAllowlist(strings.Split(*allowedImages, ",")))
+	}
+	if *admissionWebhooks != "" {
+		webhooks, err := admission.LoadWebhooks(*admissionWebhooks)
+		if err != nil {
+			log.Fatalf("failed to load admission webhooks: %v", err)
+		}
+		chain = append(chain, webhooks...)
+	}
+
 	db, err := bolt.Open(*dbPath, 0600, nil)
 	if err != nil {
 		log.Fatalf("failed to open database: %v", err)
@@ -31,16 +70,83 @@ func main() {
 	podStore := store.NewBoltStore[types.Pod](db, "pods")
 	rsStore := store.NewBoltStore[types.ReplicaSet](db, "replicasets")
 	nodeStore := store.NewBoltStore[types.Node](db, "nodes")
+	deploymentStore := store.NewBoltStore[types.Deployment](db, "deployments")
+	serviceStore := store.NewBoltStore[types.Service](db, "services")
+	endpointsStore := store.NewBoltStore[types.Endpoints](db, "endpoints")
+	namespaceStore := store.NewBoltStore[types.Namespace](db, "namespaces")
+	roleStore := store.NewBoltStore[types.Role](db, "roles")
+	roleBindingStore := store.NewBoltStore[types.RoleBinding](db, "rolebindings")
 
 	srv := &api.Server{
 		PodStore:  podStore,
 		RSStore:   rsStore,
 		NodeStore: nodeStore,
+
+		DeploymentStore: deploymentStore,
+		ServiceStore:    serviceStore,
+		EndpointsStore:  endpointsStore,
+		NamespaceStore:  namespaceStore,
+
+		RoleStore:        roleStore,
+		RoleBindingStore: roleBindingStore,
+
+		ServiceCIDR: *serviceCIDR,
+		Admission:   chain,
+	}
+
+	authenticators := auth.Union{auth.ClientCert{}}
+	if *tokenAuthFile != "" {
+		tokens, err := auth.LoadTokenFile(*tokenAuthFile)
+		if err != nil {
+			log.Fatalf("failed to load token file: %v", err)
+		}
+		authenticators = append(authenticators, tokens)
+	}
+	if *joinTokens != "" {
+		tokens, err := auth.NewJoinTokens(strings.Split(*joinTokens, ","))
+		if err != nil {
+			log.Fatalf("--join-tokens: %v", err)
+		}
+		authenticators = append(authenticators, tokens)
+	}
+	srv.Authenticator = authenticators
+	switch *authorizationMode {
+	case "AlwaysAllow":
+	case "RBAC":
+		srv.Authorizer = &auth.RBAC{Roles: roleStore, Bindings: roleBindingStore}
+	default:
+		log.Fatalf("--authorization-mode: unknown mode %q", *authorizationMode)
 	}
 
-	addr := fmt.Sprintf(":%d", *port)
-	log.Printf("apiserver: listening on %s", addr)
-	if err := http.ListenAndServe(addr, srv.Routes()); err != nil {
+	server := &http.Server{Addr: fmt.Sprintf(":%d", *port)}
+	if *certDir != "" {
+		ca, err := pki.LoadOrCreateCA(*certDir)
+		if err != nil {
+			log.Fatalf("failed to load CA: %v", err)
+		}
+		serving, err := ca.LoadOrIssue(*certDir, "apiserver", pkix.Name{CommonName: "miniku-apiserver"}, strings.Split(*tlsSANs, ","), x509.ExtKeyUsageServerAuth)
+		if err != nil {
+			log.Fatalf("failed to load serving certificate: %v", err)
+		}
+		// logs and exec go to the kubelets as an admin
+		kubeletClient, err := ca.LoadOrIssue(*certDir, "apiserver-kubelet-client", pkix.Name{CommonName: auth.UserAPIServer, Organization: []string{auth.GroupMasters}}, nil, x509.ExtKeyUsageClientAuth)
+		if err != nil {
+			log.Fatalf("failed to load kubelet client certificate: %v", err)
+		}
+		srv.CA = ca
+		srv.ServingHosts = pki.Hosts(serving.Leaf)
+		srv.KubeletTLS = &tls.Config{RootCAs: ca.Pool(), Certificates: []tls.Certificate{kubeletClient}, MinVersion: tls.VersionTLS12}
+		server.TLSConfig = pki.ServerTLS(serving, ca.Pool())
+	}
+	server.Handler = srv.Routes()
+
+	log.Printf("apiserver: listening on %s", server.Addr)
+	if server.TLSConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		log.Fatalf("server failed: %v", err)
 	}
 }
@@ -10,15 +10,39 @@ import (
 
 func main() {
 	apiServer := flag.String("api-server", "http://localhost:8080", "API server URL")
+	token := flag.String("token", "", "bearer token to authenticate to the API server with")
+	caFile := flag.String("ca-file", "", "CA bundle to verify an https API server with")
+	certFile := flag.String("cert-file", "", "client certificate to authenticate to the API server with")
+	keyFile := flag.String("key-file", "", "key of --cert-file")
+	podEvictionTimeout := flag.Duration("pod-eviction-timeout", controller.DefaultPodEvictionTimeout, "how long a node may be NotReady before its pods are evicted")
+	evictionRate := flag.Float64("node-eviction-rate", controller.DefaultEvictionRate, "pods evicted per second in each zone, 0 doesn't limit")
 	flag.Parse()
 
-	c := client.New(*apiServer)
+	tlsOpts, err := client.TLSFromFiles(*caFile, *certFile, *keyFile)
+	if err != nil {
+		log.Fatalf("failed to load TLS files: %v", err)
+	}
+	c := client.New(*apiServer, append(tlsOpts, client.WithToken(*token))...)
 
 	log.Printf("controller: connecting to API server at %s", *apiServer)
 
 	nodeCtrl := controller.NewNodeController(c)
+	nodeCtrl.PodEvictionTimeout = *podEvictionTimeout
+	nodeCtrl.EvictionRate = *evictionRate
 	go nodeCtrl.Run()
 
+	deploymentCtrl := controller.NewDeploymentController(c)
+	go deploymentCtrl.Run()
+
+	endpointsCtrl := controller.NewEndpointsController(c)
+	go endpointsCtrl.Run()
+
+	namespaceCtrl := controller.NewNamespaceController(c)
+	go namespaceCtrl.Run()
+
+	gc := controller.NewGarbageCollector(c)
+	go gc.Run()
+
 	rsCtrl := controller.New(c)
 	rsCtrl.Run()
 }
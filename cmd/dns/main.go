@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"miniku/pkg/client"
+	"miniku/pkg/dns"
+)
+
+func main() {
+	apiServer := flag.String("api-server", "http://localhost:8080", "API server URL")
+	token := flag.String("token", "", "bearer token to authenticate to the API server with")
+	caFile := flag.String("ca-file", "", "CA bundle to verify an https API server with")
+	certFile := flag.String("cert-file", "", "client certificate to authenticate to the API server with")
+	keyFile := flag.String("key-file", "", "key of --cert-file")
+	listen := flag.String("listen", ":53", "address to serve DNS on, pods reach it on their bridge's address")
+	domain := flag.String("cluster-domain", dns.DefaultDomain, "domain the cluster's names are under")
+	upstreams := flag.String("upstreams", "", "comma separated name servers for other names, defaults to the node's resolv.conf")
+	flag.Parse()
+
+	tlsOpts, err := client.TLSFromFiles(*caFile, *certFile, *keyFile)
+	if err != nil {
+		log.Fatalf("failed to load TLS files: %v", err)
+	}
+	c := client.New(*apiServer, append(tlsOpts, client.WithToken(*token))...)
+
+	log.Printf("dns: connecting to API server at %s", *apiServer)
+
+	s := dns.New(c)
+	s.Domain = *domain
+	if *upstreams != "" {
+		s.Upstreams = strings.Split(*upstreams, ",")
+	} else {
+		servers, err := dns.ReadResolvConf("/etc/resolv.conf")
+		if err != nil {
+			log.Printf("dns: no upstream name servers: %v", err)
+		}
+		s.Upstreams = servers
+	}
+	log.Fatal(s.Run(*listen))
+}
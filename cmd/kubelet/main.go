@@ -1,41 +1,132 @@
 package main
 
 import (
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
 
 	"miniku/pkg/client"
+	"miniku/pkg/dns"
 	"miniku/pkg/kubelet"
+	"miniku/pkg/pki"
 	"miniku/pkg/runtime"
 	"miniku/pkg/types"
 )
 
 func main() {
 	apiServer := flag.String("api-server", "http://localhost:8080", "API server URL")
+	token := flag.String("token", "", "bearer token to authenticate to the API server with")
+	caFile := flag.String("ca-file", "", "cluster CA bundle; when set the kubelet talks to an https API server with a client certificate from --cert-dir and serves https to clients the CA signed")
+	certDir := flag.String("cert-dir", "", "directory of the kubelet's key pairs, missing ones are requested from the API server's CA (default <root-dir>/pki)")
+	joinToken := flag.String("join-token", "", "token to get the node's first client certificate with")
 	name := flag.String("name", "", "node name (required)")
 	rootDir := flag.String("root-dir", "/var/lib/miniku", "runtime root directory")
+	maxPods := flag.Int64("max-pods", kubelet.DefaultMaxPods, "number of pods this node takes")
+	reservedCPU := flag.Int64("reserved-cpu", 0, "CPU in millicores held back from pods for the system")
+	reservedMemory := flag.Int64("reserved-memory", 0, "memory in bytes held back from pods for the system")
+	address := flag.String("address", "127.0.0.1:10250", "address to serve logs and exec on, advertised to the API server")
+	cgroupSlice := flag.String("cgroup-slice", runtime.DefaultCgroupSlice, "cgroup v2 slice for container cgroups, relative to /sys/fs/cgroup")
+	podCIDR := flag.String("pod-cidr", runtime.DefaultPodCIDR, "range pod IPs are allocated from, empty runs pods on the node's network")
+	clusterDNS := flag.String("cluster-dns", "", "comma separated name servers for containers, defaults to the node's DNS server on the pod bridge")
+	clusterDomain := flag.String("cluster-domain", dns.DefaultDomain, "domain containers search for services")
+	insecureRegistries := flag.String("insecure-registries", "", "comma separated registry hosts (host[:port]) to pull from over plain HTTP")
+	zone := flag.String("zone", "", "failure zone of the node, pods are evicted from lost nodes at a limited rate per zone")
+	insecure := flag.Bool("insecure", false, "without --ca-file, serve logs and exec over plain HTTP to anyone who can reach --address")
 	flag.Parse()
 
 	if *name == "" {
 		log.Fatal("--name is required")
 	}
+	// exec runs commands in any pod of the node, it isn't served to
+	// everyone by accident
+	if *caFile == "" && !*insecure {
+		log.Fatal("--ca-file is required to serve logs and exec to the API server only, --insecure serves them to anyone over plain HTTP")
+	}
 
-	c := client.New(*apiServer)
+	opts := []client.Option{client.WithToken(*token)}
+	var pool *x509.CertPool
+	certs := cmp.Or(*certDir, filepath.Join(*rootDir, "pki"))
+	if *caFile != "" {
+		var err error
+		if pool, err = pki.LoadCertPool(*caFile); err != nil {
+			log.Fatalf("failed to load CA bundle: %v", err)
+		}
 
-	// register node
-	if err := c.CreateNode(types.Node{
-		Name:   *name,
-		Status: types.NodeStateReady,
-	}); err != nil {
-		log.Fatalf("failed to register node: %v", err)
+		bootstrap := client.New(*apiServer, client.WithCABundle(pool), client.WithToken(*joinToken))
+		clientCert, err := kubelet.RequestCertificate(bootstrap, certs, kubelet.ClientCertName, *name, types.CertificateUsageClient, nil)
+		if err != nil {
+			log.Fatalf("failed to get client certificate: %v", err)
+		}
+		opts = append(opts, client.WithCABundle(pool), client.WithClientCert(clientCert))
 	}
-
-	log.Printf("kubelet %s: registered with API server at %s", *name, *apiServer)
+	c := client.New(*apiServer, opts...)
 
 	rt, err := runtime.NewNamespaceRuntime(*rootDir)
 	if err != nil {
 		log.Fatalf("failed to create runtime: %v", err)
 	}
+	rt.CgroupSlice = *cgroupSlice
+	rt.PodCIDR = *podCIDR
+	if *clusterDNS != "" {
+		rt.ClusterDNS = strings.Split(*clusterDNS, ",")
+	}
+	rt.ClusterDomain = *clusterDomain
+	if *insecureRegistries != "" {
+		rt.InsecureRegistries = strings.Split(*insecureRegistries, ",")
+	}
 	k := kubelet.New(c, rt, *name)
+	k.Capacity.Pods = *maxPods
+	k.Reserved = types.ResourceList{CPU: *reservedCPU, Memory: *reservedMemory}
+	k.Address = *address
+	k.PodCIDR = *podCIDR
+	if *zone != "" {
+		k.Labels = map[string]string{types.LabelZone: *zone}
+	}
+
+	// register node
+	if err := k.Register(); err != nil {
+		log.Fatalf("failed to register node: %v", err)
+	}
+
+	log.Printf("kubelet %s: registered with API server at %s", *name, *apiServer)
+
+	// the CA only signs a serving certificate for the address the node
+	// advertises, so it is asked for once the node registered
+	var servingTLS *tls.Config
+	if pool != nil {
+		host, _, err := net.SplitHostPort(*address)
+		if err != nil {
+			log.Fatalf("--address: %v", err)
+		}
+		hosts := []string{*name}
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+		serving, err := kubelet.RequestCertificate(c, certs, kubelet.ServingCertName, *name, types.CertificateUsageServer, hosts)
+		if err != nil {
+			log.Fatalf("failed to get serving certificate: %v", err)
+		}
+		servingTLS = pki.ServerTLS(serving, pool)
+		servingTLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	go func() {
+		server := &http.Server{Addr: *address, Handler: k.Handler(), TLSConfig: servingTLS}
+		var err error
+		if servingTLS != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil {
+			log.Fatalf("kubelet server failed: %v", err)
+		}
+	}()
 	k.Run()
 }
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
 	"log"
 	"net"
 	"net/http"
+	"strings"
 
 	bolt "go.etcd.io/bbolt"
 
 	"miniku/pkg/api"
+	"miniku/pkg/auth"
 	"miniku/pkg/client"
 	"miniku/pkg/controller"
+	"miniku/pkg/dns"
 	"miniku/pkg/kubelet"
+	"miniku/pkg/pki"
+	"miniku/pkg/proxy"
 	"miniku/pkg/runtime"
 	"miniku/pkg/scheduler"
 	"miniku/pkg/store"
@@ -18,6 +27,9 @@ import (
 )
 
 func main() {
+	certDir := flag.String("cert-dir", "", "run over mutual TLS with RBAC, every component authenticating with its own certificate from the CA in this directory, created if missing")
+	flag.Parse()
+
 	db, err := bolt.Open("miniku.db", 0600, nil)
 	if err != nil {
 		log.Fatalf("failed to open database: %v", err)
@@ -31,18 +43,73 @@ func main() {
 	podStore := store.NewBoltStore[types.Pod](db, "pods")
 	rsStore := store.NewBoltStore[types.ReplicaSet](db, "replicasets")
 	nodeStore := store.NewBoltStore[types.Node](db, "nodes")
+	deploymentStore := store.NewBoltStore[types.Deployment](db, "deployments")
+	serviceStore := store.NewBoltStore[types.Service](db, "services")
+	endpointsStore := store.NewBoltStore[types.Endpoints](db, "endpoints")
+	namespaceStore := store.NewBoltStore[types.Namespace](db, "namespaces")
+	roleStore := store.NewBoltStore[types.Role](db, "roles")
+	roleBindingStore := store.NewBoltStore[types.RoleBinding](db, "rolebindings")
 
 	// start API server on :8080 in a goroutine
 	srv := &api.Server{
 		PodStore:  podStore,
 		RSStore:   rsStore,
 		NodeStore: nodeStore,
+
+		DeploymentStore: deploymentStore,
+		ServiceStore:    serviceStore,
+		EndpointsStore:  endpointsStore,
+		NamespaceStore:  namespaceStore,
+
+		RoleStore:        roleStore,
+		RoleBindingStore: roleBindingStore,
 	}
 
+	// every component gets a client of its own, which with --cert-dir
+	// authenticates as the component
+	newClient := func(string, ...string) *client.Client { return client.New("http://localhost:8080") }
+	kubeletTLS := func(string) *tls.Config { return nil }
+
 	ln, err := net.Listen("tcp", ":8080")
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
+	if *certDir != "" {
+		ca, err := pki.LoadOrCreateCA(*certDir)
+		if err != nil {
+			log.Fatalf("failed to load CA: %v", err)
+		}
+		issue := func(name, user string, groups, hosts []string, usage x509.ExtKeyUsage) tls.Certificate {
+			cert, err := ca.LoadOrIssue(*certDir, name, pkix.Name{CommonName: user, Organization: groups}, hosts, usage)
+			if err != nil {
+				log.Fatalf("failed to issue certificate %s: %v", name, err)
+			}
+			return cert
+		}
+
+		srv.CA = ca
+		srv.Authenticator = auth.ClientCert{}
+		srv.Authorizer = &auth.RBAC{Roles: roleStore, Bindings: roleBindingStore}
+		srv.KubeletTLS = &tls.Config{
+			RootCAs:      ca.Pool(),
+			Certificates: []tls.Certificate{issue("apiserver-kubelet-client", auth.UserAPIServer, []string{auth.GroupMasters}, nil, x509.ExtKeyUsageClientAuth)},
+			MinVersion:   tls.VersionTLS12,
+		}
+		serving := issue("apiserver", "miniku-apiserver", nil, []string{"localhost", "127.0.0.1"}, x509.ExtKeyUsageServerAuth)
+		ln = tls.NewListener(ln, pki.ServerTLS(serving, ca.Pool()))
+		srv.ServingHosts = pki.Hosts(serving.Leaf)
+
+		newClient = func(user string, groups ...string) *client.Client {
+			cert := issue(strings.ReplaceAll(user, ":", "-"), user, groups, nil, x509.ExtKeyUsageClientAuth)
+			return client.New("https://localhost:8080", client.WithCABundle(ca.Pool()), client.WithClientCert(cert))
+		}
+		kubeletTLS = func(node string) *tls.Config {
+			cert := issue("kubelet-"+node, auth.NodeUserPrefix+node, []string{auth.GroupNodes}, []string{node, "127.0.0.1"}, x509.ExtKeyUsageServerAuth)
+			config := pki.ServerTLS(cert, ca.Pool())
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+			return config
+		}
+	}
 	go func() {
 		if err := http.Serve(ln, srv.Routes()); err != nil {
 			log.Fatalf("server failed: %v", err)
@@ -50,35 +117,87 @@ func main() {
 	}()
 	log.Println("apiserver: listening on :8080")
 
-	// create client pointing at localhost:8080
-	c := client.New("http://localhost:8080")
+	// reconcile pods -> containers
+	rt, err := runtime.NewNamespaceRuntime("/var/lib/miniku")
+	if err != nil {
+		log.Fatalf("failed to create runtime: %v", err)
+	}
+	rt.PodCIDR = runtime.DefaultPodCIDR
+	rt.ClusterDomain = dns.DefaultDomain
+	kubelet1 := kubelet.New(newClient(auth.NodeUserPrefix+"node-1", auth.GroupNodes), rt, "node-1")
+	kubelet2 := kubelet.New(newClient(auth.NodeUserPrefix+"node-2", auth.GroupNodes), rt, "node-2")
+	kubelet1.Address = "127.0.0.1:10250"
+	kubelet2.Address = "127.0.0.1:10251"
+	// the nodes share the runtime and with it the pod network
+	kubelet1.PodCIDR = rt.PodCIDR
+	kubelet2.PodCIDR = rt.PodCIDR
 
 	// register nodes via client
-	if err := c.CreateNode(types.Node{Name: "node-1", Status: types.NodeStateReady}); err != nil {
+	if err := kubelet1.Register(); err != nil {
 		log.Fatalf("failed to register node-1: %v", err)
 	}
-	if err := c.CreateNode(types.Node{Name: "node-2", Status: types.NodeStateReady}); err != nil {
+	if err := kubelet2.Register(); err != nil {
 		log.Fatalf("failed to register node-2: %v", err)
 	}
 
 	// assign pods to nodes
-	sched := scheduler.New(c)
+	sched := scheduler.New(newClient(auth.UserScheduler))
 	go sched.Run()
 
-	// reconcile pods -> containers
-	rt, err := runtime.NewNamespaceRuntime("/var/lib/miniku")
-	if err != nil {
-		log.Fatalf("failed to create runtime: %v", err)
+	for name, k := range map[string]*kubelet.Kubelet{"node-1": &kubelet1, "node-2": &kubelet2} {
+		go func() {
+			server := &http.Server{Addr: k.Address, Handler: k.Handler(), TLSConfig: kubeletTLS(name)}
+			var err error
+			if server.TLSConfig != nil {
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != nil {
+				log.Fatalf("kubelet server failed: %v", err)
+			}
+		}()
+		go k.Run()
 	}
-	kubelet1 := kubelet.New(c, rt, "node-1")
-	kubelet2 := kubelet.New(c, rt, "node-2")
-	go kubelet1.Run()
-	go kubelet2.Run()
+
+	// the controllers all run as the controller manager
+	c := newClient(auth.UserControllerManager)
 
 	// reconcile replicasets -> pods
 	rsController := controller.New(c)
 	go rsController.Run()
 
+	// roll deployments out through replicasets
+	deploymentController := controller.NewDeploymentController(c)
+	go deploymentController.Run()
+
+	// track the ready pods of services
+	endpointsController := controller.NewEndpointsController(c)
+	go endpointsController.Run()
+
+	// empty namespaces that are being deleted
+	namespaceController := controller.NewNamespaceController(c)
+	go namespaceController.Run()
+
+	// delete what lost its owners
+	garbageCollector := controller.NewGarbageCollector(c)
+	go garbageCollector.Run()
+
+	// the nodes share the host, one proxy serves both
+	go proxy.New(newClient(auth.UserKubeProxy)).Run()
+
+	// and one name server, pods reach it on the bridge
+	dnsServer := dns.New(newClient(auth.UserDNS))
+	dnsServer.Domain = rt.ClusterDomain
+	if upstreams, err := dns.ReadResolvConf("/etc/resolv.conf"); err == nil {
+		dnsServer.Upstreams = upstreams
+	}
+	go func() {
+		if err := dnsServer.Run(":53"); err != nil {
+			log.Printf("dns: %v", err)
+		}
+	}()
+
 	// mark nodes NotReady if heartbeat is stale
 	nodeController := controller.NewNodeController(c)
 	nodeController.Run()
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"miniku/pkg/auth"
+	"miniku/pkg/pki"
+)
+
+const usage = `usage:
+  pki issue --cert-dir DIR --name NAME --cn USER [--org GROUPS] [--hosts HOSTS] [--server]
+  pki join-token`
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+	switch os.Args[1] {
+	case "issue":
+		issue(os.Args[2:])
+	case "join-token":
+		token, err := auth.NewJoinToken()
+		if err != nil {
+			log.Fatalf("failed to create join token: %v", err)
+		}
+		fmt.Println(token)
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// issue writes a key pair signed by the CA in the cert dir, the same dir
+// the API server is given, creating the CA if there is none yet.
+func issue(args []string) {
+	flags := flag.NewFlagSet("issue", flag.ExitOnError)
+	certDir := flags.String("cert-dir", "", "directory of the cluster CA, the key pair is written there as NAME.crt and NAME.key (required)")
+	name := flags.String("name", "", "file name of the key pair (required)")
+	cn := flags.String("cn", "", "common name, the user the certificate authenticates as (required)")
+	org := flags.String("org", "", "comma separated organizations, the user's groups, e.g. system:masters")
+	hosts := flags.String("hosts", "", "comma separated DNS names and IPs of a serving certificate")
+	server := flags.Bool("server", false, "issue a serving certificate instead of a client certificate")
+	_ = flags.Parse(args)
+
+	if *certDir == "" || *name == "" || *cn == "" {
+		log.Fatal(usage)
+	}
+
+	ca, err := pki.LoadOrCreateCA(*certDir)
+	if err != nil {
+		log.Fatalf("failed to load CA: %v", err)
+	}
+	subject := pkix.Name{CommonName: *cn}
+	if *org != "" {
+		subject.Organization = strings.Split(*org, ",")
+	}
+	var hostList []string
+	if *hosts != "" {
+		hostList = strings.Split(*hosts, ",")
+	}
+	keyUsage := x509.ExtKeyUsageClientAuth
+	if *server {
+		keyUsage = x509.ExtKeyUsageServerAuth
+	}
+
+	certPEM, keyPEM, err := ca.Issue(subject, hostList, keyUsage)
+	if err != nil {
+		log.Fatalf("failed to issue certificate: %v", err)
+	}
+	if err := pki.WriteKeyPair(*certDir, *name, certPEM, keyPEM); err != nil {
+		log.Fatalf("failed to write key pair: %v", err)
+	}
+	certFile, keyFile := pki.KeyPairPaths(*certDir, *name)
+	log.Printf("pki: wrote %s and %s", certFile, keyFile)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"miniku/pkg/client"
+	"miniku/pkg/proxy"
+)
+
+func main() {
+	apiServer := flag.String("api-server", "http://localhost:8080", "API server URL")
+	token := flag.String("token", "", "bearer token to authenticate to the API server with")
+	caFile := flag.String("ca-file", "", "CA bundle to verify an https API server with")
+	certFile := flag.String("cert-file", "", "client certificate to authenticate to the API server with")
+	keyFile := flag.String("key-file", "", "key of --cert-file")
+	iface := flag.String("interface", proxy.DefaultInterface, "dummy interface cluster IPs are put on, empty to leave them to someone else")
+	flag.Parse()
+
+	tlsOpts, err := client.TLSFromFiles(*caFile, *certFile, *keyFile)
+	if err != nil {
+		log.Fatalf("failed to load TLS files: %v", err)
+	}
+	c := client.New(*apiServer, append(tlsOpts, client.WithToken(*token))...)
+
+	log.Printf("proxy: connecting to API server at %s", *apiServer)
+
+	p := proxy.New(c)
+	p.Interface = *iface
+	p.Run()
+}
@@ -10,11 +10,27 @@ import (
 
 func main() {
 	apiServer := flag.String("api-server", "http://localhost:8080", "API server URL")
+	token := flag.String("token", "", "bearer token to authenticate to the API server with")
+	caFile := flag.String("ca-file", "", "CA bundle to verify an https API server with")
+	certFile := flag.String("cert-file", "", "client certificate to authenticate to the API server with")
+	keyFile := flag.String("key-file", "", "key of --cert-file")
+	strategy := flag.String("scoring-strategy", string(scheduler.LeastAllocated), "how to pick between nodes a pod fits on: LeastAllocated or MostAllocated")
 	flag.Parse()
 
-	c := client.New(*apiServer)
+	switch scheduler.ScoringStrategy(*strategy) {
+	case scheduler.LeastAllocated, scheduler.MostAllocated:
+	default:
+		log.Fatalf("unknown scoring strategy %q", *strategy)
+	}
+
+	tlsOpts, err := client.TLSFromFiles(*caFile, *certFile, *keyFile)
+	if err != nil {
+		log.Fatalf("failed to load TLS files: %v", err)
+	}
+	c := client.New(*apiServer, append(tlsOpts, client.WithToken(*token))...)
 
 	log.Printf("scheduler: connecting to API server at %s", *apiServer)
 	sched := scheduler.New(c)
+	sched.Strategy = scheduler.ScoringStrategy(*strategy)
 	sched.Run()
 }
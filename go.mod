@@ -3,6 +3,6 @@ module miniku
 go 1.24.12
 
 require (
-	go.etcd.io/bbolt v1.4.3 // indirect
-	golang.org/x/sys v0.29.0 // indirect
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/sys v0.29.0
 )
@@ -0,0 +1,111 @@
+// Package admission decides whether the API server lets a write through.
+// Every create, update and delete of an object goes through a Chain of
+// plugins after it was decoded and defaulted: first the mutating plugins,
+// which may change the object, then, once the object passed validation,
+// the validating ones, which only say yes or no.
+package admission
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"miniku/pkg/types"
+)
+
+// Attributes describe one write.
+type Attributes struct {
+	Operation types.AdmissionOperation
+	// Resource is the collection written to, e.g. pods.
+	Resource string
+	// Namespace is empty for namespaces and nodes.
+	Namespace string
+	Name      string
+	// Object points at the object to be stored, e.g. a *types.Pod, which
+	// mutating plugins change in place. It is nil on DELETE.
+	Object any
+	// OldObject points at the stored object on UPDATE and DELETE.
+	OldObject any
+}
+
+// Interface is implemented by every plugin. Handles says which operations
+// it wants to see.
+type Interface interface {
+	Handles(op types.AdmissionOperation) bool
+}
+
+// MutationInterface is a plugin that may change the object being written.
+type MutationInterface interface {
+	Interface
+	Admit(ctx context.Context, a *Attributes) error
+}
+
+// ValidationInterface is a plugin that may refuse the write.
+type ValidationInterface interface {
+	Interface
+	Validate(ctx context.Context, a *Attributes) error
+}
+
+// Chain runs plugins in order, a plugin can implement both phases.
+type Chain []Interface
+
+// Admit runs the mutating plugins, stopping at the first that refuses.
+func (c Chain) Admit(ctx context.Context, a *Attributes) error {
+	for _, p := range c {
+		if m, ok := p.(MutationInterface); ok && m.Handles(a.Operation) {
+			if err := m.Admit(ctx, a); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Validate runs the validating plugins, stopping at the first that refuses.
+func (c Chain) Validate(ctx context.Context, a *Attributes) error {
+	for _, p := range c {
+		if v, ok := p.(ValidationInterface); ok && v.Handles(a.Operation) {
+			if err := v.Validate(ctx, a); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Error is a refusal, answered with Status. Any other error a plugin
+// returns is answered as an internal error.
+type Error struct {
+	Status types.Status
+}
+
+func (e *Error) Error() string {
+	return e.Status.Message
+}
+
+// Forbidden refuses a write the plugin doesn't allow.
+func Forbidden(a *Attributes, format string, args ...any) *Error {
+	return &Error{types.Status{
+		Code:    http.StatusForbidden,
+		Reason:  types.StatusReasonForbidden,
+		Message: fmt.Sprintf("%s %q is forbidden: %s", a.Resource, a.Name, fmt.Sprintf(format, args...)),
+	}}
+}
+
+// NotFound refuses a write to something that doesn't exist.
+func NotFound(format string, args ...any) *Error {
+	return &Error{types.Status{
+		Code:    http.StatusNotFound,
+		Reason:  types.StatusReasonNotFound,
+		Message: fmt.Sprintf(format, args...),
+	}}
+}
+
+// handles is embedded by plugins to implement Handles for a fixed set of
+// operations.
+type handles []types.AdmissionOperation
+
+func (h handles) Handles(op types.AdmissionOperation) bool {
+	return slices.Contains(h, op)
+}
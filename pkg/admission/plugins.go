@@ -0,0 +1,165 @@
+package admission
+
+import (
+	"context"
+	"maps"
+	"path"
+
+	"miniku/pkg/auth"
+	"miniku/pkg/types"
+)
+
+// NamespaceGetter looks up namespaces, store.NamespaceStore is one.
+type NamespaceGetter interface {
+	Get(name string) (types.Namespace, bool)
+}
+
+// NamespaceLifecycle only lets objects be created in a namespace that
+// exists and isn't being deleted, and keeps the default namespace from
+// being deleted. The API server always runs it first.
+type NamespaceLifecycle struct {
+	handles
+	namespaces NamespaceGetter
+}
+
+func NewNamespaceLifecycle(namespaces NamespaceGetter) *NamespaceLifecycle {
+	return &NamespaceLifecycle{
+		handles:    handles{types.AdmissionCreate, types.AdmissionDelete},
+		namespaces: namespaces,
+	}
+}
+
+func (p *NamespaceLifecycle) Validate(_ context.Context, a *Attributes) error {
+	if a.Resource == "namespaces" {
+		if a.Operation == types.AdmissionDelete && a.Name == types.DefaultNamespace {
+			return Forbidden(a, "the default namespace can't be deleted")
+		}
+		return nil
+	}
+	if a.Operation != types.AdmissionCreate || a.Namespace == "" {
+		return nil
+	}
+
+	ns, ok := p.namespaces.Get(a.Namespace)
+	if !ok {
+		return NotFound("namespace %s not found", a.Namespace)
+	}
+	if ns.Phase == types.NamespaceTerminating {
+		return Forbidden(a, "namespace %s is being terminated", a.Namespace)
+	}
+	return nil
+}
+
+// NodeRestriction keeps a kubelet to its own node: it may only write its
+// own Node and the pods bound to it, and can't create pods. Requests from
+// anyone but a node (see auth.NodeName) are left to RBAC. The API server
+// always runs it.
+type NodeRestriction struct {
+	handles
+}
+
+func NewNodeRestriction() *NodeRestriction {
+	return &NodeRestriction{
+		handles: handles{types.AdmissionCreate, types.AdmissionUpdate, types.AdmissionDelete},
+	}
+}
+
+func (p *NodeRestriction) Validate(ctx context.Context, a *Attributes) error {
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		return nil
+	}
+	node, ok := auth.NodeName(user)
+	if !ok {
+		return nil
+	}
+
+	switch a.Resource {
+	case "nodes":
+		if a.Name != node {
+			return Forbidden(a, "node %q can only modify its own node", node)
+		}
+	case "pods":
+		if a.Operation == types.AdmissionCreate {
+			return Forbidden(a, "node %q can't create pods", node)
+		}
+		if old, ok := a.OldObject.(*types.Pod); !ok || old.Spec.NodeName != node {
+			return Forbidden(a, "node %q can only modify pods bound to it", node)
+		}
+		if pod, ok := a.Object.(*types.Pod); ok && pod.Spec.NodeName != node {
+			return Forbidden(a, "node %q can't move pods to another node", node)
+		}
+	}
+	return nil
+}
+
+// ImageAllowlist refuses pods, ReplicaSets and Deployments running an image
+// none of its patterns match. Patterns are path.Match globs over the image
+// as written in the spec, e.g. registry.example.com/team/* or alpine:*.
+type ImageAllowlist struct {
+	handles
+	patterns []string
+}
+
+func NewImageAllowlist(patterns []string) *ImageAllowlist {
+	return &ImageAllowlist{
+		handles:  handles{types.AdmissionCreate, types.AdmissionUpdate},
+		patterns: patterns,
+	}
+}
+
+func (p *ImageAllowlist) Validate(_ context.Context, a *Attributes) error {
+	for _, spec := range podSpecs(a.Object) {
+		if !p.allowed(spec.Image) {
+			return Forbidden(a, "image %q is not allowed", spec.Image)
+		}
+	}
+	return nil
+}
+
+func (p *ImageAllowlist) allowed(image string) bool {
+	for _, pattern := range p.patterns {
+		if ok, _ := path.Match(pattern, image); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultLabels gives pods, and the templates of ReplicaSets and
+// Deployments, the labels of its set they don't have. Labels an object
+// sets itself are left alone.
+type DefaultLabels struct {
+	handles
+	labels map[string]string
+}
+
+func NewDefaultLabels(labels map[string]string) *DefaultLabels {
+	return &DefaultLabels{
+		handles: handles{types.AdmissionCreate, types.AdmissionUpdate},
+		labels:  labels,
+	}
+}
+
+func (p *DefaultLabels) Admit(_ context.Context, a *Attributes) error {
+	for _, spec := range podSpecs(a.Object) {
+		labels := maps.Clone(p.labels)
+		maps.Copy(labels, spec.Labels)
+		spec.Labels = labels
+	}
+	return nil
+}
+
+// podSpecs returns the pod specs in obj, the spec of a pod or the template
+// of a ReplicaSet or Deployment.
+func podSpecs(obj any) []*types.PodSpec {
+	switch obj := obj.(type) {
+	case *types.Pod:
+		return []*types.PodSpec{&obj.Spec}
+	case *types.ReplicaSet:
+		return []*types.PodSpec{&obj.Template}
+	case *types.Deployment:
+		return []*types.PodSpec{&obj.Template}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"miniku/pkg/auth"
+	"miniku/pkg/store"
+	"miniku/pkg/types"
+)
+
+func TestNamespaceLifecycle(t *testing.T) {
+	namespaces := store.NewMemStore[types.Namespace]()
+	namespaces.Put("shop", types.Namespace{Name: "shop", Phase: types.NamespaceActive})
+	namespaces.Put("old", types.Namespace{Name: "old", Phase: types.NamespaceTerminating})
+	p := NewNamespaceLifecycle(namespaces)
+
+	tests := []struct {
+		name     string
+		attrs    Attributes
+		wantCode int
+	}{
+		{"create in active namespace", Attributes{Operation: types.AdmissionCreate, Resource: "pods", Namespace: "shop"}, 0},
+		{"create in missing namespace", Attributes{Operation: types.AdmissionCreate, Resource: "pods", Namespace: "nope"}, http.StatusNotFound},
+		{"create in terminating namespace", Attributes{Operation: types.AdmissionCreate, Resource: "pods", Namespace: "old"}, http.StatusForbidden},
+		{"delete in terminating namespace", Attributes{Operation: types.AdmissionDelete, Resource: "pods", Namespace: "old"}, 0},
+		{"create namespace", Attributes{Operation: types.AdmissionCreate, Resource: "namespaces", Name: "new"}, 0},
+		{"delete default namespace", Attributes{Operation: types.AdmissionDelete, Resource: "namespaces", Name: "default"}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Chain{p}.Validate(context.Background(), &tt.attrs)
+			checkRefusal(t, err, tt.wantCode)
+		})
+	}
+}
+
+func TestNodeRestriction(t *testing.T) {
+	p := NewNodeRestriction()
+	kubelet := auth.WithUser(context.Background(), types.UserInfo{Name: "system:node:node-1", Groups: []string{auth.GroupNodes}})
+	scheduler := auth.WithUser(context.Background(), types.UserInfo{Name: auth.UserScheduler})
+	bound := &types.Pod{Spec: types.PodSpec{Name: "web", NodeName: "node-1"}}
+	elsewhere := &types.Pod{Spec: types.PodSpec{Name: "api", NodeName: "node-2"}}
+	unbound := &types.Pod{Spec: types.PodSpec{Name: "db"}}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		attrs    Attributes
+		wantCode int
+	}{
+		{"own node", kubelet, Attributes{Operation: types.AdmissionUpdate, Resource: "nodes", Name: "node-1"}, 0},
+		{"other node", kubelet, Attributes{Operation: types.AdmissionUpdate, Resource: "nodes", Name: "node-2"}, http.StatusForbidden},
+		{"pod bound to it", kubelet, Attributes{Operation: types.AdmissionUpdate, Resource: "pods", Object: bound, OldObject: bound}, 0},
+		{"delete pod bound to it", kubelet, Attributes{Operation: types.AdmissionDelete, Resource: "pods", OldObject: bound}, 0},
+		{"pod on another node", kubelet, Attributes{Operation: types.AdmissionUpdate, Resource: "pods", Object: elsewhere, OldObject: elsewhere}, http.StatusForbidden},
+		{"binding a pod", kubelet, Attributes{Operation: types.AdmissionUpdate, Resource: "pods", Object: bound, OldObject: unbound}, http.StatusForbidden},
+		{"moving a pod away", kubelet, Attributes{Operation: types.AdmissionUpdate, Resource: "pods", Object: elsewhere, OldObject: bound}, http.StatusForbidden},
+		{"create pod", kubelet, Attributes{Operation: types.AdmissionCreate, Resource: "pods", Object: bound}, http.StatusForbidden},
+		{"not a node", scheduler, Attributes{Operation: types.AdmissionUpdate, Resource: "pods", Object: bound, OldObject: unbound}, 0},
+		{"no user", context.Background(), Attributes{Operation: types.AdmissionUpdate, Resource: "nodes", Name: "node-2"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Chain{p}.Validate(tt.ctx, &tt.attrs)
+			checkRefusal(t, err, tt.wantCode)
+		})
+	}
+}
+
+func TestImageAllowlist(t *testing.T) {
+	p := NewImageAllowlist([]string{"registry.example.com/*", "alpine:*"})
+
+	tests := []struct {
+		name     string
+		obj      any
+		wantCode int
+	}{
+		{"allowed registry", &types.Pod{Spec: types.PodSpec{Image: "registry.example.com/web"}}, 0},
+		{"allowed tag", &types.Pod{Spec: types.PodSpec{Image: "alpine:3.20"}}, 0},
+		{"other image", &types.Pod{Spec: types.PodSpec{Image: "nginx"}}, http.StatusForbidden},
+		{"replicaset template", &types.ReplicaSet{Template: types.PodSpec{Image: "nginx"}}, http.StatusForbidden},
+		{"deployment template", &types.Deployment{Template: types.PodSpec{Image: "alpine:3.20"}}, 0},
+		{"no pods in it", &types.Service{Name: "web"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Chain{p}.Validate(context.Background(), &Attributes{Operation: types.AdmissionCreate, Object: tt.obj})
+			checkRefusal(t, err, tt.wantCode)
+		})
+	}
+}
+
+func TestDefaultLabels(t *testing.T) {
+	p := NewDefaultLabels(map[string]string{"team": "platform", "env": "prod"})
+
+	rs := &types.ReplicaSet{Template: types.PodSpec{Labels: map[string]string{"app": "web", "env": "dev"}}}
+	if err := (Chain{p}).Admit(context.Background(), &Attributes{Operation: types.AdmissionCreate, Object: rs}); err != nil {
+		t.Fatalf("Admit: %v", err)
+	}
+
+	want := map[string]string{"app": "web", "env": "dev", "team": "platform"}
+	if len(rs.Template.Labels) != len(want) {
+		t.Fatalf("got labels %v, want %v", rs.Template.Labels, want)
+	}
+	for k, v := range want {
+		if rs.Template.Labels[k] != v {
+			t.Errorf("label %s: got %q, want %q", k, rs.Template.Labels[k], v)
+		}
+	}
+}
+
+func TestChainPhases(t *testing.T) {
+	var calls []string
+	chain := Chain{
+		recorder{"validate", &calls, false},
+		recorder{"mutate", &calls, true},
+	}
+	a := &Attributes{Operation: types.AdmissionCreate}
+
+	if err := chain.Admit(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Validate(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || calls[0] != "mutate" || calls[1] != "validate" {
+		t.Errorf("got calls %v, want [mutate validate]", calls)
+	}
+}
+
+// recorder is a plugin that notes when it is called, in one phase.
+type recorder struct {
+	name     string
+	calls    *[]string
+	mutating bool
+}
+
+func (r recorder) Handles(types.AdmissionOperation) bool { return true }
+
+func (r recorder) Admit(context.Context, *Attributes) error {
+	if r.mutating {
+		*r.calls = append(*r.calls, r.name)
+	}
+	return nil
+}
+
+func (r recorder) Validate(context.Context, *Attributes) error {
+	if !r.mutating {
+		*r.calls = append(*r.calls, r.name)
+	}
+	return nil
+}
+
+// checkRefusal checks err is nil for wantCode 0, else an *Error answered
+// with wantCode.
+func checkRefusal(t *testing.T, err error, wantCode int) {
+	t.Helper()
+	if wantCode == 0 {
+		if err != nil {
+			t.Fatalf("got error %v, want the write admitted", err)
+		}
+		return
+	}
+	var refused *Error
+	if !errors.As(err, &refused) {
+		t.Fatalf("got error %v, want a refusal", err)
+	}
+	if refused.Status.Code != wantCode {
+		t.Errorf("got code %d, want %d: %s", refused.Status.Code, wantCode, refused.Status.Message)
+	}
+}
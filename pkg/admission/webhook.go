@@ -0,0 +1,215 @@
+package admission
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"time"
+
+	"miniku/pkg/auth"
+	"miniku/pkg/types"
+)
+
+// DefaultWebhookTimeout is how long a webhook gets to answer unless its
+// config says otherwise.
+const DefaultWebhookTimeout = 10 * time.Second
+
+// FailurePolicy says what happens to a write when its webhook can't be
+// asked or doesn't answer properly.
+type FailurePolicy string
+
+const (
+	// FailurePolicyFail refuses the write, it is the default.
+	FailurePolicyFail FailurePolicy = "Fail"
+	// FailurePolicyIgnore lets the write through as if the webhook allowed
+	// it.
+	FailurePolicyIgnore FailurePolicy = "Ignore"
+)
+
+// WebhookConfig is an external admission webhook, one entry of the file
+// --admission-webhooks points the API server at.
+type WebhookConfig struct {
+	Name string `json:"name"`
+	// URL is POSTed an AdmissionReview for every write it is called for.
+	URL string `json:"url"`
+	// Mutating webhooks run in the mutating phase and may send the object
+	// back changed, the others in the validating phase.
+	Mutating bool `json:"mutating,omitempty"`
+	// Operations and Resources narrow down the writes the webhook is called
+	// for, empty means all.
+	Operations []types.AdmissionOperation `json:"operations,omitempty"`
+	Resources  []string                   `json:"resources,omitempty"`
+	// TimeoutSeconds is how long to wait for an answer, zero means
+	// DefaultWebhookTimeout.
+	TimeoutSeconds int           `json:"timeoutSeconds,omitempty"`
+	FailurePolicy  FailurePolicy `json:"failurePolicy,omitempty"`
+}
+
+// LoadWebhooks reads a JSON list of WebhookConfig from path and returns the
+// webhooks in the order they are listed.
+func LoadWebhooks(path string) ([]Interface, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []WebhookConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	out := make([]Interface, 0, len(configs))
+	for _, config := range configs {
+		if config.Name == "" || config.URL == "" {
+			return nil, fmt.Errorf("parse %s: webhook needs a name and a url", path)
+		}
+		switch config.FailurePolicy {
+		case "", FailurePolicyFail, FailurePolicyIgnore:
+		default:
+			return nil, fmt.Errorf("parse %s: webhook %s: unknown failurePolicy %s", path, config.Name, config.FailurePolicy)
+		}
+		out = append(out, NewWebhook(config))
+	}
+	return out, nil
+}
+
+// NewWebhook returns a plugin calling the webhook config describes, in the
+// mutating or the validating phase.
+func NewWebhook(config WebhookConfig) Interface {
+	w := &webhook{
+		config: config,
+		client: &http.Client{Timeout: cmp.Or(time.Duration(config.TimeoutSeconds)*time.Second, DefaultWebhookTimeout)},
+	}
+	if config.Mutating {
+		return &mutatingWebhook{w}
+	}
+	return &validatingWebhook{w}
+}
+
+type webhook struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+type mutatingWebhook struct{ *webhook }
+
+type validatingWebhook struct{ *webhook }
+
+func (w *webhook) Handles(op types.AdmissionOperation) bool {
+	return len(w.config.Operations) == 0 || slices.Contains(w.config.Operations, op)
+}
+
+// Admit lets the webhook change the object by sending it back, it replaces
+// the object whole.
+func (w *mutatingWebhook) Admit(ctx context.Context, a *Attributes) error {
+	resp, err := w.review(ctx, a)
+	if err != nil || resp == nil || len(resp.Object) == 0 || a.Object == nil {
+		return err
+	}
+	obj := reflect.ValueOf(a.Object).Elem()
+	obj.SetZero()
+	if err := json.Unmarshal(resp.Object, a.Object); err != nil {
+		return fmt.Errorf("admission webhook %q sent back an invalid object: %w", w.config.Name, err)
+	}
+	return nil
+}
+
+func (w *validatingWebhook) Validate(ctx context.Context, a *Attributes) error {
+	_, err := w.review(ctx, a)
+	return err
+}
+
+// review asks the webhook about a and returns its answer, nil if it isn't
+// called for a or failed and is ignored. A denial is returned as *Error.
+func (w *webhook) review(ctx context.Context, a *Attributes) (*types.AdmissionResponse, error) {
+	if len(w.config.Resources) > 0 && !slices.Contains(w.config.Resources, a.Resource) {
+		return nil, nil
+	}
+
+	resp, err := w.call(ctx, a)
+	if err != nil {
+		if w.config.FailurePolicy == FailurePolicyIgnore {
+			log.Printf("admission: webhook %s failed, ignoring it: %v", w.config.Name, err)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed calling admission webhook %q: %w", w.config.Name, err)
+	}
+
+	if !resp.Allowed {
+		status := types.Status{Code: http.StatusForbidden, Reason: types.StatusReasonForbidden}
+		if resp.Status != nil {
+			status.Code = cmp.Or(resp.Status.Code, status.Code)
+			status.Reason = cmp.Or(resp.Status.Reason, status.Reason)
+			status.Message = resp.Status.Message
+		}
+		status.Message = fmt.Sprintf("admission webhook %q denied the request: %s", w.config.Name, cmp.Or(status.Message, "without explanation"))
+		return nil, &Error{status}
+	}
+	return resp, nil
+}
+
+func (w *webhook) call(ctx context.Context, a *Attributes) (*types.AdmissionResponse, error) {
+	req := types.AdmissionRequest{
+		UID:       newUID(),
+		Operation: a.Operation,
+		Resource:  a.Resource,
+		Namespace: a.Namespace,
+		Name:      a.Name,
+	}
+	req.UserInfo, _ = auth.UserFrom(ctx)
+	var err error
+	if a.Object != nil {
+		if req.Object, err = json.Marshal(a.Object); err != nil {
+			return nil, err
+		}
+	}
+	if a.OldObject != nil {
+		if req.OldObject, err = json.Marshal(a.OldObject); err != nil {
+			return nil, err
+		}
+	}
+	body, err := json.Marshal(types.AdmissionReview{Request: &req})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := w.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", httpResp.StatusCode)
+	}
+	var review types.AdmissionReview
+	if err := json.NewDecoder(httpResp.Body).Decode(&review); err != nil {
+		return nil, fmt.Errorf("decode review: %w", err)
+	}
+	if review.Response == nil {
+		return nil, fmt.Errorf("review has no response")
+	}
+	if review.Response.UID != req.UID {
+		return nil, fmt.Errorf("response uid %q does not match request uid %q", review.Response.UID, req.UID)
+	}
+	return review.Response, nil
+}
+
+func newUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("warning: failed to generate random bytes: %v", err)
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
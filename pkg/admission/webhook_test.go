@@ -0,0 +1,134 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"miniku/pkg/types"
+)
+
+// reviewer serves a webhook answering with respond.
+func reviewer(t *testing.T, respond func(req *types.AdmissionRequest) types.AdmissionResponse) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var review types.AdmissionReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+			http.Error(w, "bad review", http.StatusBadRequest)
+			return
+		}
+		resp := respond(review.Request)
+		resp.UID = review.Request.UID
+		_ = json.NewEncoder(w).Encode(types.AdmissionReview{Response: &resp})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestValidatingWebhook(t *testing.T) {
+	ts := reviewer(t, func(req *types.AdmissionRequest) types.AdmissionResponse {
+		var pod types.Pod
+		_ = json.Unmarshal(req.Object, &pod)
+		if pod.Spec.Labels["team"] == "" {
+			return types.AdmissionResponse{Status: &types.Status{Message: "pods need a team label"}}
+		}
+		return types.AdmissionResponse{Allowed: true}
+	})
+	chain := Chain{NewWebhook(WebhookConfig{Name: "labels", URL: ts.URL, Resources: []string{"pods"}})}
+
+	tests := []struct {
+		name     string
+		attrs    Attributes
+		wantCode int
+	}{
+		{"labeled pod", Attributes{Operation: types.AdmissionCreate, Resource: "pods",
+			Object: &types.Pod{Spec: types.PodSpec{Labels: map[string]string{"team": "shop"}}}}, 0},
+		{"unlabeled pod", Attributes{Operation: types.AdmissionCreate, Resource: "pods",
+			Object: &types.Pod{}}, http.StatusForbidden},
+		{"other resource", Attributes{Operation: types.AdmissionCreate, Resource: "services",
+			Object: &types.Service{}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRefusal(t, chain.Validate(context.Background(), &tt.attrs), tt.wantCode)
+			// a validating webhook has no say in the mutating phase
+			if err := chain.Admit(context.Background(), &tt.attrs); err != nil {
+				t.Errorf("Admit: %v", err)
+			}
+		})
+	}
+}
+
+func TestMutatingWebhook(t *testing.T) {
+	ts := reviewer(t, func(req *types.AdmissionRequest) types.AdmissionResponse {
+		var pod types.Pod
+		_ = json.Unmarshal(req.Object, &pod)
+		pod.Spec.Env = map[string]string{"REGION": "eu-west"}
+		pod.Spec.Labels = nil
+		obj, _ := json.Marshal(pod)
+		return types.AdmissionResponse{Allowed: true, Object: obj}
+	})
+	chain := Chain{NewWebhook(WebhookConfig{Name: "env", URL: ts.URL, Mutating: true, Operations: []types.AdmissionOperation{types.AdmissionCreate}})}
+
+	pod := &types.Pod{Spec: types.PodSpec{Name: "web", Labels: map[string]string{"app": "web"}}}
+	if err := chain.Admit(context.Background(), &Attributes{Operation: types.AdmissionCreate, Resource: "pods", Object: pod}); err != nil {
+		t.Fatalf("Admit: %v", err)
+	}
+	if pod.Spec.Name != "web" || pod.Spec.Env["REGION"] != "eu-west" {
+		t.Errorf("got %+v, want the pod with the injected env", pod.Spec)
+	}
+	if pod.Spec.Labels != nil {
+		t.Errorf("got labels %v, want the ones the webhook dropped gone", pod.Spec.Labels)
+	}
+
+	updated := &types.Pod{}
+	if err := chain.Admit(context.Background(), &Attributes{Operation: types.AdmissionUpdate, Resource: "pods", Object: updated}); err != nil {
+		t.Fatalf("Admit: %v", err)
+	}
+	if updated.Spec.Env != nil {
+		t.Error("webhook was called for an operation it doesn't handle")
+	}
+}
+
+func TestWebhookFailurePolicy(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		policy  FailurePolicy
+		wantErr bool
+	}{
+		{"timeout fails closed", slow.URL, "", true},
+		{"timeout fails open", slow.URL, FailurePolicyIgnore, false},
+		{"error fails closed", broken.URL, FailurePolicyFail, true},
+		{"error fails open", broken.URL, FailurePolicyIgnore, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := Chain{NewWebhook(WebhookConfig{Name: "policy", URL: tt.url, TimeoutSeconds: 1, FailurePolicy: tt.policy})}
+			start := time.Now()
+			err := chain.Validate(context.Background(), &Attributes{Operation: types.AdmissionCreate, Resource: "pods", Object: &types.Pod{}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("took %s, want the webhook cut off after its timeout", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"miniku/pkg/admission"
+	"miniku/pkg/store"
+	"miniku/pkg/types"
+)
+
+// admissionChain is the namespace lifecycle and node restriction checks,
+// which every server runs, followed by the configured plugins.
+func (s *Server) admissionChain() admission.Chain {
+	return append(admission.Chain{
+		admission.NewNamespaceLifecycle(s.NamespaceStore),
+		admission.NewNodeRestriction(),
+	}, s.Admission...)
+}
+
+// newAttributes describes a write of obj to resource, old is the stored
+// object on update and delete.
+func newAttributes(op types.AdmissionOperation, resource string, obj, old any) *admission.Attributes {
+	a := &admission.Attributes{Operation: op, Resource: resource, Object: obj, OldObject: old}
+	named := obj
+	if named == nil {
+		named = old
+	}
+	switch o := named.(type) {
+	case store.Namespaced:
+		a.Namespace, a.Name = o.GetNamespace(), o.GetName()
+	case *types.Namespace:
+		a.Name = o.Name
+	case *types.Node:
+		a.Name = o.Name
+	case *types.Role:
+		a.Name = o.Name
+	case *types.RoleBinding:
+		a.Name = o.Name
+	}
+	return a
+}
+
+// mutate runs the mutating admission plugins on a create or update of obj,
+// which they may change but not move to another name or namespace. It
+// returns false once it answered with an error.
+func (s *Server) mutate(w http.ResponseWriter, r *http.Request, op types.AdmissionOperation, resource string, obj, old any) bool {
+	a := newAttributes(op, resource, obj, old)
+	if err := s.admissionChain().Admit(r.Context(), a); err != nil {
+		writeAdmissionError(w, err)
+		return false
+	}
+	if after := newAttributes(op, resource, obj, old); after.Name != a.Name || after.Namespace != a.Namespace {
+		writeAdmissionError(w, errors.New("admission changed the name or namespace of "+resource+" "+a.Name))
+		return false
+	}
+	return true
+}
+
+// admit runs the validating admission plugins on a validated write. It
+// returns false once it answered with an error.
+func (s *Server) admit(w http.ResponseWriter, r *http.Request, op types.AdmissionOperation, resource string, obj, old any) bool {
+	if err := s.admissionChain().Validate(r.Context(), newAttributes(op, resource, obj, old)); err != nil {
+		writeAdmissionError(w, err)
+		return false
+	}
+	return true
+}
+
+// writeAdmissionError answers with the types.Status of a refusal, anything
+// else kept the write from being admitted at all.
+func writeAdmissionError(w http.ResponseWriter, err error) {
+	status := types.Status{
+		Code:    http.StatusInternalServerError,
+		Reason:  types.StatusReasonInternalError,
+		Message: err.Error(),
+	}
+	var refused *admission.Error
+	if errors.As(err, &refused) {
+		status = refused.Status
+	} else {
+		log.Printf("admission: %v", err)
+	}
+	writeStatus(w, status)
+}
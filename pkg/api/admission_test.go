@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"miniku/pkg/admission"
+	"miniku/pkg/types"
+)
+
+// denyDeletes refuses every delete, like a policy protecting objects would.
+type denyDeletes struct{}
+
+func (denyDeletes) Handles(op types.AdmissionOperation) bool { return op == types.AdmissionDelete }
+
+func (denyDeletes) Validate(_ context.Context, a *admission.Attributes) error {
+	return admission.Forbidden(a, "deletes are not allowed")
+}
+
+// renamer tries to move pods somewhere else.
+type renamer struct{}
+
+func (renamer) Handles(types.AdmissionOperation) bool { return true }
+
+func (renamer) Admit(_ context.Context, a *admission.Attributes) error {
+	if pod, ok := a.Object.(*types.Pod); ok {
+		pod.Spec.Name = "elsewhere"
+	}
+	return nil
+}
+
+func TestAdmission(t *testing.T) {
+	tests := []struct {
+		name       string
+		chain      admission.Chain
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"allowed image", admission.Chain{admission.NewImageAllowlist([]string{"nginx*"})},
+			"POST", "/pods", `{"spec":{"name":"new","image":"nginx:1.27"}}`, http.StatusCreated},
+		{"image not allowed", admission.Chain{admission.NewImageAllowlist([]string{"nginx*"})},
+			"POST", "/pods", `{"spec":{"name":"new","image":"alpine"}}`, http.StatusForbidden},
+		{"image not allowed on update", admission.Chain{admission.NewImageAllowlist([]string{"alpine"})},
+			"PUT", "/pods/web", `{"spec":{"name":"web","image":"nginx"}}`, http.StatusForbidden},
+		{"delete refused", admission.Chain{denyDeletes{}},
+			"DELETE", "/pods/web", "", http.StatusForbidden},
+		{"mutation can't rename", admission.Chain{renamer{}},
+			"POST", "/pods", `{"spec":{"name":"new","image":"nginx"}}`, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, podStore, _, _ := newTestServer()
+			srv.Admission = tt.chain
+			podStore.Put("default/web", types.Pod{Spec: types.PodSpec{Name: "web", Namespace: "default", Image: "nginx"}, Status: types.PodStatusPending})
+			ts := httptest.NewServer(srv.Routes())
+			defer ts.Close()
+
+			req, _ := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus >= 400 {
+				if pods := podStore.List(); len(pods) != 1 || pods[0].Spec.Image != "nginx" {
+					t.Errorf("got pods %+v, want the refused write to leave them alone", pods)
+				}
+			}
+		})
+	}
+}
+
+func TestAdmissionMutates(t *testing.T) {
+	srv, podStore, _, _ := newTestServer()
+	srv.Admission = admission.Chain{admission.NewDefaultLabels(map[string]string{"team": "platform"})}
+
+	req := httptest.NewRequest("POST", "/pods", strings.NewReader(`{"spec":{"name":"web","image":"nginx"}}`))
+	rec := httptest.NewRecorder()
+	srv.handleCreatePod(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want 201: %s", rec.Code, rec.Body)
+	}
+	pod, _ := podStore.Get("default/web")
+	if pod.Spec.Labels["team"] != "platform" {
+		t.Errorf("got labels %v, want the default label added", pod.Spec.Labels)
+	}
+}
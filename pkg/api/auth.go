@@ -0,0 +1,121 @@
+package api
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"miniku/pkg/auth"
+	"miniku/pkg/types"
+)
+
+// authenticate finds out who sent a request and puts them into its
+// context. A request without credentials is auth.Anonymous, one with
+// credentials that aren't accepted is answered with 401.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := auth.Anonymous
+		if s.Authenticator != nil {
+			authenticated, ok, err := s.Authenticator.AuthenticateRequest(r)
+			if err != nil {
+				if !errors.Is(err, auth.ErrInvalidCredentials) {
+					log.Printf("authentication error: %v", err)
+				}
+				writeStatus(w, types.Status{Code: http.StatusUnauthorized, Reason: types.StatusReasonUnauthorized, Message: "Unauthorized"})
+				return
+			}
+			if ok {
+				user = authenticated
+				user.Groups = append(slices.Clone(user.Groups), auth.GroupAuthenticated)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), user)))
+	})
+}
+
+// route is what a registered pattern asks to do, in RBAC's terms.
+type route struct {
+	method string
+	// resource is the collection, or collection/subresource
+	resource   string
+	item       bool
+	namespaced bool
+}
+
+// parseRoute takes apart a pattern of Routes, e.g. "GET /pods/{name}/log".
+func parseRoute(pattern string, namespaced bool) route {
+	method, path, _ := strings.Cut(pattern, " ")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	rt := route{method: method, resource: parts[0], item: len(parts) > 1, namespaced: namespaced}
+	if len(parts) > 2 {
+		rt.resource += "/" + parts[2]
+	}
+	return rt
+}
+
+// attributes describe r, a request to rt, to the authorizer.
+func (rt route) attributes(r *http.Request) auth.Attributes {
+	a := auth.Attributes{Resource: rt.resource}
+	a.User, _ = auth.UserFrom(r.Context())
+
+	switch {
+	case rt.method == http.MethodGet && !rt.item && isWatch(r):
+		a.Verb = types.VerbWatch
+	case rt.method == http.MethodGet && !rt.item:
+		a.Verb = types.VerbList
+	case rt.method == http.MethodGet:
+		a.Verb = types.VerbGet
+	case rt.method == http.MethodPost:
+		a.Verb = types.VerbCreate
+	case rt.method == http.MethodPut:
+		a.Verb = types.VerbUpdate
+	case rt.method == http.MethodPatch:
+		a.Verb = types.VerbPatch
+	case rt.method == http.MethodDelete:
+		a.Verb = types.VerbDelete
+	}
+
+	if rt.item {
+		a.Name = cmp.Or(r.PathValue("name"), r.PathValue("namespace"))
+	}
+	if rt.namespaced {
+		// a list on the bare route is across all namespaces, everything
+		// else there is in the default namespace
+		a.Namespace = r.PathValue("namespace")
+		if rt.item || rt.method != http.MethodGet {
+			a.Namespace = namespaceOf(r)
+		}
+	}
+	return a
+}
+
+// authorize wraps h, registered for rt, in the Authorizer's decision. A
+// denied request is answered with 403, or 401 if it came without
+// credentials.
+func (s *Server) authorize(rt route, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Authorizer == nil {
+			h(w, r)
+			return
+		}
+		a := rt.attributes(r)
+		if allowed, reason := s.Authorizer.Authorize(a); !allowed {
+			if a.User.Name == auth.UserAnonymous {
+				writeStatus(w, types.Status{Code: http.StatusUnauthorized, Reason: types.StatusReasonUnauthorized, Message: "Unauthorized"})
+				return
+			}
+			writeStatus(w, types.Status{Code: http.StatusForbidden, Reason: types.StatusReasonForbidden, Message: reason})
+			return
+		}
+		h(w, r)
+	}
+}
+
+func writeStatus(w http.ResponseWriter, status types.Status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status.Code)
+	writeJSON(w, status)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"miniku/pkg/auth"
+	"miniku/pkg/types"
+)
+
+func TestAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"anonymous", "", "GET", "/pods", "", http.StatusUnauthorized},
+		{"unknown token", "nope", "GET", "/pods", "", http.StatusUnauthorized},
+		{"admin", "admin", "POST", "/roles", `{"name":"reader","rules":[{"verbs":["get"],"resources":["pods"]}]}`, http.StatusCreated},
+		{"scheduler lists pods", "scheduler", "GET", "/pods", "", http.StatusOK},
+		{"scheduler can't read roles", "scheduler", "GET", "/roles", "", http.StatusForbidden},
+		{"scheduler can't delete pods", "scheduler", "DELETE", "/pods/web", "", http.StatusForbidden},
+		{"bound namespace", "alice", "GET", "/namespaces/shop/pods/cart", "", http.StatusOK},
+		{"other namespace", "alice", "GET", "/pods/web", "", http.StatusForbidden},
+		{"namespace of bare route", "alice", "GET", "/pods", "", http.StatusForbidden},
+		{"kubelet updates own node", "node-1", "PUT", "/nodes/node-1", `{"name":"node-1","status":"Ready"}`, http.StatusOK},
+		{"kubelet can't update other node", "node-1", "PUT", "/nodes/node-2", `{"name":"node-2","status":"NotReady"}`, http.StatusForbidden},
+		{"kubelet updates its pod", "node-1", "PUT", "/pods/web", `{"spec":{"name":"web","image":"nginx","node_name":"node-1"},"status":"Running"}`, http.StatusOK},
+		{"kubelet can't update other pod", "node-2", "PUT", "/pods/web", `{"spec":{"name":"web","image":"nginx","node_name":"node-1"},"status":"Failed"}`, http.StatusForbidden},
+		{"kubelet can't create pods", "node-1", "POST", "/pods", `{"spec":{"name":"new","image":"nginx","node_name":"node-1"}}`, http.StatusForbidden},
+		{"kubelet patches own node", "node-1", "PATCH", "/nodes/node-1", `{"address":"10.0.0.1"}`, http.StatusOK},
+		{"kubelet can't patch other node", "node-1", "PATCH", "/nodes/node-2", `{"address":"10.0.0.1"}`, http.StatusForbidden},
+		{"viewer can't patch", "alice", "PATCH", "/namespaces/shop/pods/cart", `{"reason":"x"}`, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, podStore, _, nodeStore := newTestServer()
+			srv.NamespaceStore.Put("shop", types.Namespace{Name: "shop", Phase: types.NamespaceActive})
+			podStore.Put("default/web", types.Pod{Spec: types.PodSpec{Name: "web", Namespace: "default", Image: "nginx", NodeName: "node-1"}, Status: types.PodStatusRunning})
+			podStore.Put("shop/cart", types.Pod{Spec: types.PodSpec{Name: "cart", Namespace: "shop", Image: "nginx"}})
+			nodeStore.Put("node-1", types.Node{Name: "node-1", Status: types.NodeStateReady})
+			nodeStore.Put("node-2", types.Node{Name: "node-2", Status: types.NodeStateReady})
+			srv.RoleBindingStore.Put("alice-view", types.RoleBinding{Name: "alice-view", Namespace: "shop", Role: "view",
+				Subjects: []types.Subject{{Kind: types.SubjectUser, Name: "alice"}}})
+			srv.Authenticator = auth.NewTokenFile(map[string]types.UserInfo{
+				"admin":     {Name: "root", Groups: []string{auth.GroupMasters}},
+				"scheduler": {Name: auth.UserScheduler},
+				"alice":     {Name: "alice"},
+				"node-1":    {Name: "system:node:node-1", Groups: []string{auth.GroupNodes}},
+				"node-2":    {Name: "system:node:node-2", Groups: []string{auth.GroupNodes}},
+			})
+			srv.Authorizer = &auth.RBAC{Roles: srv.RoleStore, Bindings: srv.RoleBindingStore}
+			ts := httptest.NewServer(srv.Routes())
+			defer ts.Close()
+
+			req, _ := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.method == http.MethodPatch {
+				req.Header.Set("Content-Type", string(types.MergePatchType))
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"miniku/pkg/auth"
+	"miniku/pkg/pki"
+	"miniku/pkg/types"
+)
+
+// handleCreateCSR signs a certificate request with the cluster CA right
+// away, nothing is stored.
+func (s *Server) handleCreateCSR(w http.ResponseWriter, r *http.Request) {
+	var csr types.CertificateSigningRequest
+	if err := json.NewDecoder(r.Body).Decode(&csr); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if s.CA == nil {
+		http.Error(w, "the API server has no CA to sign with", http.StatusNotImplemented)
+		return
+	}
+
+	var errs fieldErrors
+	req, err := pki.ParseCSR([]byte(csr.Request))
+	if err != nil {
+		errs.add(types.CauseTypeFieldValueInvalid, "request", "Invalid value: "+err.Error())
+	} else if err := req.CheckSignature(); err != nil {
+		errs.add(types.CauseTypeFieldValueInvalid, "request", "Invalid value: "+err.Error())
+	}
+	usage := x509.ExtKeyUsageClientAuth
+	switch csr.Usage {
+	case types.CertificateUsageClient:
+	case types.CertificateUsageServer:
+		usage = x509.ExtKeyUsageServerAuth
+	default:
+		errs.notSupported("usage", csr.Usage, string(types.CertificateUsageClient), string(types.CertificateUsageServer))
+	}
+	if len(errs) > 0 {
+		writeInvalid(w, "certificatesigningrequest", "", errs)
+		return
+	}
+
+	user, _ := auth.UserFrom(r.Context())
+	if ok, reason := csrAllowed(user, req, csr.Usage, s.nodeHosts); !ok {
+		writeStatus(w, types.Status{Code: http.StatusForbidden, Reason: types.StatusReasonForbidden, Message: reason})
+		return
+	}
+
+	cert, err := s.CA.Sign(req, usage)
+	if err != nil {
+		log.Printf("api: sign certificate for %s: %v", req.Subject.CommonName, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	csr.Certificate = string(cert)
+	csr.CA = string(s.CA.CertPEM)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, csr)
+}
+
+// csrAllowed decides what certificates the CA hands out: members of
+// system:masters get any, everyone else only those of nodes. A joining
+// node (system:bootstrappers) may get a node's client certificate, a node
+// its own client and serving certificates. A serving certificate may only
+// name what nodeHosts returns for the node, anything else would let it
+// pose as the API server or another kubelet.
+func csrAllowed(user types.UserInfo, req *x509.CertificateRequest, usage types.CertificateUsage, nodeHosts func(node string) []string) (bool, string) {
+	if slices.Contains(user.Groups, auth.GroupMasters) {
+		return true, ""
+	}
+	node, ok := strings.CutPrefix(req.Subject.CommonName, auth.NodeUserPrefix)
+	if !ok || node == "" || !slices.Equal(req.Subject.Organization, []string{auth.GroupNodes}) {
+		return false, fmt.Sprintf("only node certificates are signed, with common name %s<node> and organization %s", auth.NodeUserPrefix, auth.GroupNodes)
+	}
+	if len(req.EmailAddresses) > 0 || len(req.URIs) > 0 {
+		return false, "node certificates can only name DNS names and IPs"
+	}
+	if usage == types.CertificateUsageClient && (len(req.DNSNames) > 0 || len(req.IPAddresses) > 0) {
+		return false, "client certificates can't name hosts"
+	}
+
+	self, isNode := auth.NodeName(user)
+	if !(isNode && self == node || usage == types.CertificateUsageClient && slices.Contains(user.Groups, auth.GroupBootstrappers)) {
+		return false, fmt.Sprintf("user %q can't get a %s certificate for node %q", user.Name, usage, node)
+	}
+	if usage == types.CertificateUsageServer {
+		allowed := nodeHosts(node)
+		for _, name := range req.DNSNames {
+			if !slices.Contains(allowed, name) {
+				return false, fmt.Sprintf("serving certificate of node %q can only name %s, not %s", node, strings.Join(allowed, ", "), name)
+			}
+		}
+		for _, ip := range req.IPAddresses {
+			if !slices.ContainsFunc(allowed, func(host string) bool { return ip.Equal(net.ParseIP(host)) }) {
+				return false, fmt.Sprintf("serving certificate of node %q can only name %s, not %s", node, strings.Join(allowed, ", "), ip)
+			}
+		}
+	}
+	return true, ""
+}
+
+// nodeHosts are the names a node's serving certificate may carry: its name
+// and the host of the address it advertises, once it registered. The node
+// writes that address itself, so the API server's ServingHosts and the names
+// and addresses of other nodes are left out.
+func (s *Server) nodeHosts(name string) []string {
+	node, _ := s.NodeStore.Get(name)
+	taken := slices.Clone(s.ServingHosts)
+	for _, other := range s.NodeStore.List() {
+		if other.Name != name {
+			taken = append(taken, other.Name, addressHost(other.Address))
+		}
+	}
+
+	var hosts []string
+	for _, host := range []string{name, addressHost(node.Address)} {
+		if host != "" && !slices.ContainsFunc(taken, func(t string) bool { return sameHost(t, host) }) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// addressHost is the host of a node's host:port address.
+func addressHost(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+// sameHost compares names as they are and IPs by value.
+func sameHost(a, b string) bool {
+	if ipA, ipB := net.ParseIP(a), net.ParseIP(b); ipA != nil && ipB != nil {
+		return ipA.Equal(ipB)
+	}
+	return a == b
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"miniku/pkg/auth"
+	"miniku/pkg/pki"
+	"miniku/pkg/types"
+)
+
+func TestCertificateSigning(t *testing.T) {
+	node1 := pkix.Name{CommonName: "system:node:node-1", Organization: []string{auth.GroupNodes}}
+	node3 := pkix.Name{CommonName: "system:node:node-3", Organization: []string{auth.GroupNodes}}
+	node4 := pkix.Name{CommonName: "system:node:node-4", Organization: []string{auth.GroupNodes}}
+
+	tests := []struct {
+		name       string
+		token      string
+		subject    pkix.Name
+		hosts      []string
+		usage      types.CertificateUsage
+		wantStatus int
+	}{
+		{"joining node", "abcdef.0123456789abcdef", node1, nil, types.CertificateUsageClient, http.StatusCreated},
+		{"joining node serving", "abcdef.0123456789abcdef", node1, []string{"node-1"}, types.CertificateUsageServer, http.StatusForbidden},
+		{"joining as admin", "abcdef.0123456789abcdef", pkix.Name{CommonName: "root", Organization: []string{auth.GroupMasters}}, nil, types.CertificateUsageClient, http.StatusForbidden},
+		{"node renewing", "node-1", node1, nil, types.CertificateUsageClient, http.StatusCreated},
+		{"node serving", "node-1", node1, []string{"node-1", "10.0.0.1"}, types.CertificateUsageServer, http.StatusCreated},
+		{"node serving other IP", "node-1", node1, []string{"node-1", "10.96.0.1"}, types.CertificateUsageServer, http.StatusForbidden},
+		{"node serving other name", "node-1", node1, []string{"apiserver"}, types.CertificateUsageServer, http.StatusForbidden},
+		{"node serving as localhost", "node-1", node1, []string{"127.0.0.1"}, types.CertificateUsageServer, http.StatusForbidden},
+		// the address is the node's own say, it can't claim the API
+		// server's or another node's
+		{"node advertising the API server's IP", "node-3", node3, []string{"node-3", "10.0.0.100"}, types.CertificateUsageServer, http.StatusForbidden},
+		{"node advertising another node's IP", "node-4", node4, []string{"node-4", "10.0.0.2"}, types.CertificateUsageServer, http.StatusForbidden},
+		{"node serving as another node", "node-3", node3, []string{"node-1"}, types.CertificateUsageServer, http.StatusForbidden},
+		{"admin serving any name", "admin", node1, []string{"apiserver", "10.96.0.1"}, types.CertificateUsageServer, http.StatusCreated},
+		{"client with hosts", "node-1", node1, []string{"node-1"}, types.CertificateUsageClient, http.StatusForbidden},
+		{"other node", "node-2", node1, []string{"node-1"}, types.CertificateUsageServer, http.StatusForbidden},
+		{"admin", "admin", pkix.Name{CommonName: "alice"}, nil, types.CertificateUsageClient, http.StatusCreated},
+		{"scheduler", "scheduler", node1, nil, types.CertificateUsageClient, http.StatusForbidden},
+		{"anonymous", "", node1, nil, types.CertificateUsageClient, http.StatusUnauthorized},
+		{"bad usage", "admin", node1, nil, "signing", http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _, _, nodeStore := newTestServer()
+			nodeStore.Put("node-1", types.Node{Name: "node-1", Status: types.NodeStateReady, Address: "10.0.0.1:10250"})
+			nodeStore.Put("node-2", types.Node{Name: "node-2", Status: types.NodeStateReady, Address: "10.0.0.2:10250"})
+			nodeStore.Put("node-3", types.Node{Name: "node-3", Status: types.NodeStateReady, Address: "10.0.0.100:10250"})
+			nodeStore.Put("node-4", types.Node{Name: "node-4", Status: types.NodeStateReady, Address: "10.0.0.2:10250"})
+			srv.ServingHosts = []string{"apiserver", "10.0.0.100"}
+			ca, err := pki.NewCA("test-ca")
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv.CA = ca
+			joinTokens, err := auth.NewJoinTokens([]string{"abcdef.0123456789abcdef"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv.Authenticator = auth.Union{joinTokens, auth.NewTokenFile(map[string]types.UserInfo{
+				"admin":     {Name: "root", Groups: []string{auth.GroupMasters}},
+				"scheduler": {Name: auth.UserScheduler},
+				"node-1":    {Name: "system:node:node-1", Groups: []string{auth.GroupNodes}},
+				"node-2":    {Name: "system:node:node-2", Groups: []string{auth.GroupNodes}},
+				"node-3":    {Name: "system:node:node-3", Groups: []string{auth.GroupNodes}},
+				"node-4":    {Name: "system:node:node-4", Groups: []string{auth.GroupNodes}},
+			})}
+			srv.Authorizer = &auth.RBAC{Roles: srv.RoleStore, Bindings: srv.RoleBindingStore}
+			ts := httptest.NewServer(srv.Routes())
+			defer ts.Close()
+
+			key, err := pki.NewKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			csr, err := pki.NewCSR(key, tt.subject, tt.hosts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := json.Marshal(types.CertificateSigningRequest{Request: string(csr), Usage: tt.usage})
+			req, _ := http.NewRequest("POST", ts.URL+"/certificatesigningrequests", bytes.NewReader(body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if resp.StatusCode != http.StatusCreated {
+				return
+			}
+			var signed types.CertificateSigningRequest
+			if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+				t.Fatal(err)
+			}
+			if signed.Certificate == "" || signed.CA != string(ca.CertPEM) {
+				t.Errorf("got %+v, want the certificate and the CA", signed)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"maps"
+
+	"miniku/pkg/types"
+)
+
+// Objects are defaulted before they are validated, so what is stored spells
+// out what the components act on.
+
+func defaultPod(pod *types.Pod) {
+	if pod.Status == "" {
+		pod.Status = types.PodStatusPending
+	}
+}
+
+// defaultReplicaSet selects the pods of the template when the ReplicaSet
+// doesn't say which.
+func defaultReplicaSet(rs *types.ReplicaSet) {
+	if len(rs.Selector) == 0 {
+		rs.Selector = maps.Clone(rs.Template.Labels)
+	}
+}
+
+func defaultDeployment(d *types.Deployment) {
+	if len(d.Selector) == 0 {
+		d.Selector = maps.Clone(d.Template.Labels)
+	}
+}
+
+func defaultService(svc *types.Service) {
+	for i := range svc.Ports {
+		if svc.Ports[i].Protocol == "" {
+			svc.Ports[i].Protocol = types.ProtocolTCP
+		}
+	}
+}
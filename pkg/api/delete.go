@@ -0,0 +1,126 @@
+package api
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"miniku/pkg/admission"
+	"miniku/pkg/store"
+	"miniku/pkg/types"
+)
+
+func metaOf[T any](obj *T) *types.ObjectMeta {
+	return any(obj).(types.Object).GetObjectMeta()
+}
+
+// serveDelete answers DELETE on the object at key. The propagationPolicy
+// query parameter decides what happens to its dependents: Background, the
+// default, removes the object and leaves them to the garbage collector.
+// Foreground and Orphan add the finalizer of the same name, so the object is
+// only marked deleted and answered with 202 until the garbage collector
+// deleted or released its dependents.
+//
+// A kind with a grace period is marked deleted the same way, with the
+// deletionGracePeriodSeconds given by gracePeriodSeconds, until it runs out
+// and whoever cleans up after the object deletes it again with
+// gracePeriodSeconds=0. A deletion can be hurried along that way but never
+// held up longer.
+//
+// The delete is admitted by chain before anything happens to the object.
+func serveDelete[T any](w http.ResponseWriter, r *http.Request, chain admission.Chain, res resource[T], key string) {
+	var finalizer string
+	switch policy := types.DeletionPropagation(cmp.Or(r.URL.Query().Get("propagationPolicy"), string(types.DeletePropagationBackground))); {
+	case policy == types.DeletePropagationBackground:
+	case !res.collected:
+		http.Error(w, "propagationPolicy "+string(policy)+" does not apply to a "+res.kind, http.StatusBadRequest)
+		return
+	case policy == types.DeletePropagationForeground:
+		finalizer = types.FinalizerForegroundDeletion
+	case policy == types.DeletePropagationOrphan:
+		finalizer = types.FinalizerOrphan
+	default:
+		http.Error(w, "unknown propagationPolicy "+string(policy), http.StatusBadRequest)
+		return
+	}
+	var requested *int64
+	if v := r.URL.Query().Get("gracePeriodSeconds"); v != "" {
+		seconds, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || seconds < 0 {
+			http.Error(w, "invalid gracePeriodSeconds "+v, http.StatusBadRequest)
+			return
+		}
+		requested = &seconds
+	}
+
+	obj, ok := res.store.Get(key)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := chain.Validate(r.Context(), newAttributes(types.AdmissionDelete, res.resource, nil, &obj)); err != nil {
+		writeAdmissionError(w, err)
+		return
+	}
+	var grace int64
+	if res.gracePeriod != nil {
+		grace = res.gracePeriod(obj, requested)
+	}
+
+	meta := metaOf(&obj)
+	changed := true
+	switch {
+	case !meta.Deleting():
+		if finalizer != "" {
+			meta.Finalizers = append(slices.Clone(meta.Finalizers), finalizer)
+		}
+		now := time.Now()
+		meta.DeletionTimestamp = &now
+		if grace > 0 {
+			meta.DeletionGracePeriodSeconds = &grace
+		}
+	case meta.DeletionGracePeriodSeconds != nil && grace < *meta.DeletionGracePeriodSeconds:
+		meta.DeletionGracePeriodSeconds = &grace
+	default:
+		changed = false
+	}
+
+	if meta.Finalized() {
+		res.store.Delete(key)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if changed {
+		var err error
+		if obj, err = res.store.Update(key, obj); err != nil {
+			writeStoreError(w, err, res.kind)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, obj)
+}
+
+// keepDeletion carries the deletion mark of the stored object at key over to
+// obj, it is only ever set through DELETE.
+func keepDeletion[T any](st store.Store[T], key string, obj *T) {
+	var deleted *time.Time
+	var grace *int64
+	if current, ok := st.Get(key); ok {
+		deleted = metaOf(&current).DeletionTimestamp
+		grace = metaOf(&current).DeletionGracePeriodSeconds
+	}
+	metaOf(obj).DeletionTimestamp = deleted
+	metaOf(obj).DeletionGracePeriodSeconds = grace
+}
+
+// removeFinalized removes obj, just written to key, if it was deleted and
+// the write cleared the last finalizer holding it.
+func removeFinalized[T any](st store.Store[T], key string, obj T) {
+	if metaOf(&obj).Finalized() {
+		st.Delete(key)
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"miniku/pkg/types"
+)
+
+func TestDeletePropagation(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		finalizers    []string
+		wantStatus    int
+		wantKept      bool
+		wantFinalizer string
+	}{
+		{"background by default", "", nil, http.StatusNoContent, false, ""},
+		{"background", "?propagationPolicy=Background", nil, http.StatusNoContent, false, ""},
+		{"foreground", "?propagationPolicy=Foreground", nil, http.StatusAccepted, true, types.FinalizerForegroundDeletion},
+		{"orphan", "?propagationPolicy=Orphan", nil, http.StatusAccepted, true, types.FinalizerOrphan},
+		{"held by a finalizer", "", []string{"example.com/cleanup"}, http.StatusAccepted, true, "example.com/cleanup"},
+		{"unknown policy", "?propagationPolicy=Sometimes", nil, http.StatusBadRequest, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _, rsStore, _ := newTestServer()
+			rsStore.Put("default/web", types.ReplicaSet{ObjectMeta: types.ObjectMeta{Finalizers: tt.finalizers}, Name: "web"})
+
+			req := httptest.NewRequest("DELETE", "/replicasets/web"+tt.query, nil)
+			req.SetPathValue("name", "web")
+			rec := httptest.NewRecorder()
+			srv.handleDeleteReplicaSet(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			rs, ok := rsStore.Get("default/web")
+			if ok != tt.wantKept {
+				t.Fatalf("got kept %v, want %v", ok, tt.wantKept)
+			}
+			if tt.wantFinalizer == "" {
+				return
+			}
+			if !rs.Deleting() || !slices.Contains(rs.Finalizers, tt.wantFinalizer) {
+				t.Errorf("got deletionTimestamp %v and finalizers %v, want it marked and %s", rs.DeletionTimestamp, rs.Finalizers, tt.wantFinalizer)
+			}
+		})
+	}
+}
+
+func TestDeleteAfterFinalizers(t *testing.T) {
+	srv, _, rsStore, _ := newTestServer()
+	ts := httptest.NewServer(srv.Routes())
+	defer ts.Close()
+	rsStore.Put("default/web", types.ReplicaSet{Name: "web", Selector: map[string]string{"app": "web"}, Template: types.PodSpec{Image: "nginx", Labels: map[string]string{"app": "web"}}})
+
+	del := func() int {
+		t.Helper()
+		req, _ := http.NewRequest("DELETE", ts.URL+"/replicasets/web?propagationPolicy=Orphan", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := del(); got != http.StatusAccepted {
+		t.Fatalf("first delete: got status %d, want 202", got)
+	}
+	if got := del(); got != http.StatusAccepted {
+		t.Errorf("delete while finalizing: got status %d, want 202", got)
+	}
+
+	// an update can't take the deletion mark away, clearing the last
+	// finalizer removes the object
+	req, _ := http.NewRequest("PUT", ts.URL+"/replicasets/web", strings.NewReader(`{"name":"web","selector":{"app":"web"},"template":{"image":"nginx","labels":{"app":"web"}}}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update: got status %d, want 200", resp.StatusCode)
+	}
+	if rs, ok := rsStore.Get("default/web"); ok {
+		t.Fatalf("got %+v, want the replicaset gone with its finalizer", rs)
+	}
+
+	if got := del(); got != http.StatusNoContent {
+		t.Errorf("delete once gone: got status %d, want 204", got)
+	}
+}
+
+func TestDeletePodGracePeriod(t *testing.T) {
+	five := int64(5)
+	scheduled := types.PodSpec{Name: "web", NodeName: "node-1"}
+	tests := []struct {
+		name       string
+		pod        types.Pod
+		query      string
+		wantStatus int
+		wantGrace  int64
+	}{
+		{"unscheduled goes right away", types.Pod{Spec: types.PodSpec{Name: "web"}}, "", http.StatusNoContent, 0},
+		{"finished goes right away", types.Pod{Spec: scheduled, Status: types.PodStatusSucceeded}, "", http.StatusNoContent, 0},
+		{"default grace period", types.Pod{Spec: scheduled}, "", http.StatusAccepted, types.DefaultTerminationGracePeriodSeconds},
+		{"grace period of the spec", types.Pod{Spec: types.PodSpec{Name: "web", NodeName: "node-1", TerminationGracePeriodSeconds: &five}}, "", http.StatusAccepted, 5},
+		{"requested grace period", types.Pod{Spec: scheduled}, "?gracePeriodSeconds=10", http.StatusAccepted, 10},
+		{"forced", types.Pod{Spec: scheduled}, "?gracePeriodSeconds=0", http.StatusNoContent, 0},
+		{"negative grace period", types.Pod{Spec: scheduled}, "?gracePeriodSeconds=-1", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, podStore, _, _ := newTestServer()
+			podStore.Put("default/web", tt.pod)
+
+			req := httptest.NewRequest("DELETE", "/pods/web"+tt.query, nil)
+			req.SetPathValue("name", "web")
+			rec := httptest.NewRecorder()
+			srv.handleDeletePod(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			pod, ok := podStore.Get("default/web")
+			if ok != (tt.wantStatus != http.StatusNoContent) {
+				t.Fatalf("got kept %v after status %d", ok, rec.Code)
+			}
+			if tt.wantStatus != http.StatusAccepted {
+				return
+			}
+			if !pod.Deleting() || pod.DeletionGracePeriodSeconds == nil || *pod.DeletionGracePeriodSeconds != tt.wantGrace {
+				t.Errorf("got deletionTimestamp %v and grace %v, want it marked with %ds", pod.DeletionTimestamp, pod.DeletionGracePeriodSeconds, tt.wantGrace)
+			}
+		})
+	}
+}
+
+func TestDeletePodShortensGrace(t *testing.T) {
+	srv, podStore, _, _ := newTestServer()
+	podStore.Put("default/web", types.Pod{Spec: types.PodSpec{Name: "web", NodeName: "node-1"}})
+
+	del := func(query string) (int, *int64) {
+		t.Helper()
+		req := httptest.NewRequest("DELETE", "/pods/web"+query, nil)
+		req.SetPathValue("name", "web")
+		rec := httptest.NewRecorder()
+		srv.handleDeletePod(rec, req)
+		pod, _ := podStore.Get("default/web")
+		return rec.Code, pod.DeletionGracePeriodSeconds
+	}
+
+	steps := []struct {
+		query      string
+		wantStatus int
+		wantGrace  int64
+	}{
+		{"", http.StatusAccepted, 30},
+		{"?gracePeriodSeconds=10", http.StatusAccepted, 10},
+		// a longer one doesn't hold it up
+		{"?gracePeriodSeconds=60", http.StatusAccepted, 10},
+		{"", http.StatusAccepted, 10},
+		{"?gracePeriodSeconds=0", http.StatusNoContent, 0},
+	}
+	for _, step := range steps {
+		status, grace := del(step.query)
+		if status != step.wantStatus {
+			t.Fatalf("DELETE%s: got status %d, want %d", step.query, status, step.wantStatus)
+		}
+		if status == http.StatusAccepted && (grace == nil || *grace != step.wantGrace) {
+			t.Errorf("DELETE%s: got grace %v, want %d", step.query, grace, step.wantGrace)
+		}
+	}
+	if _, ok := podStore.Get("default/web"); ok {
+		t.Error("expected the pod to be gone")
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"miniku/pkg/selector"
+	"miniku/pkg/types"
+)
+
+func (s *Server) handleListDeployments(w http.ResponseWriter, r *http.Request) {
+	serveList(w, r, deploymentResource(s.DeploymentStore))
+}
+
+func (s *Server) handleCreateDeployment(w http.ResponseWriter, r *http.Request) {
+	var d types.Deployment
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !setNamespace(w, r, &d) {
+		return
+	}
+	defaultDeployment(&d)
+	if !s.mutate(w, r, types.AdmissionCreate, "deployments", &d, nil) {
+		return
+	}
+	if errs := validateDeployment(d); len(errs) > 0 {
+		writeInvalid(w, "deployment", d.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionCreate, "deployments", &d, nil) {
+		return
+	}
+
+	d = s.DeploymentStore.Put(namespacedKey(d), d)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, d)
+}
+
+func (s *Server) handleGetDeployment(w http.ResponseWriter, r *http.Request) {
+	key := objectKey(r)
+
+	d, ok := s.DeploymentStore.Get(key)
+	if !ok {
+		http.Error(w, "deployment not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, d)
+}
+
+func (s *Server) handleUpdateDeployment(w http.ResponseWriter, r *http.Request) {
+	key := objectKey(r)
+
+	var d types.Deployment
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !setNamespace(w, r, &d) {
+		return
+	}
+	current, ok := s.DeploymentStore.Get(key)
+	if !ok {
+		http.Error(w, "deployment not found", http.StatusNotFound)
+		return
+	}
+	if staleUpdate(w, &d, &current, "deployment") {
+		return
+	}
+	defaultDeployment(&d)
+	if !s.mutate(w, r, types.AdmissionUpdate, "deployments", &d, &current) {
+		return
+	}
+	if errs := validateDeploymentUpdate(d, current); len(errs) > 0 {
+		writeInvalid(w, "deployment", d.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionUpdate, "deployments", &d, &current) {
+		return
+	}
+	keepDeletion(s.DeploymentStore, key, &d)
+
+	d, err := s.DeploymentStore.Update(key, d)
+	if err != nil {
+		writeStoreError(w, err, "deployment")
+		return
+	}
+	removeFinalized(s.DeploymentStore, key, d)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, d)
+}
+
+func (s *Server) handlePatchDeployment(w http.ResponseWriter, r *http.Request) {
+	servePatch(w, r, deploymentResource(s.DeploymentStore), objectKey(r), s.handleUpdateDeployment)
+}
+
+func (s *Server) handleDeleteDeployment(w http.ResponseWriter, r *http.Request) {
+	serveDelete(w, r, s.admissionChain(), deploymentResource(s.DeploymentStore), objectKey(r))
+}
+
+// handleRollbackDeployment copies the pod template of an earlier revision
+// back into the deployment, the controller then rolls it out like any other
+// template change. It is the template as the deployment had it, so it hashes
+// the same and the revision's ReplicaSet is scaled up again.
+func (s *Server) handleRollbackDeployment(w http.ResponseWriter, r *http.Request) {
+	key := objectKey(r)
+
+	var req types.DeploymentRollback
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	d, ok := s.DeploymentStore.Get(key)
+	if !ok {
+		http.Error(w, "deployment not found", http.StatusNotFound)
+		return
+	}
+
+	history := slices.DeleteFunc(s.RSStore.Select(selector.FromSet(d.Selector)), func(rs types.ReplicaSet) bool {
+		return rs.Namespace != d.Namespace
+	})
+	rs, ok := revisionFor(history, req.Revision)
+	if !ok {
+		msg := fmt.Sprintf("revision %d not found", req.Revision)
+		if req.Revision == 0 {
+			msg = "no previous revision to roll back to"
+		}
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+
+	current := d
+	if rs.DeploymentTemplate != nil {
+		d.Template = *rs.DeploymentTemplate
+	} else {
+		// made before ReplicaSets kept the template, the labels the
+		// controller merged in from the selector stay
+		d.Template = rs.Template
+		d.Template.Labels = maps.Clone(rs.Template.Labels)
+		delete(d.Template.Labels, types.PodTemplateHashLabel)
+	}
+	if !s.mutate(w, r, types.AdmissionUpdate, "deployments", &d, &current) ||
+		!s.admit(w, r, types.AdmissionUpdate, "deployments", &d, &current) {
+		return
+	}
+
+	d, err := s.DeploymentStore.Update(key, d)
+	if err != nil {
+		writeStoreError(w, err, "deployment")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, d)
+}
+
+// revisionFor picks the ReplicaSet of the given revision out of a
+// deployment's history, or the one before the latest for revision zero.
+func revisionFor(history []types.ReplicaSet, revision uint64) (types.ReplicaSet, bool) {
+	history = slices.DeleteFunc(history, func(rs types.ReplicaSet) bool { return rs.Revision == 0 })
+	slices.SortFunc(history, func(a, b types.ReplicaSet) int { return cmp.Compare(b.Revision, a.Revision) })
+
+	if revision == 0 {
+		if len(history) < 2 {
+			return types.ReplicaSet{}, false
+		}
+		return history[1], true
+	}
+	for _, rs := range history {
+		if rs.Revision == revision {
+			return rs, true
+		}
+	}
+	return types.ReplicaSet{}, false
+}
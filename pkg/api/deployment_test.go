@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"miniku/pkg/types"
+)
+
+func TestRollbackDeployment(t *testing.T) {
+	history := []types.ReplicaSet{
+		{Name: "web-a", Revision: 1, Labels: map[string]string{"app": "web"},
+			Template: types.PodSpec{Image: "nginx:1", Labels: map[string]string{"app": "web", types.PodTemplateHashLabel: "a"}}},
+		{Name: "web-b", Revision: 2, Labels: map[string]string{"app": "web"},
+			Template: types.PodSpec{Image: "nginx:2", Labels: map[string]string{"app": "web", types.PodTemplateHashLabel: "b"}}},
+		{Name: "web-c", Revision: 3, Labels: map[string]string{"app": "web"},
+			Template: types.PodSpec{Image: "nginx:3", Labels: map[string]string{"app": "web", types.PodTemplateHashLabel: "c"}}},
+		{Name: "other", Revision: 4, Labels: map[string]string{"app": "other"},
+			Template: types.PodSpec{Image: "redis"}},
+		// the template as the deployment had it is restored, not the
+		// ReplicaSet's with the labels the controller added
+		{Name: "web-e", Revision: 5, Labels: map[string]string{"app": "web"},
+			Template:           types.PodSpec{Image: "nginx:5", Labels: map[string]string{"app": "web", "added": "x", types.PodTemplateHashLabel: "e"}},
+			DeploymentTemplate: &types.PodSpec{Image: "nginx:5", Labels: map[string]string{"app": "web"}}},
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantImage  string
+		wantLabels map[string]string
+	}{
+		{"to previous revision", `{}`, http.StatusOK, "nginx:3", map[string]string{"app": "web"}},
+		{"to given revision", `{"revision":1}`, http.StatusOK, "nginx:1", map[string]string{"app": "web"}},
+		{"to the deployment's template", `{"revision":5}`, http.StatusOK, "nginx:5", map[string]string{"app": "web"}},
+		{"other deployment's revision", `{"revision":4}`, http.StatusNotFound, "", nil},
+		{"unknown revision", `{"revision":9}`, http.StatusNotFound, "", nil},
+		{"invalid body", `{`, http.StatusBadRequest, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _, rsStore, _ := newTestServer()
+			for _, rs := range history {
+				rsStore.Put(types.Key(rs.Namespace, rs.Name), rs)
+			}
+			srv.DeploymentStore.Put("default/web", types.Deployment{
+				Name:     "web",
+				Selector: map[string]string{"app": "web"},
+				Template: types.PodSpec{Image: "nginx:3", Labels: map[string]string{"app": "web"}},
+			})
+
+			req := httptest.NewRequest("POST", "/deployments/web/rollback", strings.NewReader(tt.body))
+			req.SetPathValue("name", "web")
+			rec := httptest.NewRecorder()
+
+			srv.handleRollbackDeployment(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var d types.Deployment
+			if err := json.NewDecoder(rec.Body).Decode(&d); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if d.Template.Image != tt.wantImage {
+				t.Errorf("got image %q, want %q", d.Template.Image, tt.wantImage)
+			}
+			if !maps.Equal(d.Template.Labels, tt.wantLabels) {
+				t.Errorf("got template labels %v, want %v", d.Template.Labels, tt.wantLabels)
+			}
+		})
+	}
+}
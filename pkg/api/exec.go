@@ -0,0 +1,29 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"net/http/httputil"
+)
+
+// handlePodExec proxies an exec to the kubelet of the pod's node. The
+// connection is upgraded end to end, once the kubelet switched protocols the
+// proxy just copies bytes both ways until either side closes.
+func (s *Server) handlePodExec(w http.ResponseWriter, r *http.Request) {
+	target, ok := s.kubeletURL(w, r, "exec")
+	if !ok {
+		return
+	}
+	proxy := &httputil.ReverseProxy{
+		Transport: s.kubeletClient().Transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = target
+			pr.Out.Host = target.Host
+		},
+		ErrorHandler: func(w http.ResponseWriter, _ *http.Request, err error) {
+			log.Printf("api: exec in pod %s: %v", r.PathValue("name"), err)
+			http.Error(w, "kubelet unreachable: "+err.Error(), http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
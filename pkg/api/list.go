@@ -0,0 +1,287 @@
+package api
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"miniku/pkg/selector"
+	"miniku/pkg/store"
+	"miniku/pkg/types"
+)
+
+// resource describes one kind of object: how list and watch requests select
+// it and how DELETE treats it.
+type resource[T any] struct {
+	store store.Store[T]
+	// kind names the objects in error messages
+	kind string
+	// resource is the collection in URLs and admission, e.g. pods
+	resource string
+	key      func(T) string
+	// fields returns the values field selectors can match on
+	fields func(T) map[string]string
+	// collected is set for the kinds the garbage collector follows, only
+	// they take a propagationPolicy other than Background
+	collected bool
+	// gracePeriod returns how many seconds a deleted object is kept for
+	// someone to clean up after it, given the gracePeriodSeconds asked for.
+	// Nil means none.
+	gracePeriod func(obj T, requested *int64) int64
+}
+
+// namespacedKey is the store key of a namespaced object.
+func namespacedKey[T any](t T) string {
+	obj := any(&t).(store.Namespaced)
+	return types.Key(obj.GetNamespace(), obj.GetName())
+}
+
+func podResource(st store.PodStore) resource[types.Pod] {
+	return resource[types.Pod]{
+		store:    st,
+		kind:     "pod",
+		resource: "pods",
+		key:      namespacedKey[types.Pod],
+		fields: func(p types.Pod) map[string]string {
+			return map[string]string{
+				"spec.name":      p.Spec.Name,
+				"spec.namespace": p.Spec.Namespace,
+				"spec.node_name": p.Spec.NodeName,
+				"spec.image":     p.Spec.Image,
+				"status":         string(p.Status),
+			}
+		},
+		collected: true,
+		gracePeriod: func(p types.Pod, requested *int64) int64 {
+			// nothing runs that would have to be stopped
+			if p.Spec.NodeName == "" || p.Finished() {
+				return 0
+			}
+			if requested != nil {
+				return *requested
+			}
+			return int64(p.Spec.GracePeriod() / time.Second)
+		},
+	}
+}
+
+func replicaSetResource(st store.ReplicaSetStore) resource[types.ReplicaSet] {
+	return resource[types.ReplicaSet]{
+		store:    st,
+		kind:     "replicaset",
+		resource: "replicasets",
+		key:      namespacedKey[types.ReplicaSet],
+		fields: func(rs types.ReplicaSet) map[string]string {
+			return map[string]string{"name": rs.Name, "namespace": rs.Namespace}
+		},
+		collected: true,
+	}
+}
+
+func deploymentResource(st store.DeploymentStore) resource[types.Deployment] {
+	return resource[types.Deployment]{
+		store:    st,
+		kind:     "deployment",
+		resource: "deployments",
+		key:      namespacedKey[types.Deployment],
+		fields: func(d types.Deployment) map[string]string {
+			return map[string]string{"name": d.Name, "namespace": d.Namespace}
+		},
+		collected: true,
+	}
+}
+
+func serviceResource(st store.ServiceStore) resource[types.Service] {
+	return resource[types.Service]{
+		store:    st,
+		kind:     "service",
+		resource: "services",
+		key:      namespacedKey[types.Service],
+		fields: func(svc types.Service) map[string]string {
+			return map[string]string{"name": svc.Name, "namespace": svc.Namespace, "clusterIP": svc.ClusterIP}
+		},
+	}
+}
+
+func endpointsResource(st store.EndpointsStore) resource[types.Endpoints] {
+	return resource[types.Endpoints]{
+		store:    st,
+		kind:     "endpoints",
+		resource: "endpoints",
+		key:      namespacedKey[types.Endpoints],
+		fields: func(ep types.Endpoints) map[string]string {
+			return map[string]string{"name": ep.Name, "namespace": ep.Namespace}
+		},
+	}
+}
+
+func namespaceResource(st store.NamespaceStore) resource[types.Namespace] {
+	return resource[types.Namespace]{
+		store:    st,
+		kind:     "namespace",
+		resource: "namespaces",
+		key:      func(ns types.Namespace) string { return ns.Name },
+		fields: func(ns types.Namespace) map[string]string {
+			return map[string]string{"name": ns.Name, "phase": string(ns.Phase)}
+		},
+	}
+}
+
+func nodeResource(st store.NodeStore) resource[types.Node] {
+	return resource[types.Node]{
+		store:    st,
+		kind:     "node",
+		resource: "nodes",
+		key:      func(n types.Node) string { return n.Name },
+		fields: func(n types.Node) map[string]string {
+			return map[string]string{"name": n.Name, "status": string(n.Status)}
+		},
+	}
+}
+
+func roleResource(st store.RoleStore) resource[types.Role] {
+	return resource[types.Role]{
+		store:    st,
+		kind:     "role",
+		resource: "roles",
+		key:      func(role types.Role) string { return role.Name },
+		fields: func(role types.Role) map[string]string {
+			return map[string]string{"name": role.Name}
+		},
+	}
+}
+
+func roleBindingResource(st store.RoleBindingStore) resource[types.RoleBinding] {
+	return resource[types.RoleBinding]{
+		store:    st,
+		kind:     "rolebinding",
+		resource: "rolebindings",
+		key:      func(b types.RoleBinding) string { return b.Name },
+		fields: func(b types.RoleBinding) map[string]string {
+			return map[string]string{"name": b.Name, "role": b.Role, "namespace": b.Namespace}
+		},
+	}
+}
+
+// listQuery holds the ?labelSelector= and ?fieldSelector= of a request, and
+// the namespace of a namespaced route.
+type listQuery struct {
+	labels    selector.Selector
+	fields    selector.Selector
+	namespace string
+}
+
+func parseListQuery(r *http.Request) (listQuery, error) {
+	q := listQuery{namespace: r.PathValue("namespace")}
+	var err error
+	if q.labels, err = selector.ParseLabels(r.URL.Query().Get("labelSelector")); err != nil {
+		return q, err
+	}
+	if q.fields, err = selector.ParseFields(r.URL.Query().Get("fieldSelector")); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+func (q listQuery) Empty() bool {
+	return q.labels.Empty() && q.fields.Empty() && q.namespace == ""
+}
+
+// inNamespace reports whether obj is in the namespace asked for. Without
+// one, on the routes outside /namespaces, everything is.
+func (q listQuery) inNamespace(obj any) bool {
+	if q.namespace == "" {
+		return true
+	}
+	n, ok := obj.(store.Namespaced)
+	return !ok || cmp.Or(n.GetNamespace(), types.DefaultNamespace) == q.namespace
+}
+
+// serveList answers GET on a collection, streaming a watch if asked to.
+// X-Resource-Version carries the store's version, to watch from.
+// Label selectors go through the store's label index; field selectors are
+// evaluated on what it returns.
+func serveList[T any](w http.ResponseWriter, r *http.Request, res resource[T]) {
+	q, err := parseListQuery(r)
+	if err == nil {
+		err = res.checkFields(q.fields)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if isWatch(r) {
+		serveWatch(w, r, res, q)
+		return
+	}
+
+	// taken before listing, so a watch resumed from it can only replay
+	// changes the list already has, never miss one
+	w.Header().Set("X-Resource-Version", strconv.FormatUint(res.store.Version(), 10))
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, res.list(q))
+}
+
+// checkFields refuses a field selector on a field the kind doesn't support,
+// which would otherwise select nothing or, negated, everything.
+func (res resource[T]) checkFields(sel selector.Selector) error {
+	var zero T
+	supported := slices.Sorted(maps.Keys(res.fields(zero)))
+	for _, req := range sel {
+		if !slices.Contains(supported, req.Key) {
+			return fmt.Errorf("field selector %q not supported for %s, use one of %s", req.Key, res.resource, strings.Join(supported, ", "))
+		}
+	}
+	return nil
+}
+
+// list returns the objects q selects.
+func (res resource[T]) list(q listQuery) []T {
+	items := res.store.Select(q.labels)
+	out := make([]T, 0, len(items))
+	for _, item := range items {
+		if q.inNamespace(&item) && q.fields.Matches(res.fields(item)) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func (res resource[T]) matches(q listQuery, obj T) bool {
+	var labels map[string]string
+	if l, ok := any(&obj).(store.Labeled); ok {
+		labels = l.GetLabels()
+	}
+	return q.inNamespace(&obj) && q.labels.Matches(labels) && q.fields.Matches(res.fields(obj))
+}
+
+// filter narrows a watch event down to the selected objects. An object that
+// stops matching is reported as DELETED, one that starts matching as ADDED,
+// judged by the object the event replaced, so a resumed watch only deletes
+// what the client had. False means the event doesn't concern the watch.
+func (res resource[T]) filter(q listQuery, ev types.WatchEvent[T]) (types.WatchEvent[T], bool) {
+	if q.Empty() {
+		return ev, true
+	}
+
+	matches := res.matches(q, ev.Object)
+	matched := ev.Previous != nil && res.matches(q, *ev.Previous)
+	switch {
+	case ev.Type == types.EventDeleted:
+		// Object is the last state, the client had it if it matched
+		return ev, matches
+	case matches && !matched:
+		ev.Type = types.EventAdded
+	case matched && !matches:
+		ev.Type = types.EventDeleted
+	case !matches:
+		return ev, false
+	}
+	return ev, true
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// handleGetPodLog proxies to the kubelet of the pod's node, which serves the
+// logs from its runtime. The query (follow, tail, previous, timestamps) is
+// passed through as is.
+func (s *Server) handleGetPodLog(w http.ResponseWriter, r *http.Request) {
+	target, ok := s.kubeletURL(w, r, "log")
+	if !ok {
+		return
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, target.String(), nil)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	resp, err := s.kubeletClient().Do(req)
+	if err != nil {
+		http.Error(w, "kubelet unreachable: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	copyFlushing(w, resp.Body)
+}
+
+// kubeletURL is where the kubelet of the pod named in r serves subresource.
+// When the pod can't be reached an error response has been sent already.
+func (s *Server) kubeletURL(w http.ResponseWriter, r *http.Request, subresource string) (*url.URL, bool) {
+	pod, ok := s.PodStore.Get(objectKey(r))
+	if !ok {
+		http.Error(w, "pod not found", http.StatusNotFound)
+		return nil, false
+	}
+	if pod.Spec.NodeName == "" {
+		http.Error(w, "pod is not scheduled yet", http.StatusBadRequest)
+		return nil, false
+	}
+	node, ok := s.NodeStore.Get(pod.Spec.NodeName)
+	if !ok || node.Address == "" {
+		http.Error(w, "no address for node "+pod.Spec.NodeName, http.StatusBadGateway)
+		return nil, false
+	}
+
+	scheme := "http"
+	if s.KubeletTLS != nil {
+		scheme = "https"
+	}
+	return &url.URL{
+		Scheme:   scheme,
+		Host:     node.Address,
+		Path:     "/namespaces/" + url.PathEscape(namespaceOf(r)) + "/pods/" + url.PathEscape(r.PathValue("name")) + "/" + subresource,
+		RawQuery: r.URL.RawQuery,
+	}, true
+}
+
+// kubeletClient talks to kubelets, over https with KubeletTLS.
+func (s *Server) kubeletClient() *http.Client {
+	s.kubeletOnce.Do(func() {
+		s.kubeletTransport = http.DefaultTransport
+		if s.KubeletTLS != nil {
+			t := http.DefaultTransport.(*http.Transport).Clone()
+			t.TLSClientConfig = s.KubeletTLS
+			s.kubeletTransport = t
+		}
+	})
+	return &http.Client{Transport: s.kubeletTransport}
+}
+
+// copyFlushing copies r to w, flushing after every read so a followed log
+// streams through instead of sitting in a buffer.
+func copyFlushing(w http.ResponseWriter, r io.Reader) {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if rc.Flush() != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"miniku/pkg/selector"
+	"miniku/pkg/types"
+)
+
+// ensureDefaultNamespace creates the default namespace unless it exists, it
+// is where everything without a namespace goes.
+func (s *Server) ensureDefaultNamespace() {
+	if _, ok := s.NamespaceStore.Get(types.DefaultNamespace); !ok {
+		s.NamespaceStore.Put(types.DefaultNamespace, types.Namespace{
+			Name:  types.DefaultNamespace,
+			Phase: types.NamespaceActive,
+		})
+	}
+}
+
+func (s *Server) handleListNamespaces(w http.ResponseWriter, r *http.Request) {
+	serveList(w, r, namespaceResource(s.NamespaceStore))
+}
+
+func (s *Server) handleCreateNamespace(w http.ResponseWriter, r *http.Request) {
+	var ns types.Namespace
+	if err := json.NewDecoder(r.Body).Decode(&ns); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !s.mutate(w, r, types.AdmissionCreate, "namespaces", &ns, nil) {
+		return
+	}
+	if errs := validateNamespace(ns); len(errs) > 0 {
+		writeInvalid(w, "namespace", ns.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionCreate, "namespaces", &ns, nil) {
+		return
+	}
+
+	ns.Phase = types.NamespaceActive
+	ns = s.NamespaceStore.Put(ns.Name, ns)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, ns)
+}
+
+func (s *Server) handleGetNamespace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("namespace")
+
+	ns, ok := s.NamespaceStore.Get(name)
+	if !ok {
+		http.Error(w, "namespace not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, ns)
+}
+
+func (s *Server) handleUpdateNamespace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("namespace")
+
+	var ns types.Namespace
+	if err := json.NewDecoder(r.Body).Decode(&ns); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// the phase only moves through DELETE, a terminating namespace stays so
+	current, ok := s.NamespaceStore.Get(name)
+	if !ok {
+		http.Error(w, "namespace not found", http.StatusNotFound)
+		return
+	}
+	if staleUpdate(w, &ns, &current, "namespace") {
+		return
+	}
+	ns.Phase = current.Phase
+	if !s.mutate(w, r, types.AdmissionUpdate, "namespaces", &ns, &current) {
+		return
+	}
+	if errs := validateNamespaceUpdate(ns, current); len(errs) > 0 {
+		writeInvalid(w, "namespace", ns.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionUpdate, "namespaces", &ns, &current) {
+		return
+	}
+	keepDeletion(s.NamespaceStore, name, &ns)
+
+	ns, err := s.NamespaceStore.Update(name, ns)
+	if err != nil {
+		writeStoreError(w, err, "namespace")
+		return
+	}
+	removeFinalized(s.NamespaceStore, name, ns)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, ns)
+}
+
+func (s *Server) handlePatchNamespace(w http.ResponseWriter, r *http.Request) {
+	servePatch(w, r, namespaceResource(s.NamespaceStore), r.PathValue("namespace"), s.handleUpdateNamespace)
+}
+
+// handleDeleteNamespace marks a namespace Terminating and answers 202, the
+// namespace controller then deletes what is in it. Once it is empty, deleting
+// it again removes it for good with 204, or once its finalizers are cleared.
+func (s *Server) handleDeleteNamespace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("namespace")
+
+	ns, ok := s.NamespaceStore.Get(name)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionDelete, "namespaces", nil, &ns) {
+		return
+	}
+
+	if ns.Phase != types.NamespaceTerminating {
+		ns.Phase = types.NamespaceTerminating
+		ns, err := s.NamespaceStore.Update(name, ns)
+		if err != nil {
+			writeStoreError(w, err, "namespace")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		writeJSON(w, ns)
+		return
+	}
+
+	if !s.namespaceEmpty(name) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		writeJSON(w, ns)
+		return
+	}
+
+	// admitted above already
+	serveDelete(w, r, nil, namespaceResource(s.NamespaceStore), name)
+}
+
+// namespaceEmpty reports whether nothing is left in namespace name,
+// RoleBindings included so a namespace of the same name doesn't inherit
+// their grants.
+func (s *Server) namespaceEmpty(name string) bool {
+	q := listQuery{namespace: name}
+	// RoleBindings aren't stored by namespace, they name it in a field
+	bindings := listQuery{fields: selector.FromSet(map[string]string{"namespace": name})}
+	return len(podResource(s.PodStore).list(q)) == 0 &&
+		len(replicaSetResource(s.RSStore).list(q)) == 0 &&
+		len(deploymentResource(s.DeploymentStore).list(q)) == 0 &&
+		len(serviceResource(s.ServiceStore).list(q)) == 0 &&
+		len(endpointsResource(s.EndpointsStore).list(q)) == 0 &&
+		(s.RoleBindingStore == nil || len(roleBindingResource(s.RoleBindingStore).list(bindings)) == 0)
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"miniku/pkg/types"
+)
+
+func TestNamespacedPods(t *testing.T) {
+	srv, podStore, _, _ := newTestServer()
+	srv.NamespaceStore.Put("shop", types.Namespace{Name: "shop", Phase: types.NamespaceActive})
+	ts := httptest.NewServer(srv.Routes())
+	defer ts.Close()
+
+	// the same name in two namespaces are two pods
+	for _, ns := range []string{"default", "shop"} {
+		body := `{"spec":{"name":"web","image":"nginx:` + ns + `"}}`
+		resp, err := http.Post(ts.URL+"/namespaces/"+ns+"/pods", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create in %s: got status %d, want 201", ns, resp.StatusCode)
+		}
+	}
+	if pod, ok := podStore.Get("shop/web"); !ok || pod.Spec.Image != "nginx:shop" || pod.Spec.Namespace != "shop" {
+		t.Errorf("got %+v, want the pod stored under shop/web", pod)
+	}
+
+	tests := []struct {
+		path     string
+		wantPods int
+	}{
+		{"/pods", 2},
+		{"/namespaces/shop/pods", 1},
+		{"/namespaces/default/pods", 1},
+		{"/pods?fieldSelector=spec.namespace=shop", 1},
+		{"/namespaces/other/pods", 0},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pods []types.Pod
+		err = json.NewDecoder(resp.Body).Decode(&pods)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pods) != tt.wantPods {
+			t.Errorf("GET %s: got %d pods, want %d", tt.path, len(pods), tt.wantPods)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/namespaces/shop/pods/web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pod types.Pod
+	err = json.NewDecoder(resp.Body).Decode(&pod)
+	_ = resp.Body.Close()
+	if err != nil || pod.Spec.Image != "nginx:shop" {
+		t.Errorf("got %+v (%v), want the pod of namespace shop", pod, err)
+	}
+}
+
+func TestCreateInNamespace(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"existing namespace", "/namespaces/shop/pods", `{"spec":{"name":"web","image":"nginx"}}`, http.StatusCreated},
+		{"bare route is the default namespace", "/pods", `{"spec":{"name":"web","image":"nginx","namespace":"shop"}}`, http.StatusBadRequest},
+		{"missing namespace", "/namespaces/nope/pods", `{"spec":{"name":"web","image":"nginx"}}`, http.StatusNotFound},
+		{"terminating namespace", "/namespaces/old/pods", `{"spec":{"name":"web","image":"nginx"}}`, http.StatusForbidden},
+		{"namespace mismatch", "/namespaces/shop/pods", `{"spec":{"name":"web","image":"nginx","namespace":"old"}}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _, _, _ := newTestServer()
+			srv.NamespaceStore.Put("shop", types.Namespace{Name: "shop", Phase: types.NamespaceActive})
+			srv.NamespaceStore.Put("old", types.Namespace{Name: "old", Phase: types.NamespaceTerminating})
+			ts := httptest.NewServer(srv.Routes())
+			defer ts.Close()
+
+			resp, err := http.Post(ts.URL+tt.path, "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestDeleteNamespace(t *testing.T) {
+	srv, podStore, _, _ := newTestServer()
+	ts := httptest.NewServer(srv.Routes())
+	defer ts.Close()
+
+	del := func(name string) int {
+		t.Helper()
+		req, _ := http.NewRequest("DELETE", ts.URL+"/namespaces/"+name, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := del("default"); got != http.StatusForbidden {
+		t.Errorf("delete default: got status %d, want 403", got)
+	}
+
+	resp, err := http.Post(ts.URL+"/namespaces", "application/json", strings.NewReader(`{"name":"shop"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create namespace: got status %d, want 201", resp.StatusCode)
+	}
+	podStore.Put("shop/web", types.Pod{Spec: types.PodSpec{Name: "web", Namespace: "shop"}})
+
+	if got := del("shop"); got != http.StatusAccepted {
+		t.Errorf("first delete: got status %d, want 202", got)
+	}
+	if ns, _ := srv.NamespaceStore.Get("shop"); ns.Phase != types.NamespaceTerminating {
+		t.Errorf("got phase %q, want Terminating", ns.Phase)
+	}
+	if got := del("shop"); got != http.StatusAccepted {
+		t.Errorf("delete while not empty: got status %d, want 202", got)
+	}
+
+	podStore.Delete("shop/web")
+	srv.RoleBindingStore.Put("shop-admins", types.RoleBinding{Name: "shop-admins", Role: "cluster-admin", Namespace: "shop"})
+	if got := del("shop"); got != http.StatusAccepted {
+		t.Errorf("delete with a RoleBinding left: got status %d, want 202", got)
+	}
+
+	srv.RoleBindingStore.Delete("shop-admins")
+	if got := del("shop"); got != http.StatusNoContent {
+		t.Errorf("delete once empty: got status %d, want 204", got)
+	}
+	if _, ok := srv.NamespaceStore.Get("shop"); ok {
+		t.Error("expected the namespace to be gone")
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"miniku/pkg/jsonpatch"
+	"miniku/pkg/types"
+)
+
+// maxPatchAttempts is how often a patch is applied again to an object
+// someone else wrote in the meantime before the conflict is given back.
+const maxPatchAttempts = 5
+
+// servePatch answers PATCH on the object at key. The patch is applied to the
+// stored object according to the Content-Type, a types.PatchType, and the
+// result goes through update, the PUT handler of the kind, so it is
+// defaulted, validated and admitted like any other update.
+//
+// The patched object carries the resourceVersion it was made from. When
+// another write came in between, the patch is applied again to the new
+// object. A JSON patch whose test doesn't hold is answered with 409.
+func servePatch[T any](w http.ResponseWriter, r *http.Request, res resource[T], key string, update http.HandlerFunc) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	apply, err := patchFunc(r.Header.Get("Content-Type"), body)
+	if errors.Is(err, errUnsupportedPatch) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid patch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		current, ok := res.store.Get(key)
+		if !ok {
+			http.Error(w, res.kind+" not found", http.StatusNotFound)
+			return
+		}
+		doc, err := json.Marshal(current)
+		if err != nil {
+			log.Printf("encode %s %s: %v", res.kind, key, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		patched, err := apply(doc)
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			writeStatus(w, types.Status{
+				Code:    http.StatusConflict,
+				Reason:  types.StatusReasonConflict,
+				Message: "patch of " + res.kind + " " + key + " failed: " + err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			writeStatus(w, types.Status{
+				Code:    http.StatusUnprocessableEntity,
+				Reason:  types.StatusReasonInvalid,
+				Message: "patch of " + res.kind + " " + key + " can't be applied: " + err.Error(),
+			})
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(patched))
+		r.ContentLength = int64(len(patched))
+		if attempt == maxPatchAttempts {
+			update(w, r)
+			return
+		}
+		buf := &bufferedResponse{header: make(http.Header), code: http.StatusOK}
+		update(buf, r)
+		if buf.code != http.StatusConflict {
+			buf.flush(w)
+			return
+		}
+	}
+}
+
+var errUnsupportedPatch = errors.New("unsupported patch type, use " + string(types.MergePatchType) + " or " + string(types.JSONPatchType))
+
+// patchFunc parses a patch of contentType, returning what applies it to a
+// JSON document.
+func patchFunc(contentType string, body []byte) (func(doc []byte) ([]byte, error), error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch types.PatchType(mediaType) {
+	case types.MergePatchType:
+		if !json.Valid(body) {
+			return nil, errors.New("merge patch is not valid JSON")
+		}
+		return func(doc []byte) ([]byte, error) { return jsonpatch.MergePatch(doc, body) }, nil
+	case types.JSONPatchType:
+		patch, err := jsonpatch.Decode(body)
+		if err != nil {
+			return nil, err
+		}
+		return patch.Apply, nil
+	}
+	return nil, errUnsupportedPatch
+}
+
+// bufferedResponse holds back the answer of an update, one that lost a
+// race is retried rather than sent.
+type bufferedResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) WriteHeader(code int)        { b.code = code }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedResponse) flush(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(b.code)
+	if _, err := b.body.WriteTo(w); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
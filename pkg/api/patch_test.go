@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"miniku/pkg/types"
+)
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantReason  types.StatusReason
+		check       func(t *testing.T, srv *Server)
+	}{
+		{
+			name:        "merge patch of pod",
+			path:        "/pods/web",
+			contentType: string(types.MergePatchType),
+			body:        `{"reason":"Evicted","spec":{"labels":{"tier":"front","app":null}}}`,
+			wantStatus:  http.StatusOK,
+			check: func(t *testing.T, srv *Server) {
+				pod, _ := srv.PodStore.Get("default/web")
+				if pod.Reason != "Evicted" || pod.Spec.Image != "nginx" {
+					t.Errorf("got reason %q image %q, want Evicted and nginx", pod.Reason, pod.Spec.Image)
+				}
+				if len(pod.Spec.Labels) != 1 || pod.Spec.Labels["tier"] != "front" {
+					t.Errorf("got labels %v, want only tier=front", pod.Spec.Labels)
+				}
+			},
+		},
+		{
+			name:        "JSON patch binds pod",
+			path:        "/pods/web",
+			contentType: string(types.JSONPatchType),
+			body:        `[{"op":"test","path":"/spec/node_name","value":""},{"op":"replace","path":"/spec/node_name","value":"node-1"}]`,
+			wantStatus:  http.StatusOK,
+			check: func(t *testing.T, srv *Server) {
+				if pod, _ := srv.PodStore.Get("default/web"); pod.Spec.NodeName != "node-1" {
+					t.Errorf("got node %q, want node-1", pod.Spec.NodeName)
+				}
+			},
+		},
+		{
+			name:        "failed test",
+			path:        "/pods/web",
+			contentType: string(types.JSONPatchType),
+			body:        `[{"op":"test","path":"/spec/node_name","value":"node-2"},{"op":"replace","path":"/spec/node_name","value":"node-1"}]`,
+			wantStatus:  http.StatusConflict,
+			wantReason:  types.StatusReasonConflict,
+		},
+		{
+			name:        "immutable field",
+			path:        "/pods/web",
+			contentType: string(types.MergePatchType) + "; charset=utf-8",
+			body:        `{"spec":{"image":"redis"}}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantReason:  types.StatusReasonInvalid,
+		},
+		{
+			name:        "path not in object",
+			path:        "/pods/web",
+			contentType: string(types.JSONPatchType),
+			body:        `[{"op":"remove","path":"/spec/nope"}]`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantReason:  types.StatusReasonInvalid,
+		},
+		{
+			name:        "malformed patch",
+			path:        "/pods/web",
+			contentType: string(types.JSONPatchType),
+			body:        `[{"op":"add","path":"/reason"}]`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "unsupported patch type",
+			path:        "/pods/web",
+			contentType: "application/json",
+			body:        `{"reason":"Evicted"}`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "missing pod",
+			path:        "/pods/nope",
+			contentType: string(types.MergePatchType),
+			body:        `{"reason":"Evicted"}`,
+			wantStatus:  http.StatusNotFound,
+		},
+		{
+			name:        "merge patch of node",
+			path:        "/nodes/node-1",
+			contentType: string(types.MergePatchType),
+			body:        `{"labels":{"zone":"a"}}`,
+			wantStatus:  http.StatusOK,
+			check: func(t *testing.T, srv *Server) {
+				node, _ := srv.NodeStore.Get("node-1")
+				if node.Labels["zone"] != "a" || node.Status != types.NodeStateReady {
+					t.Errorf("got node %+v, want zone=a and still Ready", node)
+				}
+			},
+		},
+		{
+			name:        "merge patch of replicaset",
+			path:        "/replicasets/web",
+			contentType: string(types.MergePatchType),
+			body:        `{"currentCount":0,"readyCount":2}`,
+			wantStatus:  http.StatusOK,
+			check: func(t *testing.T, srv *Server) {
+				rs, _ := srv.RSStore.Get("default/web")
+				if rs.CurrentCount != 0 || rs.ReadyCount != 2 || rs.DesiredCount != 3 {
+					t.Errorf("got counts %d/%d/%d, want desired 3, current 0, ready 2", rs.DesiredCount, rs.CurrentCount, rs.ReadyCount)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, podStore, rsStore, nodeStore := newTestServer()
+			podStore.Put("default/web", types.Pod{Spec: types.PodSpec{Name: "web", Namespace: "default", Image: "nginx",
+				Labels: map[string]string{"app": "web"}}, Status: types.PodStatusPending})
+			nodeStore.Put("node-1", types.Node{Name: "node-1", Status: types.NodeStateReady})
+			rsStore.Put("default/web", types.ReplicaSet{Name: "web", Namespace: "default", DesiredCount: 3, CurrentCount: 3,
+				Selector: map[string]string{"app": "web"}, Template: types.PodSpec{Image: "nginx", Labels: map[string]string{"app": "web"}}})
+			ts := httptest.NewServer(srv.Routes())
+			defer ts.Close()
+
+			req, _ := http.NewRequest(http.MethodPatch, ts.URL+tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantReason != "" {
+				var status types.Status
+				if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+					t.Fatal(err)
+				}
+				if status.Reason != tt.wantReason {
+					t.Errorf("got reason %q, want %q", status.Reason, tt.wantReason)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, srv)
+			}
+		})
+	}
+}
+
+// TestPatchRetriesConflict has another writer get in between the patch
+// being applied and stored, the patch is applied again to what it wrote.
+func TestPatchRetriesConflict(t *testing.T) {
+	srv, podStore, _, _ := newTestServer()
+	podStore.Put("default/web", types.Pod{Spec: types.PodSpec{Name: "web", Namespace: "default", Image: "nginx"}})
+
+	calls := 0
+	update := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			pod, _ := podStore.Get("default/web")
+			pod.Message = "written in between"
+			podStore.Put("default/web", pod)
+		}
+		srv.handleUpdatePod(w, r)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, "/pods/web", strings.NewReader(`{"reason":"Evicted"}`))
+	req.Header.Set("Content-Type", string(types.MergePatchType))
+	req.SetPathValue("name", "web")
+	rec := httptest.NewRecorder()
+	servePatch(rec, req, podResource(podStore), "default/web", update)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if calls != 2 {
+		t.Errorf("update called %d times, want 2", calls)
+	}
+	pod, _ := podStore.Get("default/web")
+	if pod.Reason != "Evicted" || pod.Message != "written in between" {
+		t.Errorf("got reason %q message %q, want both writes kept", pod.Reason, pod.Message)
+	}
+}
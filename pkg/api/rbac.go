@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"miniku/pkg/auth"
+	"miniku/pkg/types"
+)
+
+// ensureBootstrapPolicy creates the bootstrap roles and bindings that are
+// missing, ones that were changed are left as they are.
+func (s *Server) ensureBootstrapPolicy() {
+	if s.RoleStore == nil || s.RoleBindingStore == nil {
+		return
+	}
+	for _, role := range auth.BootstrapRoles() {
+		if _, ok := s.RoleStore.Get(role.Name); !ok {
+			s.RoleStore.Put(role.Name, role)
+		}
+	}
+	for _, b := range auth.BootstrapRoleBindings() {
+		if _, ok := s.RoleBindingStore.Get(b.Name); !ok {
+			s.RoleBindingStore.Put(b.Name, b)
+		}
+	}
+}
+
+func (s *Server) handleListRoles(w http.ResponseWriter, r *http.Request) {
+	serveList(w, r, roleResource(s.RoleStore))
+}
+
+func (s *Server) handleCreateRole(w http.ResponseWriter, r *http.Request) {
+	var role types.Role
+	if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !s.mutate(w, r, types.AdmissionCreate, "roles", &role, nil) {
+		return
+	}
+	if errs := validateRole(role); len(errs) > 0 {
+		writeInvalid(w, "role", role.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionCreate, "roles", &role, nil) {
+		return
+	}
+
+	role = s.RoleStore.Put(role.Name, role)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, role)
+}
+
+func (s *Server) handleGetRole(w http.ResponseWriter, r *http.Request) {
+	role, ok := s.RoleStore.Get(r.PathValue("name"))
+	if !ok {
+		http.Error(w, "role not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, role)
+}
+
+func (s *Server) handleUpdateRole(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var role types.Role
+	if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	current, ok := s.RoleStore.Get(name)
+	if !ok {
+		http.Error(w, "role not found", http.StatusNotFound)
+		return
+	}
+	if staleUpdate(w, &role, &current, "role") {
+		return
+	}
+	if !s.mutate(w, r, types.AdmissionUpdate, "roles", &role, &current) {
+		return
+	}
+	if errs := validateRoleUpdate(role, current); len(errs) > 0 {
+		writeInvalid(w, "role", role.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionUpdate, "roles", &role, &current) {
+		return
+	}
+	keepDeletion(s.RoleStore, name, &role)
+
+	role, err := s.RoleStore.Update(name, role)
+	if err != nil {
+		writeStoreError(w, err, "role")
+		return
+	}
+	removeFinalized(s.RoleStore, name, role)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, role)
+}
+
+func (s *Server) handlePatchRole(w http.ResponseWriter, r *http.Request) {
+	servePatch(w, r, roleResource(s.RoleStore), r.PathValue("name"), s.handleUpdateRole)
+}
+
+func (s *Server) handleDeleteRole(w http.ResponseWriter, r *http.Request) {
+	serveDelete(w, r, s.admissionChain(), roleResource(s.RoleStore), r.PathValue("name"))
+}
+
+func (s *Server) handleListRoleBindings(w http.ResponseWriter, r *http.Request) {
+	serveList(w, r, roleBindingResource(s.RoleBindingStore))
+}
+
+func (s *Server) handleCreateRoleBinding(w http.ResponseWriter, r *http.Request) {
+	var b types.RoleBinding
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !s.mutate(w, r, types.AdmissionCreate, "rolebindings", &b, nil) {
+		return
+	}
+	if errs := validateRoleBinding(b); len(errs) > 0 {
+		writeInvalid(w, "rolebinding", b.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionCreate, "rolebindings", &b, nil) {
+		return
+	}
+
+	b = s.RoleBindingStore.Put(b.Name, b)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, b)
+}
+
+func (s *Server) handleGetRoleBinding(w http.ResponseWriter, r *http.Request) {
+	b, ok := s.RoleBindingStore.Get(r.PathValue("name"))
+	if !ok {
+		http.Error(w, "rolebinding not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, b)
+}
+
+func (s *Server) handleUpdateRoleBinding(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var b types.RoleBinding
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	current, ok := s.RoleBindingStore.Get(name)
+	if !ok {
+		http.Error(w, "rolebinding not found", http.StatusNotFound)
+		return
+	}
+	if staleUpdate(w, &b, &current, "rolebinding") {
+		return
+	}
+	if !s.mutate(w, r, types.AdmissionUpdate, "rolebindings", &b, &current) {
+		return
+	}
+	if errs := validateRoleBindingUpdate(b, current); len(errs) > 0 {
+		writeInvalid(w, "rolebinding", b.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionUpdate, "rolebindings", &b, &current) {
+		return
+	}
+	keepDeletion(s.RoleBindingStore, name, &b)
+
+	b, err := s.RoleBindingStore.Update(name, b)
+	if err != nil {
+		writeStoreError(w, err, "rolebinding")
+		return
+	}
+	removeFinalized(s.RoleBindingStore, name, b)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, b)
+}
+
+func (s *Server) handlePatchRoleBinding(w http.ResponseWriter, r *http.Request) {
+	servePatch(w, r, roleBindingResource(s.RoleBindingStore), r.PathValue("name"), s.handleUpdateRoleBinding)
+}
+
+func (s *Server) handleDeleteRoleBinding(w http.ResponseWriter, r *http.Request) {
+	serveDelete(w, r, s.admissionChain(), roleBindingResource(s.RoleBindingStore), r.PathValue("name"))
+}
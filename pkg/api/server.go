@@ -1,69 +1,258 @@
 /// Main API architecture:
 // Pods:
-//   POST /pods
-//   GET /pods
-//   GET /pods/{name}
-//   PUT /pods/{name}
-//   DELETE /pods/{name}
+//   POST /namespaces/{namespace}/pods
+//   GET /namespaces/{namespace}/pods
+//   GET /namespaces/{namespace}/pods?watch=true[&resourceVersion=N]
+//   GET /namespaces/{namespace}/pods?labelSelector=app=web,tier!=cache&fieldSelector=spec.node_name=node-1
+//   GET /namespaces/{namespace}/pods/{name}
+//   PUT /namespaces/{namespace}/pods/{name}
+//   PATCH /namespaces/{namespace}/pods/{name}
+//   DELETE /namespaces/{namespace}/pods/{name}
+//   GET /namespaces/{namespace}/pods/{name}/log[?follow=true&tail=N&previous=true&timestamps=true]
+//   POST /namespaces/{namespace}/pods/{name}/exec?command=...[&stdin=true&tty=true]
 //
 // ReplicaSets:
-//   POST /replicasets
-//   GET /replicasets
-//   GET /replicasets/{name}
-//   PUT /replicasets/{name}
-//   DELETE /replicasets/{name}
+//   POST /namespaces/{namespace}/replicasets
+//   GET /namespaces/{namespace}/replicasets
+//   GET /namespaces/{namespace}/replicasets?watch=true[&resourceVersion=N]
+//   GET /namespaces/{namespace}/replicasets/{name}
+//   PUT /namespaces/{namespace}/replicasets/{name}
+//   PATCH /namespaces/{namespace}/replicasets/{name}
+//   DELETE /namespaces/{namespace}/replicasets/{name}
+//
+// Deployments:
+//   POST /namespaces/{namespace}/deployments
+//   GET /namespaces/{namespace}/deployments
+//   GET /namespaces/{namespace}/deployments?watch=true[&resourceVersion=N]
+//   GET /namespaces/{namespace}/deployments/{name}
+//   PUT /namespaces/{namespace}/deployments/{name}
+//   PATCH /namespaces/{namespace}/deployments/{name}
+//   DELETE /namespaces/{namespace}/deployments/{name}
+//   POST /namespaces/{namespace}/deployments/{name}/rollback
+//
+// Services:
+//   POST /namespaces/{namespace}/services
+//   GET /namespaces/{namespace}/services
+//   GET /namespaces/{namespace}/services?watch=true[&resourceVersion=N]
+//   GET /namespaces/{namespace}/services/{name}
+//   PUT /namespaces/{namespace}/services/{name}
+//   PATCH /namespaces/{namespace}/services/{name}
+//   DELETE /namespaces/{namespace}/services/{name}
+//
+// Endpoints:
+//   POST /namespaces/{namespace}/endpoints
+//   GET /namespaces/{namespace}/endpoints
+//   GET /namespaces/{namespace}/endpoints?watch=true[&resourceVersion=N]
+//   GET /namespaces/{namespace}/endpoints/{name}
+//   PUT /namespaces/{namespace}/endpoints/{name}
+//   PATCH /namespaces/{namespace}/endpoints/{name}
+//   DELETE /namespaces/{namespace}/endpoints/{name}
+//
+// Namespaces:
+//   POST /namespaces
+//   GET /namespaces
+//   GET /namespaces?watch=true[&resourceVersion=N]
+//   GET /namespaces/{namespace}
+//   PUT /namespaces/{namespace}
+//   PATCH /namespaces/{namespace}
+//   DELETE /namespaces/{namespace}
 //
 // Nodes:
 //   POST /nodes
 //   GET /nodes
+//   GET /nodes?watch=true[&resourceVersion=N]
 //   GET /nodes/{name}
 //   PUT /nodes/{name}
+//   PATCH /nodes/{name}
 //   DELETE /nodes/{name}
+//
+// Roles and RoleBindings:
+//   POST /roles, /rolebindings
+//   GET /roles, /rolebindings
+//   GET /roles/{name}, /rolebindings/{name}
+//   PUT /roles/{name}, /rolebindings/{name}
+//   PATCH /roles/{name}, /rolebindings/{name}
+//   DELETE /roles/{name}, /rolebindings/{name}
+//
+// Certificates:
+//   POST /certificatesigningrequests
 
 package api
 
 import (
+	"cmp"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"miniku/pkg/admission"
+	"miniku/pkg/auth"
+	"miniku/pkg/pki"
 	"miniku/pkg/store"
 	"miniku/pkg/types"
 	"net/http"
+	"strings"
+	"sync"
 )
 
 type Server struct {
 	PodStore  store.PodStore
 	RSStore   store.ReplicaSetStore
 	NodeStore store.NodeStore
+
+	DeploymentStore store.DeploymentStore
+	ServiceStore    store.ServiceStore
+	EndpointsStore  store.EndpointsStore
+	NamespaceStore  store.NamespaceStore
+
+	RoleStore        store.RoleStore
+	RoleBindingStore store.RoleBindingStore
+
+	// ServiceCIDR is the range cluster IPs are allocated from,
+	// DefaultServiceCIDR when empty.
+	ServiceCIDR string
+	// Authenticator finds out who sends a request, without one every
+	// request is auth.Anonymous.
+	Authenticator auth.Authenticator
+	// Authorizer decides what they may do, nil allows everything.
+	Authorizer auth.Authorizer
+	// CA signs the certificate requests of nodes, nil turns that off.
+	CA *pki.CA
+	// ServingHosts are the names and IPs the API server is reached at, no
+	// node gets a serving certificate for them.
+	ServingHosts []string
+	// KubeletTLS is how logs and exec reach kubelets over https: the CA of
+	// their serving certificates and the API server's client certificate.
+	// nil talks plain HTTP.
+	KubeletTLS *tls.Config
+	// Admission is consulted on every create, update and delete, after the
+	// built-in namespace lifecycle checks.
+	Admission admission.Chain
+	// serviceMu keeps two services from getting the same cluster IP
+	serviceMu sync.Mutex
+
+	kubeletOnce      sync.Once
+	kubeletTransport http.RoundTripper
 }
 
 func (s *Server) Routes() http.Handler {
+	s.ensureDefaultNamespace()
+	s.ensureBootstrapPolicy()
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("GET /pods", s.handleListPods)
-	mux.HandleFunc("POST /pods", s.handleCreatePod)
-	mux.HandleFunc("GET /pods/{name}", s.handleGetPod)
-	mux.HandleFunc("PUT /pods/{name}", s.handleUpdatePod)
-	mux.HandleFunc("DELETE /pods/{name}", s.handleDeletePod)
+	s.handleNamespaced(mux, "GET /pods", s.handleListPods)
+	s.handleNamespaced(mux, "POST /pods", s.handleCreatePod)
+	s.handleNamespaced(mux, "GET /pods/{name}", s.handleGetPod)
+	s.handleNamespaced(mux, "PUT /pods/{name}", s.handleUpdatePod)
+	s.handleNamespaced(mux, "PATCH /pods/{name}", s.handlePatchPod)
+	s.handleNamespaced(mux, "DELETE /pods/{name}", s.handleDeletePod)
+	s.handleNamespaced(mux, "GET /pods/{name}/log", s.handleGetPodLog)
+	s.handleNamespaced(mux, "POST /pods/{name}/exec", s.handlePodExec)
+
+	s.handleNamespaced(mux, "GET /replicasets", s.handleListReplicaSets)
+	s.handleNamespaced(mux, "POST /replicasets", s.handleCreateReplicaSet)
+	s.handleNamespaced(mux, "GET /replicasets/{name}", s.handleGetReplicaSet)
+	s.handleNamespaced(mux, "PUT /replicasets/{name}", s.handleUpdateReplicaSet)
+	s.handleNamespaced(mux, "PATCH /replicasets/{name}", s.handlePatchReplicaSet)
+	s.handleNamespaced(mux, "DELETE /replicasets/{name}", s.handleDeleteReplicaSet)
+
+	s.handleNamespaced(mux, "GET /deployments", s.handleListDeployments)
+	s.handleNamespaced(mux, "POST /deployments", s.handleCreateDeployment)
+	s.handleNamespaced(mux, "GET /deployments/{name}", s.handleGetDeployment)
+	s.handleNamespaced(mux, "PUT /deployments/{name}", s.handleUpdateDeployment)
+	s.handleNamespaced(mux, "PATCH /deployments/{name}", s.handlePatchDeployment)
+	s.handleNamespaced(mux, "DELETE /deployments/{name}", s.handleDeleteDeployment)
+	s.handleNamespaced(mux, "POST /deployments/{name}/rollback", s.handleRollbackDeployment)
+
+	s.handleNamespaced(mux, "GET /services", s.handleListServices)
+	s.handleNamespaced(mux, "POST /services", s.handleCreateService)
+	s.handleNamespaced(mux, "GET /services/{name}", s.handleGetService)
+	s.handleNamespaced(mux, "PUT /services/{name}", s.handleUpdateService)
+	s.handleNamespaced(mux, "PATCH /services/{name}", s.handlePatchService)
+	s.handleNamespaced(mux, "DELETE /services/{name}", s.handleDeleteService)
+
+	s.handleNamespaced(mux, "GET /endpoints", s.handleListEndpoints)
+	s.handleNamespaced(mux, "POST /endpoints", s.handleCreateEndpoints)
+	s.handleNamespaced(mux, "GET /endpoints/{name}", s.handleGetEndpoints)
+	s.handleNamespaced(mux, "PUT /endpoints/{name}", s.handleUpdateEndpoints)
+	s.handleNamespaced(mux, "PATCH /endpoints/{name}", s.handlePatchEndpoints)
+	s.handleNamespaced(mux, "DELETE /endpoints/{name}", s.handleDeleteEndpoints)
+
+	s.handle(mux, "GET /namespaces", s.handleListNamespaces)
+	s.handle(mux, "POST /namespaces", s.handleCreateNamespace)
+	s.handle(mux, "GET /namespaces/{namespace}", s.handleGetNamespace)
+	s.handle(mux, "PUT /namespaces/{namespace}", s.handleUpdateNamespace)
+	s.handle(mux, "PATCH /namespaces/{namespace}", s.handlePatchNamespace)
+	s.handle(mux, "DELETE /namespaces/{namespace}", s.handleDeleteNamespace)
+
+	s.handle(mux, "GET /nodes", s.handleListNodes)
+	s.handle(mux, "POST /nodes", s.handleCreateNode)
+	s.handle(mux, "GET /nodes/{name}", s.handleGetNode)
+	s.handle(mux, "PUT /nodes/{name}", s.handleUpdateNode)
+	s.handle(mux, "PATCH /nodes/{name}", s.handlePatchNode)
+	s.handle(mux, "DELETE /nodes/{name}", s.handleDeleteNode)
+
+	s.handle(mux, "GET /roles", s.handleListRoles)
+	s.handle(mux, "POST /roles", s.handleCreateRole)
+	s.handle(mux, "GET /roles/{name}", s.handleGetRole)
+	s.handle(mux, "PUT /roles/{name}", s.handleUpdateRole)
+	s.handle(mux, "PATCH /roles/{name}", s.handlePatchRole)
+	s.handle(mux, "DELETE /roles/{name}", s.handleDeleteRole)
+
+	s.handle(mux, "GET /rolebindings", s.handleListRoleBindings)
+	s.handle(mux, "POST /rolebindings", s.handleCreateRoleBinding)
+	s.handle(mux, "GET /rolebindings/{name}", s.handleGetRoleBinding)
+	s.handle(mux, "PUT /rolebindings/{name}", s.handleUpdateRoleBinding)
+	s.handle(mux, "PATCH /rolebindings/{name}", s.handlePatchRoleBinding)
+	s.handle(mux, "DELETE /rolebindings/{name}", s.handleDeleteRoleBinding)
+
+	s.handle(mux, "POST /certificatesigningrequests", s.handleCreateCSR)
+
+	return s.authenticate(mux)
+}
+
+// handle registers h at pattern for a resource outside namespaces, behind
+// the authorizer.
+func (s *Server) handle(mux *http.ServeMux, pattern string, h http.HandlerFunc) {
+	mux.HandleFunc(pattern, s.authorize(parseRoute(pattern, false), h))
+}
 
-	mux.HandleFunc("GET /replicasets", s.handleListReplicaSets)
-	mux.HandleFunc("POST /replicasets", s.handleCreateReplicaSet)
-	mux.HandleFunc("GET /replicasets/{name}", s.handleGetReplicaSet)
-	mux.HandleFunc("PUT /replicasets/{name}", s.handleUpdateReplicaSet)
-	mux.HandleFunc("DELETE /replicasets/{name}", s.handleDeleteReplicaSet)
+// handleNamespaced registers h under /namespaces/{namespace} and at the bare
+// pattern, where items are in the default namespace and collections list
+// every namespace.
+func (s *Server) handleNamespaced(mux *http.ServeMux, pattern string, h http.HandlerFunc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	h = s.authorize(parseRoute(pattern, true), h)
+	mux.HandleFunc(pattern, h)
+	mux.HandleFunc(method+" /namespaces/{namespace}"+path, h)
+}
+
+// namespaceOf is the namespace r is addressed to.
+func namespaceOf(r *http.Request) string {
+	return cmp.Or(r.PathValue("namespace"), types.DefaultNamespace)
+}
 
-	mux.HandleFunc("GET /nodes", s.handleListNodes)
-	mux.HandleFunc("POST /nodes", s.handleCreateNode)
-	mux.HandleFunc("GET /nodes/{name}", s.handleGetNode)
-	mux.HandleFunc("PUT /nodes/{name}", s.handleUpdateNode)
-	mux.HandleFunc("DELETE /nodes/{name}", s.handleDeleteNode)
+// objectKey is the store key of the namespaced object r names.
+func objectKey(r *http.Request) string {
+	return types.Key(namespaceOf(r), r.PathValue("name"))
+}
 
-	return mux
+// setNamespace puts obj into the namespace r is addressed to, refusing an
+// object that names another. It returns false once it answered with an
+// error.
+func setNamespace(w http.ResponseWriter, r *http.Request, obj store.Namespaced) bool {
+	ns := namespaceOf(r)
+	if obj.GetNamespace() != "" && obj.GetNamespace() != ns {
+		http.Error(w, fmt.Sprintf("namespace %q does not match the request's %q", obj.GetNamespace(), ns), http.StatusBadRequest)
+		return false
+	}
+	obj.SetNamespace(ns)
+	return true
 }
 
 func (s *Server) handleListPods(w http.ResponseWriter, r *http.Request) {
-	pods := s.PodStore.List()
-	w.Header().Set("Content-Type", "application/json")
-	writeJSON(w, pods)
+	serveList(w, r, podResource(s.PodStore))
 }
 
 func (s *Server) handleCreatePod(w http.ResponseWriter, r *http.Request) {
@@ -72,12 +261,22 @@ func (s *Server) handleCreatePod(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-
-	if pod.Status == "" {
-		pod.Status = types.PodStatusPending
+	if !setNamespace(w, r, &pod) {
+		return
+	}
+	defaultPod(&pod)
+	if !s.mutate(w, r, types.AdmissionCreate, "pods", &pod, nil) {
+		return
+	}
+	if errs := validatePod(pod); len(errs) > 0 {
+		writeInvalid(w, "pod", pod.Spec.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionCreate, "pods", &pod, nil) {
+		return
 	}
 
-	s.PodStore.Put(pod.Spec.Name, pod)
+	pod = s.PodStore.Put(namespacedKey(pod), pod)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -85,9 +284,9 @@ func (s *Server) handleCreatePod(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGetPod(w http.ResponseWriter, r *http.Request) {
-	name := r.PathValue("name")
+	key := objectKey(r)
 
-	pod, ok := s.PodStore.Get(name)
+	pod, ok := s.PodStore.Get(key)
 	if !ok {
 		http.Error(w, "pod not found", http.StatusNotFound)
 		return
@@ -98,30 +297,58 @@ func (s *Server) handleGetPod(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleUpdatePod(w http.ResponseWriter, r *http.Request) {
-	name := r.PathValue("name")
+	key := objectKey(r)
 
 	var pod types.Pod
 	if err := json.NewDecoder(r.Body).Decode(&pod); err != nil {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	if !setNamespace(w, r, &pod) {
+		return
+	}
+	current, ok := s.PodStore.Get(key)
+	if !ok {
+		http.Error(w, "pod not found", http.StatusNotFound)
+		return
+	}
+	if staleUpdate(w, &pod, &current, "pod") {
+		return
+	}
+	defaultPod(&pod)
+	if !s.mutate(w, r, types.AdmissionUpdate, "pods", &pod, &current) {
+		return
+	}
+	if errs := validatePodUpdate(pod, current); len(errs) > 0 {
+		writeInvalid(w, "pod", pod.Spec.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionUpdate, "pods", &pod, &current) {
+		return
+	}
+	keepDeletion(s.PodStore, key, &pod)
 
-	s.PodStore.Put(name, pod)
+	pod, err := s.PodStore.Update(key, pod)
+	if err != nil {
+		writeStoreError(w, err, "pod")
+		return
+	}
+	removeFinalized(s.PodStore, key, pod)
 
 	w.Header().Set("Content-Type", "application/json")
 	writeJSON(w, pod)
 }
 
+func (s *Server) handlePatchPod(w http.ResponseWriter, r *http.Request) {
+	servePatch(w, r, podResource(s.PodStore), objectKey(r), s.handleUpdatePod)
+}
+
 func (s *Server) handleDeletePod(w http.ResponseWriter, r *http.Request) {
-	name := r.PathValue("name")
-	s.PodStore.Delete(name)
-	w.WriteHeader(http.StatusNoContent)
+	serveDelete(w, r, s.admissionChain(), podResource(s.PodStore), objectKey(r))
 }
 
 func (s *Server) handleListReplicaSets(w http.ResponseWriter, r *http.Request) {
-	replicaSets := s.RSStore.List()
-	w.Header().Set("Content-Type", "application/json")
-	writeJSON(w, replicaSets)
+	serveList(w, r, replicaSetResource(s.RSStore))
 }
 
 func (s *Server) handleCreateReplicaSet(w http.ResponseWriter, r *http.Request) {
@@ -130,8 +357,22 @@ func (s *Server) handleCreateReplicaSet(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	if !setNamespace(w, r, &rs) {
+		return
+	}
+	defaultReplicaSet(&rs)
+	if !s.mutate(w, r, types.AdmissionCreate, "replicasets", &rs, nil) {
+		return
+	}
+	if errs := validateReplicaSet(rs); len(errs) > 0 {
+		writeInvalid(w, "replicaset", rs.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionCreate, "replicasets", &rs, nil) {
+		return
+	}
 
-	s.RSStore.Put(rs.Name, rs)
+	rs = s.RSStore.Put(namespacedKey(rs), rs)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -139,9 +380,9 @@ func (s *Server) handleCreateReplicaSet(w http.ResponseWriter, r *http.Request)
 }
 
 func (s *Server) handleGetReplicaSet(w http.ResponseWriter, r *http.Request) {
-	name := r.PathValue("name")
+	key := objectKey(r)
 
-	rs, ok := s.RSStore.Get(name)
+	rs, ok := s.RSStore.Get(key)
 	if !ok {
 		http.Error(w, "replicaset not found", http.StatusNotFound)
 		return
@@ -152,30 +393,58 @@ func (s *Server) handleGetReplicaSet(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleUpdateReplicaSet(w http.ResponseWriter, r *http.Request) {
-	name := r.PathValue("name")
+	key := objectKey(r)
 
 	var rs types.ReplicaSet
 	if err := json.NewDecoder(r.Body).Decode(&rs); err != nil {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	if !setNamespace(w, r, &rs) {
+		return
+	}
+	current, ok := s.RSStore.Get(key)
+	if !ok {
+		http.Error(w, "replicaset not found", http.StatusNotFound)
+		return
+	}
+	if staleUpdate(w, &rs, &current, "replicaset") {
+		return
+	}
+	defaultReplicaSet(&rs)
+	if !s.mutate(w, r, types.AdmissionUpdate, "replicasets", &rs, &current) {
+		return
+	}
+	if errs := validateReplicaSetUpdate(rs, current); len(errs) > 0 {
+		writeInvalid(w, "replicaset", rs.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionUpdate, "replicasets", &rs, &current) {
+		return
+	}
+	keepDeletion(s.RSStore, key, &rs)
 
-	s.RSStore.Put(name, rs)
+	rs, err := s.RSStore.Update(key, rs)
+	if err != nil {
+		writeStoreError(w, err, "replicaset")
+		return
+	}
+	removeFinalized(s.RSStore, key, rs)
 
 	w.Header().Set("Content-Type", "application/json")
 	writeJSON(w, rs)
 }
 
+func (s *Server) handlePatchReplicaSet(w http.ResponseWriter, r *http.Request) {
+	servePatch(w, r, replicaSetResource(s.RSStore), objectKey(r), s.handleUpdateReplicaSet)
+}
+
 func (s *Server) handleDeleteReplicaSet(w http.ResponseWriter, r *http.Request) {
-	name := r.PathValue("name")
-	s.RSStore.Delete(name)
-	w.WriteHeader(http.StatusNoContent)
+	serveDelete(w, r, s.admissionChain(), replicaSetResource(s.RSStore), objectKey(r))
 }
 
 func (s *Server) handleListNodes(w http.ResponseWriter, r *http.Request) {
-	nodes := s.NodeStore.List()
-	w.Header().Set("Content-Type", "application/json")
-	writeJSON(w, nodes)
+	serveList(w, r, nodeResource(s.NodeStore))
 }
 
 func (s *Server) handleCreateNode(w http.ResponseWriter, r *http.Request) {
@@ -184,8 +453,18 @@ func (s *Server) handleCreateNode(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	if !s.mutate(w, r, types.AdmissionCreate, "nodes", &node, nil) {
+		return
+	}
+	if errs := validateNode(node); len(errs) > 0 {
+		writeInvalid(w, "node", node.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionCreate, "nodes", &node, nil) {
+		return
+	}
 
-	s.NodeStore.Put(node.Name, node)
+	node = s.NodeStore.Put(node.Name, node)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -213,17 +492,56 @@ func (s *Server) handleUpdateNode(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	current, ok := s.NodeStore.Get(name)
+	if !ok {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return
+	}
+	if staleUpdate(w, &node, &current, "node") {
+		return
+	}
+	if !s.mutate(w, r, types.AdmissionUpdate, "nodes", &node, &current) {
+		return
+	}
+	if errs := validateNodeUpdate(node, current); len(errs) > 0 {
+		writeInvalid(w, "node", node.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionUpdate, "nodes", &node, &current) {
+		return
+	}
+	keepDeletion(s.NodeStore, name, &node)
 
-	s.NodeStore.Put(name, node)
+	node, err := s.NodeStore.Update(name, node)
+	if err != nil {
+		writeStoreError(w, err, "node")
+		return
+	}
+	removeFinalized(s.NodeStore, name, node)
 
 	w.Header().Set("Content-Type", "application/json")
 	writeJSON(w, node)
 }
 
+func (s *Server) handlePatchNode(w http.ResponseWriter, r *http.Request) {
+	servePatch(w, r, nodeResource(s.NodeStore), r.PathValue("name"), s.handleUpdateNode)
+}
+
 func (s *Server) handleDeleteNode(w http.ResponseWriter, r *http.Request) {
-	name := r.PathValue("name")
-	s.NodeStore.Delete(name)
-	w.WriteHeader(http.StatusNoContent)
+	serveDelete(w, r, s.admissionChain(), nodeResource(s.NodeStore), r.PathValue("name"))
+}
+
+// writeStoreError maps store errors onto HTTP status codes.
+func writeStoreError(w http.ResponseWriter, err error, kind string) {
+	switch {
+	case errors.Is(err, store.ErrConflict):
+		http.Error(w, kind+" has been modified, re-read and try again", http.StatusConflict)
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, kind+" not found", http.StatusNotFound)
+	default:
+		log.Printf("store error: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
 }
 
 func writeJSON(w http.ResponseWriter, v any) {
@@ -6,6 +6,8 @@ import (
 	"miniku/pkg/types"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -14,13 +16,24 @@ func newTestServer() (*Server, store.PodStore, store.ReplicaSetStore, store.Node
 	podStore := store.NewMemStore[types.Pod]()
 	rsStore := store.NewMemStore[types.ReplicaSet]()
 	nodeStore := store.NewMemStore[types.Node]()
-	srv := &Server{PodStore: podStore, RSStore: rsStore, NodeStore: nodeStore}
+	srv := &Server{
+		PodStore:         podStore,
+		RSStore:          rsStore,
+		NodeStore:        nodeStore,
+		DeploymentStore:  store.NewMemStore[types.Deployment](),
+		ServiceStore:     store.NewMemStore[types.Service](),
+		EndpointsStore:   store.NewMemStore[types.Endpoints](),
+		NamespaceStore:   store.NewMemStore[types.Namespace](),
+		RoleStore:        store.NewMemStore[types.Role](),
+		RoleBindingStore: store.NewMemStore[types.RoleBinding](),
+	}
+	srv.ensureDefaultNamespace()
 	return srv, podStore, rsStore, nodeStore
 }
 
 func TestRoutes(t *testing.T) {
 	srv, podStore, _, _ := newTestServer()
-	podStore.Put("test", types.Pod{Spec: types.PodSpec{Name: "test", Image: "nginx"}})
+	podStore.Put("default/test", types.Pod{Spec: types.PodSpec{Name: "test", Image: "nginx"}})
 
 	ts := httptest.NewServer(srv.Routes())
 	defer ts.Close()
@@ -69,7 +82,7 @@ func TestGetPod(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			srv, podStore, _, _ := newTestServer()
 			for _, p := range tt.setupPods {
-				podStore.Put(p.Spec.Name, p)
+				podStore.Put(p.Key(), p)
 			}
 
 			req := httptest.NewRequest("GET", "/pods/"+tt.getPodName, nil)
@@ -110,7 +123,7 @@ func TestListPods(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			srv, podStore, _, _ := newTestServer()
 			for _, p := range tt.setupPods {
-				podStore.Put(p.Spec.Name, p)
+				podStore.Put(p.Key(), p)
 			}
 
 			req := httptest.NewRequest("GET", "/pods", nil)
@@ -171,7 +184,7 @@ func TestCreatePodWithStatus(t *testing.T) {
 		t.Errorf("got status %d, want 201", rec.Code)
 	}
 
-	pod, ok := podStore.Get("test")
+	pod, ok := podStore.Get("default/test")
 	if !ok {
 		t.Fatal("pod not found in store")
 	}
@@ -182,7 +195,7 @@ func TestCreatePodWithStatus(t *testing.T) {
 
 func TestUpdatePod(t *testing.T) {
 	srv, podStore, _, _ := newTestServer()
-	podStore.Put("test", types.Pod{
+	podStore.Put("default/test", types.Pod{
 		Spec:   types.PodSpec{Name: "test", Image: "nginx"},
 		Status: types.PodStatusPending,
 	})
@@ -198,7 +211,7 @@ func TestUpdatePod(t *testing.T) {
 		t.Errorf("got status %d, want 200", rec.Code)
 	}
 
-	pod, ok := podStore.Get("test")
+	pod, ok := podStore.Get("default/test")
 	if !ok {
 		t.Fatal("pod not found in store")
 	}
@@ -212,7 +225,7 @@ func TestUpdatePod(t *testing.T) {
 
 func TestDeleteThenGet(t *testing.T) {
 	srv, podStore, _, _ := newTestServer()
-	podStore.Put("victim", types.Pod{Spec: types.PodSpec{Name: "victim", Image: "nginx"}})
+	podStore.Put("default/victim", types.Pod{Spec: types.PodSpec{Name: "victim", Image: "nginx"}})
 
 	// delete the pod
 	delReq := httptest.NewRequest("DELETE", "/pods/victim", nil)
@@ -260,7 +273,7 @@ func TestGetReplicaSet(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			srv, _, rsStore, _ := newTestServer()
 			for _, rs := range tt.setupRS {
-				rsStore.Put(rs.Name, rs)
+				rsStore.Put(types.Key(rs.Namespace, rs.Name), rs)
 			}
 
 			req := httptest.NewRequest("GET", "/replicasets/"+tt.getRSName, nil)
@@ -279,7 +292,7 @@ func TestGetReplicaSet(t *testing.T) {
 func TestCreateReplicaSet(t *testing.T) {
 	srv, _, rsStore, _ := newTestServer()
 
-	body := `{"name":"nginx-rs","desiredCount":3,"selector":{"app":"nginx"},"template":{"image":"nginx:latest"}}`
+	body := `{"name":"nginx-rs","desiredCount":3,"selector":{"app":"nginx"},"template":{"image":"nginx:latest","labels":{"app":"nginx"}}}`
 	req := httptest.NewRequest("POST", "/replicasets", strings.NewReader(body))
 	rec := httptest.NewRecorder()
 
@@ -303,9 +316,14 @@ func TestCreateReplicaSet(t *testing.T) {
 
 func TestUpdateReplicaSet(t *testing.T) {
 	srv, _, rsStore, _ := newTestServer()
-	rsStore.Put("nginx-rs", types.ReplicaSet{Name: "nginx-rs", DesiredCount: 3})
+	rsStore.Put("default/nginx-rs", types.ReplicaSet{
+		Name:         "nginx-rs",
+		DesiredCount: 3,
+		Selector:     map[string]string{"app": "nginx"},
+		Template:     types.PodSpec{Image: "nginx:latest", Labels: map[string]string{"app": "nginx"}},
+	})
 
-	body := `{"name":"nginx-rs","desiredCount":5}`
+	body := `{"name":"nginx-rs","desiredCount":5,"selector":{"app":"nginx"},"template":{"image":"nginx:latest","labels":{"app":"nginx"}}}`
 	req := httptest.NewRequest("PUT", "/replicasets/nginx-rs", strings.NewReader(body))
 	req.SetPathValue("name", "nginx-rs")
 	rec := httptest.NewRecorder()
@@ -316,7 +334,7 @@ func TestUpdateReplicaSet(t *testing.T) {
 		t.Errorf("got status %d, want 200", rec.Code)
 	}
 
-	rs, ok := rsStore.Get("nginx-rs")
+	rs, ok := rsStore.Get("default/nginx-rs")
 	if !ok {
 		t.Fatal("replicaset not found")
 	}
@@ -327,8 +345,8 @@ func TestUpdateReplicaSet(t *testing.T) {
 
 func TestListReplicaSets(t *testing.T) {
 	srv, _, rsStore, _ := newTestServer()
-	rsStore.Put("rs1", types.ReplicaSet{Name: "rs1", DesiredCount: 2})
-	rsStore.Put("rs2", types.ReplicaSet{Name: "rs2", DesiredCount: 5})
+	rsStore.Put("default/rs1", types.ReplicaSet{Name: "rs1", DesiredCount: 2})
+	rsStore.Put("default/rs2", types.ReplicaSet{Name: "rs2", DesiredCount: 5})
 
 	req := httptest.NewRequest("GET", "/replicasets", nil)
 	rec := httptest.NewRecorder()
@@ -460,7 +478,7 @@ func TestUpdateNode(t *testing.T) {
 
 func TestDeleteReplicaSet(t *testing.T) {
 	srv, _, rsStore, _ := newTestServer()
-	rsStore.Put("nginx-rs", types.ReplicaSet{Name: "nginx-rs", DesiredCount: 3})
+	rsStore.Put("default/nginx-rs", types.ReplicaSet{Name: "nginx-rs", DesiredCount: 3})
 
 	req := httptest.NewRequest("DELETE", "/replicasets/nginx-rs", nil)
 	req.SetPathValue("name", "nginx-rs")
@@ -472,7 +490,7 @@ func TestDeleteReplicaSet(t *testing.T) {
 		t.Errorf("got status %d, want 204", rec.Code)
 	}
 
-	_, ok := rsStore.Get("nginx-rs")
+	_, ok := rsStore.Get("default/nginx-rs")
 	if ok {
 		t.Error("replicaset should have been deleted")
 	}
@@ -497,3 +515,307 @@ func TestDeleteNode(t *testing.T) {
 		t.Error("node should have been deleted")
 	}
 }
+
+func TestUpdatePodResourceVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    func(stored types.Pod) uint64
+		wantStatus int
+	}{
+		{
+			name:       "current version is accepted",
+			version:    func(stored types.Pod) uint64 { return stored.ResourceVersion },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "stale version is rejected",
+			version:    func(stored types.Pod) uint64 { return stored.ResourceVersion - 1 },
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "missing version overwrites",
+			version:    func(types.Pod) uint64 { return 0 },
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, podStore, _, _ := newTestServer()
+			podStore.Put("default/test", types.Pod{Spec: types.PodSpec{Name: "test", Image: "nginx"}})
+			stored := podStore.Put("default/test", types.Pod{Spec: types.PodSpec{Name: "test", Image: "nginx", NodeName: "node-1"}})
+
+			update := stored
+			update.Status = types.PodStatusRunning
+			update.ResourceVersion = tt.version(stored)
+			body, _ := json.Marshal(update)
+
+			req := httptest.NewRequest("PUT", "/pods/test", strings.NewReader(string(body)))
+			req.SetPathValue("name", "test")
+			rec := httptest.NewRecorder()
+
+			srv.handleUpdatePod(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var got types.Pod
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.ResourceVersion <= stored.ResourceVersion {
+				t.Errorf("response version %d not newer than %d", got.ResourceVersion, stored.ResourceVersion)
+			}
+		})
+	}
+}
+
+func TestWatchPods(t *testing.T) {
+	srv, podStore, _, _ := newTestServer()
+	podStore.Put("default/existing", types.Pod{Spec: types.PodSpec{Name: "existing", Image: "nginx"}})
+
+	ts := httptest.NewServer(srv.Routes())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/pods?watch=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+
+	podStore.Put("default/new", types.Pod{Spec: types.PodSpec{Name: "new", Image: "nginx"}})
+	podStore.Delete("default/existing")
+
+	dec := json.NewDecoder(resp.Body)
+	want := []struct {
+		eventType types.EventType
+		name      string
+	}{
+		{types.EventAdded, "existing"},
+		{types.EventAdded, "new"},
+		{types.EventDeleted, "existing"},
+	}
+	for _, w := range want {
+		var ev types.WatchEvent[types.Pod]
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatalf("decode event: %v", err)
+		}
+		if ev.Type != w.eventType || ev.Object.Spec.Name != w.name {
+			t.Errorf("got %s %s, want %s %s", ev.Type, ev.Object.Spec.Name, w.eventType, w.name)
+		}
+	}
+}
+
+func TestWatchResourceVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{name: "invalid version", query: "abc", wantStatus: http.StatusBadRequest},
+		{name: "expired version", query: "1", wantStatus: http.StatusGone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _, _, nodeStore := newTestServer()
+			for range 1100 {
+				nodeStore.Put("node-1", types.Node{Name: "node-1"})
+			}
+
+			req := httptest.NewRequest("GET", "/nodes?watch=true&resourceVersion="+tt.query, nil)
+			rec := httptest.NewRecorder()
+
+			srv.handleListNodes(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestListPodsWithSelectors(t *testing.T) {
+	pods := []types.Pod{
+		{Spec: types.PodSpec{Name: "web-1", NodeName: "node-1", Labels: map[string]string{"app": "web", "env": "prod"}}},
+		{Spec: types.PodSpec{Name: "web-2", NodeName: "node-2", Labels: map[string]string{"app": "web", "env": "staging"}}},
+		{Spec: types.PodSpec{Name: "db-1", NodeName: "node-1", Labels: map[string]string{"app": "db"}}},
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantNames  []string
+	}{
+		{"label equality", "labelSelector=app%3Dweb", http.StatusOK, []string{"web-1", "web-2"}},
+		{"label set", "labelSelector=env+in+(prod,dev)", http.StatusOK, []string{"web-1"}},
+		{"label does not exist", "labelSelector=!env", http.StatusOK, []string{"db-1"}},
+		{"field selector", "fieldSelector=spec.node_name%3Dnode-1", http.StatusOK, []string{"db-1", "web-1"}},
+		{"label and field", "labelSelector=app%3Dweb&fieldSelector=spec.node_name!%3Dnode-1", http.StatusOK, []string{"web-2"}},
+		{"no match", "labelSelector=app%3Dcache", http.StatusOK, []string{}},
+		{"bad label selector", "labelSelector=env+in+prod", http.StatusBadRequest, nil},
+		{"bad field selector", "fieldSelector=status+in+(Running)", http.StatusBadRequest, nil},
+		{"unsupported field", "fieldSelector=spec.nodeName%3Dnode-1", http.StatusBadRequest, nil},
+		{"unsupported negated field", "fieldSelector=metadata.name!%3Dweb-1", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, podStore, _, _ := newTestServer()
+			for _, p := range pods {
+				podStore.Put(p.Key(), p)
+			}
+
+			req := httptest.NewRequest("GET", "/pods?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+
+			srv.handleListPods(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got []types.Pod
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			names := make([]string, 0, len(got))
+			for _, p := range got {
+				names = append(names, p.Spec.Name)
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, tt.wantNames) {
+				t.Errorf("got %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestWatchPodsWithSelector(t *testing.T) {
+	srv, podStore, _, _ := newTestServer()
+	podStore.Put("default/a", types.Pod{Spec: types.PodSpec{Name: "a", NodeName: "node-1"}})
+	podStore.Put("default/b", types.Pod{Spec: types.PodSpec{Name: "b", NodeName: "node-2"}})
+
+	ts := httptest.NewServer(srv.Routes())
+	defer ts.Close()
+	defer ts.CloseClientConnections()
+
+	resp, err := http.Get(ts.URL + "/pods?watch=true&fieldSelector=spec.node_name%3Dnode-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// b moves onto node-1, a moves off it, then both are deleted
+	podStore.Put("default/b", types.Pod{Spec: types.PodSpec{Name: "b", NodeName: "node-1"}})
+	podStore.Put("default/a", types.Pod{Spec: types.PodSpec{Name: "a", NodeName: "node-2"}})
+	podStore.Delete("default/a")
+	podStore.Delete("default/b")
+
+	dec := json.NewDecoder(resp.Body)
+	want := []struct {
+		eventType types.EventType
+		name      string
+	}{
+		{types.EventAdded, "a"},
+		{types.EventAdded, "b"},
+		{types.EventDeleted, "a"},
+		{types.EventDeleted, "b"},
+	}
+	for _, w := range want {
+		ev := nextEvent(t, dec)
+		if ev.Type != w.eventType || ev.Object.Spec.Name != w.name {
+			t.Errorf("got %s %s, want %s %s", ev.Type, ev.Object.Spec.Name, w.eventType, w.name)
+		}
+	}
+}
+
+func TestResumedWatchWithSelector(t *testing.T) {
+	srv, podStore, _, _ := newTestServer()
+	podStore.Put("default/a", types.Pod{Spec: types.PodSpec{Name: "a", NodeName: "node-1"}})
+	podStore.Put("default/d", types.Pod{Spec: types.PodSpec{Name: "d", NodeName: "node-2"}})
+	b := podStore.Put("default/b", types.Pod{Spec: types.PodSpec{Name: "b", NodeName: "node-1"}})
+
+	ts := httptest.NewServer(srv.Routes())
+	defer ts.Close()
+	defer ts.CloseClientConnections()
+
+	// the client listed a and b before and resumes after them
+	resp, err := http.Get(ts.URL + "/pods?watch=true&fieldSelector=spec.node_name%3Dnode-1&resourceVersion=" + strconv.FormatUint(b.ResourceVersion, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	podStore.Put("default/a", types.Pod{Spec: types.PodSpec{Name: "a", NodeName: "node-2"}})
+	podStore.Put("default/a", types.Pod{Spec: types.PodSpec{Name: "a", NodeName: "node-3"}})
+	// the client never had d
+	podStore.Put("default/d", types.Pod{Spec: types.PodSpec{Name: "d", NodeName: "node-3"}})
+	podStore.Delete("default/b")
+	podStore.Put("default/c", types.Pod{Spec: types.PodSpec{Name: "c", NodeName: "node-1"}})
+
+	dec := json.NewDecoder(resp.Body)
+	want := []struct {
+		eventType types.EventType
+		name      string
+	}{
+		{types.EventDeleted, "a"},
+		{types.EventDeleted, "b"},
+		{types.EventAdded, "c"},
+	}
+	for _, w := range want {
+		ev := nextEvent(t, dec)
+		if ev.Type != w.eventType || ev.Object.Spec.Name != w.name {
+			t.Errorf("got %s %s, want %s %s", ev.Type, ev.Object.Spec.Name, w.eventType, w.name)
+		}
+	}
+}
+
+// nextEvent decodes the next watch event that isn't a bookmark.
+func nextEvent(t *testing.T, dec *json.Decoder) types.WatchEvent[types.Pod] {
+	t.Helper()
+	for {
+		var ev types.WatchEvent[types.Pod]
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatalf("decode event: %v", err)
+		}
+		if ev.Type != types.EventBookmark {
+			return ev
+		}
+	}
+}
+
+func TestWatchBookmark(t *testing.T) {
+	srv, podStore, _, _ := newTestServer()
+	ts := httptest.NewServer(srv.Routes())
+	defer ts.Close()
+	defer ts.CloseClientConnections()
+
+	resp, err := http.Get(ts.URL + "/pods?watch=true&fieldSelector=spec.node_name%3Dnode-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	a := podStore.Put("default/a", types.Pod{Spec: types.PodSpec{Name: "a", NodeName: "node-2"}})
+
+	var ev types.WatchEvent[types.Pod]
+	if err := json.NewDecoder(resp.Body).Decode(&ev); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	if ev.Type != types.EventBookmark || ev.Object.ResourceVersion != a.ResourceVersion || ev.Object.Spec.Name != "" {
+		t.Errorf("got %s %q at %d, want an empty BOOKMARK at %d", ev.Type, ev.Object.Spec.Name, ev.Object.ResourceVersion, a.ResourceVersion)
+	}
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"miniku/pkg/types"
+)
+
+// DefaultServiceCIDR is where cluster IPs come from unless the server is told
+// otherwise.
+const DefaultServiceCIDR = "10.96.0.0/16"
+
+func (s *Server) handleListServices(w http.ResponseWriter, r *http.Request) {
+	serveList(w, r, serviceResource(s.ServiceStore))
+}
+
+func (s *Server) handleCreateService(w http.ResponseWriter, r *http.Request) {
+	var svc types.Service
+	if err := json.NewDecoder(r.Body).Decode(&svc); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !setNamespace(w, r, &svc) {
+		return
+	}
+	defaultService(&svc)
+	if !s.mutate(w, r, types.AdmissionCreate, "services", &svc, nil) {
+		return
+	}
+	if errs := validateService(svc); len(errs) > 0 {
+		writeInvalid(w, "service", svc.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionCreate, "services", &svc, nil) {
+		return
+	}
+
+	s.serviceMu.Lock()
+	defer s.serviceMu.Unlock()
+
+	if status, err := s.assignClusterIP(&svc, nil); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	svc = s.ServiceStore.Put(namespacedKey(svc), svc)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, svc)
+}
+
+func (s *Server) handleGetService(w http.ResponseWriter, r *http.Request) {
+	key := objectKey(r)
+
+	svc, ok := s.ServiceStore.Get(key)
+	if !ok {
+		http.Error(w, "service not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, svc)
+}
+
+func (s *Server) handleUpdateService(w http.ResponseWriter, r *http.Request) {
+	key := objectKey(r)
+
+	var svc types.Service
+	if err := json.NewDecoder(r.Body).Decode(&svc); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !setNamespace(w, r, &svc) {
+		return
+	}
+	current, ok := s.ServiceStore.Get(key)
+	if !ok {
+		http.Error(w, "service not found", http.StatusNotFound)
+		return
+	}
+	if staleUpdate(w, &svc, &current, "service") {
+		return
+	}
+	defaultService(&svc)
+	// leaving the cluster IP out keeps it
+	if svc.ClusterIP == "" {
+		svc.ClusterIP = current.ClusterIP
+	}
+	if !s.mutate(w, r, types.AdmissionUpdate, "services", &svc, &current) {
+		return
+	}
+	if errs := validateServiceUpdate(svc, current); len(errs) > 0 {
+		writeInvalid(w, "service", svc.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionUpdate, "services", &svc, &current) {
+		return
+	}
+	keepDeletion(s.ServiceStore, key, &svc)
+
+	s.serviceMu.Lock()
+	defer s.serviceMu.Unlock()
+
+	if status, err := s.assignClusterIP(&svc, &current); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	svc, err := s.ServiceStore.Update(key, svc)
+	if err != nil {
+		writeStoreError(w, err, "service")
+		return
+	}
+	removeFinalized(s.ServiceStore, key, svc)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, svc)
+}
+
+func (s *Server) handlePatchService(w http.ResponseWriter, r *http.Request) {
+	servePatch(w, r, serviceResource(s.ServiceStore), objectKey(r), s.handleUpdateService)
+}
+
+func (s *Server) handleDeleteService(w http.ResponseWriter, r *http.Request) {
+	serveDelete(w, r, s.admissionChain(), serviceResource(s.ServiceStore), objectKey(r))
+}
+
+// assignClusterIP gives a validated service a cluster IP, or checks the one
+// it asked for is free. current is the stored service on update, whose IP
+// is kept. It returns the status to answer with on error.
+func (s *Server) assignClusterIP(svc *types.Service, current *types.Service) (int, error) {
+	if current != nil && current.ClusterIP != "" {
+		return 0, nil
+	}
+
+	cidr := s.ServiceCIDR
+	if cidr == "" {
+		cidr = DefaultServiceCIDR
+	}
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil || subnet.IP.To4() == nil {
+		return http.StatusInternalServerError, fmt.Errorf("bad service CIDR %q", cidr)
+	}
+
+	used := make(map[string]bool)
+	for _, other := range s.ServiceStore.List() {
+		if namespacedKey(other) != namespacedKey(*svc) {
+			used[other.ClusterIP] = true
+		}
+	}
+
+	if svc.ClusterIP != "" {
+		ip := net.ParseIP(svc.ClusterIP).To4()
+		if ip == nil || !subnet.Contains(ip) || ip.Equal(subnet.IP) || ip.Equal(lastIP(subnet)) {
+			return http.StatusBadRequest, fmt.Errorf("clusterIP %s is not in the service range %s", svc.ClusterIP, subnet)
+		}
+		svc.ClusterIP = ip.String()
+		if used[svc.ClusterIP] {
+			return http.StatusConflict, fmt.Errorf("clusterIP %s is already allocated", svc.ClusterIP)
+		}
+		return 0, nil
+	}
+
+	// the network and broadcast addresses are left out
+	base := binary.BigEndian.Uint32(subnet.IP.To4())
+	last := binary.BigEndian.Uint32(lastIP(subnet))
+	for n := base + 1; n < last; n++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, n)
+		if !used[ip.String()] {
+			svc.ClusterIP = ip.String()
+			return 0, nil
+		}
+	}
+	return http.StatusInternalServerError, fmt.Errorf("no cluster IPs left in %s", subnet)
+}
+
+func lastIP(subnet *net.IPNet) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	for i := range ip {
+		ip[i] = subnet.IP.To4()[i] | ^subnet.Mask[len(subnet.Mask)-net.IPv4len+i]
+	}
+	return ip
+}
+
+func (s *Server) handleListEndpoints(w http.ResponseWriter, r *http.Request) {
+	serveList(w, r, endpointsResource(s.EndpointsStore))
+}
+
+func (s *Server) handleCreateEndpoints(w http.ResponseWriter, r *http.Request) {
+	var ep types.Endpoints
+	if err := json.NewDecoder(r.Body).Decode(&ep); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !setNamespace(w, r, &ep) {
+		return
+	}
+	if !s.mutate(w, r, types.AdmissionCreate, "endpoints", &ep, nil) {
+		return
+	}
+	if errs := validateEndpoints(ep); len(errs) > 0 {
+		writeInvalid(w, "endpoints", ep.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionCreate, "endpoints", &ep, nil) {
+		return
+	}
+
+	ep = s.EndpointsStore.Put(namespacedKey(ep), ep)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, ep)
+}
+
+func (s *Server) handleGetEndpoints(w http.ResponseWriter, r *http.Request) {
+	key := objectKey(r)
+
+	ep, ok := s.EndpointsStore.Get(key)
+	if !ok {
+		http.Error(w, "endpoints not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, ep)
+}
+
+func (s *Server) handleUpdateEndpoints(w http.ResponseWriter, r *http.Request) {
+	key := objectKey(r)
+
+	var ep types.Endpoints
+	if err := json.NewDecoder(r.Body).Decode(&ep); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !setNamespace(w, r, &ep) {
+		return
+	}
+	current, ok := s.EndpointsStore.Get(key)
+	if !ok {
+		http.Error(w, "endpoints not found", http.StatusNotFound)
+		return
+	}
+	if staleUpdate(w, &ep, &current, "endpoints") {
+		return
+	}
+	if !s.mutate(w, r, types.AdmissionUpdate, "endpoints", &ep, &current) {
+		return
+	}
+	if errs := validateEndpointsUpdate(ep, current); len(errs) > 0 {
+		writeInvalid(w, "endpoints", ep.Name, errs)
+		return
+	}
+	if !s.admit(w, r, types.AdmissionUpdate, "endpoints", &ep, &current) {
+		return
+	}
+	keepDeletion(s.EndpointsStore, key, &ep)
+
+	ep, err := s.EndpointsStore.Update(key, ep)
+	if err != nil {
+		writeStoreError(w, err, "endpoints")
+		return
+	}
+	removeFinalized(s.EndpointsStore, key, ep)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, ep)
+}
+
+func (s *Server) handlePatchEndpoints(w http.ResponseWriter, r *http.Request) {
+	servePatch(w, r, endpointsResource(s.EndpointsStore), objectKey(r), s.handleUpdateEndpoints)
+}
+
+func (s *Server) handleDeleteEndpoints(w http.ResponseWriter, r *http.Request) {
+	serveDelete(w, r, s.admissionChain(), endpointsResource(s.EndpointsStore), objectKey(r))
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"miniku/pkg/types"
+)
+
+func TestCreateServiceClusterIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   []types.Service
+		body       string
+		wantStatus int
+		wantIP     string
+	}{
+		{"first free address", nil,
+			`{"name":"web","ports":[{"port":80}]}`, http.StatusCreated, "10.96.0.1"},
+		{"skips allocated addresses",
+			[]types.Service{{Name: "a", ClusterIP: "10.96.0.1"}, {Name: "b", ClusterIP: "10.96.0.2"}},
+			`{"name":"web","ports":[{"port":80}]}`, http.StatusCreated, "10.96.0.3"},
+		{"requested address", nil,
+			`{"name":"web","ports":[{"port":80}],"clusterIP":"10.96.3.4"}`, http.StatusCreated, "10.96.3.4"},
+		{"requested address taken",
+			[]types.Service{{Name: "a", ClusterIP: "10.96.3.4"}},
+			`{"name":"web","ports":[{"port":80}],"clusterIP":"10.96.3.4"}`, http.StatusConflict, ""},
+		{"requested address out of range", nil,
+			`{"name":"web","ports":[{"port":80}],"clusterIP":"10.0.0.1"}`, http.StatusBadRequest, ""},
+		{"broadcast address", nil,
+			`{"name":"web","ports":[{"port":80}],"clusterIP":"10.96.255.255"}`, http.StatusBadRequest, ""},
+		{"no ports", nil, `{"name":"web"}`, http.StatusUnprocessableEntity, ""},
+		{"port out of range", nil,
+			`{"name":"web","ports":[{"port":80,"targetPort":70000}]}`, http.StatusUnprocessableEntity, ""},
+		{"udp", nil,
+			`{"name":"web","ports":[{"port":53,"protocol":"UDP"}]}`, http.StatusUnprocessableEntity, ""},
+		{"invalid body", nil, `{`, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _, _, _ := newTestServer()
+			for _, svc := range tt.existing {
+				srv.ServiceStore.Put(types.Key(svc.Namespace, svc.Name), svc)
+			}
+
+			req := httptest.NewRequest("POST", "/services", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			srv.handleCreateService(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+			var svc types.Service
+			if err := json.NewDecoder(rec.Body).Decode(&svc); err != nil {
+				t.Fatal(err)
+			}
+			if svc.ClusterIP != tt.wantIP {
+				t.Errorf("got clusterIP %q, want %q", svc.ClusterIP, tt.wantIP)
+			}
+			if stored, _ := srv.ServiceStore.Get("default/web"); stored.ClusterIP != tt.wantIP {
+				t.Errorf("stored clusterIP %q, want %q", stored.ClusterIP, tt.wantIP)
+			}
+		})
+	}
+}
+
+func TestUpdateServiceClusterIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"keeps address when left out", `{"name":"web","ports":[{"port":8080}]}`, http.StatusOK},
+		{"same address", `{"name":"web","ports":[{"port":8080}],"clusterIP":"10.96.0.7"}`, http.StatusOK},
+		{"changed address", `{"name":"web","ports":[{"port":8080}],"clusterIP":"10.96.0.8"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _, _, _ := newTestServer()
+			srv.ServiceStore.Put("default/web", types.Service{
+				Name: "web", Ports: []types.ServicePort{{Port: 80}}, ClusterIP: "10.96.0.7",
+			})
+
+			req := httptest.NewRequest("PUT", "/services/web", strings.NewReader(tt.body))
+			req.SetPathValue("name", "web")
+			rec := httptest.NewRecorder()
+			srv.handleUpdateService(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			stored, _ := srv.ServiceStore.Get("default/web")
+			if stored.ClusterIP != "10.96.0.7" {
+				t.Errorf("stored clusterIP %q, want 10.96.0.7", stored.ClusterIP)
+			}
+			wantPort := 80
+			if tt.wantStatus == http.StatusOK {
+				wantPort = 8080
+			}
+			if stored.Ports[0].Port != wantPort {
+				t.Errorf("stored port %d, want %d", stored.Ports[0].Port, wantPort)
+			}
+		})
+	}
+}
@@ -0,0 +1,518 @@
+package api
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"miniku/pkg/selector"
+	"miniku/pkg/store"
+	"miniku/pkg/types"
+)
+
+// Objects are defaulted and then validated on create and update. Whatever
+// is wrong is answered with 422 and a types.Status naming every invalid
+// field, rather than stopping at the first.
+
+// fieldErrors collects the invalid fields of an object.
+type fieldErrors []types.StatusCause
+
+func (e *fieldErrors) add(t types.CauseType, field, message string) {
+	*e = append(*e, types.StatusCause{Type: t, Field: field, Message: message})
+}
+
+func (e *fieldErrors) required(field string) {
+	e.add(types.CauseTypeFieldValueRequired, field, "Required value")
+}
+
+func (e *fieldErrors) invalid(field string, value any, detail string) {
+	e.add(types.CauseTypeFieldValueInvalid, field, fmt.Sprintf("Invalid value: %#v: %s", value, detail))
+}
+
+func (e *fieldErrors) notSupported(field string, value any, supported ...string) {
+	e.add(types.CauseTypeFieldValueNotSupported, field, fmt.Sprintf("Unsupported value: %#v: supported values: %s", value, strings.Join(supported, ", ")))
+}
+
+func (e *fieldErrors) duplicate(field string, value any) {
+	e.add(types.CauseTypeFieldValueDuplicate, field, fmt.Sprintf("Duplicate value: %#v", value))
+}
+
+func (e *fieldErrors) immutable(field string) {
+	e.add(types.CauseTypeFieldValueImmutable, field, "field is immutable")
+}
+
+// unchanged flags field as immutable if it differs between the object sent
+// and the stored one.
+func (e *fieldErrors) unchanged(field string, value, old any) {
+	if !reflect.DeepEqual(value, old) {
+		e.immutable(field)
+	}
+}
+
+// writeInvalid answers 422 with everything that is wrong with the named
+// object.
+func writeInvalid(w http.ResponseWriter, kind, name string, errs fieldErrors) {
+	details := make([]string, len(errs))
+	for i, cause := range errs {
+		details[i] = cause.Field + ": " + cause.Message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	writeJSON(w, types.Status{
+		Code:    http.StatusUnprocessableEntity,
+		Reason:  types.StatusReasonInvalid,
+		Message: fmt.Sprintf("%s %q is invalid: %s", kind, name, strings.Join(details, ", ")),
+		Causes:  errs,
+	})
+}
+
+// staleUpdate answers 409 like the store would if obj was read before
+// current was written. It comes first so a writer that raced someone else
+// re-reads rather than being told about fields the other write changed.
+func staleUpdate[T any](w http.ResponseWriter, obj, current *T, kind string) bool {
+	rv := metaOf(obj).ResourceVersion
+	if rv == 0 || rv == metaOf(current).ResourceVersion {
+		return false
+	}
+	writeStoreError(w, store.ErrConflict, kind)
+	return true
+}
+
+var (
+	dns1123Label  = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	qualifiedName = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	envVarName    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// isDNS1123Label explains what is wrong with s as an RFC 1123 label, the
+// name of a namespace or service, which ends up in DNS names as is.
+func isDNS1123Label(s string) string {
+	if len(s) > 63 {
+		return "must be no more than 63 characters"
+	}
+	if !dns1123Label.MatchString(s) {
+		return "a lowercase RFC 1123 label must consist of lower case alphanumeric characters or '-', and must start and end with an alphanumeric character"
+	}
+	return ""
+}
+
+// isDNS1123Subdomain explains what is wrong with s as an RFC 1123
+// subdomain, the name of most objects.
+func isDNS1123Subdomain(s string) string {
+	if len(s) > 253 {
+		return "must be no more than 253 characters"
+	}
+	for _, label := range strings.Split(s, ".") {
+		if !dns1123Label.MatchString(label) {
+			return "a lowercase RFC 1123 subdomain must consist of lower case alphanumeric characters, '-' or '.', and must start and end with an alphanumeric character"
+		}
+	}
+	return ""
+}
+
+// isQualifiedName explains what is wrong with s as a label key, a name
+// with an optional DNS subdomain prefix, e.g. topology.kubernetes.io/zone.
+func isQualifiedName(s string) string {
+	prefix, name, ok := strings.Cut(s, "/")
+	if !ok {
+		name = prefix
+	} else if msg := isDNS1123Subdomain(prefix); prefix == "" || msg != "" {
+		return "prefix part " + cmp.Or(msg, "must be non-empty")
+	}
+	if len(name) > 63 {
+		return "name part must be no more than 63 characters"
+	}
+	if !qualifiedName.MatchString(name) {
+		return "name part must consist of alphanumeric characters, '-', '_' or '.', and must start and end with an alphanumeric character"
+	}
+	return ""
+}
+
+// isLabelValue explains what is wrong with s as a label value.
+func isLabelValue(s string) string {
+	if len(s) > 63 {
+		return "must be no more than 63 characters"
+	}
+	if s != "" && !qualifiedName.MatchString(s) {
+		return "a valid label must be an empty string or consist of alphanumeric characters, '-', '_' or '.', and must start and end with an alphanumeric character"
+	}
+	return ""
+}
+
+// isPathSegment explains what is wrong with s as the name of a role or
+// binding, which only has to fit in a URL, e.g. system:node.
+func isPathSegment(s string) string {
+	if s == "." || s == ".." {
+		return "may not be '.' or '..'"
+	}
+	if strings.ContainsAny(s, "/%") {
+		return "may not contain '/' or '%'"
+	}
+	return ""
+}
+
+// validateName checks the name of an object at field, isValid is
+// isDNS1123Label or isDNS1123Subdomain.
+func (e *fieldErrors) validateName(field, name string, isValid func(string) string) {
+	if name == "" {
+		e.required(field)
+		return
+	}
+	if msg := isValid(name); msg != "" {
+		e.invalid(field, name, msg)
+	}
+}
+
+// validateUpdatedName checks that an update is sent to the URL of the
+// object it carries, old is the object stored there.
+func (e *fieldErrors) validateUpdatedName(field, name, old string) {
+	if name != old {
+		e.invalid(field, name, "must match the name in the URL, "+old)
+	}
+}
+
+// validateLabels checks the keys and values of labels or a selector.
+func (e *fieldErrors) validateLabels(field string, labels map[string]string) {
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		path := field + "[" + key + "]"
+		if msg := isQualifiedName(key); msg != "" {
+			e.invalid(path, key, msg)
+		}
+		if msg := isLabelValue(labels[key]); msg != "" {
+			e.invalid(path, labels[key], msg)
+		}
+	}
+}
+
+// validateSelector checks a selector that is required to match the labels
+// of template.
+func (e *fieldErrors) validateSelector(field string, sel, templateLabels map[string]string) {
+	if len(sel) == 0 {
+		e.required(field)
+		return
+	}
+	e.validateLabels(field, sel)
+	if !selector.FromSet(sel).Matches(templateLabels) {
+		e.invalid("template.labels", templateLabels, "`selector` does not match template `labels`")
+	}
+}
+
+// validatePodSpec checks the spec of a pod, or a pod template, which has
+// neither name nor node.
+func (e *fieldErrors) validatePodSpec(field string, spec types.PodSpec, template bool) {
+	if !template {
+		e.validateName(field+".name", spec.Name, isDNS1123Subdomain)
+		if spec.NodeName != "" {
+			e.validateName(field+".node_name", spec.NodeName, isDNS1123Subdomain)
+		}
+	}
+	if spec.Image == "" {
+		e.required(field + ".image")
+	}
+	if len(spec.Command) > 0 && spec.Command[0] == "" {
+		e.required(field + ".command[0]")
+	}
+	for _, name := range slices.Sorted(maps.Keys(spec.Env)) {
+		if !envVarName.MatchString(name) {
+			e.invalid(field+".env["+name+"]", name, "must consist of alphanumeric characters or '_', and must not start with a digit")
+		}
+	}
+	e.validateLabels(field+".labels", spec.Labels)
+
+	switch spec.RestartPolicy {
+	case "", types.RestartPolicyAlways, types.RestartPolicyOnFailure, types.RestartPolicyNever:
+	default:
+		e.notSupported(field+".restartPolicy", spec.RestartPolicy,
+			string(types.RestartPolicyAlways), string(types.RestartPolicyOnFailure), string(types.RestartPolicyNever))
+	}
+
+	e.validateResources(field+".resources", spec.Resources)
+	e.validateProbe(field+".livenessProbe", spec.LivenessProbe)
+	e.validateProbe(field+".readinessProbe", spec.ReadinessProbe)
+	e.validateProbe(field+".startupProbe", spec.StartupProbe)
+
+	if spec.TerminationGracePeriodSeconds != nil {
+		e.nonNegative(field+".terminationGracePeriodSeconds", *spec.TerminationGracePeriodSeconds)
+	}
+}
+
+func (e *fieldErrors) validateResources(field string, r types.ResourceRequirements) {
+	e.validateResourceList(field+".requests", r.Requests)
+	e.validateResourceList(field+".limits", r.Limits)
+	if r.Limits.CPU > 0 && r.Requests.CPU > r.Limits.CPU {
+		e.invalid(field+".requests.cpu", r.Requests.CPU, "must be less than or equal to the cpu limit")
+	}
+	if r.Limits.Memory > 0 && r.Requests.Memory > r.Limits.Memory {
+		e.invalid(field+".requests.memory", r.Requests.Memory, "must be less than or equal to the memory limit")
+	}
+}
+
+func (e *fieldErrors) validateResourceList(field string, list types.ResourceList) {
+	e.nonNegative(field+".cpu", list.CPU)
+	e.nonNegative(field+".memory", list.Memory)
+	e.nonNegative(field+".pids", list.PIDs)
+}
+
+func (e *fieldErrors) validateProbe(field string, p *types.Probe) {
+	if p == nil {
+		return
+	}
+	handlers := 0
+	if p.Exec != nil {
+		handlers++
+		if len(p.Exec.Command) == 0 || p.Exec.Command[0] == "" {
+			e.required(field + ".exec.command")
+		}
+	}
+	if p.HTTPGet != nil {
+		handlers++
+		e.validatePort(field+".httpGet.port", p.HTTPGet.Port)
+	}
+	if p.TCPSocket != nil {
+		handlers++
+		e.validatePort(field+".tcpSocket.port", p.TCPSocket.Port)
+	}
+	if handlers != 1 {
+		e.invalid(field, handlers, "must have exactly one of exec, httpGet and tcpSocket")
+	}
+
+	e.nonNegative(field+".initialDelaySeconds", int64(p.InitialDelaySeconds))
+	e.nonNegative(field+".periodSeconds", int64(p.PeriodSeconds))
+	e.nonNegative(field+".timeoutSeconds", int64(p.TimeoutSeconds))
+	e.nonNegative(field+".successThreshold", int64(p.SuccessThreshold))
+	e.nonNegative(field+".failureThreshold", int64(p.FailureThreshold))
+}
+
+func (e *fieldErrors) nonNegative(field string, v int64) {
+	if v < 0 {
+		e.invalid(field, v, "must be greater than or equal to 0")
+	}
+}
+
+func (e *fieldErrors) validatePort(field string, port int) {
+	if port < 1 || port > 65535 {
+		e.invalid(field, port, "must be between 1 and 65535, inclusive")
+	}
+}
+
+func validatePod(pod types.Pod) fieldErrors {
+	var errs fieldErrors
+	errs.validatePodSpec("spec", pod.Spec, false)
+	switch pod.Status {
+	case types.PodStatusPending, types.PodStatusRunning, types.PodStatusSucceeded, types.PodStatusFailed, types.PodStatusUnknown:
+	default:
+		errs.notSupported("status", pod.Status,
+			string(types.PodStatusPending), string(types.PodStatusRunning), string(types.PodStatusSucceeded),
+			string(types.PodStatusFailed), string(types.PodStatusUnknown))
+	}
+	return errs
+}
+
+// validatePodUpdate also keeps the spec as it was, apart from the labels
+// and binding the pod to a node once.
+func validatePodUpdate(pod, old types.Pod) fieldErrors {
+	errs := validatePod(pod)
+	errs.validateUpdatedName("spec.name", pod.Spec.Name, old.Spec.Name)
+	if old.Spec.NodeName != "" {
+		errs.unchanged("spec.node_name", pod.Spec.NodeName, old.Spec.NodeName)
+	}
+	errs.unchanged("spec.image", pod.Spec.Image, old.Spec.Image)
+	errs.unchanged("spec.command", pod.Spec.Command, old.Spec.Command)
+	errs.unchanged("spec.env", pod.Spec.Env, old.Spec.Env)
+	errs.unchanged("spec.restartPolicy", pod.Spec.RestartPolicy, old.Spec.RestartPolicy)
+	errs.unchanged("spec.resources", pod.Spec.Resources, old.Spec.Resources)
+	errs.unchanged("spec.livenessProbe", pod.Spec.LivenessProbe, old.Spec.LivenessProbe)
+	errs.unchanged("spec.readinessProbe", pod.Spec.ReadinessProbe, old.Spec.ReadinessProbe)
+	errs.unchanged("spec.startupProbe", pod.Spec.StartupProbe, old.Spec.StartupProbe)
+	errs.unchanged("spec.terminationGracePeriodSeconds", pod.Spec.TerminationGracePeriodSeconds, old.Spec.TerminationGracePeriodSeconds)
+	return errs
+}
+
+func validateReplicaSet(rs types.ReplicaSet) fieldErrors {
+	var errs fieldErrors
+	errs.validateName("name", rs.Name, isDNS1123Subdomain)
+	errs.validateLabels("labels", rs.Labels)
+	errs.validateSelector("selector", rs.Selector, rs.Template.Labels)
+	errs.validatePodSpec("template", rs.Template, true)
+	return errs
+}
+
+func validateReplicaSetUpdate(rs, old types.ReplicaSet) fieldErrors {
+	errs := validateReplicaSet(rs)
+	errs.validateUpdatedName("name", rs.Name, old.Name)
+	errs.unchanged("selector", rs.Selector, old.Selector)
+	return errs
+}
+
+func validateDeployment(d types.Deployment) fieldErrors {
+	var errs fieldErrors
+	errs.validateName("name", d.Name, isDNS1123Subdomain)
+	errs.validateSelector("selector", d.Selector, d.Template.Labels)
+	errs.validatePodSpec("template", d.Template, true)
+	return errs
+}
+
+func validateDeploymentUpdate(d, old types.Deployment) fieldErrors {
+	errs := validateDeployment(d)
+	errs.validateUpdatedName("name", d.Name, old.Name)
+	errs.unchanged("selector", d.Selector, old.Selector)
+	return errs
+}
+
+func validateService(svc types.Service) fieldErrors {
+	var errs fieldErrors
+	errs.validateName("name", svc.Name, isDNS1123Label)
+	errs.validateLabels("labels", svc.Labels)
+	errs.validateLabels("selector", svc.Selector)
+
+	if len(svc.Ports) == 0 {
+		errs.required("ports")
+	}
+	names := make(map[string]bool)
+	for i, p := range svc.Ports {
+		field := fmt.Sprintf("ports[%d]", i)
+		if p.Name != "" {
+			if msg := isDNS1123Label(p.Name); msg != "" {
+				errs.invalid(field+".name", p.Name, msg)
+			}
+		}
+		if names[p.Name] {
+			errs.duplicate(field+".name", p.Name)
+		}
+		names[p.Name] = true
+		errs.validatePort(field+".port", p.Port)
+		if p.TargetPort != 0 {
+			errs.validatePort(field+".targetPort", p.TargetPort)
+		}
+		if p.Protocol != types.ProtocolTCP {
+			errs.notSupported(field+".protocol", p.Protocol, string(types.ProtocolTCP))
+		}
+	}
+
+	if svc.ClusterIP != "" && net.ParseIP(svc.ClusterIP).To4() == nil {
+		errs.invalid("clusterIP", svc.ClusterIP, "must be a valid IPv4 address")
+	}
+	return errs
+}
+
+func validateServiceUpdate(svc, old types.Service) fieldErrors {
+	errs := validateService(svc)
+	errs.validateUpdatedName("name", svc.Name, old.Name)
+	if old.ClusterIP != "" {
+		errs.unchanged("clusterIP", svc.ClusterIP, old.ClusterIP)
+	}
+	return errs
+}
+
+func validateEndpoints(ep types.Endpoints) fieldErrors {
+	var errs fieldErrors
+	errs.validateName("name", ep.Name, isDNS1123Label)
+	for i, addr := range ep.Addresses {
+		if net.ParseIP(addr.IP) == nil {
+			errs.invalid(fmt.Sprintf("addresses[%d].ip", i), addr.IP, "must be a valid IP address")
+		}
+	}
+	for i, p := range ep.Ports {
+		errs.validatePort(fmt.Sprintf("ports[%d].port", i), p.Port)
+	}
+	return errs
+}
+
+func validateEndpointsUpdate(ep, old types.Endpoints) fieldErrors {
+	errs := validateEndpoints(ep)
+	errs.validateUpdatedName("name", ep.Name, old.Name)
+	return errs
+}
+
+func validateNamespace(ns types.Namespace) fieldErrors {
+	var errs fieldErrors
+	errs.validateName("name", ns.Name, isDNS1123Label)
+	errs.validateLabels("labels", ns.Labels)
+	return errs
+}
+
+func validateNamespaceUpdate(ns, old types.Namespace) fieldErrors {
+	errs := validateNamespace(ns)
+	errs.validateUpdatedName("name", ns.Name, old.Name)
+	return errs
+}
+
+func validateNode(node types.Node) fieldErrors {
+	var errs fieldErrors
+	errs.validateName("name", node.Name, isDNS1123Subdomain)
+	errs.validateLabels("labels", node.Labels)
+	return errs
+}
+
+func validateNodeUpdate(node, old types.Node) fieldErrors {
+	errs := validateNode(node)
+	errs.validateUpdatedName("name", node.Name, old.Name)
+	return errs
+}
+
+var verbs = []string{types.VerbGet, types.VerbList, types.VerbWatch, types.VerbCreate, types.VerbUpdate, types.VerbPatch, types.VerbDelete, types.VerbAll}
+
+func validateRole(role types.Role) fieldErrors {
+	var errs fieldErrors
+	errs.validateName("name", role.Name, isPathSegment)
+	for i, rule := range role.Rules {
+		field := fmt.Sprintf("rules[%d]", i)
+		if len(rule.Verbs) == 0 {
+			errs.required(field + ".verbs")
+		}
+		for j, verb := range rule.Verbs {
+			if !slices.Contains(verbs, verb) {
+				errs.notSupported(fmt.Sprintf("%s.verbs[%d]", field, j), verb, verbs...)
+			}
+		}
+		if len(rule.Resources) == 0 {
+			errs.required(field + ".resources")
+		}
+	}
+	return errs
+}
+
+func validateRoleUpdate(role, old types.Role) fieldErrors {
+	errs := validateRole(role)
+	errs.validateUpdatedName("name", role.Name, old.Name)
+	return errs
+}
+
+func validateRoleBinding(b types.RoleBinding) fieldErrors {
+	var errs fieldErrors
+	errs.validateName("name", b.Name, isPathSegment)
+	if b.Namespace != "" {
+		errs.validateName("namespace", b.Namespace, isDNS1123Label)
+	}
+	errs.validateName("role", b.Role, isPathSegment)
+	if len(b.Subjects) == 0 {
+		errs.required("subjects")
+	}
+	for i, subject := range b.Subjects {
+		field := fmt.Sprintf("subjects[%d]", i)
+		switch subject.Kind {
+		case types.SubjectUser, types.SubjectGroup:
+		default:
+			errs.notSupported(field+".kind", subject.Kind, string(types.SubjectUser), string(types.SubjectGroup))
+		}
+		if subject.Name == "" {
+			errs.required(field + ".name")
+		}
+	}
+	return errs
+}
+
+// validateRoleBindingUpdate also keeps the role, a binding is replaced to
+// grant another.
+func validateRoleBindingUpdate(b, old types.RoleBinding) fieldErrors {
+	errs := validateRoleBinding(b)
+	errs.validateUpdatedName("name", b.Name, old.Name)
+	errs.unchanged("role", b.Role, old.Role)
+	return errs
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"miniku/pkg/types"
+)
+
+func TestCreateValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantFields []string
+	}{
+		{"pod without name", "/pods", `{"spec":{"image":"nginx"}}`, []string{"spec.name"}},
+		{"pod without image", "/pods", `{"spec":{"name":"web"}}`, []string{"spec.image"}},
+		{"pod name not a subdomain", "/pods", `{"spec":{"name":"Web_1","image":"nginx"}}`, []string{"spec.name"}},
+		{"pod empty command", "/pods", `{"spec":{"name":"web","image":"nginx","command":[""]}}`, []string{"spec.command[0]"}},
+		{"pod bad label", "/pods", `{"spec":{"name":"web","image":"nginx","labels":{"-app":"web"}}}`, []string{"spec.labels[-app]"}},
+		{"pod every field at once", "/pods", `{"spec":{"labels":{"app":"a b"}},"status":"Sleeping"}`,
+			[]string{"spec.name", "spec.image", "spec.labels[app]", "status"}},
+		{"replicaset selector misses template", "/replicasets",
+			`{"name":"web","selector":{"app":"web"},"template":{"image":"nginx","labels":{"app":"db"}}}`,
+			[]string{"template.labels"}},
+		{"replicaset without selector or labels", "/replicasets",
+			`{"name":"web","template":{"image":"nginx"}}`, []string{"selector"}},
+		{"deployment selector misses template", "/deployments",
+			`{"name":"web","selector":{"app":"web"},"template":{"image":"nginx"}}`, []string{"template.labels"}},
+		{"service name not a label", "/services", `{"name":"web.shop","ports":[{"port":80}]}`, []string{"name"}},
+		{"service duplicate port names", "/services", `{"name":"web","ports":[{"port":80},{"port":81}]}`,
+			[]string{"ports[1].name"}},
+		{"namespace without name", "/namespaces", `{}`, []string{"name"}},
+		{"node with bad name", "/nodes", `{"name":"node 1"}`, []string{"name"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, podStore, rsStore, _ := newTestServer()
+			ts := httptest.NewServer(srv.Routes())
+			defer ts.Close()
+
+			resp, err := http.Post(ts.URL+tt.path, "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			if resp.StatusCode != http.StatusUnprocessableEntity {
+				t.Fatalf("got status %d, want 422", resp.StatusCode)
+			}
+			var status types.Status
+			if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+				t.Fatal(err)
+			}
+			if status.Reason != types.StatusReasonInvalid {
+				t.Errorf("got reason %q, want %q", status.Reason, types.StatusReasonInvalid)
+			}
+			var fields []string
+			for _, cause := range status.Causes {
+				fields = append(fields, cause.Field)
+			}
+			if !slices.Equal(fields, tt.wantFields) {
+				t.Errorf("got fields %v, want %v", fields, tt.wantFields)
+			}
+			if len(podStore.List()) != 0 || len(rsStore.List()) != 0 {
+				t.Error("invalid object was stored")
+			}
+		})
+	}
+}
+
+func TestCreateDefaulting(t *testing.T) {
+	srv, _, rsStore, _ := newTestServer()
+
+	body := `{"name":"web","template":{"image":"nginx","labels":{"app":"web"}}}`
+	req := httptest.NewRequest("POST", "/replicasets", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	srv.handleCreateReplicaSet(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want 201: %s", rec.Code, rec.Body)
+	}
+	rs, _ := rsStore.Get("default/web")
+	if rs.Selector["app"] != "web" {
+		t.Errorf("got selector %v, want the template labels", rs.Selector)
+	}
+}
+
+func TestUpdateValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantField  string
+	}{
+		{"labels may change", `{"spec":{"name":"web","image":"nginx","labels":{"app":"web"}}}`, http.StatusOK, ""},
+		{"binding to a node", `{"spec":{"name":"web","image":"nginx","node_name":"node-1"}}`, http.StatusOK, ""},
+		{"name differs from url", `{"spec":{"name":"db","image":"nginx"}}`, http.StatusUnprocessableEntity, "spec.name"},
+		{"image is immutable", `{"spec":{"name":"web","image":"redis"}}`, http.StatusUnprocessableEntity, "spec.image"},
+		{"stale write conflicts first", `{"resourceVersion":99,"spec":{"name":"web","image":"redis"}}`, http.StatusConflict, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, podStore, _, _ := newTestServer()
+			podStore.Put("default/web", types.Pod{Spec: types.PodSpec{Name: "web", Image: "nginx"}, Status: types.PodStatusPending})
+
+			req := httptest.NewRequest("PUT", "/pods/web", strings.NewReader(tt.body))
+			req.SetPathValue("name", "web")
+			rec := httptest.NewRecorder()
+			srv.handleUpdatePod(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantField == "" {
+				return
+			}
+			var status types.Status
+			if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+				t.Fatal(err)
+			}
+			if len(status.Causes) != 1 || status.Causes[0].Field != tt.wantField {
+				t.Errorf("got causes %+v, want one for %s", status.Causes, tt.wantField)
+			}
+		})
+	}
+}
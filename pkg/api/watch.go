@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"miniku/pkg/store"
+	"miniku/pkg/types"
+)
+
+func isWatch(r *http.Request) bool {
+	return r.URL.Query().Get("watch") == "true"
+}
+
+// serveWatch streams store changes as newline-delimited JSON events until the
+// client goes away or falls too far behind. ?resourceVersion=N resumes after
+// version N, 410 Gone when the store no longer has it; without it the current
+// objects are sent first as ADDED events.
+// With selectors, objects that start or stop matching are sent as ADDED and
+// DELETED, see resource.filter, and changes to other objects as a BOOKMARK of
+// the version reached, so the client can resume from there.
+func serveWatch[T any](w http.ResponseWriter, r *http.Request, res resource[T], q listQuery) {
+	var since uint64
+	if v := r.URL.Query().Get("resourceVersion"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "invalid resourceVersion", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, stop, err := res.store.Watch(since)
+	if errors.Is(err, store.ErrVersionTooOld) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer stop()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev, ok = res.filter(q, ev); !ok {
+				// one bookmark for a run of skipped events is enough
+				if len(events) > 0 {
+					continue
+				}
+				if ev, ok = bookmark(ev); !ok {
+					continue
+				}
+			}
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// bookmark is an event carrying nothing but ev's resourceVersion, false for
+// objects without one.
+func bookmark[T any](ev types.WatchEvent[T]) (types.WatchEvent[T], bool) {
+	v, ok := any(&ev.Object).(store.Versioned)
+	if !ok {
+		return ev, false
+	}
+	var obj T
+	any(&obj).(store.Versioned).SetResourceVersion(v.GetResourceVersion())
+	return types.WatchEvent[T]{Type: types.EventBookmark, Object: obj}, true
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"miniku/pkg/types"
+)
+
+// ErrInvalidCredentials is returned for a request whose credentials aren't
+// accepted, it is answered with 401.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator finds out who sent a request. ok is false when the request
+// carries no credentials of the kind it checks.
+type Authenticator interface {
+	AuthenticateRequest(r *http.Request) (user types.UserInfo, ok bool, err error)
+}
+
+// Union asks its authenticators in order, the first that accepts the
+// request's credentials decides. Credentials are only invalid if none of
+// them accepts them, e.g. a bearer token is looked up in every token list.
+type Union []Authenticator
+
+func (u Union) AuthenticateRequest(r *http.Request) (types.UserInfo, bool, error) {
+	var invalid error
+	for _, a := range u {
+		user, ok, err := a.AuthenticateRequest(r)
+		switch {
+		case ok:
+			return user, true, nil
+		case errors.Is(err, ErrInvalidCredentials):
+			invalid = err
+		case err != nil:
+			return types.UserInfo{}, false, err
+		}
+	}
+	return types.UserInfo{}, false, invalid
+}
+
+// TokenFile authenticates bearer tokens against a static list.
+type TokenFile struct {
+	tokens map[string]types.UserInfo
+}
+
+// LoadTokenFile reads a CSV file of token,user,uid and optionally a quoted,
+// comma separated list of groups, one token a line:
+//
+//	31ada4fd-adec-460c-809a-9e56ceb75269,system:scheduler,scheduler
+//	a2c1b3d4e5f6,system:node:node-1,node-1,"system:nodes"
+func LoadTokenFile(path string) (*TokenFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	tokens := make(map[string]types.UserInfo, len(records))
+	for i, rec := range records {
+		if len(rec) < 3 || rec[0] == "" || rec[1] == "" {
+			return nil, fmt.Errorf("parse %s: line %d: want token,user,uid[,groups]", path, i+1)
+		}
+		user := types.UserInfo{Name: rec[1], UID: rec[2]}
+		if len(rec) > 3 && rec[3] != "" {
+			user.Groups = strings.Split(rec[3], ",")
+		}
+		tokens[rec[0]] = user
+	}
+	return &TokenFile{tokens: tokens}, nil
+}
+
+// NewTokenFile authenticates the given tokens, e.g. in tests.
+func NewTokenFile(tokens map[string]types.UserInfo) *TokenFile {
+	return &TokenFile{tokens: tokens}
+}
+
+// joinToken is the form of a join token, a public id and a secret.
+var joinToken = regexp.MustCompile(`^([a-z0-9]{6})\.[a-z0-9]{16}$`)
+
+// NewJoinTokens authenticates join tokens, e.g. abcdef.0123456789abcdef, as
+// user system:bootstrap:<id> in GroupBootstrappers. All a node can do with
+// one is ask for its client certificate.
+func NewJoinTokens(tokens []string) (*TokenFile, error) {
+	users := make(map[string]types.UserInfo, len(tokens))
+	for _, token := range tokens {
+		m := joinToken.FindStringSubmatch(token)
+		if m == nil {
+			return nil, fmt.Errorf("join token %q is not of the form [a-z0-9]{6}.[a-z0-9]{16}", token)
+		}
+		users[token] = types.UserInfo{Name: JoinUserPrefix + m[1], Groups: []string{GroupBootstrappers}}
+	}
+	return &TokenFile{tokens: users}, nil
+}
+
+// NewJoinToken returns a random join token for NewJoinTokens.
+func NewJoinToken() (string, error) {
+	const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 22)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = chars[int(b[i])%len(chars)]
+	}
+	return string(b[:6]) + "." + string(b[6:]), nil
+}
+
+func (f *TokenFile) AuthenticateRequest(r *http.Request) (types.UserInfo, bool, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return types.UserInfo{}, false, nil
+	}
+	user, ok := f.tokens[strings.TrimSpace(token)]
+	if !ok {
+		return types.UserInfo{}, false, ErrInvalidCredentials
+	}
+	return user, true, nil
+}
+
+// ClientCert authenticates a request by the client certificate it was sent
+// with over TLS: the common name is the user, the organizations its groups.
+// The certificate must have been verified against the API server's client
+// CA already.
+type ClientCert struct{}
+
+func (ClientCert) AuthenticateRequest(r *http.Request) (types.UserInfo, bool, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return types.UserInfo{}, false, nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return types.UserInfo{}, false, ErrInvalidCredentials
+	}
+	return types.UserInfo{Name: cert.Subject.CommonName, Groups: cert.Subject.Organization}, true, nil
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"miniku/pkg/types"
+)
+
+func TestTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.csv")
+	data := "# token,user,uid,groups\n" +
+		"s3cret,system:scheduler,scheduler\n" +
+		"n0de,system:node:node-1,node-1,\"system:nodes,ops\"\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := LoadTokenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		wantOK     bool
+		wantErr    error
+		wantUser   string
+		wantGroups []string
+	}{
+		{"no header", "", false, nil, "", nil},
+		{"basic auth", "Basic Zm9vOmJhcg==", false, nil, "", nil},
+		{"known token", "Bearer s3cret", true, nil, "system:scheduler", nil},
+		{"token with groups", "Bearer n0de", true, nil, "system:node:node-1", []string{"system:nodes", "ops"}},
+		{"unknown token", "Bearer nope", false, ErrInvalidCredentials, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/pods", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			user, ok, err := tokens.AuthenticateRequest(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if user.Name != tt.wantUser || !slices.Equal(user.Groups, tt.wantGroups) {
+				t.Errorf("got user %+v, want %s in %v", user, tt.wantUser, tt.wantGroups)
+			}
+		})
+	}
+}
+
+func TestUnion(t *testing.T) {
+	joinTokens, err := NewJoinTokens([]string{"abcdef.0123456789abcdef"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := Union{NewTokenFile(map[string]types.UserInfo{"s3cret": {Name: "alice"}}), joinTokens}
+
+	tests := []struct {
+		token    string
+		wantUser string
+		wantErr  error
+	}{
+		{"s3cret", "alice", nil},
+		{"abcdef.0123456789abcdef", "system:bootstrap:abcdef", nil},
+		{"nope", "", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/pods", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			user, _, err := u.AuthenticateRequest(r)
+			if !errors.Is(err, tt.wantErr) || user.Name != tt.wantUser {
+				t.Errorf("got %q, %v, want %q, %v", user.Name, err, tt.wantUser, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewJoinTokens(t *testing.T) {
+	token, err := NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewJoinTokens([]string{token}); err != nil {
+		t.Errorf("NewJoinToken made %q, which NewJoinTokens refuses: %v", token, err)
+	}
+	if _, err := NewJoinTokens([]string{"short.token"}); err == nil {
+		t.Error("got no error for a malformed token")
+	}
+}
+
+func TestLoadTokenFileRejectsShortLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.csv")
+	if err := os.WriteFile(path, []byte("s3cret,system:scheduler\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTokenFile(path); err == nil {
+		t.Fatal("got no error for a line without uid")
+	}
+}
+
+func TestNodeName(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     types.UserInfo
+		wantNode string
+		wantOK   bool
+	}{
+		{"kubelet", types.UserInfo{Name: "system:node:node-1", Groups: []string{GroupNodes}}, "node-1", true},
+		{"not in nodes group", types.UserInfo{Name: "system:node:node-1"}, "", false},
+		{"other user", types.UserInfo{Name: "alice", Groups: []string{GroupNodes}}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, ok := NodeName(tt.user)
+			if node != tt.wantNode || ok != tt.wantOK {
+				t.Errorf("got %q, %v, want %q, %v", node, ok, tt.wantNode, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+
+	"miniku/pkg/types"
+)
+
+// Attributes describe what a request asks to do.
+type Attributes struct {
+	User types.UserInfo
+	// Verb is one of the types.Verb* constants.
+	Verb string
+	// Resource is the collection, or collection/subresource, e.g. pods/log.
+	Resource string
+	// Namespace is empty for resources outside namespaces and lists across
+	// all of them.
+	Namespace string
+	// Name is empty for requests on a whole collection.
+	Name string
+}
+
+// Authorizer decides whether a request is allowed. reason explains a
+// denial.
+type Authorizer interface {
+	Authorize(a Attributes) (allowed bool, reason string)
+}
+
+// RoleGetter and RoleBindingLister are what RBAC reads its policy from,
+// store.RoleStore and store.RoleBindingStore are them.
+type RoleGetter interface {
+	Get(name string) (types.Role, bool)
+}
+
+type RoleBindingLister interface {
+	List() []types.RoleBinding
+}
+
+// RBAC allows a request if a RoleBinding grants its user, or a group they
+// are in, a Role with a rule matching it. Members of GroupMasters are
+// allowed everything.
+type RBAC struct {
+	Roles    RoleGetter
+	Bindings RoleBindingLister
+}
+
+func (a *RBAC) Authorize(attrs Attributes) (bool, string) {
+	if slices.Contains(attrs.User.Groups, GroupMasters) {
+		return true, ""
+	}
+	for _, b := range a.Bindings.List() {
+		if b.Namespace != "" && b.Namespace != attrs.Namespace {
+			continue
+		}
+		if !slices.ContainsFunc(b.Subjects, func(s types.Subject) bool { return appliesTo(s, attrs.User) }) {
+			continue
+		}
+		role, ok := a.Roles.Get(b.Role)
+		if !ok {
+			continue
+		}
+		if slices.ContainsFunc(role.Rules, func(r types.PolicyRule) bool { return allows(r, attrs) }) {
+			return true, ""
+		}
+	}
+
+	where := "in all namespaces"
+	if attrs.Namespace != "" {
+		where = "in namespace " + attrs.Namespace
+	}
+	return false, fmt.Sprintf("user %q cannot %s %s %s", attrs.User.Name, attrs.Verb, attrs.Resource, where)
+}
+
+func appliesTo(s types.Subject, user types.UserInfo) bool {
+	switch s.Kind {
+	case types.SubjectUser:
+		return s.Name == user.Name
+	case types.SubjectGroup:
+		return slices.Contains(user.Groups, s.Name)
+	}
+	return false
+}
+
+func allows(r types.PolicyRule, attrs Attributes) bool {
+	return matches(r.Verbs, attrs.Verb) &&
+		matches(r.Resources, attrs.Resource) &&
+		(len(r.ResourceNames) == 0 || attrs.Name != "" && slices.Contains(r.ResourceNames, attrs.Name))
+}
+
+func matches(allowed []string, v string) bool {
+	return slices.Contains(allowed, types.VerbAll) || slices.Contains(allowed, v)
+}
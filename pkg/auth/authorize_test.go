@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"testing"
+
+	"miniku/pkg/store"
+	"miniku/pkg/types"
+)
+
+func TestRBAC(t *testing.T) {
+	roles := store.NewMemStore[types.Role]()
+	bindings := store.NewMemStore[types.RoleBinding]()
+	for _, role := range BootstrapRoles() {
+		roles.Put(role.Name, role)
+	}
+	for _, b := range BootstrapRoleBindings() {
+		bindings.Put(b.Name, b)
+	}
+	roles.Put("web-editor", types.Role{Name: "web-editor", Rules: []types.PolicyRule{
+		{Verbs: []string{types.VerbGet, types.VerbUpdate}, Resources: []string{"deployments"}, ResourceNames: []string{"web"}},
+	}})
+	bindings.Put("alice-shop", types.RoleBinding{Name: "alice-shop", Namespace: "shop", Role: "web-editor",
+		Subjects: []types.Subject{{Kind: types.SubjectUser, Name: "alice"}}})
+	bindings.Put("viewers", types.RoleBinding{Name: "viewers", Role: "view",
+		Subjects: []types.Subject{{Kind: types.SubjectGroup, Name: "viewers"}}})
+	a := &RBAC{Roles: roles, Bindings: bindings}
+
+	alice := types.UserInfo{Name: "alice", Groups: []string{GroupAuthenticated}}
+	bob := types.UserInfo{Name: "bob", Groups: []string{"viewers", GroupAuthenticated}}
+	admin := types.UserInfo{Name: "root", Groups: []string{GroupMasters}}
+	scheduler := types.UserInfo{Name: UserScheduler}
+	node := types.UserInfo{Name: "system:node:node-1", Groups: []string{GroupNodes}}
+
+	tests := []struct {
+		name  string
+		attrs Attributes
+		want  bool
+	}{
+		{"named object in bound namespace", Attributes{User: alice, Verb: types.VerbUpdate, Resource: "deployments", Namespace: "shop", Name: "web"}, true},
+		{"other name", Attributes{User: alice, Verb: types.VerbUpdate, Resource: "deployments", Namespace: "shop", Name: "api"}, false},
+		{"other namespace", Attributes{User: alice, Verb: types.VerbUpdate, Resource: "deployments", Namespace: "default", Name: "web"}, false},
+		{"verb not in rule", Attributes{User: alice, Verb: types.VerbDelete, Resource: "deployments", Namespace: "shop", Name: "web"}, false},
+		{"list with resource names", Attributes{User: alice, Verb: types.VerbList, Resource: "deployments", Namespace: "shop"}, false},
+		{"group binding", Attributes{User: bob, Verb: types.VerbWatch, Resource: "pods"}, true},
+		{"view can't write", Attributes{User: bob, Verb: types.VerbCreate, Resource: "pods", Namespace: "default"}, false},
+		{"view can't read subresources", Attributes{User: bob, Verb: types.VerbGet, Resource: "pods/log", Namespace: "default", Name: "web"}, false},
+		{"masters", Attributes{User: admin, Verb: types.VerbDelete, Resource: "rolebindings", Name: "viewers"}, true},
+		{"scheduler binds pods", Attributes{User: scheduler, Verb: types.VerbUpdate, Resource: "pods", Namespace: "default", Name: "web"}, true},
+		{"scheduler can't delete pods", Attributes{User: scheduler, Verb: types.VerbDelete, Resource: "pods", Namespace: "default", Name: "web"}, false},
+		{"node heartbeat", Attributes{User: node, Verb: types.VerbUpdate, Resource: "nodes", Name: "node-1"}, true},
+		{"node can't create pods", Attributes{User: node, Verb: types.VerbCreate, Resource: "pods", Namespace: "default"}, false},
+		{"anonymous", Attributes{User: Anonymous, Verb: types.VerbList, Resource: "pods"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, reason := a.Authorize(tt.attrs); got != tt.want {
+				t.Errorf("got allowed %v (%s), want %v", got, reason, tt.want)
+			}
+		})
+	}
+}
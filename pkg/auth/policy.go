@@ -0,0 +1,59 @@
+package auth
+
+import "miniku/pkg/types"
+
+var (
+	readVerbs = []string{types.VerbGet, types.VerbList, types.VerbWatch}
+	allVerbs  = []string{types.VerbAll}
+)
+
+// BootstrapRoles are the roles the API server creates when they are
+// missing: cluster-admin and view for people, and one per system component.
+func BootstrapRoles() []types.Role {
+	return []types.Role{
+		{Name: "cluster-admin", Rules: []types.PolicyRule{
+			{Verbs: allVerbs, Resources: []string{"*"}},
+		}},
+		{Name: "view", Rules: []types.PolicyRule{
+			{Verbs: readVerbs, Resources: []string{"pods", "replicasets", "deployments", "services", "endpoints", "namespaces", "nodes"}},
+		}},
+		{Name: "system:scheduler", Rules: []types.PolicyRule{
+			{Verbs: readVerbs, Resources: []string{"pods", "nodes"}},
+			// binding a pod is a patch of its node name
+			{Verbs: []string{types.VerbUpdate, types.VerbPatch}, Resources: []string{"pods"}},
+		}},
+		{Name: "system:controller-manager", Rules: []types.PolicyRule{
+			{Verbs: allVerbs, Resources: []string{"pods", "replicasets", "deployments", "services", "endpoints", "namespaces"}},
+			{Verbs: append([]string{types.VerbUpdate, types.VerbPatch}, readVerbs...), Resources: []string{"nodes"}},
+			// the grants of a deleted namespace go with it
+			{Verbs: append([]string{types.VerbDelete}, readVerbs...), Resources: []string{"rolebindings"}},
+		}},
+		// what a kubelet may write is narrowed down further to its own node
+		// and the pods bound to it by admission.NodeRestriction
+		{Name: "system:node", Rules: []types.PolicyRule{
+			{Verbs: readVerbs, Resources: []string{"pods", "nodes", "services", "endpoints", "namespaces"}},
+			{Verbs: []string{types.VerbCreate, types.VerbUpdate, types.VerbPatch}, Resources: []string{"nodes"}},
+			{Verbs: []string{types.VerbUpdate, types.VerbPatch, types.VerbDelete}, Resources: []string{"pods"}},
+			// renewing its client and getting its serving certificate
+			{Verbs: []string{types.VerbCreate}, Resources: []string{"certificatesigningrequests"}},
+		}},
+		{Name: "system:node-bootstrapper", Rules: []types.PolicyRule{
+			{Verbs: []string{types.VerbCreate}, Resources: []string{"certificatesigningrequests"}},
+		}},
+	}
+}
+
+// BootstrapRoleBindings give the bootstrap roles to the system components.
+func BootstrapRoleBindings() []types.RoleBinding {
+	user := func(name string) []types.Subject { return []types.Subject{{Kind: types.SubjectUser, Name: name}} }
+	group := func(name string) []types.Subject { return []types.Subject{{Kind: types.SubjectGroup, Name: name}} }
+	return []types.RoleBinding{
+		{Name: "cluster-admin", Role: "cluster-admin", Subjects: group(GroupMasters)},
+		{Name: "system:scheduler", Role: "system:scheduler", Subjects: user(UserScheduler)},
+		{Name: "system:controller-manager", Role: "system:controller-manager", Subjects: user(UserControllerManager)},
+		{Name: "system:nodes", Role: "system:node", Subjects: group(GroupNodes)},
+		{Name: "system:node-bootstrapper", Role: "system:node-bootstrapper", Subjects: group(GroupBootstrappers)},
+		{Name: "system:kube-proxy", Role: "view", Subjects: user(UserKubeProxy)},
+		{Name: "system:dns", Role: "view", Subjects: user(UserDNS)},
+	}
+}
@@ -0,0 +1,61 @@
+// Package auth finds out who sends a request to the API server and decides
+// whether they may do what they ask for.
+package auth
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"miniku/pkg/types"
+)
+
+// The users and groups the system components and requests without
+// credentials are known as.
+const (
+	UserAnonymous         = "system:anonymous"
+	UserScheduler         = "system:scheduler"
+	UserControllerManager = "system:controller-manager"
+	UserKubeProxy         = "system:kube-proxy"
+	UserDNS               = "system:dns"
+	// UserAPIServer is the API server talking to kubelets.
+	UserAPIServer = "system:apiserver"
+	// NodeUserPrefix followed by the node's name is the user of a kubelet.
+	NodeUserPrefix = "system:node:"
+	// JoinUserPrefix followed by a join token's id is the user of a node
+	// that joins with it.
+	JoinUserPrefix = "system:bootstrap:"
+
+	GroupUnauthenticated = "system:unauthenticated"
+	GroupAuthenticated   = "system:authenticated"
+	GroupNodes           = "system:nodes"
+	// GroupBootstrappers may ask for the client certificate of a node.
+	GroupBootstrappers = "system:bootstrappers"
+	// GroupMasters is allowed everything without asking RBAC.
+	GroupMasters = "system:masters"
+)
+
+// Anonymous is the user of a request without credentials.
+var Anonymous = types.UserInfo{Name: UserAnonymous, Groups: []string{GroupUnauthenticated}}
+
+// NodeName returns the node a kubelet's user is for.
+func NodeName(user types.UserInfo) (string, bool) {
+	name, ok := strings.CutPrefix(user.Name, NodeUserPrefix)
+	if !ok || name == "" || !slices.Contains(user.Groups, GroupNodes) {
+		return "", false
+	}
+	return name, true
+}
+
+type userKey struct{}
+
+// WithUser returns ctx carrying the user of the request it belongs to.
+func WithUser(ctx context.Context, user types.UserInfo) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFrom returns the user WithUser put into ctx.
+func UserFrom(ctx context.Context) (types.UserInfo, bool) {
+	user, ok := ctx.Value(userKey{}).(types.UserInfo)
+	return user, ok
+}
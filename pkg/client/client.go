@@ -2,81 +2,293 @@ package client
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"miniku/pkg/types"
 	"net/http"
+	"net/url"
+	"strconv"
 )
 
+// ErrConflict is returned by the Update* methods when the API server rejects
+// the write because the object changed since it was read. Callers should
+// re-read the object, re-apply their change and try again. The Patch*
+// methods return it when a test operation of a JSON patch failed.
+var ErrConflict = errors.New("conflict")
+
+// ErrInvalid is returned by the Create*, Update* and Patch* methods when the
+// API server rejects the object as invalid. The error carries the server's
+// message naming the invalid fields.
+var ErrInvalid = errors.New("invalid")
+
+// ErrNotFound is returned by the Patch* methods when there is no object to
+// patch.
+var ErrNotFound = errors.New("not found")
+
+const maxConflictRetries = 5
+
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
+	// token is sent as a bearer token with every request, if set
+	token string
+	// tls is used to talk to an https API server, if set
+	tls *tls.Config
 }
 
-func New(apiServerURL string) *Client {
-	return &Client{
-		baseURL:    apiServerURL,
-		httpClient: &http.Client{},
+func New(apiServerURL string, opts ...Option) *Client {
+	c := &Client{baseURL: apiServerURL}
+	for _, opt := range opts {
+		opt(c)
+	}
+	var transport http.RoundTripper = http.DefaultTransport
+	if c.tls != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = c.tls
+		transport = t
+	}
+	if c.token != "" {
+		transport = &bearerAuth{token: c.token, next: transport}
 	}
+	c.httpClient = &http.Client{Transport: transport}
+	return c
+}
+
+// bearerAuth adds the Authorization header to the requests it passes on.
+type bearerAuth struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (b *bearerAuth) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	return b.next.RoundTrip(req)
 }
 
 // Pods
 
-func (c *Client) ListPods() ([]types.Pod, error) {
+func (c *Client) ListPods(opts ...ListOption) ([]types.Pod, error) {
 	var pods []types.Pod
-	if err := c.list("/pods", &pods); err != nil {
+	if err := c.list(listPath("/pods", opts), &pods); err != nil {
 		return nil, err
 	}
 	return pods, nil
 }
 
-func (c *Client) GetPod(name string) (types.Pod, bool, error) {
+func (c *Client) GetPod(namespace, name string) (types.Pod, bool, error) {
 	var pod types.Pod
-	found, err := c.get("/pods/"+name, &pod)
+	found, err := c.get(namespacedPath(namespace, "pods")+"/"+name, &pod)
 	return pod, found, err
 }
 
 func (c *Client) CreatePod(pod types.Pod) error {
-	return c.create("/pods", pod)
+	return c.create(namespacedPath(pod.Spec.Namespace, "pods"), pod)
 }
 
 func (c *Client) UpdatePod(name string, pod types.Pod) error {
-	return c.update("/pods/"+name, pod)
+	return c.update(namespacedPath(pod.Spec.Namespace, "pods")+"/"+name, pod)
 }
 
-func (c *Client) DeletePod(name string) error {
-	return c.delete("/pods/" + name)
+// PatchPod changes the fields of pod name that the patch of type pt names
+// and returns the pod as stored.
+func (c *Client) PatchPod(namespace, name string, pt types.PatchType, data []byte) (types.Pod, error) {
+	var pod types.Pod
+	err := c.patch(namespacedPath(namespace, "pods")+"/"+name, pt, data, &pod)
+	return pod, err
 }
 
-func (c *Client) ListReplicaSets() ([]types.ReplicaSet, error) {
+func (c *Client) DeletePod(namespace, name string, opts ...DeleteOption) error {
+	return c.delete(deletePath(namespacedPath(namespace, "pods")+"/"+name, opts))
+}
+
+func (c *Client) ListReplicaSets(opts ...ListOption) ([]types.ReplicaSet, error) {
 	var rsList []types.ReplicaSet
-	if err := c.list("/replicasets", &rsList); err != nil {
+	if err := c.list(listPath("/replicasets", opts), &rsList); err != nil {
 		return nil, err
 	}
 	return rsList, nil
 }
 
-func (c *Client) GetReplicaSet(name string) (types.ReplicaSet, bool, error) {
+func (c *Client) GetReplicaSet(namespace, name string) (types.ReplicaSet, bool, error) {
 	var rs types.ReplicaSet
-	found, err := c.get("/replicasets/"+name, &rs)
+	found, err := c.get(namespacedPath(namespace, "replicasets")+"/"+name, &rs)
 	return rs, found, err
 }
 
 func (c *Client) CreateReplicaSet(rs types.ReplicaSet) error {
-	return c.create("/replicasets", rs)
+	return c.create(namespacedPath(rs.Namespace, "replicasets"), rs)
 }
 
 func (c *Client) UpdateReplicaSet(name string, rs types.ReplicaSet) error {
-	return c.update("/replicasets/"+name, rs)
+	return c.update(namespacedPath(rs.Namespace, "replicasets")+"/"+name, rs)
+}
+
+func (c *Client) PatchReplicaSet(namespace, name string, pt types.PatchType, data []byte) (types.ReplicaSet, error) {
+	var rs types.ReplicaSet
+	err := c.patch(namespacedPath(namespace, "replicasets")+"/"+name, pt, data, &rs)
+	return rs, err
 }
 
-func (c *Client) DeleteReplicaSet(name string) error {
-	return c.delete("/replicasets/" + name)
+func (c *Client) DeleteReplicaSet(namespace, name string, opts ...DeleteOption) error {
+	return c.delete(deletePath(namespacedPath(namespace, "replicasets")+"/"+name, opts))
 }
 
-func (c *Client) ListNodes() ([]types.Node, error) {
+func (c *Client) ListDeployments(opts ...ListOption) ([]types.Deployment, error) {
+	var deployments []types.Deployment
+	if err := c.list(listPath("/deployments", opts), &deployments); err != nil {
+		return nil, err
+	}
+	return deployments, nil
+}
+
+func (c *Client) GetDeployment(namespace, name string) (types.Deployment, bool, error) {
+	var d types.Deployment
+	found, err := c.get(namespacedPath(namespace, "deployments")+"/"+name, &d)
+	return d, found, err
+}
+
+func (c *Client) CreateDeployment(d types.Deployment) error {
+	return c.create(namespacedPath(d.Namespace, "deployments"), d)
+}
+
+func (c *Client) UpdateDeployment(name string, d types.Deployment) error {
+	return c.update(namespacedPath(d.Namespace, "deployments")+"/"+name, d)
+}
+
+func (c *Client) PatchDeployment(namespace, name string, pt types.PatchType, data []byte) (types.Deployment, error) {
+	var d types.Deployment
+	err := c.patch(namespacedPath(namespace, "deployments")+"/"+name, pt, data, &d)
+	return d, err
+}
+
+func (c *Client) DeleteDeployment(namespace, name string, opts ...DeleteOption) error {
+	return c.delete(deletePath(namespacedPath(namespace, "deployments")+"/"+name, opts))
+}
+
+// RollbackDeployment rolls the deployment back to the pod template of an
+// earlier revision, or to the previous one if revision is zero.
+func (c *Client) RollbackDeployment(namespace, name string, revision uint64) error {
+	return c.post(namespacedPath(namespace, "deployments")+"/"+name+"/rollback", types.DeploymentRollback{Revision: revision})
+}
+
+// PauseDeployment stops template changes from being rolled out until
+// ResumeDeployment is called.
+func (c *Client) PauseDeployment(namespace, name string) error {
+	return c.setPaused(namespace, name, true)
+}
+
+func (c *Client) ResumeDeployment(namespace, name string) error {
+	return c.setPaused(namespace, name, false)
+}
+
+func (c *Client) setPaused(namespace, name string, paused bool) error {
+	return RetryOnConflict(func() error {
+		d, found, err := c.GetDeployment(namespace, name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("deployment %s not found", name)
+		}
+		d.Paused = paused
+		return c.UpdateDeployment(name, d)
+	})
+}
+
+func (c *Client) ListServices(opts ...ListOption) ([]types.Service, error) {
+	var services []types.Service
+	if err := c.list(listPath("/services", opts), &services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+func (c *Client) GetService(namespace, name string) (types.Service, bool, error) {
+	var svc types.Service
+	found, err := c.get(namespacedPath(namespace, "services")+"/"+name, &svc)
+	return svc, found, err
+}
+
+// CreateService creates a service, read it back for the cluster IP it was
+// given.
+func (c *Client) CreateService(svc types.Service) error {
+	return c.create(namespacedPath(svc.Namespace, "services"), svc)
+}
+
+func (c *Client) UpdateService(name string, svc types.Service) error {
+	return c.update(namespacedPath(svc.Namespace, "services")+"/"+name, svc)
+}
+
+func (c *Client) DeleteService(namespace, name string, opts ...DeleteOption) error {
+	return c.delete(deletePath(namespacedPath(namespace, "services")+"/"+name, opts))
+}
+
+func (c *Client) ListEndpoints(opts ...ListOption) ([]types.Endpoints, error) {
+	var endpoints []types.Endpoints
+	if err := c.list(listPath("/endpoints", opts), &endpoints); err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+func (c *Client) GetEndpoints(namespace, name string) (types.Endpoints, bool, error) {
+	var ep types.Endpoints
+	found, err := c.get(namespacedPath(namespace, "endpoints")+"/"+name, &ep)
+	return ep, found, err
+}
+
+func (c *Client) CreateEndpoints(ep types.Endpoints) error {
+	return c.create(namespacedPath(ep.Namespace, "endpoints"), ep)
+}
+
+func (c *Client) UpdateEndpoints(name string, ep types.Endpoints) error {
+	return c.update(namespacedPath(ep.Namespace, "endpoints")+"/"+name, ep)
+}
+
+func (c *Client) PatchEndpoints(namespace, name string, pt types.PatchType, data []byte) (types.Endpoints, error) {
+	var ep types.Endpoints
+	err := c.patch(namespacedPath(namespace, "endpoints")+"/"+name, pt, data, &ep)
+	return ep, err
+}
+
+func (c *Client) DeleteEndpoints(namespace, name string, opts ...DeleteOption) error {
+	return c.delete(deletePath(namespacedPath(namespace, "endpoints")+"/"+name, opts))
+}
+
+func (c *Client) ListNamespaces(opts ...ListOption) ([]types.Namespace, error) {
+	var namespaces []types.Namespace
+	if err := c.list(listPath("/namespaces", opts), &namespaces); err != nil {
+		return nil, err
+	}
+	return namespaces, nil
+}
+
+func (c *Client) GetNamespace(name string) (types.Namespace, bool, error) {
+	var ns types.Namespace
+	found, err := c.get("/namespaces/"+name, &ns)
+	return ns, found, err
+}
+
+func (c *Client) CreateNamespace(ns types.Namespace) error {
+	return c.create("/namespaces", ns)
+}
+
+func (c *Client) UpdateNamespace(name string, ns types.Namespace) error {
+	return c.update("/namespaces/"+name, ns)
+}
+
+// DeleteNamespace starts deleting a namespace and everything in it. The
+// namespace stays, Terminating, until the namespace controller emptied it.
+func (c *Client) DeleteNamespace(name string) error {
+	return c.delete("/namespaces/" + name)
+}
+
+func (c *Client) ListNodes(opts ...ListOption) ([]types.Node, error) {
 	var nodes []types.Node
-	if err := c.list("/nodes", &nodes); err != nil {
+	if err := c.list(listPath("/nodes", opts), &nodes); err != nil {
 		return nil, err
 	}
 	return nodes, nil
@@ -96,22 +308,145 @@ func (c *Client) UpdateNode(name string, node types.Node) error {
 	return c.update("/nodes/"+name, node)
 }
 
+func (c *Client) PatchNode(name string, pt types.PatchType, data []byte) (types.Node, error) {
+	var node types.Node
+	err := c.patch("/nodes/"+name, pt, data, &node)
+	return node, err
+}
+
 func (c *Client) DeleteNode(name string) error {
 	return c.delete("/nodes/" + name)
 }
 
+func (c *Client) ListRoles() ([]types.Role, error) {
+	var roles []types.Role
+	if err := c.list("/roles", &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (c *Client) GetRole(name string) (types.Role, bool, error) {
+	var role types.Role
+	found, err := c.get("/roles/"+name, &role)
+	return role, found, err
+}
+
+func (c *Client) CreateRole(role types.Role) error {
+	return c.create("/roles", role)
+}
+
+func (c *Client) UpdateRole(name string, role types.Role) error {
+	return c.update("/roles/"+name, role)
+}
+
+func (c *Client) DeleteRole(name string) error {
+	return c.delete("/roles/" + name)
+}
+
+func (c *Client) ListRoleBindings(opts ...ListOption) ([]types.RoleBinding, error) {
+	var bindings []types.RoleBinding
+	if err := c.list(listPath("/rolebindings", opts), &bindings); err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
+
+func (c *Client) GetRoleBinding(name string) (types.RoleBinding, bool, error) {
+	var b types.RoleBinding
+	found, err := c.get("/rolebindings/"+name, &b)
+	return b, found, err
+}
+
+func (c *Client) CreateRoleBinding(b types.RoleBinding) error {
+	return c.create("/rolebindings", b)
+}
+
+func (c *Client) UpdateRoleBinding(name string, b types.RoleBinding) error {
+	return c.update("/rolebindings/"+name, b)
+}
+
+func (c *Client) DeleteRoleBinding(name string) error {
+	return c.delete("/rolebindings/" + name)
+}
+
+// SignCertificate asks the API server's CA to sign a certificate request
+// and returns the answer carrying the certificate.
+func (c *Client) SignCertificate(csr types.CertificateSigningRequest) (types.CertificateSigningRequest, error) {
+	const path = "/certificatesigningrequests"
+	data, err := json.Marshal(csr)
+	if err != nil {
+		return csr, err
+	}
+
+	resp, err := c.httpClient.Post(c.baseURL+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return csr, fmt.Errorf("POST %s: %w", path, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return csr, invalidError("POST", path, resp)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		var status types.Status
+		if json.NewDecoder(resp.Body).Decode(&status) == nil && status.Message != "" {
+			return csr, fmt.Errorf("POST %s: status %d: %s", path, resp.StatusCode, status.Message)
+		}
+		return csr, fmt.Errorf("POST %s: status %d", path, resp.StatusCode)
+	}
+
+	var signed types.CertificateSigningRequest
+	if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+		return csr, err
+	}
+	return signed, nil
+}
+
+// namespacedPath is the path of a resource collection in namespace, the
+// default namespace when it is empty.
+func namespacedPath(namespace, resource string) string {
+	if namespace == "" {
+		namespace = types.DefaultNamespace
+	}
+	return "/namespaces/" + url.PathEscape(namespace) + "/" + resource
+}
+
+// RetryOnConflict calls fn until it returns anything other than ErrConflict,
+// giving up after a few attempts. fn is expected to refresh its copy of the
+// object when it hits a conflict.
+func RetryOnConflict(fn func() error) error {
+	var err error
+	for range maxConflictRetries {
+		if err = fn(); !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+	return err
+}
+
 func (c *Client) list(path string, out any) error {
+	_, err := c.listVersion(path, out)
+	return err
+}
+
+// listVersion is list, also returning the resourceVersion the API server's
+// store was at, which a watch can resume from.
+func (c *Client) listVersion(path string, out any) (uint64, error) {
 	resp, err := c.httpClient.Get(c.baseURL + path)
 	if err != nil {
-		return fmt.Errorf("GET %s: %w", path, err)
+		return 0, fmt.Errorf("GET %s: %w", path, err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("GET %s: status %d", path, resp.StatusCode)
+		return 0, fmt.Errorf("GET %s: status %d", path, resp.StatusCode)
 	}
 
-	return json.NewDecoder(resp.Body).Decode(out)
+	// older API servers don't send it, the informer then resumes from the
+	// newest object listed
+	version, _ := strconv.ParseUint(resp.Header.Get("X-Resource-Version"), 10, 64)
+	return version, json.NewDecoder(resp.Body).Decode(out)
 }
 
 func (c *Client) get(path string, out any) (bool, error) {
@@ -146,12 +481,37 @@ func (c *Client) create(path string, body any) error {
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return invalidError("POST", path, resp)
+	}
 	if resp.StatusCode != http.StatusCreated {
 		return fmt.Errorf("POST %s: status %d", path, resp.StatusCode)
 	}
 	return nil
 }
 
+// post sends an action to a subresource, e.g. a rollback.
+func (c *Client) post(path string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Post(c.baseURL+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("POST %s: %w", path, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("POST %s: %w", path, ErrConflict)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST %s: status %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
 func (c *Client) update(path string, body any) error {
 	data, err := json.Marshal(body)
 	if err != nil {
@@ -170,12 +530,56 @@ func (c *Client) update(path string, body any) error {
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("PUT %s: %w", path, ErrConflict)
+	}
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return invalidError("PUT", path, resp)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("PUT %s: status %d", path, resp.StatusCode)
 	}
 	return nil
 }
 
+// invalidError wraps ErrInvalid with the message of the types.Status the
+// API server answered 422 with.
+func invalidError(method, path string, resp *http.Response) error {
+	var status types.Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil || status.Message == "" {
+		return fmt.Errorf("%s %s: %w", method, path, ErrInvalid)
+	}
+	return fmt.Errorf("%s %s: %w: %s", method, path, ErrInvalid, status.Message)
+}
+
+// patch sends a patch of type pt and decodes the patched object into out. A
+// JSON patch whose test failed is ErrConflict.
+func (c *Client) patch(path string, pt types.PatchType, data []byte, out any) error {
+	req, err := http.NewRequest(http.MethodPatch, c.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(pt))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("PATCH %s: %w", path, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(resp.Body).Decode(out)
+	case http.StatusNotFound:
+		return fmt.Errorf("PATCH %s: %w", path, ErrNotFound)
+	case http.StatusConflict:
+		return fmt.Errorf("PATCH %s: %w", path, ErrConflict)
+	case http.StatusUnprocessableEntity:
+		return invalidError("PATCH", path, resp)
+	}
+	return fmt.Errorf("PATCH %s: status %d", path, resp.StatusCode)
+}
+
 func (c *Client) delete(path string) error {
 	req, err := http.NewRequest(http.MethodDelete, c.baseURL+path, nil)
 	if err != nil {
@@ -188,7 +592,8 @@ func (c *Client) delete(path string) error {
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode != http.StatusNoContent {
+	// 202 is a namespace being emptied or an object kept by finalizers
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusAccepted {
 		return fmt.Errorf("DELETE %s: status %d", path, resp.StatusCode)
 	}
 	return nil
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"errors"
 	"miniku/pkg/api"
 	"miniku/pkg/store"
 	"miniku/pkg/types"
@@ -13,7 +14,12 @@ func setup() (*Client, store.PodStore, store.ReplicaSetStore, store.NodeStore, *
 	rsStore := store.NewMemStore[types.ReplicaSet]()
 	nodeStore := store.NewMemStore[types.Node]()
 
-	srv := &api.Server{PodStore: podStore, RSStore: rsStore, NodeStore: nodeStore}
+	srv := &api.Server{
+		PodStore:       podStore,
+		RSStore:        rsStore,
+		NodeStore:      nodeStore,
+		NamespaceStore: store.NewMemStore[types.Namespace](),
+	}
 	ts := httptest.NewServer(srv.Routes())
 
 	c := New(ts.URL)
@@ -39,7 +45,7 @@ func TestPodRoundTrip(t *testing.T) {
 	}
 
 	// get
-	got, found, err := c.GetPod("test")
+	got, found, err := c.GetPod("default", "test")
 	if err != nil {
 		t.Fatalf("GetPod: %v", err)
 	}
@@ -66,7 +72,7 @@ func TestPodRoundTrip(t *testing.T) {
 		t.Fatalf("UpdatePod: %v", err)
 	}
 
-	updated, _, _ := c.GetPod("test")
+	updated, _, _ := c.GetPod("default", "test")
 	if updated.Spec.NodeName != "node-1" {
 		t.Errorf("got node %q, want %q", updated.Spec.NodeName, "node-1")
 	}
@@ -74,12 +80,19 @@ func TestPodRoundTrip(t *testing.T) {
 		t.Errorf("got status %q, want %q", updated.Status, types.PodStatusRunning)
 	}
 
-	// delete
-	if err := c.DeletePod("test"); err != nil {
+	// delete, a pod on a node is kept for its grace period
+	if err := c.DeletePod("default", "test"); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	deleting, found, _ := c.GetPod("default", "test")
+	if !found || !deleting.Deleting() {
+		t.Fatalf("got %+v, want the pod marked deleted", deleting)
+	}
+	if err := c.DeletePod("default", "test", GracePeriod(0)); err != nil {
 		t.Fatalf("DeletePod: %v", err)
 	}
 
-	_, found, _ = c.GetPod("test")
+	_, found, _ = c.GetPod("default", "test")
 	if found {
 		t.Error("expected pod to be deleted")
 	}
@@ -93,7 +106,7 @@ func TestReplicaSetRoundTrip(t *testing.T) {
 		Name:         "web",
 		DesiredCount: 3,
 		Selector:     map[string]string{"app": "web"},
-		Template:     types.PodSpec{Image: "nginx"},
+		Template:     types.PodSpec{Image: "nginx", Labels: map[string]string{"app": "web"}},
 	}
 	if err := c.CreateReplicaSet(rs); err != nil {
 		t.Fatalf("CreateReplicaSet: %v", err)
@@ -103,7 +116,7 @@ func TestReplicaSetRoundTrip(t *testing.T) {
 		t.Fatalf("expected 1 RS in store")
 	}
 
-	got, found, err := c.GetReplicaSet("web")
+	got, found, err := c.GetReplicaSet("default", "web")
 	if err != nil {
 		t.Fatalf("GetReplicaSet: %v", err)
 	}
@@ -127,16 +140,16 @@ func TestReplicaSetRoundTrip(t *testing.T) {
 		t.Fatalf("UpdateReplicaSet: %v", err)
 	}
 
-	updated, _, _ := c.GetReplicaSet("web")
+	updated, _, _ := c.GetReplicaSet("default", "web")
 	if updated.DesiredCount != 5 {
 		t.Errorf("got desired %d, want 5", updated.DesiredCount)
 	}
 
-	if err := c.DeleteReplicaSet("web"); err != nil {
+	if err := c.DeleteReplicaSet("default", "web"); err != nil {
 		t.Fatalf("DeleteReplicaSet: %v", err)
 	}
 
-	_, found, _ = c.GetReplicaSet("web")
+	_, found, _ = c.GetReplicaSet("default", "web")
 	if found {
 		t.Error("expected RS to be deleted")
 	}
@@ -198,7 +211,7 @@ func TestGetNotFound(t *testing.T) {
 	c, _, _, _, ts := setup()
 	defer ts.Close()
 
-	_, found, err := c.GetPod("nonexistent")
+	_, found, err := c.GetPod("default", "nonexistent")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -206,7 +219,7 @@ func TestGetNotFound(t *testing.T) {
 		t.Error("expected not found")
 	}
 
-	_, found, err = c.GetReplicaSet("nonexistent")
+	_, found, err = c.GetReplicaSet("default", "nonexistent")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -222,3 +235,154 @@ func TestGetNotFound(t *testing.T) {
 		t.Error("expected not found")
 	}
 }
+
+func TestUpdateConflict(t *testing.T) {
+	c, podStore, _, _, ts := setup()
+	defer ts.Close()
+
+	if err := c.CreatePod(types.Pod{Spec: types.PodSpec{Name: "test", Image: "nginx"}}); err != nil {
+		t.Fatalf("CreatePod: %v", err)
+	}
+	stale, _, _ := c.GetPod("default", "test")
+
+	// someone else writes in between
+	podStore.Put("default/test", types.Pod{Spec: types.PodSpec{Name: "test", Image: "nginx", NodeName: "node-1"}})
+
+	stale.Status = types.PodStatusRunning
+	err := c.UpdatePod("test", stale)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("got err %v, want ErrConflict", err)
+	}
+
+	// re-read and retry keeps the concurrent write
+	err = RetryOnConflict(func() error {
+		fresh, _, getErr := c.GetPod("default", "test")
+		if getErr != nil {
+			return getErr
+		}
+		fresh.Status = types.PodStatusRunning
+		return c.UpdatePod("test", fresh)
+	})
+	if err != nil {
+		t.Fatalf("RetryOnConflict: %v", err)
+	}
+
+	got, _, _ := c.GetPod("default", "test")
+	if got.Spec.NodeName != "node-1" || got.Status != types.PodStatusRunning {
+		t.Errorf("got node %q status %q, want node-1/Running", got.Spec.NodeName, got.Status)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	c, podStore, rsStore, _, ts := setup()
+	defer ts.Close()
+
+	podStore.Put("default/test", types.Pod{Spec: types.PodSpec{Name: "test", Namespace: "default", Image: "nginx"}})
+	bind := []byte(`[{"op":"test","path":"/spec/node_name","value":""},{"op":"replace","path":"/spec/node_name","value":"node-1"}]`)
+
+	pod, err := c.PatchPod("default", "test", types.JSONPatchType, bind)
+	if err != nil {
+		t.Fatalf("PatchPod: %v", err)
+	}
+	if pod.Spec.NodeName != "node-1" || pod.Spec.Image != "nginx" {
+		t.Errorf("got node %q image %q, want node-1 and nginx", pod.Spec.NodeName, pod.Spec.Image)
+	}
+
+	// the pod is no longer unbound
+	if _, err := c.PatchPod("default", "test", types.JSONPatchType, bind); !errors.Is(err, ErrConflict) {
+		t.Errorf("got err %v, want ErrConflict", err)
+	}
+	if _, err := c.PatchPod("default", "test", types.MergePatchType, []byte(`{"spec":{"image":"redis"}}`)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("got err %v, want ErrInvalid", err)
+	}
+	if _, err := c.PatchNode("nonexistent", types.MergePatchType, []byte(`{}`)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got err %v, want ErrNotFound", err)
+	}
+
+	rsStore.Put("default/web", types.ReplicaSet{Name: "web", Namespace: "default", DesiredCount: 2, CurrentCount: 2,
+		Selector: map[string]string{"app": "web"}, Template: types.PodSpec{Image: "nginx", Labels: map[string]string{"app": "web"}}})
+	rs, err := c.PatchReplicaSet("default", "web", types.MergePatchType, []byte(`{"readyCount":1}`))
+	if err != nil {
+		t.Fatalf("PatchReplicaSet: %v", err)
+	}
+	if rs.DesiredCount != 2 || rs.ReadyCount != 1 {
+		t.Errorf("got desired %d ready %d, want 2 and 1", rs.DesiredCount, rs.ReadyCount)
+	}
+}
+
+func TestRetryOnConflictGivesUp(t *testing.T) {
+	calls := 0
+	err := RetryOnConflict(func() error {
+		calls++
+		return ErrConflict
+	})
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("got err %v, want ErrConflict", err)
+	}
+	if calls != maxConflictRetries {
+		t.Errorf("got %d calls, want %d", calls, maxConflictRetries)
+	}
+}
+
+func TestWatchPods(t *testing.T) {
+	c, podStore, _, _, ts := setup()
+	defer ts.Close()
+
+	created := podStore.Put("default/a", types.Pod{Spec: types.PodSpec{Name: "a", Image: "nginx"}})
+
+	events, stop, err := c.WatchPods(created.ResourceVersion)
+	if err != nil {
+		t.Fatalf("WatchPods: %v", err)
+	}
+	defer stop()
+
+	created.Status = types.PodStatusRunning
+	podStore.Put("default/a", created)
+
+	ev, ok := <-events
+	if !ok {
+		t.Fatal("watch closed unexpectedly")
+	}
+	if ev.Type != types.EventModified || ev.Object.Status != types.PodStatusRunning {
+		t.Errorf("got %s with status %q, want MODIFIED/Running", ev.Type, ev.Object.Status)
+	}
+
+	stop()
+	for range events {
+		// drain until the reader notices the stop
+	}
+}
+
+func TestWatchExpired(t *testing.T) {
+	c, _, _, nodeStore, ts := setup()
+	defer ts.Close()
+
+	for range 1100 {
+		nodeStore.Put("node-1", types.Node{Name: "node-1"})
+	}
+
+	if _, _, err := c.WatchNodes(1); !errors.Is(err, ErrExpired) {
+		t.Fatalf("got err %v, want ErrExpired", err)
+	}
+}
+
+func TestListPodsWithSelectors(t *testing.T) {
+	c, podStore, _, _, ts := setup()
+	defer ts.Close()
+
+	podStore.Put("default/web-1", types.Pod{Spec: types.PodSpec{Name: "web-1", NodeName: "node-1", Labels: map[string]string{"app": "web"}}})
+	podStore.Put("default/web-2", types.Pod{Spec: types.PodSpec{Name: "web-2", NodeName: "node-2", Labels: map[string]string{"app": "web"}}})
+	podStore.Put("default/db-1", types.Pod{Spec: types.PodSpec{Name: "db-1", NodeName: "node-1", Labels: map[string]string{"app": "db"}}})
+
+	pods, err := c.ListPods(LabelSelector("app=web"), FieldSelector("spec.node_name=node-1"))
+	if err != nil {
+		t.Fatalf("ListPods: %v", err)
+	}
+	if len(pods) != 1 || pods[0].Spec.Name != "web-1" {
+		t.Errorf("got %v, want only web-1", pods)
+	}
+
+	if _, err := c.ListPods(LabelSelector("app in web")); err == nil {
+		t.Error("expected error for malformed selector")
+	}
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"miniku/pkg/remotecommand"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type ExecOptions struct {
+	Command []string
+	// Stdin is sent to the command until it returns EOF.
+	Stdin  io.Reader
+	Stdout io.Writer
+	// Stderr isn't used with a TTY, the terminal carries both streams.
+	Stderr io.Writer
+	// TTY runs the command on a pseudo terminal.
+	TTY bool
+	// Resize sets the terminal's size while TTY is set.
+	Resize <-chan remotecommand.TerminalSize
+}
+
+// Exec runs a command in a pod's container and returns its exit code. err is
+// only set when the command couldn't be run at all.
+func (c *Client) Exec(namespace, name string, opts ExecOptions) (int, error) {
+	if len(opts.Command) == 0 {
+		return -1, errors.New("no command given")
+	}
+	q := url.Values{"command": opts.Command}
+	if opts.Stdin != nil {
+		q.Set("stdin", "true")
+	}
+	if opts.TTY {
+		q.Set("tty", "true")
+	}
+	path := namespacedPath(namespace, "pods") + "/" + name + "/exec?" + q.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+path, nil)
+	if err != nil {
+		return -1, err
+	}
+	remotecommand.SetUpgradeHeaders(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return -1, fmt.Errorf("POST %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		return -1, fmt.Errorf("POST %s: status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		_ = resp.Body.Close()
+		return -1, fmt.Errorf("POST %s: connection can't be written to", path)
+	}
+	conn := remotecommand.NewConn(rwc)
+	defer func() { _ = conn.Close() }()
+
+	done := make(chan struct{})
+	defer close(done)
+	if opts.Stdin != nil {
+		go func() {
+			_, _ = io.Copy(conn.Writer(remotecommand.StreamStdin), opts.Stdin)
+			// an empty frame closes the command's stdin
+			_ = conn.WriteFrame(remotecommand.StreamStdin, nil)
+		}()
+	}
+	if opts.Resize != nil {
+		go func() {
+			for {
+				select {
+				case size, ok := <-opts.Resize:
+					if !ok {
+						return
+					}
+					_ = conn.WriteJSON(remotecommand.StreamResize, size)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		stream, p, err := conn.ReadFrame()
+		if err != nil {
+			return -1, fmt.Errorf("exec in %s: connection lost: %w", name, err)
+		}
+		var w io.Writer
+		switch stream {
+		case remotecommand.StreamStdout:
+			w = opts.Stdout
+		case remotecommand.StreamStderr:
+			w = opts.Stderr
+		case remotecommand.StreamStatus:
+			var status remotecommand.Status
+			if err := json.Unmarshal(p, &status); err != nil {
+				return -1, fmt.Errorf("exec in %s: decode status: %w", name, err)
+			}
+			if status.Error != "" {
+				return -1, fmt.Errorf("exec in %s: %s", name, status.Error)
+			}
+			return status.ExitCode, nil
+		}
+		if w != nil {
+			if _, err := w.Write(p); err != nil {
+				return -1, err
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package client
+
+import "sync"
+
+// IndexFunc returns the index values an object should be found under.
+type IndexFunc[T any] func(obj T) []string
+
+// Indexer is a thread-safe object cache keyed by name, with optional
+// secondary indexes so lookups such as "pods on node-1" avoid a full scan.
+type Indexer[T any] struct {
+	mu        sync.RWMutex
+	items     map[string]T
+	indexFunc map[string]IndexFunc[T]
+	// index name -> index value -> set of keys
+	indices map[string]map[string]map[string]struct{}
+}
+
+func NewIndexer[T any]() *Indexer[T] {
+	return &Indexer[T]{
+		items:     make(map[string]T),
+		indexFunc: make(map[string]IndexFunc[T]),
+		indices:   make(map[string]map[string]map[string]struct{}),
+	}
+}
+
+// AddIndex registers a secondary index and indexes the current contents.
+func (x *Indexer[T]) AddIndex(name string, fn IndexFunc[T]) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.indexFunc[name] = fn
+	x.indices[name] = make(map[string]map[string]struct{})
+	for key, obj := range x.items {
+		x.addToIndex(name, key, obj)
+	}
+}
+
+func (x *Indexer[T]) Get(key string) (T, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	obj, ok := x.items[key]
+	return obj, ok
+}
+
+func (x *Indexer[T]) List() []T {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	out := make([]T, 0, len(x.items))
+	for _, obj := range x.items {
+		out = append(out, obj)
+	}
+	return out
+}
+
+// ListKeys returns the keys of all cached objects.
+func (x *Indexer[T]) ListKeys() []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	out := make([]string, 0, len(x.items))
+	for key := range x.items {
+		out = append(out, key)
+	}
+	return out
+}
+
+// ByIndex returns the objects indexed under value in the named index.
+func (x *Indexer[T]) ByIndex(name, value string) []T {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	keys := x.indices[name][value]
+	out := make([]T, 0, len(keys))
+	for key := range keys {
+		out = append(out, x.items[key])
+	}
+	return out
+}
+
+func (x *Indexer[T]) put(key string, obj T) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if old, ok := x.items[key]; ok {
+		x.removeFromIndices(key, old)
+	}
+	x.items[key] = obj
+	for name := range x.indexFunc {
+		x.addToIndex(name, key, obj)
+	}
+}
+
+func (x *Indexer[T]) delete(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if old, ok := x.items[key]; ok {
+		x.removeFromIndices(key, old)
+		delete(x.items, key)
+	}
+}
+
+func (x *Indexer[T]) addToIndex(name, key string, obj T) {
+	for _, value := range x.indexFunc[name](obj) {
+		keys := x.indices[name][value]
+		if keys == nil {
+			keys = make(map[string]struct{})
+			x.indices[name][value] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func (x *Indexer[T]) removeFromIndices(key string, obj T) {
+	for name, fn := range x.indexFunc {
+		for _, value := range fn(obj) {
+			keys := x.indices[name][value]
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(x.indices[name], value)
+			}
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package client
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"miniku/pkg/types"
+)
+
+// EventHandler receives cache changes from an Informer. Handlers run on the
+// informer's goroutine and should only do cheap work such as queueing keys.
+// Any of the funcs may be nil.
+type EventHandler[T any] struct {
+	OnAdd    func(obj T)
+	OnUpdate func(oldObj, newObj T)
+	OnDelete func(obj T)
+}
+
+// ListFunc lists the objects along with the resourceVersion to watch from,
+// zero when unknown.
+type ListFunc[T any] func() ([]T, uint64, error)
+type WatchFunc[T any] func(resourceVersion uint64) (<-chan types.WatchEvent[T], func(), error)
+
+// Informer keeps a local cache of one resource in sync with the API server.
+// It lists once, then follows the watch stream, relisting when the stream
+// expires. Without a watch func it falls back to relisting every
+// PollInterval.
+type Informer[T any] struct {
+	list  ListFunc[T]
+	watch WatchFunc[T]
+	key   func(T) string
+	cache *Indexer[T]
+
+	// PollInterval is the relist period when polling, and the back-off
+	// after a failed list or watch.
+	PollInterval time.Duration
+	// ResyncPeriod re-delivers every cached object as an update, so
+	// handlers can act on state that changes outside the API server
+	// (container exits, heartbeats going stale). Zero disables it.
+	ResyncPeriod time.Duration
+
+	mu       sync.RWMutex
+	handlers []EventHandler[T]
+
+	synced   chan struct{}
+	syncOnce sync.Once
+}
+
+func NewInformer[T any](list ListFunc[T], watch WatchFunc[T], key func(T) string) *Informer[T] {
+	return &Informer[T]{
+		list:         list,
+		watch:        watch,
+		key:          key,
+		cache:        NewIndexer[T](),
+		PollInterval: 5 * time.Second,
+		synced:       make(chan struct{}),
+	}
+}
+
+// Informers of namespaced objects key them by types.Key, namespace/name.
+
+// PodInformer caches the pods matching opts, e.g. a kubelet only watching
+// FieldSelector("spec.node_name=" + name).
+func (c *Client) PodInformer(opts ...ListOption) *Informer[types.Pod] {
+	return NewInformer(
+		listFunc[types.Pod](c, "/pods", opts),
+		func(rv uint64) (<-chan types.WatchEvent[types.Pod], func(), error) { return c.WatchPods(rv, opts...) },
+		func(p types.Pod) string { return types.Key(p.Spec.Namespace, p.Spec.Name) },
+	)
+}
+
+func (c *Client) ReplicaSetInformer(opts ...ListOption) *Informer[types.ReplicaSet] {
+	return NewInformer(
+		listFunc[types.ReplicaSet](c, "/replicasets", opts),
+		func(rv uint64) (<-chan types.WatchEvent[types.ReplicaSet], func(), error) {
+			return c.WatchReplicaSets(rv, opts...)
+		},
+		func(rs types.ReplicaSet) string { return types.Key(rs.Namespace, rs.Name) },
+	)
+}
+
+func (c *Client) DeploymentInformer(opts ...ListOption) *Informer[types.Deployment] {
+	return NewInformer(
+		listFunc[types.Deployment](c, "/deployments", opts),
+		func(rv uint64) (<-chan types.WatchEvent[types.Deployment], func(), error) {
+			return c.WatchDeployments(rv, opts...)
+		},
+		func(d types.Deployment) string { return types.Key(d.Namespace, d.Name) },
+	)
+}
+
+func (c *Client) ServiceInformer(opts ...ListOption) *Informer[types.Service] {
+	return NewInformer(
+		listFunc[types.Service](c, "/services", opts),
+		func(rv uint64) (<-chan types.WatchEvent[types.Service], func(), error) {
+			return c.WatchServices(rv, opts...)
+		},
+		func(svc types.Service) string { return types.Key(svc.Namespace, svc.Name) },
+	)
+}
+
+func (c *Client) EndpointsInformer(opts ...ListOption) *Informer[types.Endpoints] {
+	return NewInformer(
+		listFunc[types.Endpoints](c, "/endpoints", opts),
+		func(rv uint64) (<-chan types.WatchEvent[types.Endpoints], func(), error) {
+			return c.WatchEndpoints(rv, opts...)
+		},
+		func(ep types.Endpoints) string { return types.Key(ep.Namespace, ep.Name) },
+	)
+}
+
+func (c *Client) NamespaceInformer(opts ...ListOption) *Informer[types.Namespace] {
+	return NewInformer(
+		listFunc[types.Namespace](c, "/namespaces", opts),
+		func(rv uint64) (<-chan types.WatchEvent[types.Namespace], func(), error) {
+			return c.WatchNamespaces(rv, opts...)
+		},
+		func(ns types.Namespace) string { return ns.Name },
+	)
+}
+
+func (c *Client) NodeInformer(opts ...ListOption) *Informer[types.Node] {
+	return NewInformer(
+		listFunc[types.Node](c, "/nodes", opts),
+		func(rv uint64) (<-chan types.WatchEvent[types.Node], func(), error) { return c.WatchNodes(rv, opts...) },
+		func(n types.Node) string { return n.Name },
+	)
+}
+
+func listFunc[T any](c *Client, path string, opts []ListOption) ListFunc[T] {
+	return func() ([]T, uint64, error) {
+		var items []T
+		version, err := c.listVersion(listPath(path, opts), &items)
+		return items, version, err
+	}
+}
+
+func (i *Informer[T]) AddEventHandler(h EventHandler[T]) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.handlers = append(i.handlers, h)
+}
+
+func (i *Informer[T]) AddIndex(name string, fn IndexFunc[T]) {
+	i.cache.AddIndex(name, fn)
+}
+
+func (i *Informer[T]) Get(key string) (T, bool) {
+	return i.cache.Get(key)
+}
+
+func (i *Informer[T]) List() []T {
+	return i.cache.List()
+}
+
+func (i *Informer[T]) ByIndex(name, value string) []T {
+	return i.cache.ByIndex(name, value)
+}
+
+// Synced is closed once the first list has been loaded into the cache.
+func (i *Informer[T]) Synced() <-chan struct{} {
+	return i.synced
+}
+
+// Run keeps the cache in sync until stop is closed. A nil stop runs forever.
+func (i *Informer[T]) Run(stop <-chan struct{}) {
+	if i.ResyncPeriod > 0 {
+		go i.resyncLoop(stop)
+	}
+
+	for {
+		items, version, err := i.list()
+		if err != nil {
+			log.Printf("informer: list failed: %v", err)
+			if !sleep(stop, i.PollInterval) {
+				return
+			}
+			continue
+		}
+		version = max(version, i.replace(items))
+
+		if i.watch == nil {
+			if !sleep(stop, i.PollInterval) {
+				return
+			}
+			continue
+		}
+		if !i.watchFrom(version, stop) {
+			return
+		}
+	}
+}
+
+// watchFrom follows the change stream, re-watching whenever the stream ends.
+// It returns when a relist is needed, or false once stop is closed.
+func (i *Informer[T]) watchFrom(version uint64, stop <-chan struct{}) bool {
+	for {
+		events, cancel, err := i.watch(version)
+		if errors.Is(err, ErrExpired) {
+			// relisting straight away could expire again just the same
+			log.Printf("informer: watch from %d expired, relisting", version)
+			return sleep(stop, i.PollInterval)
+		}
+		if err != nil {
+			log.Printf("informer: watch failed: %v", err)
+			return sleep(stop, i.PollInterval)
+		}
+
+		version = i.consume(events, stop, version)
+		cancel()
+		select {
+		case <-stop:
+			return false
+		default:
+		}
+	}
+}
+
+func (i *Informer[T]) consume(events <-chan types.WatchEvent[T], stop <-chan struct{}, version uint64) uint64 {
+	for {
+		select {
+		case <-stop:
+			return version
+		case ev, ok := <-events:
+			if !ok {
+				return version
+			}
+			if ev.Type != types.EventBookmark {
+				i.apply(ev)
+			}
+			version = max(version, objectVersion(&ev.Object))
+		}
+	}
+}
+
+func (i *Informer[T]) apply(ev types.WatchEvent[T]) {
+	key := i.key(ev.Object)
+	old, exists := i.cache.Get(key)
+
+	if ev.Type == types.EventDeleted {
+		if exists {
+			i.cache.delete(key)
+			i.dispatchDelete(ev.Object)
+		}
+		return
+	}
+	i.upsert(key, old, exists, ev.Object)
+}
+
+// upsert stores obj unless the cache already holds the same or a newer
+// version, which happens when a watch replays events a list already saw.
+func (i *Informer[T]) upsert(key string, old T, exists bool, obj T) {
+	if exists {
+		version := objectVersion(&obj)
+		if version != 0 && version <= objectVersion(&old) {
+			return
+		}
+	}
+
+	i.cache.put(key, obj)
+	if exists {
+		i.dispatchUpdate(old, obj)
+	} else {
+		i.dispatchAdd(obj)
+	}
+}
+
+// replace syncs the cache with a full list and returns the newest version
+// seen, the watch resumes from it when the list had no version.
+func (i *Informer[T]) replace(items []T) uint64 {
+	var version uint64
+	seen := make(map[string]struct{}, len(items))
+	for _, obj := range items {
+		key := i.key(obj)
+		seen[key] = struct{}{}
+		old, exists := i.cache.Get(key)
+		i.upsert(key, old, exists, obj)
+		version = max(version, objectVersion(&obj))
+	}
+
+	for _, key := range i.cache.ListKeys() {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		if old, ok := i.cache.Get(key); ok {
+			i.cache.delete(key)
+			i.dispatchDelete(old)
+		}
+	}
+
+	i.syncOnce.Do(func() { close(i.synced) })
+	return version
+}
+
+func (i *Informer[T]) resyncLoop(stop <-chan struct{}) {
+	<-i.synced
+	for sleep(stop, i.ResyncPeriod) {
+		for _, obj := range i.cache.List() {
+			i.dispatchUpdate(obj, obj)
+		}
+	}
+}
+
+func (i *Informer[T]) dispatchAdd(obj T) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for _, h := range i.handlers {
+		if h.OnAdd != nil {
+			h.OnAdd(obj)
+		}
+	}
+}
+
+func (i *Informer[T]) dispatchUpdate(oldObj, newObj T) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for _, h := range i.handlers {
+		if h.OnUpdate != nil {
+			h.OnUpdate(oldObj, newObj)
+		}
+	}
+}
+
+func (i *Informer[T]) dispatchDelete(obj T) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for _, h := range i.handlers {
+		if h.OnDelete != nil {
+			h.OnDelete(obj)
+		}
+	}
+}
+
+func objectVersion[T any](obj *T) uint64 {
+	if v, ok := any(obj).(interface{ GetResourceVersion() uint64 }); ok {
+		return v.GetResourceVersion()
+	}
+	return 0
+}
+
+// sleep waits for d, returning false if stop was closed first.
+func sleep(stop <-chan struct{}, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,241 @@
+package client
+
+import (
+	"miniku/pkg/types"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type recordingHandler struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (h *recordingHandler) handler() EventHandler[types.Pod] {
+	return EventHandler[types.Pod]{
+		OnAdd:    func(p types.Pod) { h.record("add " + p.Spec.Name) },
+		OnUpdate: func(_, p types.Pod) { h.record("update " + p.Spec.Name) },
+		OnDelete: func(p types.Pod) { h.record("delete " + p.Spec.Name) },
+	}
+}
+
+func (h *recordingHandler) record(ev string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, ev)
+}
+
+func (h *recordingHandler) has(ev string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, e := range h.events {
+		if e == ev {
+			return true
+		}
+	}
+	return false
+}
+
+func waitUntil(t *testing.T, desc string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for: %s", desc)
+}
+
+func TestInformer(t *testing.T) {
+	tests := []struct {
+		name  string
+		watch bool
+	}{
+		{name: "watch", watch: true},
+		{name: "polling fallback", watch: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, podStore, _, _, ts := setup()
+			defer ts.Close()
+			defer ts.CloseClientConnections()
+
+			podStore.Put("default/a", types.Pod{Spec: types.PodSpec{Name: "a", NodeName: "node-1"}})
+
+			inf := c.PodInformer()
+			if !tt.watch {
+				inf = NewInformer(func() ([]types.Pod, uint64, error) {
+					pods, err := c.ListPods()
+					return pods, 0, err
+				}, nil, func(p types.Pod) string { return p.Key() })
+			}
+			inf.PollInterval = 10 * time.Millisecond
+			inf.AddIndex("node", func(p types.Pod) []string { return []string{p.Spec.NodeName} })
+
+			h := &recordingHandler{}
+			inf.AddEventHandler(h.handler())
+
+			stop := make(chan struct{})
+			defer close(stop)
+			go inf.Run(stop)
+			<-inf.Synced()
+
+			if _, ok := inf.Get("default/a"); !ok {
+				t.Fatal("expected pod a in cache after sync")
+			}
+			if !h.has("add a") {
+				t.Error("expected add event for a")
+			}
+
+			podStore.Put("default/b", types.Pod{Spec: types.PodSpec{Name: "b", NodeName: "node-2"}})
+			podStore.Put("default/a", types.Pod{Spec: types.PodSpec{Name: "a", NodeName: "node-2"}})
+			podStore.Delete("default/b")
+
+			waitUntil(t, "a moved to node-2", func() bool {
+				return len(inf.ByIndex("node", "node-2")) == 1 && len(inf.ByIndex("node", "node-1")) == 0
+			})
+			if !h.has("update a") {
+				t.Error("expected update event for a")
+			}
+			if tt.watch {
+				// polling may never observe b's short life
+				waitUntil(t, "b added and deleted", func() bool { return h.has("add b") && h.has("delete b") })
+			}
+			if _, ok := inf.Get("default/b"); ok {
+				t.Error("expected b to be gone from the cache")
+			}
+		})
+	}
+}
+
+func TestInformerResync(t *testing.T) {
+	c, podStore, _, _, ts := setup()
+	defer ts.Close()
+	defer ts.CloseClientConnections()
+
+	podStore.Put("default/a", types.Pod{Spec: types.PodSpec{Name: "a"}})
+
+	inf := c.PodInformer()
+	inf.ResyncPeriod = 10 * time.Millisecond
+	h := &recordingHandler{}
+	inf.AddEventHandler(h.handler())
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go inf.Run(stop)
+
+	waitUntil(t, "resync update for a", func() bool { return h.has("update a") })
+}
+
+// The newest pod the informer lists is older than the watch history, it must
+// watch from the store's version instead of relisting over and over.
+func TestInformerWatchesFromListVersion(t *testing.T) {
+	c, podStore, _, _, ts := setup()
+	defer ts.Close()
+	defer ts.CloseClientConnections()
+
+	podStore.Put("default/a", types.Pod{Spec: types.PodSpec{Name: "a", NodeName: "node-1"}})
+	for range 1100 {
+		podStore.Put("default/b", types.Pod{Spec: types.PodSpec{Name: "b", NodeName: "node-2"}})
+	}
+
+	opt := FieldSelector("spec.node_name=node-1")
+	list := listFunc[types.Pod](c, "/pods", []ListOption{opt})
+	var lists atomic.Int32
+	inf := NewInformer(
+		func() ([]types.Pod, uint64, error) {
+			lists.Add(1)
+			return list()
+		},
+		func(rv uint64) (<-chan types.WatchEvent[types.Pod], func(), error) { return c.WatchPods(rv, opt) },
+		func(p types.Pod) string { return p.Key() },
+	)
+	inf.PollInterval = time.Minute
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go inf.Run(stop)
+	<-inf.Synced()
+
+	podStore.Put("default/c", types.Pod{Spec: types.PodSpec{Name: "c", NodeName: "node-1"}})
+	waitUntil(t, "c in the cache", func() bool {
+		_, ok := inf.Get("default/c")
+		return ok
+	})
+	if n := lists.Load(); n != 1 {
+		t.Errorf("listed %d times, want 1", n)
+	}
+}
+
+// Changes a filtered informer doesn't see still move it along, so a watch
+// that ends after many of them resumes instead of expiring.
+func TestInformerFollowsBookmarks(t *testing.T) {
+	c, podStore, _, _, ts := setup()
+	defer ts.Close()
+	defer ts.CloseClientConnections()
+
+	podStore.Put("default/a", types.Pod{Spec: types.PodSpec{Name: "a", NodeName: "node-1"}})
+
+	opt := FieldSelector("spec.node_name=node-1")
+	list := listFunc[types.Pod](c, "/pods", []ListOption{opt})
+	var lists atomic.Int32
+	var bookmarked atomic.Uint64
+	inf := NewInformer(
+		func() ([]types.Pod, uint64, error) {
+			lists.Add(1)
+			return list()
+		},
+		func(rv uint64) (<-chan types.WatchEvent[types.Pod], func(), error) {
+			events, stop, err := c.WatchPods(rv, opt)
+			if err != nil {
+				return nil, nil, err
+			}
+			out := make(chan types.WatchEvent[types.Pod])
+			go func() {
+				defer close(out)
+				for ev := range events {
+					out <- ev
+					if ev.Type == types.EventBookmark {
+						bookmarked.Store(ev.Object.ResourceVersion)
+					}
+				}
+			}()
+			return out, stop, nil
+		},
+		func(p types.Pod) string { return p.Key() },
+	)
+	inf.PollInterval = time.Minute
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go inf.Run(stop)
+	<-inf.Synced()
+
+	// once the informer sees a change it is watching
+	a := podStore.Put("default/a", types.Pod{Spec: types.PodSpec{Name: "a", NodeName: "node-1"}})
+	waitUntil(t, "a updated", func() bool {
+		got, _ := inf.Get("default/a")
+		return got.ResourceVersion == a.ResourceVersion
+	})
+
+	var last types.Pod
+	for range 1100 {
+		last = podStore.Put("default/b", types.Pod{Spec: types.PodSpec{Name: "b", NodeName: "node-2"}})
+	}
+	waitUntil(t, "bookmark of the last write", func() bool { return bookmarked.Load() == last.ResourceVersion })
+
+	ts.CloseClientConnections()
+	podStore.Put("default/c", types.Pod{Spec: types.PodSpec{Name: "c", NodeName: "node-1"}})
+	waitUntil(t, "c in the cache", func() bool {
+		_, ok := inf.Get("default/c")
+		return ok
+	})
+	if n := lists.Load(); n != 1 {
+		t.Errorf("listed %d times, want 1", n)
+	}
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type LogOptions struct {
+	// Follow keeps the stream open until the container exits.
+	Follow bool
+	// TailLines only returns that many of the last lines, 0 means all.
+	TailLines int
+	// Previous reads the log of the container that ran before a restart.
+	Previous bool
+	// Timestamps prefixes every line with the time it was written.
+	Timestamps bool
+}
+
+// PodLogs streams the logs of a pod's container. The caller closes the
+// returned reader, which also ends a followed stream.
+func (c *Client) PodLogs(namespace, name string, opts LogOptions) (io.ReadCloser, error) {
+	q := url.Values{}
+	if opts.Follow {
+		q.Set("follow", "true")
+	}
+	if opts.TailLines > 0 {
+		q.Set("tail", strconv.Itoa(opts.TailLines))
+	}
+	if opts.Previous {
+		q.Set("previous", "true")
+	}
+	if opts.Timestamps {
+		q.Set("timestamps", "true")
+	}
+	path := namespacedPath(namespace, "pods") + "/" + name + "/log"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	resp, err := c.httpClient.Get(c.baseURL + path)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
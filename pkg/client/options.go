@@ -0,0 +1,113 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"strconv"
+
+	"miniku/pkg/pki"
+	"miniku/pkg/types"
+)
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken authenticates every request with a bearer token.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithCABundle trusts an https API server whose certificate one of pool's
+// CAs signed, instead of the system's CAs.
+func WithCABundle(pool *x509.CertPool) Option {
+	return func(c *Client) { c.tlsConfig().RootCAs = pool }
+}
+
+// WithClientCert authenticates every request with a client certificate.
+func WithClientCert(cert tls.Certificate) Option {
+	return func(c *Client) { c.tlsConfig().Certificates = []tls.Certificate{cert} }
+}
+
+// TLSFromFiles reads a CA bundle and a client key pair for WithCABundle and
+// WithClientCert. Empty paths are left out.
+func TLSFromFiles(caFile, certFile, keyFile string) ([]Option, error) {
+	var opts []Option
+	if caFile != "" {
+		pool, err := pki.LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithCABundle(pool))
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithClientCert(cert))
+	}
+	return opts, nil
+}
+
+func (c *Client) tlsConfig() *tls.Config {
+	if c.tls == nil {
+		c.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return c.tls
+}
+
+// ListOption narrows down what a List*, Watch* or *Informer call returns.
+type ListOption func(url.Values)
+
+// LabelSelector selects objects by label, e.g. "app=web,tier!=cache" or
+// "env in (prod,staging)".
+func LabelSelector(s string) ListOption {
+	return func(q url.Values) { q.Set("labelSelector", s) }
+}
+
+// FieldSelector selects objects by field, e.g. "spec.node_name=node-1".
+func FieldSelector(s string) ListOption {
+	return func(q url.Values) { q.Set("fieldSelector", s) }
+}
+
+func listQuery(opts []ListOption) url.Values {
+	q := url.Values{}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+func listPath(path string, opts []ListOption) string {
+	if q := listQuery(opts); len(q) > 0 {
+		return path + "?" + q.Encode()
+	}
+	return path
+}
+
+// DeleteOption changes how a Delete* call deletes.
+type DeleteOption func(url.Values)
+
+// PropagationPolicy decides what happens to the objects the deleted one
+// owns, background deletion when not given.
+func PropagationPolicy(p types.DeletionPropagation) DeleteOption {
+	return func(q url.Values) { q.Set("propagationPolicy", string(p)) }
+}
+
+// GracePeriod gives a pod that long to stop instead of the grace period of
+// its spec. Zero removes it without waiting for the kubelet.
+func GracePeriod(seconds int64) DeleteOption {
+	return func(q url.Values) { q.Set("gracePeriodSeconds", strconv.FormatInt(seconds, 10)) }
+}
+
+func deletePath(path string, opts []DeleteOption) string {
+	q := url.Values{}
+	for _, opt := range opts {
+		opt(q)
+	}
+	if len(q) > 0 {
+		return path + "?" + q.Encode()
+	}
+	return path
+}
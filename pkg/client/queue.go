@@ -0,0 +1,123 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	queueBaseDelay = 5 * time.Millisecond
+	queueMaxDelay  = 60 * time.Second
+)
+
+// WorkQueue is a de-duplicating queue of object keys. A key added several
+// times before it is processed is handed out once, and a key is never handed
+// to two workers at the same time: adding it while it is being processed
+// re-queues it once Done is called.
+type WorkQueue struct {
+	mu           sync.Mutex
+	cond         *sync.Cond
+	queue        []string
+	dirty        map[string]struct{}
+	processing   map[string]struct{}
+	failures     map[string]int
+	shuttingDown bool
+}
+
+func NewWorkQueue() *WorkQueue {
+	q := &WorkQueue{
+		dirty:      make(map[string]struct{}),
+		processing: make(map[string]struct{}),
+		failures:   make(map[string]int),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *WorkQueue) Add(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if _, ok := q.dirty[key]; ok {
+		return
+	}
+	q.dirty[key] = struct{}{}
+	if _, ok := q.processing[key]; ok {
+		return
+	}
+	q.queue = append(q.queue, key)
+	q.cond.Signal()
+}
+
+// AddAfter adds key once delay has passed.
+func (q *WorkQueue) AddAfter(key string, delay time.Duration) {
+	if delay <= 0 {
+		q.Add(key)
+		return
+	}
+	time.AfterFunc(delay, func() { q.Add(key) })
+}
+
+// AddRateLimited re-adds a key that failed, backing off exponentially with
+// the number of consecutive failures until Forget is called.
+func (q *WorkQueue) AddRateLimited(key string) {
+	q.mu.Lock()
+	failures := q.failures[key]
+	q.failures[key] = failures + 1
+	q.mu.Unlock()
+
+	q.AddAfter(key, min(queueMaxDelay, queueBaseDelay*time.Duration(1<<min(failures, 20))))
+}
+
+// Forget resets the failure count of key after it was processed successfully.
+func (q *WorkQueue) Forget(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.failures, key)
+}
+
+// Get blocks until a key is available. It returns false once the queue is
+// shut down. Every key returned must be passed to Done.
+func (q *WorkQueue) Get() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.queue) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if len(q.queue) == 0 {
+		return "", false
+	}
+
+	key := q.queue[0]
+	q.queue = q.queue[1:]
+	q.processing[key] = struct{}{}
+	delete(q.dirty, key)
+	return key, true
+}
+
+// Done marks key as processed, re-queueing it if it was added meanwhile.
+func (q *WorkQueue) Done(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.processing, key)
+	if _, ok := q.dirty[key]; ok {
+		q.queue = append(q.queue, key)
+		q.cond.Signal()
+	}
+}
+
+func (q *WorkQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queue)
+}
+
+// ShutDown makes Get return false once the queue has drained.
+func (q *WorkQueue) ShutDown() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkQueueDeduplicates(t *testing.T) {
+	q := NewWorkQueue()
+	q.Add("a")
+	q.Add("b")
+	q.Add("a")
+
+	if got := q.Len(); got != 2 {
+		t.Fatalf("got len %d, want 2", got)
+	}
+
+	key, _ := q.Get()
+	if key != "a" {
+		t.Errorf("got %q, want a", key)
+	}
+
+	// adding a key that is being processed defers it until Done
+	q.Add("a")
+	if got := q.Len(); got != 1 {
+		t.Errorf("got len %d while a is processing, want 1", got)
+	}
+	q.Done("a")
+	if got := q.Len(); got != 2 {
+		t.Errorf("got len %d after Done, want 2", got)
+	}
+}
+
+func TestWorkQueueRateLimited(t *testing.T) {
+	q := NewWorkQueue()
+
+	q.AddRateLimited("a")
+	q.AddRateLimited("a")
+
+	start := time.Now()
+	key, ok := q.Get()
+	if !ok || key != "a" {
+		t.Fatalf("got %q/%v, want a/true", key, ok)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("rate limited add took too long")
+	}
+	q.Done(key)
+
+	q.mu.Lock()
+	failures := q.failures["a"]
+	q.mu.Unlock()
+	if failures != 2 {
+		t.Errorf("got %d failures, want 2", failures)
+	}
+
+	q.Forget("a")
+	q.mu.Lock()
+	_, tracked := q.failures["a"]
+	q.mu.Unlock()
+	if tracked {
+		t.Error("expected Forget to reset failures")
+	}
+}
+
+func TestWorkQueueShutDown(t *testing.T) {
+	q := NewWorkQueue()
+	q.Add("a")
+	q.ShutDown()
+
+	if key, ok := q.Get(); !ok || key != "a" {
+		t.Errorf("got %q/%v, want queued key before shutdown", key, ok)
+	}
+	if _, ok := q.Get(); ok {
+		t.Error("expected Get to return false after shutdown")
+	}
+	q.Add("b")
+	if got := q.Len(); got != 0 {
+		t.Errorf("got len %d, want adds ignored after shutdown", got)
+	}
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"miniku/pkg/types"
+)
+
+// ErrExpired is returned by the Watch* methods when the requested
+// resourceVersion is no longer retained by the API server. Callers should
+// list again (or watch from zero) and start over.
+var ErrExpired = errors.New("resource version expired")
+
+// WatchPods streams pod changes after resourceVersion. With a resourceVersion
+// of zero the current pods are sent first as ADDED events. A watch with
+// selectors gets BOOKMARK events for the changes it doesn't see. The channel
+// is closed when the stream ends; call stop to end it early.
+func (c *Client) WatchPods(resourceVersion uint64, opts ...ListOption) (<-chan types.WatchEvent[types.Pod], func(), error) {
+	return watch[types.Pod](c, "/pods", resourceVersion, opts)
+}
+
+func (c *Client) WatchReplicaSets(resourceVersion uint64, opts ...ListOption) (<-chan types.WatchEvent[types.ReplicaSet], func(), error) {
+	return watch[types.ReplicaSet](c, "/replicasets", resourceVersion, opts)
+}
+
+func (c *Client) WatchDeployments(resourceVersion uint64, opts ...ListOption) (<-chan types.WatchEvent[types.Deployment], func(), error) {
+	return watch[types.Deployment](c, "/deployments", resourceVersion, opts)
+}
+
+func (c *Client) WatchServices(resourceVersion uint64, opts ...ListOption) (<-chan types.WatchEvent[types.Service], func(), error) {
+	return watch[types.Service](c, "/services", resourceVersion, opts)
+}
+
+func (c *Client) WatchEndpoints(resourceVersion uint64, opts ...ListOption) (<-chan types.WatchEvent[types.Endpoints], func(), error) {
+	return watch[types.Endpoints](c, "/endpoints", resourceVersion, opts)
+}
+
+func (c *Client) WatchNamespaces(resourceVersion uint64, opts ...ListOption) (<-chan types.WatchEvent[types.Namespace], func(), error) {
+	return watch[types.Namespace](c, "/namespaces", resourceVersion, opts)
+}
+
+func (c *Client) WatchNodes(resourceVersion uint64, opts ...ListOption) (<-chan types.WatchEvent[types.Node], func(), error) {
+	return watch[types.Node](c, "/nodes", resourceVersion, opts)
+}
+
+func watch[T any](c *Client, path string, resourceVersion uint64, opts []ListOption) (<-chan types.WatchEvent[T], func(), error) {
+	q := listQuery(opts)
+	q.Set("watch", "true")
+	q.Set("resourceVersion", strconv.FormatUint(resourceVersion, 10))
+	resp, err := c.httpClient.Get(c.baseURL + path + "?" + q.Encode())
+	if err != nil {
+		return nil, nil, fmt.Errorf("WATCH %s: %w", path, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusGone {
+			return nil, nil, fmt.Errorf("WATCH %s: %w", path, ErrExpired)
+		}
+		return nil, nil, fmt.Errorf("WATCH %s: status %d", path, resp.StatusCode)
+	}
+
+	events := make(chan types.WatchEvent[T])
+	done := make(chan struct{})
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(done)
+			_ = resp.Body.Close()
+		})
+	}
+
+	go func() {
+		defer close(events)
+		defer stop()
+
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var ev types.WatchEvent[T]
+			if err := dec.Decode(&ev); err != nil {
+				return
+			}
+			select {
+			case events <- ev:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return events, stop, nil
+}
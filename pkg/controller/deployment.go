@@ -0,0 +1,424 @@
+/*
+Deployments roll their pod template out through ReplicaSets, one per
+template. For each deployment:
+ 1. find the ReplicaSets it selects
+ 2. find or create the one whose pod-template-hash matches the template
+ 3. scale that one up as far as maxSurge allows
+ 4. scale the others down as far as maxUnavailable allows
+ 5. delete scaled-down ReplicaSets beyond the revision history limit
+ 6. and then update the status
+
+Rolling back copies an old template into the deployment (see the API
+server), which then rolls out like any other change. Paused deployments
+only get their status updated, and so do deployments being deleted.
+
+ReplicaSets carry an owner reference to their deployment, orphaned ones
+the selector matches are adopted.
+*/
+package controller
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"maps"
+	"miniku/pkg/client"
+	"miniku/pkg/types"
+	"slices"
+	"time"
+)
+
+type DeploymentController struct {
+	client             *client.Client
+	deploymentInformer *client.Informer[types.Deployment]
+	rsInformer         *client.Informer[types.ReplicaSet]
+	queue              *client.WorkQueue
+	PollInterval       time.Duration
+}
+
+func NewDeploymentController(c *client.Client) *DeploymentController {
+	ctrl := &DeploymentController{
+		client:             c,
+		deploymentInformer: c.DeploymentInformer(),
+		rsInformer:         c.ReplicaSetInformer(),
+		queue:              client.NewWorkQueue(),
+		PollInterval:       5 * time.Second,
+	}
+
+	ctrl.deploymentInformer.AddEventHandler(client.EventHandler[types.Deployment]{
+		OnAdd:    func(d types.Deployment) { ctrl.queue.Add(types.Key(d.Namespace, d.Name)) },
+		OnUpdate: func(_, d types.Deployment) { ctrl.queue.Add(types.Key(d.Namespace, d.Name)) },
+	})
+	enqueueOwners := func(rs types.ReplicaSet) {
+		for _, d := range ctrl.deploymentsFor(rs) {
+			ctrl.queue.Add(types.Key(d.Namespace, d.Name))
+		}
+	}
+	ctrl.rsInformer.AddEventHandler(client.EventHandler[types.ReplicaSet]{
+		OnAdd:    enqueueOwners,
+		OnUpdate: func(_, rs types.ReplicaSet) { enqueueOwners(rs) },
+		OnDelete: enqueueOwners,
+	})
+
+	return ctrl
+}
+
+func (c *DeploymentController) Run() {
+	c.startInformers(nil)
+
+	for {
+		key, ok := c.queue.Get()
+		if !ok {
+			return
+		}
+		c.sync(key)
+	}
+}
+
+// startInformers runs the informers until stop is closed and waits for their
+// caches to fill.
+func (c *DeploymentController) startInformers(stop <-chan struct{}) {
+	c.deploymentInformer.PollInterval = c.PollInterval
+	c.deploymentInformer.ResyncPeriod = c.PollInterval
+	c.rsInformer.PollInterval = c.PollInterval
+
+	go c.deploymentInformer.Run(stop)
+	go c.rsInformer.Run(stop)
+	<-c.deploymentInformer.Synced()
+	<-c.rsInformer.Synced()
+}
+
+func (c *DeploymentController) sync(key string) {
+	defer c.queue.Done(key)
+
+	d, ok := c.deploymentInformer.Get(key)
+	if !ok {
+		c.queue.Forget(key)
+		return
+	}
+
+	if err := c.reconcile(d); err != nil {
+		log.Printf("deployment: failed to reconcile %s: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return
+	}
+	c.queue.Forget(key)
+}
+
+func (c *DeploymentController) reconcile(d types.Deployment) error {
+	replicaSets := c.replicaSetsOf(d)
+	hash := templateHash(d.Template)
+
+	var newRS *types.ReplicaSet
+	var oldRSs []types.ReplicaSet
+	for i := range replicaSets {
+		if replicaSets[i].Labels[types.PodTemplateHashLabel] == hash {
+			newRS = &replicaSets[i]
+		} else {
+			oldRSs = append(oldRSs, replicaSets[i])
+		}
+	}
+
+	if d.Paused || d.Deleting() {
+		return c.updateStatus(d, newRS, replicaSets)
+	}
+	if err := c.adoptReplicaSets(d, replicaSets); err != nil {
+		return err
+	}
+
+	latest := latestRevision(replicaSets)
+	if newRS == nil {
+		// the ReplicaSet's add event brings us back here to roll it out
+		replicas := d.Replicas
+		if activeCount(oldRSs) > 0 {
+			replicas = 0
+		}
+		return c.createReplicaSet(d, hash, latest+1, replicas)
+	}
+
+	// rolled back to a template we still have a ReplicaSet for
+	if newRS.Revision < latest {
+		log.Printf("deployment: %s rolling back to %s", d.Name, newRS.Name)
+		return c.updateReplicaSet(*newRS, newRS.DesiredCount, latest+1)
+	}
+
+	if err := c.rollout(d, newRS, oldRSs); err != nil {
+		return err
+	}
+	if err := c.pruneHistory(d, oldRSs); err != nil {
+		return err
+	}
+	return c.updateStatus(d, newRS, replicaSets)
+}
+
+// rollout moves replicas from the old ReplicaSets to the new one, keeping
+// the total within Replicas+maxSurge and the ready pods at or above
+// Replicas-maxUnavailable.
+func (c *DeploymentController) rollout(d types.Deployment, newRS *types.ReplicaSet, oldRSs []types.ReplicaSet) error {
+	maxSurge, maxUnavailable := rollingBounds(d.Strategy)
+
+	desired := newRS.DesiredCount
+	switch {
+	case activeCount(oldRSs) == 0:
+		// nothing to replace, this is a plain scale
+		desired = d.Replicas
+	case desired < d.Replicas:
+		total := newRS.DesiredCount + activeCount(oldRSs)
+		if limit := d.Replicas + maxSurge; total < limit {
+			desired = min(d.Replicas, desired+limit-total)
+		}
+	case desired > d.Replicas:
+		desired = d.Replicas
+	}
+	if desired != newRS.DesiredCount {
+		if err := c.updateReplicaSet(*newRS, desired, newRS.Revision); err != nil {
+			return err
+		}
+	}
+
+	// oldest first
+	slices.SortFunc(oldRSs, func(a, b types.ReplicaSet) int { return cmp.Compare(a.Revision, b.Revision) })
+
+	ready := newRS.ReadyCount
+	for _, rs := range oldRSs {
+		ready += min(rs.ReadyCount, rs.DesiredCount)
+	}
+	var removable uint
+	if minAvailable := d.Replicas - min(maxUnavailable, d.Replicas); ready > minAvailable {
+		removable = ready - minAvailable
+	}
+
+	for _, rs := range oldRSs {
+		if rs.DesiredCount == 0 {
+			continue
+		}
+		// pods that aren't ready don't count towards availability, so
+		// they can go right away
+		keep := min(rs.ReadyCount, rs.DesiredCount)
+		n := min(removable, keep)
+		keep -= n
+		removable -= n
+
+		if keep != rs.DesiredCount {
+			if err := c.updateReplicaSet(rs, keep, rs.Revision); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rollingBounds applies the default of surging by one pod when the strategy
+// allows no slack at all.
+func rollingBounds(s types.RollingUpdate) (maxSurge, maxUnavailable uint) {
+	if s.MaxSurge == 0 && s.MaxUnavailable == 0 {
+		return 1, 0
+	}
+	return s.MaxSurge, s.MaxUnavailable
+}
+
+// pruneHistory deletes the oldest scaled-down ReplicaSets beyond the
+// deployment's revision history limit.
+func (c *DeploymentController) pruneHistory(d types.Deployment, oldRSs []types.ReplicaSet) error {
+	limit := uint(types.DefaultRevisionHistoryLimit)
+	if d.RevisionHistoryLimit != nil {
+		limit = *d.RevisionHistoryLimit
+	}
+
+	var history []types.ReplicaSet
+	for _, rs := range oldRSs {
+		if rs.DesiredCount == 0 && rs.CurrentCount == 0 {
+			history = append(history, rs)
+		}
+	}
+	if uint(len(history)) <= limit {
+		return nil
+	}
+
+	slices.SortFunc(history, func(a, b types.ReplicaSet) int { return cmp.Compare(a.Revision, b.Revision) })
+	for _, rs := range history[:uint(len(history))-limit] {
+		if err := c.client.DeleteReplicaSet(rs.Namespace, rs.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *DeploymentController) createReplicaSet(d types.Deployment, hash string, revision uint64, replicas uint) error {
+	// the selector wins over the template labels so the ReplicaSet and its
+	// pods always match back to the deployment
+	labels := maps.Clone(d.Template.Labels)
+	if labels == nil {
+		labels = make(map[string]string, len(d.Selector)+1)
+	}
+	maps.Copy(labels, d.Selector)
+	labels[types.PodTemplateHashLabel] = hash
+
+	selector := maps.Clone(d.Selector)
+	if selector == nil {
+		selector = make(map[string]string, 1)
+	}
+	selector[types.PodTemplateHashLabel] = hash
+
+	template := d.Template
+	template.Labels = labels
+
+	rs := types.ReplicaSet{
+		ObjectMeta: types.ObjectMeta{
+			OwnerReferences: []types.OwnerReference{{Kind: types.KindDeployment, Name: d.Name, Controller: true}},
+		},
+		Name:         d.Name + "-" + hash,
+		Namespace:    d.Namespace,
+		Labels:       maps.Clone(labels),
+		DesiredCount: replicas,
+		Selector:     selector,
+		Template:     template,
+		Revision:     revision,
+
+		DeploymentTemplate: &d.Template,
+	}
+	log.Printf("deployment: %s creating %s (revision %d)", d.Name, rs.Name, revision)
+	return c.client.CreateReplicaSet(rs)
+}
+
+// adoptReplicaSets makes d the controller of those of its ReplicaSets that
+// have none.
+func (c *DeploymentController) adoptReplicaSets(d types.Deployment, replicaSets []types.ReplicaSet) error {
+	for _, rs := range replicaSets {
+		if rs.ControllerRef() != nil {
+			continue
+		}
+		log.Printf("deployment: %s adopting %s", d.Name, rs.Name)
+		refs := append(slices.Clone(rs.OwnerReferences),
+			types.OwnerReference{Kind: types.KindDeployment, Name: d.Name, Controller: true})
+		patch, err := ownerReferencesPatch(rs.ObjectMeta, refs)
+		if err != nil {
+			return err
+		}
+		// one that changed meanwhile is looked at again with its update
+		_, err = c.client.PatchReplicaSet(rs.Namespace, rs.Name, types.JSONPatchType, patch)
+		if err != nil && !errors.Is(err, client.ErrConflict) && !errors.Is(err, client.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateReplicaSet patches only DesiredCount and Revision, the counts the RS
+// controller writes are left to it.
+func (c *DeploymentController) updateReplicaSet(rs types.ReplicaSet, desired uint, revision uint64) error {
+	patch, err := json.Marshal([]map[string]any{
+		{"op": "add", "path": "/desiredCount", "value": desired},
+		{"op": "add", "path": "/revision", "value": revision},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.client.PatchReplicaSet(rs.Namespace, rs.Name, types.JSONPatchType, patch)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (c *DeploymentController) updateStatus(d types.Deployment, newRS *types.ReplicaSet, replicaSets []types.ReplicaSet) error {
+	var status types.DeploymentStatus
+	if newRS != nil {
+		status.Revision = newRS.Revision
+		status.UpdatedReplicas = newRS.CurrentCount
+	}
+	for _, rs := range replicaSets {
+		status.Replicas += rs.CurrentCount
+		status.ReadyReplicas += rs.ReadyCount
+	}
+	if d.Status == status {
+		return nil
+	}
+
+	patch, err := json.Marshal([]map[string]any{
+		{"op": "add", "path": "/status", "value": status},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.client.PatchDeployment(d.Namespace, d.Name, types.JSONPatchType, patch)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// replicaSetsOf returns the ReplicaSets the deployment controls and the
+// orphans whose labels match its selector.
+func (c *DeploymentController) replicaSetsOf(d types.Deployment) []types.ReplicaSet {
+	var out []types.ReplicaSet
+	for _, rs := range c.rsInformer.List() {
+		if owns(d, rs) {
+			out = append(out, rs)
+		}
+	}
+	return out
+}
+
+// deploymentsFor returns the deployment controlling rs or, for an orphan,
+// those whose selector matches it.
+func (c *DeploymentController) deploymentsFor(rs types.ReplicaSet) []types.Deployment {
+	var out []types.Deployment
+	for _, d := range c.deploymentInformer.List() {
+		if owns(d, rs) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func owns(d types.Deployment, rs types.ReplicaSet) bool {
+	if types.Key(d.Namespace, "") != types.Key(rs.Namespace, "") {
+		return false
+	}
+	if ref := rs.ControllerRef(); ref != nil {
+		return ref.Kind == types.KindDeployment && ref.Name == d.Name
+	}
+	if len(d.Selector) == 0 {
+		return false
+	}
+	for key, value := range d.Selector {
+		if rs.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// activeCount is the number of replicas the ReplicaSets are asked to run.
+func activeCount(replicaSets []types.ReplicaSet) uint {
+	var n uint
+	for _, rs := range replicaSets {
+		n += rs.DesiredCount
+	}
+	return n
+}
+
+func latestRevision(replicaSets []types.ReplicaSet) uint64 {
+	var latest uint64
+	for _, rs := range replicaSets {
+		latest = max(latest, rs.Revision)
+	}
+	return latest
+}
+
+// templateHash names a pod template, identical templates hash the same so a
+// rollback finds its old ReplicaSet again.
+func templateHash(template types.PodSpec) string {
+	data, err := json.Marshal(template)
+	if err != nil {
+		// a PodSpec always marshals
+		panic(err)
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(data)
+	return fmt.Sprintf("%08x", h.Sum32())
+}
@@ -0,0 +1,313 @@
+package controller
+
+import (
+	"maps"
+	"miniku/pkg/testutil"
+	"miniku/pkg/types"
+	"testing"
+	"time"
+)
+
+func TestRollout(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy types.RollingUpdate
+		newRS    types.ReplicaSet
+		oldRS    types.ReplicaSet
+		wantNew  uint
+		wantOld  uint
+	}{
+		{
+			name:    "surges one pod by default",
+			newRS:   types.ReplicaSet{DesiredCount: 0},
+			oldRS:   types.ReplicaSet{DesiredCount: 4, ReadyCount: 4},
+			wantNew: 1,
+			wantOld: 4,
+		},
+		{
+			name:    "scales old down once new pod is ready",
+			newRS:   types.ReplicaSet{DesiredCount: 1, ReadyCount: 1},
+			oldRS:   types.ReplicaSet{DesiredCount: 4, ReadyCount: 4},
+			wantNew: 1,
+			wantOld: 3,
+		},
+		{
+			name:     "max unavailable without surge",
+			strategy: types.RollingUpdate{MaxUnavailable: 2},
+			newRS:    types.ReplicaSet{DesiredCount: 0},
+			oldRS:    types.ReplicaSet{DesiredCount: 4, ReadyCount: 4},
+			wantNew:  0,
+			wantOld:  2,
+		},
+		{
+			name:    "drops old pods that are not ready",
+			newRS:   types.ReplicaSet{DesiredCount: 1, ReadyCount: 1},
+			oldRS:   types.ReplicaSet{DesiredCount: 4, ReadyCount: 1},
+			wantNew: 1,
+			wantOld: 1,
+		},
+		{
+			name:     "surge and unavailable together",
+			strategy: types.RollingUpdate{MaxSurge: 2, MaxUnavailable: 1},
+			newRS:    types.ReplicaSet{DesiredCount: 0},
+			oldRS:    types.ReplicaSet{DesiredCount: 4, ReadyCount: 4},
+			wantNew:  2,
+			wantOld:  3,
+		},
+		{
+			name:    "plain scale without old pods",
+			newRS:   types.ReplicaSet{DesiredCount: 2, ReadyCount: 2},
+			oldRS:   types.ReplicaSet{DesiredCount: 0},
+			wantNew: 4,
+			wantOld: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := testutil.NewTestEnv()
+			defer env.Close()
+
+			d := types.Deployment{Name: "web", Replicas: 4, Strategy: tt.strategy}
+			tt.newRS.Name, tt.newRS.Revision = "web-new", 2
+			tt.oldRS.Name, tt.oldRS.Revision = "web-old", 1
+			for _, rs := range []*types.ReplicaSet{&tt.newRS, &tt.oldRS} {
+				rs.Selector = map[string]string{"app": "web"}
+				rs.Template = types.PodSpec{Image: "nginx", Labels: map[string]string{"app": "web"}}
+			}
+			newRS := env.RSStore.Put(types.Key(tt.newRS.Namespace, tt.newRS.Name), tt.newRS)
+			oldRS := env.RSStore.Put(types.Key(tt.oldRS.Namespace, tt.oldRS.Name), tt.oldRS)
+
+			ctrl := NewDeploymentController(env.Client)
+			if err := ctrl.rollout(d, &newRS, []types.ReplicaSet{oldRS}); err != nil {
+				t.Fatalf("rollout: %v", err)
+			}
+
+			gotNew, _ := env.RSStore.Get("default/web-new")
+			gotOld, _ := env.RSStore.Get("default/web-old")
+			if gotNew.DesiredCount != tt.wantNew || gotOld.DesiredCount != tt.wantOld {
+				t.Errorf("got new=%d old=%d, want new=%d old=%d",
+					gotNew.DesiredCount, gotOld.DesiredCount, tt.wantNew, tt.wantOld)
+			}
+		})
+	}
+}
+
+func TestTemplateHash(t *testing.T) {
+	a := types.PodSpec{Image: "nginx:1", Labels: map[string]string{"app": "web", "tier": "front"}}
+	b := types.PodSpec{Image: "nginx:1", Labels: map[string]string{"tier": "front", "app": "web"}}
+	c := types.PodSpec{Image: "nginx:2", Labels: map[string]string{"app": "web", "tier": "front"}}
+
+	if templateHash(a) != templateHash(b) {
+		t.Error("expected identical templates to hash the same")
+	}
+	if templateHash(a) == templateHash(c) {
+		t.Error("expected different templates to hash differently")
+	}
+}
+
+func TestDeploymentRollingUpdate(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	d := types.Deployment{
+		Name:     "web",
+		Replicas: 3,
+		Selector: map[string]string{"app": "web"},
+		Template: types.PodSpec{Image: "nginx:1", Labels: map[string]string{"app": "web"}},
+	}
+	env.DeploymentStore.Put(types.Key(d.Namespace, d.Name), d)
+
+	runDeploymentController(t, env)
+	maxTotal := fakeReplicaSets(t, env)
+
+	waitForRollout(t, env, "web", 1, "nginx:1")
+
+	// ship a new image
+	d, _ = env.DeploymentStore.Get("default/web")
+	d.Template.Image = "nginx:2"
+	env.DeploymentStore.Put(types.Key(d.Namespace, d.Name), d)
+
+	waitForRollout(t, env, "web", 2, "nginx:2")
+	if got := maxTotal(); got > 4 {
+		t.Errorf("rollout ran %d replicas at once, want at most replicas+maxSurge=4", got)
+	}
+
+	// and take it back
+	if err := env.Client.RollbackDeployment("default", "web", 1); err != nil {
+		t.Fatalf("RollbackDeployment: %v", err)
+	}
+	waitForRollout(t, env, "web", 3, "nginx:1")
+
+	if len(env.RSStore.List()) != 2 {
+		t.Errorf("got %d replicasets, want the rollback to reuse the first one", len(env.RSStore.List()))
+	}
+}
+
+func TestDeploymentRollbackReusesReplicaSet(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	d := types.Deployment{
+		Name:     "web",
+		Replicas: 2,
+		Selector: map[string]string{"app": "web"},
+		Template: types.PodSpec{Image: "nginx:1", Labels: map[string]string{"app": "web", "tier": "front"}},
+	}
+	env.DeploymentStore.Put(types.Key(d.Namespace, d.Name), d)
+
+	runDeploymentController(t, env)
+	fakeReplicaSets(t, env)
+
+	waitForRollout(t, env, "web", 1, "nginx:1")
+	d, _ = env.DeploymentStore.Get("default/web")
+	d.Template.Image = "nginx:2"
+	env.DeploymentStore.Put(types.Key(d.Namespace, d.Name), d)
+	waitForRollout(t, env, "web", 2, "nginx:2")
+
+	if err := env.Client.RollbackDeployment("default", "web", 1); err != nil {
+		t.Fatalf("RollbackDeployment: %v", err)
+	}
+	waitForRollout(t, env, "web", 3, "nginx:1")
+
+	d, _ = env.DeploymentStore.Get("default/web")
+	if !maps.Equal(d.Template.Labels, map[string]string{"app": "web", "tier": "front"}) {
+		t.Errorf("got template labels %v, want those of revision 1", d.Template.Labels)
+	}
+	if n := len(env.RSStore.List()); n != 2 {
+		t.Errorf("got %d replicasets, want the rollback to re-adopt the first one", n)
+	}
+}
+
+func TestDeploymentPaused(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	env.DeploymentStore.Put("default/web", types.Deployment{
+		Name:     "web",
+		Replicas: 2,
+		Selector: map[string]string{"app": "web"},
+		Template: types.PodSpec{Image: "nginx:1", Labels: map[string]string{"app": "web"}},
+	})
+
+	runDeploymentController(t, env)
+	fakeReplicaSets(t, env)
+	waitForRollout(t, env, "web", 1, "nginx:1")
+
+	if err := env.Client.PauseDeployment("default", "web"); err != nil {
+		t.Fatalf("PauseDeployment: %v", err)
+	}
+	d, _ := env.DeploymentStore.Get("default/web")
+	d.Template.Image = "nginx:2"
+	env.DeploymentStore.Put(types.Key(d.Namespace, d.Name), d)
+
+	time.Sleep(100 * time.Millisecond)
+	if n := len(env.RSStore.List()); n != 1 {
+		t.Fatalf("got %d replicasets while paused, want 1", n)
+	}
+
+	if err := env.Client.ResumeDeployment("default", "web"); err != nil {
+		t.Fatalf("ResumeDeployment: %v", err)
+	}
+	waitForRollout(t, env, "web", 2, "nginx:2")
+}
+
+func TestPruneHistory(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	limit := uint(1)
+	d := types.Deployment{Name: "web", RevisionHistoryLimit: &limit}
+	var old []types.ReplicaSet
+	for i, name := range []string{"web-1", "web-2", "web-3"} {
+		old = append(old, env.RSStore.Put(types.Key("", name), types.ReplicaSet{Name: name, Revision: uint64(i + 1)}))
+	}
+
+	ctrl := NewDeploymentController(env.Client)
+	if err := ctrl.pruneHistory(d, old); err != nil {
+		t.Fatalf("pruneHistory: %v", err)
+	}
+
+	got := env.RSStore.List()
+	if len(got) != 1 || got[0].Name != "web-3" {
+		t.Errorf("got %v, want only the newest web-3 kept", got)
+	}
+}
+
+// runDeploymentController runs the controller until the test ends.
+func runDeploymentController(t *testing.T, env *testutil.TestEnv) {
+	t.Helper()
+	ctrl := NewDeploymentController(env.Client)
+	ctrl.PollInterval = 20 * time.Millisecond
+
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+		ctrl.queue.ShutDown()
+	})
+	ctrl.startInformers(stop)
+
+	go func() {
+		for {
+			key, ok := ctrl.queue.Get()
+			if !ok {
+				return
+			}
+			ctrl.sync(key)
+		}
+	}()
+}
+
+// fakeReplicaSets stands in for the ReplicaSet controller and kubelets: every
+// ReplicaSet immediately runs and readies what it is asked for. It returns
+// the highest total of desired replicas seen.
+func fakeReplicaSets(t *testing.T, env *testutil.TestEnv) func() uint {
+	t.Helper()
+	done := make(chan struct{})
+	maxTotal := make(chan uint)
+	t.Cleanup(func() { close(done) })
+
+	go func() {
+		var highest uint
+		for {
+			var total uint
+			for _, rs := range env.RSStore.List() {
+				total += rs.DesiredCount
+				if rs.CurrentCount != rs.DesiredCount || rs.ReadyCount != rs.DesiredCount {
+					rs.CurrentCount, rs.ReadyCount = rs.DesiredCount, rs.DesiredCount
+					_, _ = env.RSStore.Update(types.Key(rs.Namespace, rs.Name), rs)
+				}
+			}
+			highest = max(highest, total)
+
+			select {
+			case <-done:
+				return
+			case maxTotal <- highest:
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}()
+
+	return func() uint { return <-maxTotal }
+}
+
+// waitForRollout waits until every replica of the deployment runs image at
+// the given revision.
+func waitForRollout(t *testing.T, env *testutil.TestEnv, name string, revision uint64, image string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		d, _ := env.DeploymentStore.Get(types.Key("", name))
+		if d.Status.Revision == revision && d.Status.UpdatedReplicas == d.Replicas && d.Status.Replicas == d.Replicas {
+			for _, rs := range env.RSStore.List() {
+				if rs.Revision == revision && rs.Template.Image == image {
+					return
+				}
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	d, _ := env.DeploymentStore.Get(types.Key("", name))
+	t.Fatalf("timed out waiting for revision %d of %s, status %+v", revision, image, d.Status)
+}
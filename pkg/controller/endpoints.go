@@ -0,0 +1,193 @@
+/*
+The endpoints controller keeps an Endpoints object next to every service,
+listing the IPs of the ready pods its selector matches with the ports they
+serve it on. Service and pod changes queue the services concerned, the
+proxies on the nodes follow the Endpoints.
+
+Services without a selector are left alone, their Endpoints are whatever
+was written to them. A selector only picks pods in the service's namespace.
+*/
+package controller
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"log"
+	"miniku/pkg/client"
+	"miniku/pkg/types"
+	"reflect"
+	"slices"
+	"time"
+)
+
+type EndpointsController struct {
+	client            *client.Client
+	serviceInformer   *client.Informer[types.Service]
+	podInformer       *client.Informer[types.Pod]
+	endpointsInformer *client.Informer[types.Endpoints]
+	queue             *client.WorkQueue
+	PollInterval      time.Duration
+}
+
+func NewEndpointsController(c *client.Client) *EndpointsController {
+	ctrl := &EndpointsController{
+		client:            c,
+		serviceInformer:   c.ServiceInformer(),
+		podInformer:       c.PodInformer(),
+		endpointsInformer: c.EndpointsInformer(),
+		queue:             client.NewWorkQueue(),
+		PollInterval:      5 * time.Second,
+	}
+
+	ctrl.podInformer.AddIndex(labelIndex, podLabelIndex)
+
+	ctrl.serviceInformer.AddEventHandler(client.EventHandler[types.Service]{
+		OnAdd:    func(svc types.Service) { ctrl.queue.Add(types.Key(svc.Namespace, svc.Name)) },
+		OnUpdate: func(_, svc types.Service) { ctrl.queue.Add(types.Key(svc.Namespace, svc.Name)) },
+		OnDelete: func(svc types.Service) { ctrl.queue.Add(types.Key(svc.Namespace, svc.Name)) },
+	})
+	ctrl.podInformer.AddEventHandler(client.EventHandler[types.Pod]{
+		OnAdd: func(pod types.Pod) { ctrl.enqueueServicesFor(pod) },
+		OnUpdate: func(oldPod, pod types.Pod) {
+			// labels may have changed, so both the old and new services care
+			ctrl.enqueueServicesFor(oldPod)
+			ctrl.enqueueServicesFor(pod)
+		},
+		OnDelete: func(pod types.Pod) { ctrl.enqueueServicesFor(pod) },
+	})
+	// someone else touching our Endpoints gets them put right
+	ctrl.endpointsInformer.AddEventHandler(client.EventHandler[types.Endpoints]{
+		OnUpdate: func(_, ep types.Endpoints) { ctrl.queue.Add(types.Key(ep.Namespace, ep.Name)) },
+		OnDelete: func(ep types.Endpoints) { ctrl.queue.Add(types.Key(ep.Namespace, ep.Name)) },
+	})
+
+	return ctrl
+}
+
+func (c *EndpointsController) Run() {
+	c.startInformers(nil)
+
+	for {
+		key, ok := c.queue.Get()
+		if !ok {
+			return
+		}
+		c.sync(key)
+	}
+}
+
+// startInformers runs the informers until stop is closed and waits for their
+// caches to fill.
+func (c *EndpointsController) startInformers(stop <-chan struct{}) {
+	c.serviceInformer.PollInterval = c.PollInterval
+	c.serviceInformer.ResyncPeriod = c.PollInterval
+	c.podInformer.PollInterval = c.PollInterval
+	c.endpointsInformer.PollInterval = c.PollInterval
+
+	go c.serviceInformer.Run(stop)
+	go c.podInformer.Run(stop)
+	go c.endpointsInformer.Run(stop)
+	<-c.serviceInformer.Synced()
+	<-c.podInformer.Synced()
+	<-c.endpointsInformer.Synced()
+}
+
+func (c *EndpointsController) enqueueServicesFor(pod types.Pod) {
+	for _, svc := range c.serviceInformer.List() {
+		if len(svc.Selector) > 0 && selects(svc.Namespace, svc.Selector, pod) {
+			c.queue.Add(types.Key(svc.Namespace, svc.Name))
+		}
+	}
+}
+
+func (c *EndpointsController) sync(key string) {
+	defer c.queue.Done(key)
+
+	if err := c.reconcile(key); err != nil {
+		log.Printf("endpoints: failed to reconcile %s: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return
+	}
+	c.queue.Forget(key)
+}
+
+func (c *EndpointsController) reconcile(key string) error {
+	current, exists := c.endpointsInformer.Get(key)
+
+	svc, ok := c.serviceInformer.Get(key)
+	if !ok {
+		if !exists {
+			return nil
+		}
+		log.Printf("endpoints: service %s is gone, deleting its endpoints", key)
+		return c.client.DeleteEndpoints(current.Namespace, current.Name)
+	}
+	if len(svc.Selector) == 0 {
+		return nil
+	}
+
+	want := types.Endpoints{
+		Name:      svc.Name,
+		Namespace: svc.Namespace,
+		Addresses: c.readyAddresses(svc),
+		Ports:     endpointPorts(svc),
+	}
+	if !exists {
+		return c.client.CreateEndpoints(want)
+	}
+	if reflect.DeepEqual(current.Addresses, want.Addresses) && reflect.DeepEqual(current.Ports, want.Ports) {
+		return nil
+	}
+
+	// the addresses and ports are ours, anything else set on the Endpoints
+	// is left alone
+	patch, err := json.Marshal([]map[string]any{
+		{"op": "add", "path": "/addresses", "value": want.Addresses},
+		{"op": "add", "path": "/ports", "value": want.Ports},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.client.PatchEndpoints(svc.Namespace, svc.Name, types.JSONPatchType, patch)
+	if errors.Is(err, client.ErrNotFound) {
+		// the cache is behind, the Endpoints event brings us back
+		return nil
+	}
+	return err
+}
+
+// readyAddresses lists the ready pods of a service that have an IP, sorted
+// so unchanged endpoints compare equal.
+func (c *EndpointsController) readyAddresses(svc types.Service) []types.EndpointAddress {
+	var pods []types.Pod
+	for key, value := range svc.Selector {
+		pods = c.podInformer.ByIndex(labelIndex, labelIndexKey(svc.Namespace, key, value))
+		break
+	}
+
+	addresses := []types.EndpointAddress{}
+	for _, pod := range pods {
+		// a pod being deleted is shutting down, stop sending it traffic
+		if !selects(svc.Namespace, svc.Selector, pod) || !pod.IsReady() || pod.PodIP == "" || pod.Deleting() {
+			continue
+		}
+		addresses = append(addresses, types.EndpointAddress{
+			IP:       pod.PodIP,
+			NodeName: pod.Spec.NodeName,
+			PodName:  pod.Spec.Name,
+		})
+	}
+	slices.SortFunc(addresses, func(a, b types.EndpointAddress) int {
+		return cmp.Or(cmp.Compare(a.IP, b.IP), cmp.Compare(a.PodName, b.PodName))
+	})
+	return addresses
+}
+
+func endpointPorts(svc types.Service) []types.EndpointPort {
+	ports := make([]types.EndpointPort, 0, len(svc.Ports))
+	for _, p := range svc.Ports {
+		ports = append(ports, types.EndpointPort{Name: p.Name, Port: p.Target()})
+	}
+	return ports
+}
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"miniku/pkg/testutil"
+	"miniku/pkg/types"
+)
+
+func readyPod(name, ip string, labels map[string]string) types.Pod {
+	return types.Pod{
+		Spec:   types.PodSpec{Name: name, Image: "nginx", NodeName: "node-1", Labels: labels},
+		Status: types.PodStatusRunning,
+		PodIP:  ip,
+		Conditions: []types.PodCondition{
+			{Type: types.PodReady, Status: types.ConditionTrue},
+		},
+	}
+}
+
+func startEndpointsInformers(tb testing.TB, c *EndpointsController) {
+	tb.Helper()
+	stop := make(chan struct{})
+	tb.Cleanup(func() { close(stop) })
+	c.startInformers(stop)
+}
+
+func TestReconcileEndpoints(t *testing.T) {
+	web := map[string]string{"app": "web"}
+	notReady := readyPod("web-3", "10.244.0.4", web)
+	notReady.Conditions[0].Status = types.ConditionFalse
+	noIP := readyPod("web-4", "", web)
+
+	tests := []struct {
+		name    string
+		service types.Service
+		pods    []types.Pod
+		want    *types.Endpoints
+	}{
+		{
+			name: "ready pods with an IP",
+			service: types.Service{Name: "web", Selector: web,
+				Ports: []types.ServicePort{{Name: "http", Port: 80, TargetPort: 8080}, {Name: "admin", Port: 9000}}},
+			pods: []types.Pod{
+				readyPod("web-2", "10.244.0.3", web),
+				readyPod("web-1", "10.244.0.2", web),
+				notReady,
+				noIP,
+				readyPod("db-1", "10.244.0.9", map[string]string{"app": "db"}),
+			},
+			want: &types.Endpoints{
+				Name: "web",
+				Addresses: []types.EndpointAddress{
+					{IP: "10.244.0.2", NodeName: "node-1", PodName: "web-1"},
+					{IP: "10.244.0.3", NodeName: "node-1", PodName: "web-2"},
+				},
+				Ports: []types.EndpointPort{{Name: "http", Port: 8080}, {Name: "admin", Port: 9000}},
+			},
+		},
+		{
+			name:    "no ready pods",
+			service: types.Service{Name: "web", Selector: web, Ports: []types.ServicePort{{Port: 80}}},
+			pods:    []types.Pod{notReady},
+			want: &types.Endpoints{
+				Name:      "web",
+				Addresses: []types.EndpointAddress{},
+				Ports:     []types.EndpointPort{{Port: 80}},
+			},
+		},
+		{
+			name:    "no selector",
+			service: types.Service{Name: "web", Ports: []types.ServicePort{{Port: 80}}},
+			pods:    []types.Pod{readyPod("web-1", "10.244.0.2", web)},
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := testutil.NewTestEnv()
+			defer env.Close()
+
+			for _, pod := range tt.pods {
+				env.PodStore.Put(pod.Key(), pod)
+			}
+			env.ServiceStore.Put(types.Key(tt.service.Namespace, tt.service.Name), tt.service)
+
+			ctrl := NewEndpointsController(env.Client)
+			startEndpointsInformers(t, ctrl)
+			if err := ctrl.reconcile(types.Key(tt.service.Namespace, tt.service.Name)); err != nil {
+				t.Fatalf("reconcile: %v", err)
+			}
+
+			got, ok := env.EndpointsStore.Get(types.Key(tt.service.Namespace, tt.service.Name))
+			if tt.want == nil {
+				if ok {
+					t.Fatalf("expected no endpoints, got %+v", got)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("expected endpoints to be created")
+			}
+			if !reflect.DeepEqual(got.Addresses, tt.want.Addresses) {
+				t.Errorf("addresses: got %+v, want %+v", got.Addresses, tt.want.Addresses)
+			}
+			if !reflect.DeepEqual(got.Ports, tt.want.Ports) {
+				t.Errorf("ports: got %+v, want %+v", got.Ports, tt.want.Ports)
+			}
+		})
+	}
+}
+
+func TestReconcileEndpointsServiceDeleted(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	env.EndpointsStore.Put("default/web", types.Endpoints{
+		Name:      "web",
+		Addresses: []types.EndpointAddress{{IP: "10.244.0.2"}},
+		Ports:     []types.EndpointPort{{Port: 80}},
+	})
+
+	ctrl := NewEndpointsController(env.Client)
+	startEndpointsInformers(t, ctrl)
+	if err := ctrl.reconcile("default/web"); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	if _, ok := env.EndpointsStore.Get("default/web"); ok {
+		t.Error("expected the endpoints of a deleted service to be deleted")
+	}
+}
+
+func TestReconcileEndpointsUpdate(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	web := map[string]string{"app": "web"}
+	env.ServiceStore.Put("default/web", types.Service{Name: "web", Selector: web, Ports: []types.ServicePort{{Port: 80}}})
+	env.PodStore.Put("default/web-1", readyPod("web-1", "10.244.0.2", web))
+	stale := env.EndpointsStore.Put("default/web", types.Endpoints{
+		Name:      "web",
+		Addresses: []types.EndpointAddress{{IP: "10.244.0.7", PodName: "gone"}},
+		Ports:     []types.EndpointPort{{Port: 80}},
+	})
+
+	ctrl := NewEndpointsController(env.Client)
+	startEndpointsInformers(t, ctrl)
+	if err := ctrl.reconcile("default/web"); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	got, _ := env.EndpointsStore.Get("default/web")
+	if got.ResourceVersion == stale.ResourceVersion {
+		t.Fatal("expected the endpoints to be updated")
+	}
+	want := []types.EndpointAddress{{IP: "10.244.0.2", NodeName: "node-1", PodName: "web-1"}}
+	if !reflect.DeepEqual(got.Addresses, want) {
+		t.Errorf("addresses: got %+v, want %+v", got.Addresses, want)
+	}
+
+	// converged, nothing to write
+	if err := ctrl.reconcile("default/web"); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if again, _ := env.EndpointsStore.Get("default/web"); again.ResourceVersion != got.ResourceVersion {
+		t.Error("expected converged endpoints not to be written again")
+	}
+}
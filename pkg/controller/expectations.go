@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"sync"
+	"time"
+)
+
+// how long we wait for the informer to confirm our own creates/deletes before
+// reconciling anyway
+const expectationsTimeout = 30 * time.Second
+
+// expectations counts the pod creations and deletions a controller has issued
+// but not yet seen come back through its informer. Reconciling before they
+// arrive would act on a stale cache and e.g. create the same replicas twice.
+type expectations struct {
+	mu      sync.Mutex
+	pending map[string]*expectation
+}
+
+type expectation struct {
+	adds, dels int
+	setAt      time.Time
+}
+
+func newExpectations() *expectations {
+	return &expectations{pending: make(map[string]*expectation)}
+}
+
+func (e *expectations) expect(key string, adds, dels int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending[key] = &expectation{adds: adds, dels: dels, setAt: time.Now()}
+}
+
+func (e *expectations) creationObserved(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if exp, ok := e.pending[key]; ok && exp.adds > 0 {
+		exp.adds--
+	}
+}
+
+func (e *expectations) deletionObserved(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if exp, ok := e.pending[key]; ok && exp.dels > 0 {
+		exp.dels--
+	}
+}
+
+// satisfied reports whether key can be reconciled against the cache.
+func (e *expectations) satisfied(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exp, ok := e.pending[key]
+	if !ok {
+		return true
+	}
+	if (exp.adds <= 0 && exp.dels <= 0) || time.Since(exp.setAt) > expectationsTimeout {
+		delete(e.pending, key)
+		return true
+	}
+	return false
+}
+
+func (e *expectations) forget(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.pending, key)
+}
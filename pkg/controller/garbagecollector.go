@@ -0,0 +1,331 @@
+/*
+The garbage collector deletes objects whose owners are gone. It follows
+deployments, ReplicaSets and pods through their owner references:
+ 1. an object whose owners all don't exist anymore is deleted, which is how
+    background deletion reaches the dependents of a deleted owner
+ 2. an owner deleted with the orphan finalizer has the owner reference
+    removed from its dependents, then the finalizer from itself
+ 3. an owner deleted with the foregroundDeletion finalizer has its
+    dependents deleted in the foreground too, and once they are all gone
+    the finalizer is removed from itself
+
+The API server removes an owner once its last finalizer is removed. Objects
+are named "Kind/namespace/name" in the queue and the owner index.
+*/
+package controller
+
+import (
+	"log"
+	"miniku/pkg/client"
+	"miniku/pkg/store"
+	"miniku/pkg/types"
+	"slices"
+	"strings"
+	"time"
+)
+
+// indexes objects by the "Kind/namespace/name" of each of their owners
+const ownerIndex = "owners"
+
+type GarbageCollector struct {
+	queue        *client.WorkQueue
+	kinds        map[string]gcKind
+	PollInterval time.Duration
+}
+
+// gcKind is one kind of object the garbage collector follows.
+type gcKind interface {
+	// meta returns the metadata of the cached object at key
+	meta(key string) (*types.ObjectMeta, bool)
+	// dependentsOf returns the cached objects the owner node owns
+	dependentsOf(owner string) []string
+	// exists asks the API server whether the object at key exists
+	exists(key string) (bool, error)
+	// update applies change to the object at key unless it returns false
+	update(key string, change func(*types.ObjectMeta) bool) error
+	delete(key string, opts ...client.DeleteOption) error
+	run(stop <-chan struct{}, resync time.Duration)
+}
+
+func NewGarbageCollector(c *client.Client) *GarbageCollector {
+	gc := &GarbageCollector{
+		queue:        client.NewWorkQueue(),
+		kinds:        make(map[string]gcKind),
+		PollInterval: 5 * time.Second,
+	}
+	addGCKind(gc, &gcResource[types.Deployment]{
+		kind:     types.KindDeployment,
+		informer: c.DeploymentInformer(),
+		get:      c.GetDeployment,
+		put:      c.UpdateDeployment,
+		del:      c.DeleteDeployment,
+	})
+	addGCKind(gc, &gcResource[types.ReplicaSet]{
+		kind:     types.KindReplicaSet,
+		informer: c.ReplicaSetInformer(),
+		get:      c.GetReplicaSet,
+		put:      c.UpdateReplicaSet,
+		del:      c.DeleteReplicaSet,
+	})
+	addGCKind(gc, &gcResource[types.Pod]{
+		kind:     types.KindPod,
+		informer: c.PodInformer(),
+		get:      c.GetPod,
+		put:      c.UpdatePod,
+		del:      c.DeletePod,
+	})
+	return gc
+}
+
+// addGCKind indexes the objects of r by owner and queues them on every
+// change. A deleted object queues its dependents, whose owner may now be
+// gone, and its owners, which may be waiting for it.
+func addGCKind[T any](gc *GarbageCollector, r *gcResource[T]) {
+	r.informer.AddIndex(ownerIndex, func(obj T) []string {
+		namespace, _ := objectKey(&obj)
+		refs := objectMeta(&obj).OwnerReferences
+		out := make([]string, 0, len(refs))
+		for _, ref := range refs {
+			out = append(out, ownerNode(namespace, ref))
+		}
+		return out
+	})
+	r.informer.AddEventHandler(client.EventHandler[T]{
+		OnAdd:    func(obj T) { gc.queue.Add(r.node(obj)) },
+		OnUpdate: func(_, obj T) { gc.queue.Add(r.node(obj)) },
+		OnDelete: func(obj T) {
+			for _, dependent := range gc.dependentsOf(r.node(obj)) {
+				gc.queue.Add(dependent)
+			}
+			namespace, _ := objectKey(&obj)
+			for _, ref := range objectMeta(&obj).OwnerReferences {
+				gc.queue.Add(ownerNode(namespace, ref))
+			}
+		},
+	})
+	gc.kinds[r.kind] = r
+}
+
+func (gc *GarbageCollector) Run() {
+	gc.startInformers(nil)
+
+	for {
+		node, ok := gc.queue.Get()
+		if !ok {
+			return
+		}
+		gc.sync(node)
+	}
+}
+
+// startInformers runs the informers until stop is closed and waits for their
+// caches to fill. The resync looks at every object again every PollInterval
+// as a safety net.
+func (gc *GarbageCollector) startInformers(stop <-chan struct{}) {
+	for _, kind := range gc.kinds {
+		kind.run(stop, gc.PollInterval)
+	}
+}
+
+func (gc *GarbageCollector) sync(node string) {
+	defer gc.queue.Done(node)
+
+	if err := gc.process(node); err != nil {
+		log.Printf("gc: failed to process %s: %v", node, err)
+		gc.queue.AddRateLimited(node)
+		return
+	}
+	gc.queue.Forget(node)
+}
+
+func (gc *GarbageCollector) process(node string) error {
+	kindName, key, _ := strings.Cut(node, "/")
+	kind, ok := gc.kinds[kindName]
+	if !ok {
+		return nil
+	}
+	meta, ok := kind.meta(key)
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case meta.Deleting() && slices.Contains(meta.Finalizers, types.FinalizerOrphan):
+		return gc.orphanDependents(node, kind, key)
+	case meta.Deleting() && slices.Contains(meta.Finalizers, types.FinalizerForegroundDeletion):
+		return gc.deleteDependents(node, kind, key)
+	}
+	return gc.collect(node, kind, key, meta)
+}
+
+// collect deletes the object at key if it has owners and none of them
+// exists anymore.
+func (gc *GarbageCollector) collect(node string, kind gcKind, key string, meta *types.ObjectMeta) error {
+	if len(meta.OwnerReferences) == 0 || meta.Deleting() {
+		return nil
+	}
+
+	namespace, _ := types.SplitKey(key)
+	for _, ref := range meta.OwnerReferences {
+		owner, ok := gc.kinds[ref.Kind]
+		if !ok {
+			// we can't tell, so it stays
+			return nil
+		}
+		ownerKey := types.Key(namespace, ref.Name)
+		if _, ok := owner.meta(ownerKey); ok {
+			return nil
+		}
+		// the owner may be too new for our cache
+		exists, err := owner.exists(ownerKey)
+		if err != nil || exists {
+			return err
+		}
+	}
+
+	log.Printf("gc: deleting %s, its owners are gone", node)
+	return kind.delete(key)
+}
+
+// orphanDependents removes the owner reference to node from its dependents
+// and then lets node go.
+func (gc *GarbageCollector) orphanDependents(node string, kind gcKind, key string) error {
+	for _, dependent := range gc.dependentsOf(node) {
+		depKind, depKey, _ := strings.Cut(dependent, "/")
+		namespace, _ := types.SplitKey(depKey)
+		err := gc.kinds[depKind].update(depKey, func(meta *types.ObjectMeta) bool {
+			n := len(meta.OwnerReferences)
+			meta.OwnerReferences = slices.DeleteFunc(meta.OwnerReferences, func(ref types.OwnerReference) bool {
+				return ownerNode(namespace, ref) == node
+			})
+			return len(meta.OwnerReferences) != n
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("gc: orphaned the dependents of %s", node)
+	return gc.finalize(kind, key, types.FinalizerOrphan)
+}
+
+// deleteDependents deletes the dependents of node in the foreground and
+// lets node go once they are gone. Until then their delete events bring
+// node back here.
+func (gc *GarbageCollector) deleteDependents(node string, kind gcKind, key string) error {
+	dependents := gc.dependentsOf(node)
+	for _, dependent := range dependents {
+		depKind, depKey, _ := strings.Cut(dependent, "/")
+		meta, ok := gc.kinds[depKind].meta(depKey)
+		if !ok || meta.Deleting() {
+			continue
+		}
+		if err := gc.kinds[depKind].delete(depKey, client.PropagationPolicy(types.DeletePropagationForeground)); err != nil {
+			return err
+		}
+	}
+	if len(dependents) > 0 {
+		return nil
+	}
+
+	log.Printf("gc: dependents of %s are gone", node)
+	return gc.finalize(kind, key, types.FinalizerForegroundDeletion)
+}
+
+// finalize removes finalizer from the deleted object at key.
+func (gc *GarbageCollector) finalize(kind gcKind, key, finalizer string) error {
+	return kind.update(key, func(meta *types.ObjectMeta) bool {
+		if !slices.Contains(meta.Finalizers, finalizer) {
+			return false
+		}
+		meta.Finalizers = slices.DeleteFunc(meta.Finalizers, func(f string) bool { return f == finalizer })
+		return true
+	})
+}
+
+// dependentsOf returns the cached objects of every kind owned by node.
+func (gc *GarbageCollector) dependentsOf(node string) []string {
+	var out []string
+	for _, kind := range gc.kinds {
+		out = append(out, kind.dependentsOf(node)...)
+	}
+	return out
+}
+
+// gcResource is a gcKind on top of an informer and the client calls of T.
+type gcResource[T any] struct {
+	kind     string
+	informer *client.Informer[T]
+	get      func(namespace, name string) (T, bool, error)
+	put      func(name string, obj T) error
+	del      func(namespace, name string, opts ...client.DeleteOption) error
+}
+
+func (r *gcResource[T]) node(obj T) string {
+	namespace, name := objectKey(&obj)
+	return r.kind + "/" + types.Key(namespace, name)
+}
+
+func (r *gcResource[T]) meta(key string) (*types.ObjectMeta, bool) {
+	obj, ok := r.informer.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return objectMeta(&obj), true
+}
+
+func (r *gcResource[T]) dependentsOf(owner string) []string {
+	var out []string
+	for _, obj := range r.informer.ByIndex(ownerIndex, owner) {
+		out = append(out, r.node(obj))
+	}
+	return out
+}
+
+func (r *gcResource[T]) exists(key string) (bool, error) {
+	namespace, name := types.SplitKey(key)
+	_, found, err := r.get(namespace, name)
+	return found, err
+}
+
+// update reads the object fresh rather than from the cache, so the write
+// rarely conflicts, and retries when it does.
+func (r *gcResource[T]) update(key string, change func(*types.ObjectMeta) bool) error {
+	namespace, name := types.SplitKey(key)
+	return client.RetryOnConflict(func() error {
+		obj, found, err := r.get(namespace, name)
+		if err != nil || !found {
+			return err
+		}
+		if !change(objectMeta(&obj)) {
+			return nil
+		}
+		return r.put(name, obj)
+	})
+}
+
+func (r *gcResource[T]) delete(key string, opts ...client.DeleteOption) error {
+	namespace, name := types.SplitKey(key)
+	return r.del(namespace, name, opts...)
+}
+
+func (r *gcResource[T]) run(stop <-chan struct{}, resync time.Duration) {
+	r.informer.PollInterval = resync
+	r.informer.ResyncPeriod = resync
+	go r.informer.Run(stop)
+	<-r.informer.Synced()
+}
+
+// ownerNode names the owner ref points to from an object in namespace.
+func ownerNode(namespace string, ref types.OwnerReference) string {
+	return ref.Kind + "/" + types.Key(namespace, ref.Name)
+}
+
+func objectMeta[T any](obj *T) *types.ObjectMeta {
+	return any(obj).(types.Object).GetObjectMeta()
+}
+
+func objectKey[T any](obj *T) (namespace, name string) {
+	n := any(obj).(store.Namespaced)
+	return n.GetNamespace(), n.GetName()
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"miniku/pkg/client"
+	"miniku/pkg/testutil"
+	"miniku/pkg/types"
+)
+
+func ownedBy(kind, name string) types.ObjectMeta {
+	return types.ObjectMeta{OwnerReferences: []types.OwnerReference{{Kind: kind, Name: name, Controller: true}}}
+}
+
+// putTree stores deployment web owning ReplicaSet web-1 owning two pods.
+func putTree(env *testutil.TestEnv) {
+	labels := map[string]string{"app": "web"}
+	template := types.PodSpec{Image: "nginx", Labels: labels}
+	env.DeploymentStore.Put("default/web", types.Deployment{Name: "web", Selector: labels, Template: template})
+	env.RSStore.Put("default/web-1", types.ReplicaSet{ObjectMeta: ownedBy(types.KindDeployment, "web"), Name: "web-1", Selector: labels, Template: template})
+	for _, name := range []string{"web-1-a", "web-1-b"} {
+		env.PodStore.Put("default/"+name, types.Pod{ObjectMeta: ownedBy(types.KindReplicaSet, "web-1"), Spec: types.PodSpec{Name: name, Image: "nginx"}})
+	}
+}
+
+func TestGarbageCollector(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     types.DeletionPropagation
+		wantRS     bool
+		wantPods   int
+		wantOwners int
+	}{
+		{"background", types.DeletePropagationBackground, false, 0, 0},
+		{"foreground", types.DeletePropagationForeground, false, 0, 0},
+		{"orphan", types.DeletePropagationOrphan, true, 2, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := testutil.NewTestEnv()
+			defer env.Close()
+			putTree(env)
+			runGarbageCollector(t, env)
+
+			if err := env.Client.DeleteDeployment("default", "web", client.PropagationPolicy(tt.policy)); err != nil {
+				t.Fatalf("DeleteDeployment: %v", err)
+			}
+
+			waitFor(t, "the deployment to be gone", func() bool {
+				_, ok := env.DeploymentStore.Get("default/web")
+				return !ok
+			})
+			// orphaned dependents stay, let a wrong deletion show up
+			if tt.policy == types.DeletePropagationOrphan {
+				time.Sleep(100 * time.Millisecond)
+			}
+			waitFor(t, "the dependents to settle", func() bool {
+				_, ok := env.RSStore.Get("default/web-1")
+				return ok == tt.wantRS && len(env.PodStore.List()) == tt.wantPods
+			})
+
+			if rs, ok := env.RSStore.Get("default/web-1"); ok && len(rs.OwnerReferences) != 0 {
+				t.Errorf("got owners %v on the orphaned replicaset, want none", rs.OwnerReferences)
+			}
+			for _, pod := range env.PodStore.List() {
+				if len(pod.OwnerReferences) != tt.wantOwners {
+					t.Errorf("pod %s: got owners %v, want %d", pod.Spec.Name, pod.OwnerReferences, tt.wantOwners)
+				}
+			}
+		})
+	}
+}
+
+func TestGarbageCollectorForegroundWaits(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+	putTree(env)
+	// a finalizer of someone else keeps one pod around
+	pod, _ := env.PodStore.Get("default/web-1-a")
+	pod.Finalizers = []string{"example.com/keep"}
+	env.PodStore.Put("default/web-1-a", pod)
+	runGarbageCollector(t, env)
+
+	if err := env.Client.DeleteDeployment("default", "web", client.PropagationPolicy(types.DeletePropagationForeground)); err != nil {
+		t.Fatalf("DeleteDeployment: %v", err)
+	}
+	waitFor(t, "the pod without finalizer to be gone", func() bool {
+		_, ok := env.PodStore.Get("default/web-1-b")
+		return !ok
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	d, ok := env.DeploymentStore.Get("default/web")
+	if !ok || !d.Deleting() {
+		t.Fatalf("got %+v, want the deployment kept and marked deleted while a pod is left", d)
+	}
+	if rs, ok := env.RSStore.Get("default/web-1"); !ok || !rs.Deleting() {
+		t.Fatalf("got %+v, want the replicaset kept and marked deleted while a pod is left", rs)
+	}
+
+	pod, _ = env.PodStore.Get("default/web-1-a")
+	pod.Finalizers = nil
+	if err := env.Client.UpdatePod(pod.Spec.Name, pod); err != nil {
+		t.Fatalf("UpdatePod: %v", err)
+	}
+	if err := env.Client.DeletePod("default", "web-1-a"); err != nil {
+		t.Fatalf("DeletePod: %v", err)
+	}
+	waitFor(t, "the deployment to be gone", func() bool {
+		_, ok := env.DeploymentStore.Get("default/web")
+		return !ok
+	})
+}
+
+// runGarbageCollector runs the garbage collector until the test ends.
+func runGarbageCollector(t *testing.T, env *testutil.TestEnv) {
+	t.Helper()
+	gc := NewGarbageCollector(env.Client)
+	gc.PollInterval = 20 * time.Millisecond
+
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+		gc.queue.ShutDown()
+	})
+	gc.startInformers(stop)
+
+	go func() {
+		for {
+			node, ok := gc.queue.Get()
+			if !ok {
+				return
+			}
+			gc.sync(node)
+		}
+	}()
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
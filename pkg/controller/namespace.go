@@ -0,0 +1,164 @@
+/*
+The namespace controller empties namespaces that are being deleted. For
+each Terminating namespace:
+ 1. delete its deployments and ReplicaSets, so nothing recreates pods
+ 2. delete its services and endpoints
+ 3. delete its pods
+ 4. delete its RoleBindings, so a namespace of the same name doesn't
+    inherit their grants
+ 5. and then delete the namespace itself
+
+The API server refuses new objects in a Terminating namespace, so once the
+deletes went through it is empty and goes away. Until then it is retried.
+*/
+package controller
+
+import (
+	"log"
+	"miniku/pkg/client"
+	"miniku/pkg/types"
+	"time"
+)
+
+type NamespaceController struct {
+	client            *client.Client
+	namespaceInformer *client.Informer[types.Namespace]
+	queue             *client.WorkQueue
+	PollInterval      time.Duration
+}
+
+func NewNamespaceController(c *client.Client) *NamespaceController {
+	ctrl := &NamespaceController{
+		client:            c,
+		namespaceInformer: c.NamespaceInformer(),
+		queue:             client.NewWorkQueue(),
+		PollInterval:      5 * time.Second,
+	}
+
+	enqueue := func(ns types.Namespace) {
+		if ns.Phase == types.NamespaceTerminating {
+			ctrl.queue.Add(ns.Name)
+		}
+	}
+	ctrl.namespaceInformer.AddEventHandler(client.EventHandler[types.Namespace]{
+		OnAdd:    enqueue,
+		OnUpdate: func(_, ns types.Namespace) { enqueue(ns) },
+	})
+
+	return ctrl
+}
+
+func (c *NamespaceController) Run() {
+	c.startInformers(nil)
+
+	for {
+		key, ok := c.queue.Get()
+		if !ok {
+			return
+		}
+		c.sync(key)
+	}
+}
+
+// startInformers runs the informer until stop is closed and waits for its
+// cache to fill.
+func (c *NamespaceController) startInformers(stop <-chan struct{}) {
+	c.namespaceInformer.PollInterval = c.PollInterval
+	c.namespaceInformer.ResyncPeriod = c.PollInterval
+
+	go c.namespaceInformer.Run(stop)
+	<-c.namespaceInformer.Synced()
+}
+
+func (c *NamespaceController) sync(key string) {
+	defer c.queue.Done(key)
+
+	ns, ok := c.namespaceInformer.Get(key)
+	if !ok || ns.Phase != types.NamespaceTerminating {
+		c.queue.Forget(key)
+		return
+	}
+
+	gone, err := c.reconcile(ns.Name)
+	if err != nil {
+		log.Printf("namespace: failed to delete %s: %v", ns.Name, err)
+	}
+	if err != nil || !gone {
+		c.queue.AddRateLimited(key)
+		return
+	}
+	c.queue.Forget(key)
+}
+
+// reconcile deletes everything in namespace name and then the namespace. gone
+// reports whether the namespace was empty and is deleted.
+func (c *NamespaceController) reconcile(name string) (gone bool, err error) {
+	inNamespace := client.FieldSelector("namespace=" + name)
+
+	deployments, err := c.client.ListDeployments(inNamespace)
+	if err != nil {
+		return false, err
+	}
+	for _, d := range deployments {
+		if err := c.client.DeleteDeployment(name, d.Name); err != nil {
+			return false, err
+		}
+	}
+
+	replicaSets, err := c.client.ListReplicaSets(inNamespace)
+	if err != nil {
+		return false, err
+	}
+	for _, rs := range replicaSets {
+		if err := c.client.DeleteReplicaSet(name, rs.Name); err != nil {
+			return false, err
+		}
+	}
+
+	services, err := c.client.ListServices(inNamespace)
+	if err != nil {
+		return false, err
+	}
+	for _, svc := range services {
+		if err := c.client.DeleteService(name, svc.Name); err != nil {
+			return false, err
+		}
+	}
+
+	endpoints, err := c.client.ListEndpoints(inNamespace)
+	if err != nil {
+		return false, err
+	}
+	for _, ep := range endpoints {
+		if err := c.client.DeleteEndpoints(name, ep.Name); err != nil {
+			return false, err
+		}
+	}
+
+	pods, err := c.client.ListPods(client.FieldSelector("spec.namespace=" + name))
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range pods {
+		if err := c.client.DeletePod(name, pod.Spec.Name); err != nil {
+			return false, err
+		}
+	}
+
+	bindings, err := c.client.ListRoleBindings(inNamespace)
+	if err != nil {
+		return false, err
+	}
+	for _, b := range bindings {
+		if err := c.client.DeleteRoleBinding(b.Name); err != nil {
+			return false, err
+		}
+	}
+
+	log.Printf("namespace: %s is empty, deleting it", name)
+	if err := c.client.DeleteNamespace(name); err != nil {
+		return false, err
+	}
+	_, found, err := c.client.GetNamespace(name)
+	return err == nil && !found, err
+}
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"testing"
+
+	"miniku/pkg/testutil"
+	"miniku/pkg/types"
+)
+
+func TestReconcileNamespace(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	env.NamespaceStore.Put("shop", types.Namespace{Name: "shop", Phase: types.NamespaceTerminating})
+	env.DeploymentStore.Put("shop/web", types.Deployment{Name: "web", Namespace: "shop"})
+	env.RSStore.Put("shop/web-1", types.ReplicaSet{Name: "web-1", Namespace: "shop"})
+	env.ServiceStore.Put("shop/web", types.Service{Name: "web", Namespace: "shop"})
+	env.EndpointsStore.Put("shop/web", types.Endpoints{Name: "web", Namespace: "shop"})
+	env.PodStore.Put("shop/web-1-abc", types.Pod{Spec: types.PodSpec{Name: "web-1-abc", Image: "nginx", Namespace: "shop"}})
+	env.RoleBindingStore.Put("shop-admins", types.RoleBinding{Name: "shop-admins", Role: "cluster-admin", Namespace: "shop"})
+	env.RoleBindingStore.Put("default-admins", types.RoleBinding{Name: "default-admins", Role: "cluster-admin", Namespace: "default"})
+	// the same names elsewhere stay
+	env.DeploymentStore.Put("default/web", types.Deployment{Name: "web"})
+	env.PodStore.Put("default/web-1-abc", types.Pod{Spec: types.PodSpec{Name: "web-1-abc", Image: "nginx"}})
+
+	ctrl := NewNamespaceController(env.Client)
+	gone, err := ctrl.reconcile("shop")
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if !gone {
+		t.Error("expected the emptied namespace to be deleted")
+	}
+
+	if _, ok := env.NamespaceStore.Get("shop"); ok {
+		t.Error("expected namespace shop to be gone")
+	}
+	if n := len(env.DeploymentStore.List()) + len(env.RSStore.List()) + len(env.ServiceStore.List()) +
+		len(env.EndpointsStore.List()) + len(env.PodStore.List()); n != 2 {
+		t.Errorf("got %d objects left, want only the 2 of the default namespace", n)
+	}
+	if _, ok := env.PodStore.Get("default/web-1-abc"); !ok {
+		t.Error("expected the default namespace's pod to stay")
+	}
+	if _, ok := env.RoleBindingStore.Get("shop-admins"); ok {
+		t.Error("expected shop's RoleBinding to be gone, a new shop would inherit it")
+	}
+	if _, ok := env.RoleBindingStore.Get("default-admins"); !ok {
+		t.Error("expected the default namespace's RoleBinding to stay")
+	}
+}
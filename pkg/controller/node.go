@@ -1,6 +1,9 @@
 package controller
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"miniku/pkg/client"
 	"miniku/pkg/types"
@@ -9,15 +12,33 @@ import (
 
 const NODE_HEARTBEAT_THRESHOLD = 15 * time.Second
 
+// Defaults of NodeController.PodEvictionTimeout and EvictionRate.
+const (
+	DefaultPodEvictionTimeout = 5 * time.Minute
+	DefaultEvictionRate       = 0.1
+)
+
 type NodeController struct {
 	client       *client.Client
 	PollInterval time.Duration
+	// PodEvictionTimeout is how long a node may be NotReady before its pods
+	// are evicted, in case it comes back.
+	PodEvictionTimeout time.Duration
+	// EvictionRate is how many pods a second are evicted in each zone, so a
+	// zone dropping off the network isn't emptied all at once. Zero doesn't
+	// limit.
+	EvictionRate float64
+	// zones holds the eviction rate limit of each zone
+	zones map[string]*tokenBucket
 }
 
 func NewNodeController(client *client.Client) *NodeController {
 	return &NodeController{
-		client:       client,
-		PollInterval: 5 * time.Second,
+		client:             client,
+		PollInterval:       5 * time.Second,
+		PodEvictionTimeout: DefaultPodEvictionTimeout,
+		EvictionRate:       DefaultEvictionRate,
+		zones:              make(map[string]*tokenBucket),
 	}
 }
 
@@ -38,12 +59,108 @@ func (c *NodeController) Run() {
 }
 
 func (c *NodeController) reconcile(node types.Node) {
+	if status := nodeStatus(node); status != node.Status {
+		// when the kubelet heartbeat races us the node is judged again on
+		// the next pass
+		patch, err := json.Marshal([]map[string]any{
+			{"op": "test", "path": "/time", "value": node.LastHeartbeat},
+			{"op": "add", "path": "/status", "value": status},
+		})
+		if err != nil {
+			log.Printf("node controller: failed to encode status of node %s: %v", node.Name, err)
+			return
+		}
+		patched, err := c.client.PatchNode(node.Name, types.JSONPatchType, patch)
+		if errors.Is(err, client.ErrConflict) || errors.Is(err, client.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			log.Printf("node controller: failed to update node %s: %v", node.Name, err)
+			return
+		}
+		node = patched
+	}
+
+	if node.Status == types.NodeStateNotReady && time.Since(node.LastHeartbeat) > NODE_HEARTBEAT_THRESHOLD+c.PodEvictionTimeout {
+		c.evictPods(node)
+	}
+}
+
+// evictPods marks the pods of a lost node Unknown and deletes them, so their
+// ReplicaSets replace them elsewhere. They are removed once the node's
+// kubelet comes back and stopped them. Pods the zone's rate limit holds back
+// are evicted on a later pass.
+func (c *NodeController) evictPods(node types.Node) {
+	pods, err := c.client.ListPods(client.FieldSelector("spec.node_name=" + node.Name))
+	if err != nil {
+		log.Printf("node controller: failed to list pods of node %s: %v", node.Name, err)
+		return
+	}
+
+	limit := c.zones[node.Zone()]
+	if limit == nil {
+		limit = &tokenBucket{rate: c.EvictionRate, burst: 1, tokens: 1}
+		c.zones[node.Zone()] = limit
+	}
+	for _, pod := range pods {
+		if pod.Finished() || pod.Deleting() {
+			continue
+		}
+		if c.EvictionRate > 0 && !limit.allow(time.Now()) {
+			return
+		}
+		log.Printf("node controller: evicting pod %s from NotReady node %s", pod.Key(), node.Name)
+		if err := c.evict(pod, node.Name); err != nil {
+			log.Printf("node controller: failed to evict pod %s: %v", pod.Key(), err)
+		}
+	}
+}
+
+// evict patches the pod's status while it is still bound to nodeName, then
+// deletes it. A pod that is gone or was replaced is left alone.
+func (c *NodeController) evict(pod types.Pod, nodeName string) error {
+	patch, err := json.Marshal([]map[string]any{
+		{"op": "test", "path": "/spec/node_name", "value": nodeName},
+		{"op": "add", "path": "/status", "value": types.PodStatusUnknown},
+		{"op": "add", "path": "/reason", "value": types.PodReasonNodeLost},
+		{"op": "add", "path": "/message", "value": fmt.Sprintf("node %s is not responding", nodeName)},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.client.PatchPod(pod.Spec.Namespace, pod.Spec.Name, types.JSONPatchType, patch)
+	if errors.Is(err, client.ErrConflict) || errors.Is(err, client.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.client.DeletePod(pod.Spec.Namespace, pod.Spec.Name)
+}
+
+func nodeStatus(node types.Node) types.NodeState {
 	if time.Since(node.LastHeartbeat) > NODE_HEARTBEAT_THRESHOLD {
-		node.Status = types.NodeStateNotReady
-	} else {
-		node.Status = types.NodeStateReady
+		return types.NodeStateNotReady
+	}
+	return types.NodeStateReady
+}
+
+// tokenBucket allows rate events a second, up to burst of them at once.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
 	}
-	if err := c.client.UpdateNode(node.Name, node); err != nil {
-		log.Printf("node controller: failed to update node %s: %v", node.Name, err)
+	b.last = now
+	if b.tokens < 1 {
+		return false
 	}
+	b.tokens--
+	return true
 }
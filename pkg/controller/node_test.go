@@ -89,3 +89,93 @@ func TestNodeControllerReconcile(t *testing.T) {
 		})
 	}
 }
+
+// A heartbeat arriving after the node was listed wins over the stale copy.
+func TestNodeControllerHeartbeatRace(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	listed := types.Node{Name: "node-1", Status: types.NodeStateReady, LastHeartbeat: time.Now().Add(-time.Minute)}
+	env.NodeStore.Put(listed.Name, types.Node{Name: "node-1", Status: types.NodeStateReady, LastHeartbeat: time.Now()})
+
+	NewNodeController(env.Client).reconcile(listed)
+
+	if node, _ := env.NodeStore.Get(listed.Name); node.Status != types.NodeStateReady {
+		t.Errorf("expected status %s, got %s", types.NodeStateReady, node.Status)
+	}
+}
+
+func TestNodeControllerEvicts(t *testing.T) {
+	tests := []struct {
+		name          string
+		lastHeartbeat time.Duration
+		wantEvicted   bool
+	}{
+		{"ready node", 0, false},
+		{"within the toleration", -NODE_HEARTBEAT_THRESHOLD - 30*time.Second, false},
+		{"past the toleration", -NODE_HEARTBEAT_THRESHOLD - 2*time.Minute, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := testutil.NewTestEnv()
+			defer env.Close()
+
+			node := env.NodeStore.Put("node-1", types.Node{Name: "node-1", Status: types.NodeStateReady, LastHeartbeat: time.Now().Add(tt.lastHeartbeat)})
+			env.PodStore.Put("default/web", types.Pod{Spec: types.PodSpec{Name: "web", Image: "nginx", NodeName: "node-1"}, Status: types.PodStatusRunning})
+			env.PodStore.Put("default/done", types.Pod{Spec: types.PodSpec{Name: "done", Image: "nginx", NodeName: "node-1"}, Status: types.PodStatusSucceeded})
+			env.PodStore.Put("default/elsewhere", types.Pod{Spec: types.PodSpec{Name: "elsewhere", Image: "nginx", NodeName: "node-2"}, Status: types.PodStatusRunning})
+
+			ctrl := NewNodeController(env.Client)
+			ctrl.PodEvictionTimeout = time.Minute
+			ctrl.reconcile(node)
+
+			pod, _ := env.PodStore.Get("default/web")
+			evicted := pod.Status == types.PodStatusUnknown && pod.Reason == types.PodReasonNodeLost && pod.Deleting()
+			if evicted != tt.wantEvicted {
+				t.Errorf("got status %s, reason %q and deletionTimestamp %v, want evicted %v", pod.Status, pod.Reason, pod.DeletionTimestamp, tt.wantEvicted)
+			}
+			for _, key := range []string{"default/done", "default/elsewhere"} {
+				if other, _ := env.PodStore.Get(key); other.Status == types.PodStatusUnknown || other.Deleting() {
+					t.Errorf("expected pod %s to be left alone", key)
+				}
+			}
+		})
+	}
+}
+
+func TestNodeControllerEvictionRate(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	lost := time.Now().Add(-time.Hour)
+	nodes := []types.Node{
+		{Name: "node-a1", LastHeartbeat: lost, Labels: map[string]string{types.LabelZone: "a"}},
+		{Name: "node-a2", LastHeartbeat: lost, Labels: map[string]string{types.LabelZone: "a"}},
+		{Name: "node-b1", LastHeartbeat: lost, Labels: map[string]string{types.LabelZone: "b"}},
+	}
+	for _, node := range nodes {
+		env.NodeStore.Put(node.Name, node)
+		for _, name := range []string{"web-1", "web-2"} {
+			name += "-" + node.Name
+			env.PodStore.Put(types.Key("", name), types.Pod{Spec: types.PodSpec{Name: name, Image: "nginx", NodeName: node.Name}, Status: types.PodStatusRunning})
+		}
+	}
+
+	ctrl := NewNodeController(env.Client)
+	for _, node := range nodes {
+		ctrl.reconcile(node)
+	}
+
+	// one pod a zone gets through the burst, the rest waits its turn
+	evicted := map[string]int{}
+	for _, pod := range env.PodStore.List() {
+		if pod.Deleting() {
+			node, _ := env.NodeStore.Get(pod.Spec.NodeName)
+			evicted[node.Zone()]++
+		}
+	}
+	if evicted["a"] != 1 || evicted["b"] != 1 {
+		t.Errorf("got evictions per zone %v, want one in each", evicted)
+	}
+}
@@ -4,96 +4,357 @@ for each:
  2. calc diff
  3. if too few, create pods
  4. if too many, delete pods
- 5. and then update the current count
+ 5. and then update the current and ready counts
+
+ReplicaSets and pods are watched through informers; any change to either
+queues the affected ReplicaSet, and reconciles read from the local caches.
+A ReplicaSet's selector only picks pods in its own namespace.
+
+The pods a ReplicaSet creates carry an owner reference to it. It adopts
+orphaned pods its selector matches by adding one, and releases pods it no
+longer matches by removing it; pods controlled by something else are never
+touched. A ReplicaSet being deleted is left to the garbage collector.
 */
 package controller
 
 import (
+	"cmp"
 	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"miniku/pkg/client"
 	"miniku/pkg/types"
+	"slices"
 	"time"
 )
 
+// indexes pods by each of their "namespace/key=value" labels
+const labelIndex = "labels"
+
+// indexes pods by the "namespace/name" of the ReplicaSet controlling them
+const controllerIndex = "controller"
+
 type ReplicaSetController struct {
 	client       *client.Client
+	rsInformer   *client.Informer[types.ReplicaSet]
+	podInformer  *client.Informer[types.Pod]
+	queue        *client.WorkQueue
+	expectations *expectations
 	PollInterval time.Duration
 }
 
-func New(client *client.Client) *ReplicaSetController {
-	return &ReplicaSetController{
-		client:       client,
+func New(c *client.Client) *ReplicaSetController {
+	ctrl := &ReplicaSetController{
+		client:       c,
+		rsInformer:   c.ReplicaSetInformer(),
+		podInformer:  c.PodInformer(),
+		queue:        client.NewWorkQueue(),
+		expectations: newExpectations(),
 		PollInterval: 5 * time.Second,
 	}
+
+	ctrl.podInformer.AddIndex(labelIndex, podLabelIndex)
+	ctrl.podInformer.AddIndex(controllerIndex, podControllerIndex)
+
+	ctrl.rsInformer.AddEventHandler(client.EventHandler[types.ReplicaSet]{
+		OnAdd:    func(rs types.ReplicaSet) { ctrl.queue.Add(rsKey(rs)) },
+		OnUpdate: func(_, rs types.ReplicaSet) { ctrl.queue.Add(rsKey(rs)) },
+		OnDelete: func(rs types.ReplicaSet) { ctrl.expectations.forget(rsKey(rs)) },
+	})
+	ctrl.podInformer.AddEventHandler(client.EventHandler[types.Pod]{
+		OnAdd: func(pod types.Pod) {
+			for _, rs := range ctrl.replicaSetsFor(pod) {
+				ctrl.expectations.creationObserved(rsKey(rs))
+				ctrl.queue.Add(rsKey(rs))
+			}
+		},
+		OnUpdate: func(oldPod, pod types.Pod) {
+			// a pod given a grace period is as good as deleted
+			if !oldPod.Deleting() && pod.Deleting() {
+				for _, rs := range ctrl.replicaSetsFor(pod) {
+					ctrl.expectations.deletionObserved(rsKey(rs))
+				}
+			}
+			// labels may have changed, so both the old and new owners care
+			for _, rs := range append(ctrl.replicaSetsFor(oldPod), ctrl.replicaSetsFor(pod)...) {
+				ctrl.queue.Add(rsKey(rs))
+			}
+		},
+		OnDelete: func(pod types.Pod) {
+			for _, rs := range ctrl.replicaSetsFor(pod) {
+				if !pod.Deleting() {
+					ctrl.expectations.deletionObserved(rsKey(rs))
+				}
+				ctrl.queue.Add(rsKey(rs))
+			}
+		},
+	})
+
+	return ctrl
 }
 
 func (c *ReplicaSetController) Run() {
+	c.startInformers(nil)
+
 	for {
-		rsList, err := c.client.ListReplicaSets()
-		if err != nil {
-			log.Printf("controller: failed to list replicasets: %v", err)
-			time.Sleep(c.PollInterval)
-			continue
+		key, ok := c.queue.Get()
+		if !ok {
+			return
 		}
+		c.sync(key)
+	}
+}
 
-		for _, rs := range rsList {
-			if err := c.reconcile(rs); err != nil {
-				log.Printf("controller: failed to reconcile %s: %v", rs.Name, err)
-			}
-		}
+// startInformers runs the informers until stop is closed and waits for their
+// caches to fill. The ReplicaSet resync re-reconciles everything every
+// PollInterval as a safety net.
+func (c *ReplicaSetController) startInformers(stop <-chan struct{}) {
+	c.rsInformer.PollInterval = c.PollInterval
+	c.rsInformer.ResyncPeriod = c.PollInterval
+	c.podInformer.PollInterval = c.PollInterval
+
+	go c.rsInformer.Run(stop)
+	go c.podInformer.Run(stop)
+	<-c.rsInformer.Synced()
+	<-c.podInformer.Synced()
+}
 
-		time.Sleep(c.PollInterval)
+func (c *ReplicaSetController) sync(key string) {
+	defer c.queue.Done(key)
+
+	rs, ok := c.rsInformer.Get(key)
+	if !ok {
+		c.queue.Forget(key)
+		return
+	}
+	// our own creates/deletes haven't reached the cache yet, the pod
+	// events will queue the ReplicaSet again once they do
+	if !c.expectations.satisfied(key) {
+		return
 	}
+
+	if err := c.reconcile(rs); err != nil {
+		log.Printf("controller: failed to reconcile %s: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return
+	}
+	c.queue.Forget(key)
 }
+
 func (c *ReplicaSetController) reconcile(rs types.ReplicaSet) error {
-	matchingPods, err := c.getMatchingPods(rs)
+	matchingPods, finishedPods, err := c.getMatchingPods(rs)
 	if err != nil {
 		return err
 	}
 	current := uint(len(matchingPods))
 	desired := rs.DesiredCount
 
-	if current < desired {
-		diff := desired - current
-		for range diff {
-			if err := c.createPod(rs); err != nil {
-				return err
+	// the garbage collector deletes or releases the pods, don't replace them
+	if rs.Deleting() {
+		return c.updateCountsIfChanged(rs, current, matchingPods)
+	}
+
+	// finished pods don't count towards current and are replaced below,
+	// delete them so they don't pile up
+	toDelete := finishedPods
+	var toCreate uint
+	switch {
+	case current < desired:
+		toCreate = desired - current
+	case current > desired:
+		// get rid of pods that aren't running yet first
+		slices.SortStableFunc(matchingPods, func(a, b types.Pod) int {
+			return cmp.Compare(podRank(a), podRank(b))
+		})
+		toDelete = append(toDelete, matchingPods[:current-desired]...)
+	}
+	if toCreate == 0 && len(toDelete) == 0 {
+		return c.updateCountsIfChanged(rs, current, matchingPods)
+	}
+
+	key := rsKey(rs)
+	c.expectations.expect(key, int(toCreate), len(toDelete))
+	for i := range toCreate {
+		if err := c.createPod(rs); err != nil {
+			// these will never show up in the cache
+			for range toCreate - i {
+				c.expectations.creationObserved(key)
 			}
+			for range toDelete {
+				c.expectations.deletionObserved(key)
+			}
+			return err
+		}
+	}
+	for i, pod := range toDelete {
+		if err := c.deletePod(pod); err != nil {
+			for range len(toDelete) - i {
+				c.expectations.deletionObserved(key)
+			}
+			return err
 		}
-		current += diff
 	}
 
 	if current > desired {
-		diff := current - desired
-		for i := range diff {
-			if err := c.deletePod(matchingPods[i]); err != nil {
-				return err
-			}
+		matchingPods = matchingPods[current-desired:]
+	}
+	return c.updateCountsIfChanged(rs, desired, matchingPods)
+}
+
+// updateCountsIfChanged writes current and the number of ready pods unless
+// they are already up to date.
+func (c *ReplicaSetController) updateCountsIfChanged(rs types.ReplicaSet, current uint, pods []types.Pod) error {
+	var ready uint
+	for _, pod := range pods {
+		if pod.IsReady() {
+			ready++
 		}
-		current -= diff
 	}
+	ready = min(ready, current)
+
+	if rs.CurrentCount == current && rs.ReadyCount == ready {
+		return nil
+	}
+	return c.updateCounts(rs, current, ready)
+}
 
-	rs.CurrentCount = current
-	return c.client.UpdateReplicaSet(rs.Name, rs)
+// podRank orders pods by how cheap they are to delete.
+func podRank(pod types.Pod) int {
+	switch pod.Status {
+	case types.PodStatusPending:
+		return 0
+	case types.PodStatusRunning:
+		return 2
+	default:
+		return 1
+	}
 }
-func (c *ReplicaSetController) getMatchingPods(rs types.ReplicaSet) ([]types.Pod, error) {
-	pods, err := c.client.ListPods()
+
+// updateCounts only owns CurrentCount and ReadyCount, so it merge patches
+// just those rather than clobbering a concurrent change to e.g.
+// DesiredCount.
+func (c *ReplicaSetController) updateCounts(rs types.ReplicaSet, current, ready uint) error {
+	patch, err := json.Marshal(map[string]uint{"currentCount": current, "readyCount": ready})
 	if err != nil {
-		return nil, err
+		return err
 	}
+	_, err = c.client.PatchReplicaSet(rs.Namespace, rs.Name, types.MergePatchType, patch)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil
+	}
+	return err
+}
 
-	result := make([]types.Pod, 0, len(pods))
-	for _, pod := range pods {
-		if pod.Status == types.PodStatusFailed {
+// getMatchingPods returns the pods of rs, split into those that haven't
+// finished and those that have. Pods being deleted or lost with their node
+// are neither, they are replaced while they go away. On the way it releases
+// the pods rs controls but no longer selects and adopts the orphans it
+// selects.
+func (c *ReplicaSetController) getMatchingPods(rs types.ReplicaSet) (active, finished []types.Pod, err error) {
+	claim := func(pod types.Pod) {
+		switch {
+		case pod.Deleting() || pod.Status == types.PodStatusUnknown:
+		case pod.Finished():
+			finished = append(finished, pod)
+		default:
+			active = append(active, pod)
+		}
+	}
+
+	for _, pod := range c.podInformer.ByIndex(controllerIndex, rsKey(rs)) {
+		if selects(rs.Namespace, rs.Selector, pod) {
+			claim(pod)
 			continue
 		}
-		if matchesSelector(pod, rs.Selector) {
-			result = append(result, pod)
+		if err := c.release(rs, pod); err != nil {
+			return nil, nil, err
 		}
 	}
-	return result, nil
+	// an empty selector would adopt the whole namespace
+	if rs.Deleting() || len(rs.Selector) == 0 {
+		return active, finished, nil
+	}
+
+	for _, pod := range c.candidatePods(rs) {
+		if pod.ControllerRef() != nil || pod.Deleting() || !selects(rs.Namespace, rs.Selector, pod) {
+			continue
+		}
+		adopted, err := c.adopt(rs, pod)
+		if err != nil {
+			return nil, nil, err
+		}
+		if adopted {
+			claim(pod)
+		}
+	}
+	return active, finished, nil
+}
+
+// adopt makes rs the controller of the orphaned pod. It reports false when
+// the pod went away or changed in the meantime, its update queues rs again.
+func (c *ReplicaSetController) adopt(rs types.ReplicaSet, pod types.Pod) (bool, error) {
+	log.Printf("controller: %s adopting pod %s", rsKey(rs), pod.Key())
+	refs := append(slices.Clone(pod.OwnerReferences), controllerRef(rs))
+	return c.patchOwners(pod, refs)
+}
+
+// release removes rs as the controller of pod, which it doesn't select
+// anymore.
+func (c *ReplicaSetController) release(rs types.ReplicaSet, pod types.Pod) error {
+	log.Printf("controller: %s releasing pod %s", rsKey(rs), pod.Key())
+	refs := slices.DeleteFunc(slices.Clone(pod.OwnerReferences), func(ref types.OwnerReference) bool {
+		return ref.Controller
+	})
+	_, err := c.patchOwners(pod, refs)
+	return err
+}
+
+// patchOwners sets the pod's owner references to refs, unless they changed
+// since pod was read or the pod is gone, which is reported as false.
+func (c *ReplicaSetController) patchOwners(pod types.Pod, refs []types.OwnerReference) (bool, error) {
+	patch, err := ownerReferencesPatch(pod.ObjectMeta, refs)
+	if err != nil {
+		return false, err
+	}
+	_, err = c.client.PatchPod(pod.Spec.Namespace, pod.Spec.Name, types.JSONPatchType, patch)
+	if errors.Is(err, client.ErrConflict) || errors.Is(err, client.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// candidatePods looks up pods through the label index of the first selector
+// pair, the caller checks the rest of the selector.
+func (c *ReplicaSetController) candidatePods(rs types.ReplicaSet) []types.Pod {
+	for key, value := range rs.Selector {
+		return c.podInformer.ByIndex(labelIndex, labelIndexKey(rs.Namespace, key, value))
+	}
+	return c.podInformer.List()
+}
+
+// replicaSetsFor returns the ReplicaSet controlling pod or, for an orphan,
+// the ReplicaSets whose selector matches it.
+func (c *ReplicaSetController) replicaSetsFor(pod types.Pod) []types.ReplicaSet {
+	if ref := pod.ControllerRef(); ref != nil {
+		if ref.Kind != types.KindReplicaSet {
+			return nil
+		}
+		if rs, ok := c.rsInformer.Get(types.Key(pod.Spec.Namespace, ref.Name)); ok {
+			return []types.ReplicaSet{rs}
+		}
+		return nil
+	}
+
+	var out []types.ReplicaSet
+	for _, rs := range c.rsInformer.List() {
+		if selects(rs.Namespace, rs.Selector, pod) {
+			out = append(out, rs)
+		}
+	}
+	return out
 }
 
 // a better alternative would be to use uuid's for this
@@ -107,19 +368,60 @@ func generatePodName(rsName string) string {
 }
 
 func (c *ReplicaSetController) createPod(rs types.ReplicaSet) error {
+	// the selector wins over the template so the pod matches back to this RS
+	labels := maps.Clone(rs.Template.Labels)
+	if labels == nil {
+		labels = make(map[string]string, len(rs.Selector))
+	}
+	maps.Copy(labels, rs.Selector)
+
+	// everything else the template says, e.g. the restart policy, is the
+	// pod's
+	spec := rs.Template
+	spec.Name = generatePodName(rs.Name)
+	spec.Namespace = rs.Namespace
+	spec.Labels = labels
+
 	pod := types.Pod{
-		Spec: types.PodSpec{
-			Name:    generatePodName(rs.Name),
-			Image:   rs.Template.Image,
-			Command: rs.Template.Command,
-			Labels:  rs.Selector, // so it matches back to this RS
+		ObjectMeta: types.ObjectMeta{
+			OwnerReferences: []types.OwnerReference{controllerRef(rs)},
 		},
+		Spec:   spec,
 		Status: types.PodStatusPending,
 	}
 	return c.client.CreatePod(pod)
 }
 func (c *ReplicaSetController) deletePod(pod types.Pod) error {
-	return c.client.DeletePod(pod.Spec.Name)
+	return c.client.DeletePod(pod.Spec.Namespace, pod.Spec.Name)
+}
+
+// rsKey is what a ReplicaSet is queued and cached under.
+func rsKey(rs types.ReplicaSet) string {
+	return types.Key(rs.Namespace, rs.Name)
+}
+
+func controllerRef(rs types.ReplicaSet) types.OwnerReference {
+	return types.OwnerReference{Kind: types.KindReplicaSet, Name: rs.Name, Controller: true}
+}
+
+// ownerReferencesPatch is a JSON patch replacing the owner references of the
+// object meta was read from with refs, if they are still the same. Without
+// any there is no member to test, the whole object must be unchanged then.
+func ownerReferencesPatch(meta types.ObjectMeta, refs []types.OwnerReference) ([]byte, error) {
+	test := map[string]any{"op": "test", "path": "/ownerReferences", "value": meta.OwnerReferences}
+	if len(meta.OwnerReferences) == 0 {
+		test = map[string]any{"op": "test", "path": "/resourceVersion", "value": meta.ResourceVersion}
+	}
+	return json.Marshal([]map[string]any{
+		test,
+		{"op": "add", "path": "/ownerReferences", "value": refs},
+	})
+}
+
+// selects reports whether selector, of an object in namespace, picks pod.
+// Selectors never reach into other namespaces.
+func selects(namespace string, selector map[string]string, pod types.Pod) bool {
+	return types.Key(namespace, "") == types.Key(pod.Spec.Namespace, "") && matchesSelector(pod, selector)
 }
 
 func matchesSelector(pod types.Pod, selector map[string]string) bool {
@@ -131,3 +433,22 @@ func matchesSelector(pod types.Pod, selector map[string]string) bool {
 	}
 	return true
 }
+
+func podLabelIndex(pod types.Pod) []string {
+	out := make([]string, 0, len(pod.Spec.Labels))
+	for key, value := range pod.Spec.Labels {
+		out = append(out, labelIndexKey(pod.Spec.Namespace, key, value))
+	}
+	return out
+}
+
+func labelIndexKey(namespace, key, value string) string {
+	return types.Key(namespace, key+"="+value)
+}
+
+func podControllerIndex(pod types.Pod) []string {
+	if ref := pod.ControllerRef(); ref != nil && ref.Kind == types.KindReplicaSet {
+		return []string{types.Key(pod.Spec.Namespace, ref.Name)}
+	}
+	return nil
+}
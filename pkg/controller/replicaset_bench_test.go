@@ -41,10 +41,13 @@ func BenchmarkGetMatchingPods(b *testing.B) {
 			// half match, half don't
 			for i := range size {
 				labels := map[string]string{"app": "other"}
+				var owners []types.OwnerReference
 				if i%2 == 0 {
 					labels = map[string]string{"app": "nginx"}
+					owners = []types.OwnerReference{{Kind: types.KindReplicaSet, Name: "nginx-rs", Controller: true}}
 				}
-				env.PodStore.Put(fmt.Sprintf("pod-%d", i), types.Pod{
+				env.PodStore.Put(fmt.Sprintf("default/pod-%d", i), types.Pod{
+					ObjectMeta: types.ObjectMeta{OwnerReferences: owners},
 					Spec: types.PodSpec{
 						Name:   fmt.Sprintf("pod-%d", i),
 						Image:  "nginx",
@@ -59,10 +62,11 @@ func BenchmarkGetMatchingPods(b *testing.B) {
 			}
 
 			ctrl := New(env.Client)
+			startInformers(b, ctrl)
 
 			b.ResetTimer()
 			for i := 0; i < b.N; i++ {
-				_, _ = ctrl.getMatchingPods(rs)
+				_, _, _ = ctrl.getMatchingPods(rs)
 			}
 		})
 	}
@@ -80,13 +84,14 @@ func BenchmarkReconcile(b *testing.B) {
 			Image: "nginx:latest",
 		},
 	}
-	env.RSStore.Put(rs.Name, rs)
+	env.RSStore.Put(types.Key(rs.Namespace, rs.Name), rs)
 
 	ctrl := New(env.Client)
+	startInformers(b, ctrl)
 
 	for b.Loop() {
 		for _, pod := range env.PodStore.List() {
-			env.PodStore.Delete(pod.Spec.Name)
+			env.PodStore.Delete(pod.Key())
 		}
 		_ = ctrl.reconcile(rs)
 	}
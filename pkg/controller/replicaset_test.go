@@ -3,7 +3,9 @@ package controller
 import (
 	"miniku/pkg/testutil"
 	"miniku/pkg/types"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestReconcile(t *testing.T) {
@@ -19,7 +21,7 @@ func TestReconcile(t *testing.T) {
 				Name:         "nginx-rs",
 				DesiredCount: 3,
 				Selector:     map[string]string{"app": "nginx"},
-				Template:     types.PodSpec{Image: "nginx:latest"},
+				Template:     types.PodSpec{Image: "nginx:latest", Labels: map[string]string{"app": "nginx"}},
 			},
 			existingPods:     []types.Pod{},
 			expectedPodCount: 3,
@@ -30,10 +32,10 @@ func TestReconcile(t *testing.T) {
 				Name:         "nginx-rs",
 				DesiredCount: 3,
 				Selector:     map[string]string{"app": "nginx"},
-				Template:     types.PodSpec{Image: "nginx:latest"},
+				Template:     types.PodSpec{Image: "nginx:latest", Labels: map[string]string{"app": "nginx"}},
 			},
 			existingPods: []types.Pod{
-				{Spec: types.PodSpec{Name: "nginx-rs-abc", Labels: map[string]string{"app": "nginx"}}},
+				{Spec: types.PodSpec{Name: "nginx-rs-abc", Image: "nginx", Labels: map[string]string{"app": "nginx"}}},
 			},
 			expectedPodCount: 3,
 		},
@@ -43,12 +45,12 @@ func TestReconcile(t *testing.T) {
 				Name:         "nginx-rs",
 				DesiredCount: 3,
 				Selector:     map[string]string{"app": "nginx"},
-				Template:     types.PodSpec{Image: "nginx:latest"},
+				Template:     types.PodSpec{Image: "nginx:latest", Labels: map[string]string{"app": "nginx"}},
 			},
 			existingPods: []types.Pod{
-				{Spec: types.PodSpec{Name: "nginx-rs-1", Labels: map[string]string{"app": "nginx"}}},
-				{Spec: types.PodSpec{Name: "nginx-rs-2", Labels: map[string]string{"app": "nginx"}}},
-				{Spec: types.PodSpec{Name: "nginx-rs-3", Labels: map[string]string{"app": "nginx"}}},
+				{Spec: types.PodSpec{Name: "nginx-rs-1", Image: "nginx", Labels: map[string]string{"app": "nginx"}}},
+				{Spec: types.PodSpec{Name: "nginx-rs-2", Image: "nginx", Labels: map[string]string{"app": "nginx"}}},
+				{Spec: types.PodSpec{Name: "nginx-rs-3", Image: "nginx", Labels: map[string]string{"app": "nginx"}}},
 			},
 			expectedPodCount: 3,
 		},
@@ -58,13 +60,13 @@ func TestReconcile(t *testing.T) {
 				Name:         "nginx-rs",
 				DesiredCount: 2,
 				Selector:     map[string]string{"app": "nginx"},
-				Template:     types.PodSpec{Image: "nginx:latest"},
+				Template:     types.PodSpec{Image: "nginx:latest", Labels: map[string]string{"app": "nginx"}},
 			},
 			existingPods: []types.Pod{
-				{Spec: types.PodSpec{Name: "nginx-rs-1", Labels: map[string]string{"app": "nginx"}}},
-				{Spec: types.PodSpec{Name: "nginx-rs-2", Labels: map[string]string{"app": "nginx"}}},
-				{Spec: types.PodSpec{Name: "nginx-rs-3", Labels: map[string]string{"app": "nginx"}}},
-				{Spec: types.PodSpec{Name: "nginx-rs-4", Labels: map[string]string{"app": "nginx"}}},
+				{Spec: types.PodSpec{Name: "nginx-rs-1", Image: "nginx", Labels: map[string]string{"app": "nginx"}}},
+				{Spec: types.PodSpec{Name: "nginx-rs-2", Image: "nginx", Labels: map[string]string{"app": "nginx"}}},
+				{Spec: types.PodSpec{Name: "nginx-rs-3", Image: "nginx", Labels: map[string]string{"app": "nginx"}}},
+				{Spec: types.PodSpec{Name: "nginx-rs-4", Image: "nginx", Labels: map[string]string{"app": "nginx"}}},
 			},
 			expectedPodCount: 2,
 		},
@@ -74,12 +76,12 @@ func TestReconcile(t *testing.T) {
 				Name:         "nginx-rs",
 				DesiredCount: 0,
 				Selector:     map[string]string{"app": "nginx"},
-				Template:     types.PodSpec{Image: "nginx:latest"},
+				Template:     types.PodSpec{Image: "nginx:latest", Labels: map[string]string{"app": "nginx"}},
 			},
 			existingPods: []types.Pod{
-				{Spec: types.PodSpec{Name: "nginx-rs-1", Labels: map[string]string{"app": "nginx"}}},
-				{Spec: types.PodSpec{Name: "nginx-rs-2", Labels: map[string]string{"app": "nginx"}}},
-				{Spec: types.PodSpec{Name: "nginx-rs-3", Labels: map[string]string{"app": "nginx"}}},
+				{Spec: types.PodSpec{Name: "nginx-rs-1", Image: "nginx", Labels: map[string]string{"app": "nginx"}}},
+				{Spec: types.PodSpec{Name: "nginx-rs-2", Image: "nginx", Labels: map[string]string{"app": "nginx"}}},
+				{Spec: types.PodSpec{Name: "nginx-rs-3", Image: "nginx", Labels: map[string]string{"app": "nginx"}}},
 			},
 			expectedPodCount: 0,
 		},
@@ -89,15 +91,30 @@ func TestReconcile(t *testing.T) {
 				Name:         "nginx-rs",
 				DesiredCount: 2,
 				Selector:     map[string]string{"app": "nginx"},
-				Template:     types.PodSpec{Image: "nginx:latest"},
+				Template:     types.PodSpec{Image: "nginx:latest", Labels: map[string]string{"app": "nginx"}},
 			},
 			existingPods: []types.Pod{
-				{Spec: types.PodSpec{Name: "nginx-rs-1", Labels: map[string]string{"app": "nginx"}}},
-				{Spec: types.PodSpec{Name: "redis-1", Labels: map[string]string{"app": "redis"}}},
-				{Spec: types.PodSpec{Name: "postgres-1", Labels: map[string]string{"app": "postgres"}}},
+				{Spec: types.PodSpec{Name: "nginx-rs-1", Image: "nginx", Labels: map[string]string{"app": "nginx"}}},
+				{Spec: types.PodSpec{Name: "redis-1", Image: "nginx", Labels: map[string]string{"app": "redis"}}},
+				{Spec: types.PodSpec{Name: "postgres-1", Image: "nginx", Labels: map[string]string{"app": "postgres"}}},
 			},
 			expectedPodCount: 2, // 1 existing nginx + 1 created
 		},
+		{
+			name: "replace finished pods",
+			replicaSet: types.ReplicaSet{
+				Name:         "nginx-rs",
+				DesiredCount: 2,
+				Selector:     map[string]string{"app": "nginx"},
+				Template:     types.PodSpec{Image: "nginx:latest", Labels: map[string]string{"app": "nginx"}},
+			},
+			existingPods: []types.Pod{
+				{Spec: types.PodSpec{Name: "nginx-rs-1", Image: "nginx", Labels: map[string]string{"app": "nginx"}}, Status: types.PodStatusRunning},
+				{Spec: types.PodSpec{Name: "nginx-rs-2", Image: "nginx", Labels: map[string]string{"app": "nginx"}}, Status: types.PodStatusFailed},
+				{Spec: types.PodSpec{Name: "nginx-rs-3", Image: "nginx", Labels: map[string]string{"app": "nginx"}}, Status: types.PodStatusSucceeded},
+			},
+			expectedPodCount: 2, // finished ones deleted, 1 created
+		},
 	}
 
 	for _, tt := range tests {
@@ -106,25 +123,28 @@ func TestReconcile(t *testing.T) {
 			defer env.Close()
 
 			for _, pod := range tt.existingPods {
-				env.PodStore.Put(pod.Spec.Name, pod)
+				env.PodStore.Put(pod.Key(), pod)
 			}
 
-			env.RSStore.Put(tt.replicaSet.Name, tt.replicaSet)
+			env.RSStore.Put(types.Key(tt.replicaSet.Namespace, tt.replicaSet.Name), tt.replicaSet)
 
 			ctrl := New(env.Client)
+			startInformers(t, ctrl)
 			_ = ctrl.reconcile(tt.replicaSet)
 
 			// count matching pods after recon.
-			matchingPods, err := ctrl.getMatchingPods(tt.replicaSet)
-			if err != nil {
-				t.Fatalf("getMatchingPods: %v", err)
+			matching := 0
+			for _, pod := range env.PodStore.List() {
+				if matchesSelector(pod, tt.replicaSet.Selector) {
+					matching++
+				}
 			}
-			if len(matchingPods) != tt.expectedPodCount {
-				t.Errorf("expected %d matching pods, got %d", tt.expectedPodCount, len(matchingPods))
+			if matching != tt.expectedPodCount {
+				t.Errorf("expected %d matching pods, got %d", tt.expectedPodCount, matching)
 			}
 
 			// verify currentcount was updated
-			updatedRS, _ := env.RSStore.Get(tt.replicaSet.Name)
+			updatedRS, _ := env.RSStore.Get(types.Key(tt.replicaSet.Namespace, tt.replicaSet.Name))
 			if int(updatedRS.CurrentCount) != tt.expectedPodCount {
 				t.Errorf("expected CurrentCount %d, got %d", tt.expectedPodCount, updatedRS.CurrentCount)
 			}
@@ -204,3 +224,216 @@ func TestMatchesSelector(t *testing.T) {
 		})
 	}
 }
+
+func TestReconcileNamespaced(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+	env.NamespaceStore.Put("shop", types.Namespace{Name: "shop", Phase: types.NamespaceActive})
+
+	// a pod with the right labels in the default namespace isn't the shop's
+	env.PodStore.Put("default/nginx-1", types.Pod{Spec: types.PodSpec{Name: "nginx-1", Image: "nginx", Labels: map[string]string{"app": "nginx"}}})
+	rs := env.RSStore.Put("shop/nginx-rs", types.ReplicaSet{
+		Name:         "nginx-rs",
+		Namespace:    "shop",
+		DesiredCount: 2,
+		Selector:     map[string]string{"app": "nginx"},
+		Template:     types.PodSpec{Image: "nginx:latest", Labels: map[string]string{"app": "nginx"}},
+	})
+
+	ctrl := New(env.Client)
+	startInformers(t, ctrl)
+	if err := ctrl.reconcile(rs); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	inShop := 0
+	for _, pod := range env.PodStore.List() {
+		if pod.Spec.Namespace == "shop" {
+			inShop++
+		}
+	}
+	if inShop != 2 {
+		t.Errorf("got %d pods in shop, want 2", inShop)
+	}
+	if _, ok := env.PodStore.Get("default/nginx-1"); !ok {
+		t.Error("expected the default namespace's pod to be left alone")
+	}
+	if got, _ := env.RSStore.Get("shop/nginx-rs"); got.CurrentCount != 2 {
+		t.Errorf("got CurrentCount %d, want 2", got.CurrentCount)
+	}
+}
+
+func TestReconcileReplacesLostPods(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	now := time.Now()
+	grace := int64(30)
+	deleting := ownedBy(types.KindReplicaSet, "nginx-rs")
+	deleting.DeletionTimestamp, deleting.DeletionGracePeriodSeconds = &now, &grace
+	labels := map[string]string{"app": "nginx"}
+	env.PodStore.Put("default/nginx-rs-1", types.Pod{ObjectMeta: ownedBy(types.KindReplicaSet, "nginx-rs"), Spec: types.PodSpec{Name: "nginx-rs-1", Image: "nginx", Labels: labels}})
+	env.PodStore.Put("default/nginx-rs-2", types.Pod{ObjectMeta: deleting, Spec: types.PodSpec{Name: "nginx-rs-2", Image: "nginx", Labels: labels, NodeName: "node-1"}})
+	env.PodStore.Put("default/nginx-rs-3", types.Pod{ObjectMeta: ownedBy(types.KindReplicaSet, "nginx-rs"), Spec: types.PodSpec{Name: "nginx-rs-3", Image: "nginx", Labels: labels, NodeName: "node-2"}, Status: types.PodStatusUnknown})
+	rs := env.RSStore.Put("default/nginx-rs", types.ReplicaSet{
+		Name:         "nginx-rs",
+		DesiredCount: 3,
+		Selector:     labels,
+		Template:     types.PodSpec{Image: "nginx:latest", Labels: labels},
+	})
+
+	ctrl := New(env.Client)
+	startInformers(t, ctrl)
+	if err := ctrl.reconcile(rs); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	// the shutting down and the lost pod are left alone and replaced
+	if pod, ok := env.PodStore.Get("default/nginx-rs-2"); !ok || !pod.Deleting() {
+		t.Errorf("got %+v, want the deleting pod left alone", pod)
+	}
+	if _, ok := env.PodStore.Get("default/nginx-rs-3"); !ok {
+		t.Error("expected the lost pod to be left alone")
+	}
+	if got := len(env.PodStore.List()); got != 5 {
+		t.Errorf("got %d pods, want two replacements next to the three", got)
+	}
+	if got, _ := env.RSStore.Get("default/nginx-rs"); got.CurrentCount != 3 {
+		t.Errorf("got CurrentCount %d, want 3 without the deleting and lost pods", got.CurrentCount)
+	}
+}
+
+func TestReconcileOwnership(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	nginx := map[string]string{"app": "nginx"}
+	env.PodStore.Put("default/orphan", types.Pod{Spec: types.PodSpec{Name: "orphan", Image: "nginx", Labels: nginx}})
+	env.PodStore.Put("default/others", types.Pod{
+		ObjectMeta: ownedBy(types.KindReplicaSet, "other-rs"),
+		Spec:       types.PodSpec{Name: "others", Image: "nginx", Labels: nginx},
+	})
+	env.PodStore.Put("default/relabeled", types.Pod{
+		ObjectMeta: ownedBy(types.KindReplicaSet, "nginx-rs"),
+		Spec:       types.PodSpec{Name: "relabeled", Image: "nginx", Labels: map[string]string{"app": "debug"}},
+	})
+	rs := env.RSStore.Put("default/nginx-rs", types.ReplicaSet{
+		Name:         "nginx-rs",
+		DesiredCount: 2,
+		Selector:     nginx,
+		Template:     types.PodSpec{Image: "nginx:latest", Labels: map[string]string{"app": "nginx"}},
+	})
+
+	ctrl := New(env.Client)
+	startInformers(t, ctrl)
+	if err := ctrl.reconcile(rs); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	tests := []struct {
+		pod  string
+		want string
+	}{
+		{"orphan", "nginx-rs"},
+		{"others", "other-rs"},
+		{"relabeled", ""},
+	}
+	for _, tt := range tests {
+		pod, _ := env.PodStore.Get("default/" + tt.pod)
+		var got string
+		if ref := pod.ControllerRef(); ref != nil {
+			got = ref.Name
+		}
+		if got != tt.want {
+			t.Errorf("pod %s: got controller %q, want %q", tt.pod, got, tt.want)
+		}
+	}
+
+	// the adopted orphan counts, so one pod is created next to it
+	owned := 0
+	for _, pod := range env.PodStore.List() {
+		if pod.IsControlledBy(types.KindReplicaSet, "nginx-rs") {
+			owned++
+		}
+	}
+	if owned != 2 {
+		t.Errorf("got %d pods controlled by nginx-rs, want 2", owned)
+	}
+}
+
+// A pod claimed by someone else after it was read isn't taken over.
+func TestAdoptClaimedMeanwhile(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	nginx := map[string]string{"app": "nginx"}
+	read := env.PodStore.Put("default/orphan", types.Pod{Spec: types.PodSpec{Name: "orphan", Image: "nginx", Labels: nginx}})
+	claimed := read
+	claimed.ObjectMeta = ownedBy(types.KindReplicaSet, "other-rs")
+	env.PodStore.Put("default/orphan", claimed)
+
+	adopted, err := New(env.Client).adopt(types.ReplicaSet{Name: "nginx-rs", Selector: nginx}, read)
+	if err != nil || adopted {
+		t.Fatalf("got adopted %v err %v, want neither", adopted, err)
+	}
+	pod, _ := env.PodStore.Get("default/orphan")
+	if ref := pod.ControllerRef(); ref == nil || ref.Name != "other-rs" || len(pod.OwnerReferences) != 1 {
+		t.Errorf("got owner references %v, want only other-rs", pod.OwnerReferences)
+	}
+}
+
+// startInformers fills the controller's caches from the test env and stops
+// the informers when the test ends.
+func startInformers(tb testing.TB, c *ReplicaSetController) {
+	tb.Helper()
+	stop := make(chan struct{})
+	tb.Cleanup(func() { close(stop) })
+	c.startInformers(stop)
+}
+
+func TestReconcilePodTemplate(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	grace := int64(5)
+	template := types.PodSpec{
+		Image:         "nginx:latest",
+		Command:       []string{"nginx", "-g", "daemon off;"},
+		Env:           map[string]string{"MODE": "prod"},
+		Labels:        map[string]string{"app": "nginx", "tier": "front"},
+		RestartPolicy: types.RestartPolicyOnFailure,
+		// readiness gates the endpoints and ready counts of replicas
+		LivenessProbe:  &types.Probe{HTTPGet: &types.HTTPGetAction{Path: "/healthz", Port: 80}},
+		ReadinessProbe: &types.Probe{TCPSocket: &types.TCPSocketAction{Port: 80}, PeriodSeconds: 5},
+		StartupProbe:   &types.Probe{Exec: &types.ExecAction{Command: []string{"cat", "/tmp/ready"}}, FailureThreshold: 30},
+		// cgroup limits and scheduling go by them
+		Resources: types.ResourceRequirements{
+			Requests: types.ResourceList{CPU: 250, Memory: 64 << 20},
+			Limits:   types.ResourceList{CPU: 500, Memory: 128 << 20},
+		},
+		TerminationGracePeriodSeconds: &grace,
+	}
+	rs := env.RSStore.Put("default/nginx-rs", types.ReplicaSet{
+		Name:         "nginx-rs",
+		DesiredCount: 1,
+		Selector:     map[string]string{"app": "nginx"},
+		Template:     template,
+	})
+
+	ctrl := New(env.Client)
+	startInformers(t, ctrl)
+	if err := ctrl.reconcile(rs); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	pods := env.PodStore.List()
+	if len(pods) != 1 {
+		t.Fatalf("got %d pods, want 1", len(pods))
+	}
+	got := pods[0].Spec
+	want := template
+	want.Name, want.Namespace = got.Name, types.DefaultNamespace
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got pod spec %+v, want the template %+v", got, want)
+	}
+}
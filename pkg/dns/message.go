@@ -0,0 +1,262 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// The little of RFC 1035 the server needs: reading a query's question and
+// EDNS payload size, and writing answers with A and SRV records. Names are
+// written without compression.
+
+const (
+	typeA    uint16 = 1
+	typeAAAA uint16 = 28
+	typeSRV  uint16 = 33
+	typeOPT  uint16 = 41
+
+	classINET uint16 = 1
+
+	rcodeSuccess        = 0
+	rcodeFormatError    = 1
+	rcodeServerFailure  = 2
+	rcodeNameError      = 3
+	rcodeNotImplemented = 4
+
+	headerLen = 12
+	// maxUDPSize is what a UDP answer may take without EDNS.
+	maxUDPSize = 512
+)
+
+var errMalformed = errors.New("malformed message")
+
+type header struct {
+	id      uint16
+	flags   uint16
+	qdcount uint16
+	ancount uint16
+	nscount uint16
+	arcount uint16
+}
+
+const (
+	flagResponse           = 1 << 15
+	flagAuthoritative      = 1 << 10
+	flagTruncated          = 1 << 9
+	flagRecursionDesired   = 1 << 8
+	flagRecursionAvailable = 1 << 7
+)
+
+func (h header) opcode() int {
+	return int(h.flags>>11) & 0xf
+}
+
+type question struct {
+	name  string
+	qtype uint16
+	class uint16
+}
+
+// query is what the server reads from a request.
+type query struct {
+	header   header
+	question question
+	// udpSize is the largest UDP answer the client takes, from its OPT
+	// record, or maxUDPSize without one.
+	udpSize int
+}
+
+type record struct {
+	name  string
+	rtype uint16
+	ttl   uint32
+	// ip is the address of an A record
+	ip net.IP
+	// priority, weight, port and target make up an SRV record
+	priority, weight, port uint16
+	target                 string
+}
+
+func parseQuery(msg []byte) (*query, error) {
+	if len(msg) < headerLen {
+		return nil, errMalformed
+	}
+	q := &query{
+		header: header{
+			id:      binary.BigEndian.Uint16(msg[0:]),
+			flags:   binary.BigEndian.Uint16(msg[2:]),
+			qdcount: binary.BigEndian.Uint16(msg[4:]),
+			ancount: binary.BigEndian.Uint16(msg[6:]),
+			nscount: binary.BigEndian.Uint16(msg[8:]),
+			arcount: binary.BigEndian.Uint16(msg[10:]),
+		},
+		udpSize: maxUDPSize,
+	}
+	if q.header.qdcount != 1 {
+		return q, errMalformed
+	}
+
+	name, off, err := readName(msg, headerLen)
+	if err != nil || off+4 > len(msg) {
+		return q, errMalformed
+	}
+	q.question = question{
+		name:  name,
+		qtype: binary.BigEndian.Uint16(msg[off:]),
+		class: binary.BigEndian.Uint16(msg[off+2:]),
+	}
+	off += 4
+
+	// skip to the additional section for the OPT record
+	records := int(q.header.ancount) + int(q.header.nscount) + int(q.header.arcount)
+	for i := range records {
+		var rtype, class uint16
+		if _, off, err = readName(msg, off); err != nil || off+10 > len(msg) {
+			return q, errMalformed
+		}
+		rtype = binary.BigEndian.Uint16(msg[off:])
+		class = binary.BigEndian.Uint16(msg[off+2:])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10 + rdlen
+		if off > len(msg) {
+			return q, errMalformed
+		}
+		if rtype == typeOPT && i >= records-int(q.header.arcount) && int(class) > q.udpSize {
+			q.udpSize = int(class)
+		}
+	}
+	return q, nil
+}
+
+// readName reads the name at off, following compression pointers, and
+// returns it without the trailing dot along with the offset after it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errMalformed
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errMalformed
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		case n&0xc0 != 0:
+			return "", 0, errMalformed
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+func appendName(b []byte, name string) []byte {
+	for label := range strings.SplitSeq(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func appendRecord(b []byte, r record) []byte {
+	b = appendName(b, r.name)
+	b = binary.BigEndian.AppendUint16(b, r.rtype)
+	b = binary.BigEndian.AppendUint16(b, classINET)
+	b = binary.BigEndian.AppendUint32(b, r.ttl)
+
+	var rdata []byte
+	switch r.rtype {
+	case typeA:
+		rdata = r.ip.To4()
+	case typeSRV:
+		rdata = binary.BigEndian.AppendUint16(rdata, r.priority)
+		rdata = binary.BigEndian.AppendUint16(rdata, r.weight)
+		rdata = binary.BigEndian.AppendUint16(rdata, r.port)
+		rdata = appendName(rdata, r.target)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...)
+}
+
+// response is an answer to q. Without room for everything in maxSize,
+// records are left out from the end and the answer is marked truncated.
+type response struct {
+	rcode         int
+	authoritative bool
+	answers       []record
+	additional    []record
+}
+
+func (r response) pack(q *query, maxSize int) []byte {
+	flags := uint16(flagResponse|flagRecursionAvailable) |
+		uint16(q.header.opcode())<<11 |
+		q.header.flags&flagRecursionDesired |
+		uint16(r.rcode)
+	if r.authoritative {
+		flags |= flagAuthoritative
+	}
+
+	answers, additional := r.answers, r.additional
+	for {
+		b := make([]byte, headerLen, maxUDPSize)
+		binary.BigEndian.PutUint16(b[0:], q.header.id)
+		binary.BigEndian.PutUint16(b[2:], flags)
+		binary.BigEndian.PutUint16(b[4:], 1)
+		binary.BigEndian.PutUint16(b[6:], uint16(len(answers)))
+		binary.BigEndian.PutUint16(b[10:], uint16(len(additional)))
+
+		b = appendName(b, q.question.name)
+		b = binary.BigEndian.AppendUint16(b, q.question.qtype)
+		b = binary.BigEndian.AppendUint16(b, q.question.class)
+		for _, rr := range answers {
+			b = appendRecord(b, rr)
+		}
+		for _, rr := range additional {
+			b = appendRecord(b, rr)
+		}
+
+		if len(b) <= maxSize || len(answers) == 0 {
+			return b
+		}
+		flags |= flagTruncated
+		if len(additional) > 0 {
+			additional = nil
+		} else {
+			answers = answers[:len(answers)-1]
+		}
+	}
+}
+
+// errorResponse answers a message that couldn't be read with only its
+// header.
+func errorResponse(msg []byte, rcode int) []byte {
+	if len(msg) < headerLen {
+		return nil
+	}
+	b := make([]byte, headerLen)
+	copy(b, msg[:4])
+	flags := binary.BigEndian.Uint16(b[2:])&(0xf<<11|flagRecursionDesired) |
+		flagResponse | flagRecursionAvailable | uint16(rcode)
+	binary.BigEndian.PutUint16(b[2:], flags)
+	return b
+}
@@ -0,0 +1,417 @@
+/*
+Package dns is the cluster's name server. It answers for names under the
+cluster domain from what the API server knows, followed through informers,
+and passes every other question on to the node's own name servers.
+
+Under the cluster domain (cluster.local by default):
+
+	<service>.<ns>.svc                A    the service's cluster IP
+	<pod>.<service>.<ns>.svc          A    a ready pod behind the service
+	_<port>._tcp.<service>.<ns>.svc   SRV  the service port, named or by number
+	<pod>.<ns>.pod                    A    the pod's IP
+	<node>.node                       A    the node's address
+
+Containers get it as their name server with <ns>.svc.<domain>, svc.<domain>
+and <domain> to search, so a service in the pod's own namespace is found by
+its bare name and one in another namespace as <service>.<ns>.
+*/
+package dns
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"miniku/pkg/client"
+	"miniku/pkg/types"
+)
+
+// DefaultDomain is the cluster domain unless told otherwise.
+const DefaultDomain = "cluster.local"
+
+// ttl is what answers are cached for, short since pods come and go.
+const ttl = 5
+
+type Server struct {
+	podInformer       *client.Informer[types.Pod]
+	serviceInformer   *client.Informer[types.Service]
+	endpointsInformer *client.Informer[types.Endpoints]
+	nodeInformer      *client.Informer[types.Node]
+	PollInterval      time.Duration
+	// Domain is the cluster domain the server answers for itself.
+	Domain string
+	// Upstreams are the name servers (host or host:port) other names are
+	// forwarded to, tried in order. Without any they get SERVFAIL.
+	Upstreams []string
+	// Timeout bounds one exchange with an upstream.
+	Timeout time.Duration
+}
+
+func New(c *client.Client) *Server {
+	return &Server{
+		podInformer:       c.PodInformer(),
+		serviceInformer:   c.ServiceInformer(),
+		endpointsInformer: c.EndpointsInformer(),
+		nodeInformer:      c.NodeInformer(),
+		PollInterval:      5 * time.Second,
+		Domain:            DefaultDomain,
+		Timeout:           2 * time.Second,
+	}
+}
+
+// Run serves DNS on addr over UDP and TCP.
+func (s *Server) Run(addr string) error {
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		_ = udp.Close()
+		return err
+	}
+
+	s.startInformers(nil)
+	log.Printf("dns: serving %s on %s", s.Domain, addr)
+
+	errs := make(chan error, 2)
+	go func() { errs <- s.serveUDP(udp) }()
+	go func() { errs <- s.serveTCP(tcp) }()
+	err = <-errs
+	_ = udp.Close()
+	_ = tcp.Close()
+	return err
+}
+
+// startInformers runs the informers until stop is closed and waits for their
+// caches to fill.
+func (s *Server) startInformers(stop <-chan struct{}) {
+	s.podInformer.PollInterval = s.PollInterval
+	s.serviceInformer.PollInterval = s.PollInterval
+	s.endpointsInformer.PollInterval = s.PollInterval
+	s.nodeInformer.PollInterval = s.PollInterval
+
+	go s.podInformer.Run(stop)
+	go s.serviceInformer.Run(stop)
+	go s.endpointsInformer.Run(stop)
+	go s.nodeInformer.Run(stop)
+	<-s.podInformer.Synced()
+	<-s.serviceInformer.Synced()
+	<-s.endpointsInformer.Synced()
+	<-s.nodeInformer.Synced()
+}
+
+func (s *Server) serveUDP(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		msg := slices.Clone(buf[:n])
+		go func() {
+			if resp := s.handle(msg, "udp"); resp != nil {
+				_, _ = conn.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.handleTCP(conn)
+	}
+}
+
+// handleTCP answers the length prefixed messages of a connection until the
+// client is done or goes quiet.
+func (s *Server) handleTCP(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	for {
+		_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+		msg, err := readTCP(r)
+		if err != nil {
+			return
+		}
+		resp := s.handle(msg, "tcp")
+		if resp == nil {
+			return
+		}
+		if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(resp)))); err != nil {
+			return
+		}
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+func readTCP(r io.Reader) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// handle answers one message that came in over network.
+func (s *Server) handle(msg []byte, network string) []byte {
+	q, err := parseQuery(msg)
+	if err != nil {
+		return errorResponse(msg, rcodeFormatError)
+	}
+	if q.header.flags&flagResponse != 0 {
+		return nil
+	}
+	if q.header.opcode() != 0 {
+		return errorResponse(msg, rcodeNotImplemented)
+	}
+
+	maxSize := 65535
+	if network == "udp" {
+		maxSize = q.udpSize
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(q.question.name, "."))
+	rel, ok := s.relative(name)
+	if !ok {
+		resp, err := s.forward(msg, network)
+		if err != nil {
+			log.Printf("dns: forward %s: %v", q.question.name, err)
+			return errorResponse(msg, rcodeServerFailure)
+		}
+		return resp
+	}
+
+	return s.resolve(q, rel).pack(q, maxSize)
+}
+
+// relative is name with the cluster domain cut off, ok reports whether it
+// is in the domain at all.
+func (s *Server) relative(name string) (string, bool) {
+	domain := strings.ToLower(strings.Trim(s.Domain, "."))
+	if name == domain {
+		return "", true
+	}
+	return strings.CutSuffix(name, "."+domain)
+}
+
+// resolve answers a question for a name under the cluster domain, rel being
+// the part before it.
+func (s *Server) resolve(q *query, rel string) response {
+	resp := response{authoritative: true}
+	if q.question.class != classINET {
+		resp.rcode = rcodeNameError
+		return resp
+	}
+
+	ips, srvs, found := s.lookup(rel)
+	if !found {
+		resp.rcode = rcodeNameError
+		return resp
+	}
+
+	switch q.question.qtype {
+	case typeA:
+		for _, ip := range ips {
+			resp.answers = append(resp.answers, record{name: q.question.name, rtype: typeA, ttl: ttl, ip: ip})
+		}
+	case typeSRV:
+		for _, srv := range srvs {
+			srv.name = q.question.name
+			resp.answers = append(resp.answers, srv)
+			target, _ := s.relative(strings.ToLower(srv.target))
+			targetIPs, _, _ := s.lookup(target)
+			for _, ip := range targetIPs {
+				resp.additional = append(resp.additional, record{name: srv.target, rtype: typeA, ttl: ttl, ip: ip})
+			}
+		}
+	}
+	// a name that exists without records of the type asked for, AAAA
+	// among them, gets an empty answer
+	return resp
+}
+
+// lookup finds the addresses and services a name under the cluster domain
+// has. found is false for names that don't exist.
+func (s *Server) lookup(rel string) (ips []net.IP, srvs []record, found bool) {
+	labels := strings.Split(rel, ".")
+	switch {
+	case len(labels) == 3 && labels[2] == "pod":
+		pod, ok := s.podInformer.Get(types.Key(labels[1], labels[0]))
+		if !ok {
+			return nil, nil, false
+		}
+		return parseIPs(pod.PodIP), nil, true
+
+	case len(labels) == 2 && labels[1] == "node":
+		node, ok := s.nodeInformer.Get(labels[0])
+		if !ok {
+			return nil, nil, false
+		}
+		host, _, err := net.SplitHostPort(node.Address)
+		if err != nil {
+			host = node.Address
+		}
+		return parseIPs(host), nil, true
+
+	case len(labels) == 3 && labels[2] == "svc":
+		svc, ok := s.serviceInformer.Get(types.Key(labels[1], labels[0]))
+		if !ok {
+			return nil, nil, false
+		}
+		return parseIPs(svc.ClusterIP), nil, true
+
+	case len(labels) == 4 && labels[3] == "svc":
+		ep, ok := s.endpointsInformer.Get(types.Key(labels[2], labels[1]))
+		if !ok {
+			return nil, nil, false
+		}
+		for _, addr := range ep.Addresses {
+			if strings.EqualFold(addr.PodName, labels[0]) {
+				return parseIPs(addr.IP), nil, true
+			}
+		}
+		return nil, nil, false
+
+	case len(labels) == 5 && labels[1] == "_tcp" && labels[4] == "svc":
+		svc, ok := s.serviceInformer.Get(types.Key(labels[3], labels[2]))
+		if !ok {
+			return nil, nil, false
+		}
+		target := svc.Name + "." + labels[3] + ".svc." + s.Domain
+		for _, port := range svc.Ports {
+			if labels[0] != "_"+portName(port) {
+				continue
+			}
+			srvs = append(srvs, record{
+				rtype:    typeSRV,
+				ttl:      ttl,
+				priority: 0,
+				weight:   100,
+				port:     uint16(port.Port),
+				target:   target,
+			})
+		}
+		return nil, srvs, len(srvs) > 0
+	}
+	// the domain itself and svc, pod and node under it exist, if empty
+	switch rel {
+	case "", "svc", "pod", "node":
+		return nil, nil, true
+	}
+	return nil, nil, false
+}
+
+// portName is what a service port is called in SRV names, its number when
+// it has no name.
+func portName(p types.ServicePort) string {
+	if p.Name != "" {
+		return strings.ToLower(p.Name)
+	}
+	return strconv.Itoa(p.Port)
+}
+
+func parseIPs(s string) []net.IP {
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return nil
+	}
+	return []net.IP{ip}
+}
+
+// forward asks the upstreams in turn and returns the first answer.
+func (s *Server) forward(msg []byte, network string) ([]byte, error) {
+	if len(s.Upstreams) == 0 {
+		return nil, errors.New("no upstream name servers")
+	}
+	var errs []error
+	for _, upstream := range s.Upstreams {
+		resp, err := s.exchange(network, upstream, msg)
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (s *Server) exchange(network, upstream string, msg []byte) ([]byte, error) {
+	if _, _, err := net.SplitHostPort(upstream); err != nil {
+		upstream = net.JoinHostPort(upstream, "53")
+	}
+	conn, err := net.DialTimeout(network, upstream, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(s.Timeout))
+
+	if network == "tcp" {
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...)); err != nil {
+			return nil, err
+		}
+		return readTCP(conn)
+	}
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// an answer to something else is dropped
+		if n >= 2 && binary.BigEndian.Uint16(buf) == binary.BigEndian.Uint16(msg) {
+			return buf[:n], nil
+		}
+	}
+}
+
+// ReadResolvConf returns the name servers of a resolv.conf.
+func ReadResolvConf(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return servers, nil
+}
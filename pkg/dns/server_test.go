@@ -0,0 +1,261 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"miniku/pkg/testutil"
+	"miniku/pkg/types"
+)
+
+// serve runs s on a loopback UDP and TCP port of the same number and returns
+// the address.
+func serve(t *testing.T, s *Server) string {
+	t.Helper()
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := udp.LocalAddr().String()
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = udp.Close()
+		_ = tcp.Close()
+	})
+	go func() { _ = s.serveUDP(udp) }()
+	go func() { _ = s.serveTCP(tcp) }()
+	return addr
+}
+
+func newTestServer(t *testing.T, env *testutil.TestEnv) *Server {
+	t.Helper()
+	s := New(env.Client)
+	s.Timeout = 500 * time.Millisecond
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	s.startInformers(stop)
+	return s
+}
+
+// resolver asks only the server at addr.
+func resolver(addr string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+func TestServer(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	env.ServiceStore.Put("default/web", types.Service{
+		Name:      "web",
+		ClusterIP: "10.96.0.10",
+		Ports:     []types.ServicePort{{Name: "http", Port: 80}, {Port: 8443}},
+	})
+	env.EndpointsStore.Put("default/web", types.Endpoints{
+		Name:      "web",
+		Addresses: []types.EndpointAddress{{IP: "10.244.0.5", PodName: "web-1"}},
+	})
+	env.ServiceStore.Put("shop/web", types.Service{Name: "web", Namespace: "shop", ClusterIP: "10.96.0.20"})
+	env.PodStore.Put("default/web-1", types.Pod{Spec: types.PodSpec{Name: "web-1"}, PodIP: "10.244.0.5"})
+	env.PodStore.Put("default/pending", types.Pod{Spec: types.PodSpec{Name: "pending"}})
+	env.NodeStore.Put("node-1", types.Node{Name: "node-1", Address: "192.168.1.7:10250"})
+
+	r := resolver(serve(t, newTestServer(t, env)))
+
+	tests := []struct {
+		name    string
+		want    []string
+		wantErr bool
+	}{
+		{"web.default.svc.cluster.local", []string{"10.96.0.10"}, false},
+		{"WEB.Default.svc.cluster.local.", []string{"10.96.0.10"}, false},
+		{"web.shop.svc.cluster.local", []string{"10.96.0.20"}, false},
+		{"web-1.web.default.svc.cluster.local", []string{"10.244.0.5"}, false},
+		{"web-1.default.pod.cluster.local", []string{"10.244.0.5"}, false},
+		{"node-1.node.cluster.local", []string{"192.168.1.7"}, false},
+		{"web-2.web.default.svc.cluster.local", nil, true},
+		{"web-1.web.shop.svc.cluster.local", nil, true},
+		{"db.default.svc.cluster.local", nil, true},
+		{"web.svc.cluster.local", nil, true},
+		{"pending.default.pod.cluster.local", nil, true},
+		{"web-1.shop.pod.cluster.local", nil, true},
+		{"web.cluster.local", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.LookupHost(context.Background(), tt.name)
+			if tt.wantErr {
+				var dnsErr *net.DNSError
+				if !errors.As(err, &dnsErr) {
+					t.Fatalf("expected a DNS error, got %v, %v", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lookup: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("srv", func(t *testing.T) {
+		for _, port := range []struct {
+			service string
+			want    uint16
+		}{{"http", 80}, {"8443", 8443}} {
+			_, srvs, err := r.LookupSRV(context.Background(), port.service, "tcp", "web.default.svc.cluster.local")
+			if err != nil {
+				t.Fatalf("lookup %s: %v", port.service, err)
+			}
+			if len(srvs) != 1 || srvs[0].Port != port.want || srvs[0].Target != "web.default.svc.cluster.local." {
+				t.Errorf("%s: got %+v, want port %d on web.default.svc.cluster.local.", port.service, srvs, port.want)
+			}
+		}
+		if _, _, err := r.LookupSRV(context.Background(), "grpc", "tcp", "web.default.svc.cluster.local"); err == nil {
+			t.Error("expected an unknown port to fail")
+		}
+	})
+}
+
+func TestServerForward(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+	env.ServiceStore.Put("default/web", types.Service{Name: "web", ClusterIP: "10.96.0.10"})
+	env.ServiceStore.Put("default/api", types.Service{Name: "api", ClusterIP: "10.96.0.11"})
+
+	// the upstream is another cluster's server
+	upstream := newTestServer(t, env)
+	upstream.Domain = "example.test"
+	upstreamAddr := serve(t, upstream)
+
+	s := newTestServer(t, env)
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing answers on dead, the next upstream does
+	s.Upstreams = []string{dead.LocalAddr().String(), upstreamAddr}
+	r := resolver(serve(t, s))
+
+	got, err := r.LookupHost(context.Background(), "api.default.svc.example.test")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if !slices.Equal(got, []string{"10.96.0.11"}) {
+		t.Errorf("got %v, want [10.96.0.11]", got)
+	}
+	_ = dead.Close()
+
+	s.Upstreams = nil
+	resp := s.handle(newQuery(0x1234, "api.default.svc.example.test", typeA), "udp")
+	if len(resp) < headerLen || resp[0] != 0x12 || resp[1] != 0x34 {
+		t.Fatalf("got %x, want an answer to query 0x1234", resp)
+	}
+	if rcode := resp[3] & 0xf; rcode != rcodeServerFailure {
+		t.Errorf("got rcode %d without upstreams, want %d", rcode, rcodeServerFailure)
+	}
+}
+
+func newQuery(id uint16, name string, qtype uint16) []byte {
+	msg := []byte{byte(id >> 8), byte(id), 1, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	msg = appendName(msg, name)
+	return append(msg, byte(qtype>>8), byte(qtype), 0, byte(classINET))
+}
+
+func TestHandleMalformed(t *testing.T) {
+	s := &Server{Domain: DefaultDomain}
+
+	tests := []struct {
+		name      string
+		msg       []byte
+		wantRcode byte
+		wantNil   bool
+	}{
+		{"too short", []byte{1, 2, 3}, 0, true},
+		{"cut off question", newQuery(1, "web.default.svc.cluster.local", typeA)[:20], rcodeFormatError, false},
+		{"response", func() []byte {
+			msg := newQuery(1, "web.default.svc.cluster.local", typeA)
+			msg[2] |= 0x80
+			return msg
+		}(), 0, true},
+		{"not a query", func() []byte {
+			msg := newQuery(1, "web.default.svc.cluster.local", typeA)
+			msg[2] |= 2 << 3
+			return msg
+		}(), rcodeNotImplemented, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := s.handle(tt.msg, "udp")
+			if tt.wantNil {
+				if resp != nil {
+					t.Fatalf("expected no answer, got %x", resp)
+				}
+				return
+			}
+			if len(resp) != headerLen {
+				t.Fatalf("got %x, want a bare header", resp)
+			}
+			if rcode := resp[3] & 0xf; rcode != tt.wantRcode {
+				t.Errorf("got rcode %d, want %d", rcode, tt.wantRcode)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	q := &query{question: question{name: "many.svc.cluster.local", qtype: typeA, class: classINET}}
+	var resp response
+	for i := range 100 {
+		resp.answers = append(resp.answers, record{
+			name: q.question.name, rtype: typeA, ttl: ttl, ip: net.IPv4(10, 0, 0, byte(i)),
+		})
+	}
+
+	msg := resp.pack(q, maxUDPSize)
+	if len(msg) > maxUDPSize {
+		t.Fatalf("got %d bytes, want at most %d", len(msg), maxUDPSize)
+	}
+	if flags := uint16(msg[2])<<8 | uint16(msg[3]); flags&flagTruncated == 0 {
+		t.Error("expected the answer to be marked truncated")
+	}
+
+	if full := resp.pack(q, 65535); uint16(full[6])<<8|uint16(full[7]) != 100 {
+		t.Error("expected every answer without a size limit")
+	}
+}
+
+func TestReadResolvConf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	conf := "# generated\nnameserver 192.168.1.1\nsearch lan\nnameserver 1.1.1.1\noptions edns0\n"
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadResolvConf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"192.168.1.1", "1.1.1.1"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,52 @@
+package kubelet
+
+import (
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"fmt"
+
+	"miniku/pkg/auth"
+	"miniku/pkg/client"
+	"miniku/pkg/pki"
+	"miniku/pkg/types"
+)
+
+// The kubelet's key pairs in its cert dir.
+const (
+	ClientCertName  = "kubelet-client"
+	ServingCertName = "kubelet"
+)
+
+// RequestCertificate returns key pair name of node in certDir. When there
+// is none yet or it expired it asks the API server's CA for a new one
+// through c, which authenticates with a join token for the first client
+// certificate and with that for the rest. hosts are what a serving
+// certificate is valid for.
+func RequestCertificate(c *client.Client, certDir, name, node string, usage types.CertificateUsage, hosts []string) (tls.Certificate, error) {
+	if cert, err := pki.LoadKeyPair(certDir, name); err == nil {
+		return cert, nil
+	}
+
+	key, err := pki.NewKey()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	subject := pkix.Name{CommonName: auth.NodeUserPrefix + node, Organization: []string{auth.GroupNodes}}
+	req, err := pki.NewCSR(key, subject, hosts)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	signed, err := c.SignCertificate(types.CertificateSigningRequest{Request: string(req), Usage: usage})
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("request %s certificate: %w", usage, err)
+	}
+
+	keyPEM, err := pki.EncodeKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := pki.WriteKeyPair(certDir, name, []byte(signed.Certificate), keyPEM); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair([]byte(signed.Certificate), keyPEM)
+}
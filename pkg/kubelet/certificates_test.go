@@ -0,0 +1,101 @@
+package kubelet
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"testing"
+
+	"miniku/pkg/api"
+	"miniku/pkg/auth"
+	"miniku/pkg/client"
+	"miniku/pkg/pki"
+	"miniku/pkg/store"
+	"miniku/pkg/types"
+)
+
+const joinToken = "abcdef.0123456789abcdef"
+
+// newTLSServer runs an API server over mutual TLS with the CA signing node
+// certificates and nodes joining with joinToken.
+func newTLSServer(t *testing.T, ca *pki.CA) *httptest.Server {
+	t.Helper()
+	roles := store.NewMemStore[types.Role]()
+	bindings := store.NewMemStore[types.RoleBinding]()
+	joinTokens, err := auth.NewJoinTokens([]string{joinToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &api.Server{
+		PodStore:         store.NewMemStore[types.Pod](),
+		RSStore:          store.NewMemStore[types.ReplicaSet](),
+		NodeStore:        store.NewMemStore[types.Node](),
+		NamespaceStore:   store.NewMemStore[types.Namespace](),
+		RoleStore:        roles,
+		RoleBindingStore: bindings,
+		CA:               ca,
+		Authenticator:    auth.Union{auth.ClientCert{}, joinTokens},
+		Authorizer:       &auth.RBAC{Roles: roles, Bindings: bindings},
+	}
+	cert, err := ca.LoadOrIssue(t.TempDir(), "apiserver", pkix.Name{CommonName: "apiserver"}, []string{"127.0.0.1"}, x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(srv.Routes())
+	ts.TLS = pki.ServerTLS(cert, ca.Pool())
+	ts.StartTLS()
+	return ts
+}
+
+func TestBootstrapCertificates(t *testing.T) {
+	ca, err := pki.NewCA("test-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newTLSServer(t, ca)
+	defer ts.Close()
+	dir := t.TempDir()
+
+	bootstrap := client.New(ts.URL, client.WithCABundle(ca.Pool()), client.WithToken(joinToken))
+	clientCert, err := RequestCertificate(bootstrap, dir, ClientCertName, "node-1", types.CertificateUsageClient, nil)
+	if err != nil {
+		t.Fatalf("client certificate: %v", err)
+	}
+	if _, err := bootstrap.ListPods(); err == nil {
+		t.Error("join token can list pods, want it to only get certificates")
+	}
+
+	c := client.New(ts.URL, client.WithCABundle(ca.Pool()), client.WithClientCert(clientCert))
+	if err := c.CreateNode(types.Node{Name: "node-1", Status: types.NodeStateReady, Address: "127.0.0.1:10250"}); err != nil {
+		t.Fatalf("node can't register with its client certificate: %v", err)
+	}
+	if err := c.CreateNode(types.Node{Name: "node-2", Status: types.NodeStateReady}); err == nil {
+		t.Error("node-1 registered node-2")
+	}
+
+	// only for the name and address the node advertises
+	if _, err := RequestCertificate(c, t.TempDir(), ServingCertName, "node-1", types.CertificateUsageServer, []string{"node-1", "10.0.0.9"}); err == nil {
+		t.Error("node got a serving certificate for an address it doesn't advertise")
+	}
+	serving, err := RequestCertificate(c, dir, ServingCertName, "node-1", types.CertificateUsageServer, []string{"node-1", "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("serving certificate: %v", err)
+	}
+	if err := serving.Leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Errorf("serving certificate: %v", err)
+	}
+
+	// the next start finds the certificates, the join token isn't needed
+	// anymore
+	again, err := RequestCertificate(client.New(ts.URL), dir, ClientCertName, "node-1", types.CertificateUsageClient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Leaf.Equal(clientCert.Leaf) {
+		t.Error("got a new client certificate, want the stored one")
+	}
+
+	if _, err := client.New(ts.URL).ListPods(); err == nil {
+		t.Error("client without the CA bundle trusts the API server")
+	}
+}
@@ -1,11 +1,14 @@
 package kubelet
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"miniku/pkg/client"
 	"miniku/pkg/runtime"
 	"miniku/pkg/types"
+	"sync"
 	"time"
 )
 
@@ -14,19 +17,79 @@ const maxRetryCount = 3
 const baseDelay = 1 * time.Second
 const maxDelay = 60 * time.Second
 
+// stableRun is how long a container has to run for its next restart to
+// start the CrashLoopBackOff over.
+const stableRun = 10 * time.Minute
+
+// orphanGracePeriod is what a container gets to stop when its pod is
+// already gone, there is no grace period left to go by.
+const orphanGracePeriod = 2 * time.Second
+
+// reasonContainersNotReady explains a False Ready condition.
+const reasonContainersNotReady = "ContainersNotReady"
+
 type Kubelet struct {
 	name         string
 	client       *client.Client
 	runtime      runtime.Runtime
+	podInformer  *client.Informer[types.Pod]
+	queue        *client.WorkQueue
+	prober       *prober
+	terminating  *sync.Map // keys of the deleted pods being stopped
 	PollInterval time.Duration
+	// Capacity is reported to the scheduler, Reserved is held back from it
+	// for the system. Set them before Register.
+	Capacity types.ResourceList
+	Reserved types.ResourceList
+	// Address is where Handler is served, advertised on the node for the
+	// API server to reach us.
+	Address string
+	// PodCIDR is the range the runtime gives pod IPs from, advertised on
+	// the node.
+	PodCIDR string
+	// Labels are put on the node when it registers.
+	Labels map[string]string
 }
 
-func New(client *client.Client, runtime runtime.Runtime, name string) Kubelet {
-	return Kubelet{
+func New(c *client.Client, runtime runtime.Runtime, name string) Kubelet {
+	k := Kubelet{
 		name:         name,
-		client:       client,
+		client:       c,
 		runtime:      runtime,
+		podInformer:  c.PodInformer(client.FieldSelector("spec.node_name=" + name)),
+		queue:        client.NewWorkQueue(),
+		prober:       newProber(runtime),
+		terminating:  &sync.Map{},
 		PollInterval: 5 * time.Second,
+		Capacity:     hostCapacity(),
+	}
+
+	// New returns the kubelet by value, so the callbacks hold on to the
+	// queue rather than to k
+	queue := k.queue
+	informer := k.podInformer
+	k.prober.onChange = queue.Add
+	k.prober.onFailure = func(podKey, containerID string, kind probeKind) {
+		log.Printf("kubelet: killing container %s of pod %s, %s probe failed", containerID, podKey, kind)
+		pod, _ := informer.Get(podKey)
+		if err := runtime.Stop(containerID, pod.Spec.GracePeriod()); err != nil {
+			log.Printf("kubelet: failed to stop container %s: %v", containerID, err)
+		}
+		queue.Add(podKey)
+	}
+
+	k.podInformer.AddEventHandler(client.EventHandler[types.Pod]{
+		OnAdd:    k.enqueueIfOwned,
+		OnUpdate: func(_, pod types.Pod) { k.enqueueIfOwned(pod) },
+		OnDelete: k.enqueueIfOwned,
+	})
+
+	return k
+}
+
+func (k *Kubelet) enqueueIfOwned(pod types.Pod) {
+	if pod.Spec.NodeName == k.name {
+		k.queue.Add(pod.Key())
 	}
 }
 
@@ -40,13 +103,13 @@ func (k *Kubelet) Sync() {
 	}
 
 	for _, container := range containers {
-		pod, exists, err := k.client.GetPod(container.Name)
+		pod, exists, err := k.client.GetPod(container.Namespace, container.Name)
 		if err != nil {
-			log.Printf("sync: failed to get pod %s: %v", container.Name, err)
+			log.Printf("sync: failed to get pod %s: %v", container.PodKey(), err)
 			continue
 		}
 		if !exists {
-			k.removeContainer(container.Name, container.ID)
+			k.removeContainer(container.PodKey(), container.ID)
 			continue
 		}
 
@@ -59,7 +122,7 @@ func (k *Kubelet) Sync() {
 			log.Printf("sync: linking container %s to pod %s", container.ID, pod.Spec.Name)
 			pod.ContainerID = container.ID
 			pod.Status = types.PodStatusRunning
-			if err := k.client.UpdatePod(pod.Spec.Name, pod); err != nil {
+			if err := k.updatePod(pod); err != nil {
 				log.Printf("sync: failed to update pod %s: %v", pod.Spec.Name, err)
 			}
 		}
@@ -68,6 +131,8 @@ func (k *Kubelet) Sync() {
 
 // cleanupOrphanedContainers stops and removes containers whose pods
 // no longer exist in the store (e.g. deleted via API or scaled down).
+// The informer only caches this node's pods, so a container it doesn't know
+// about is checked against the API server before it is removed.
 func (k *Kubelet) cleanupOrphanedContainers() {
 	containers, err := k.runtime.List()
 	if err != nil {
@@ -76,20 +141,23 @@ func (k *Kubelet) cleanupOrphanedContainers() {
 	}
 
 	for _, container := range containers {
-		_, exists, err := k.client.GetPod(container.Name)
+		if _, exists := k.podInformer.Get(container.PodKey()); exists {
+			continue
+		}
+		_, exists, err := k.client.GetPod(container.Namespace, container.Name)
 		if err != nil {
-			log.Printf("kubelet: failed to get pod %s: %v", container.Name, err)
+			log.Printf("kubelet: failed to get pod %s: %v", container.PodKey(), err)
 			continue
 		}
 		if !exists {
-			k.removeContainer(container.Name, container.ID)
+			k.removeContainer(container.PodKey(), container.ID)
 		}
 	}
 }
 
 func (k *Kubelet) removeContainer(name string, id string) {
 	log.Printf("kubelet: removing orphan container %s (%s)", name, id)
-	if err := k.runtime.Stop(id); err != nil {
+	if err := k.runtime.Stop(id, orphanGracePeriod); err != nil {
 		log.Printf("kubelet: failed to stop container %s: %v", id, err)
 	}
 	if err := k.runtime.Remove(id); err != nil {
@@ -99,35 +167,160 @@ func (k *Kubelet) removeContainer(name string, id string) {
 
 func (k *Kubelet) Run() {
 	k.Sync()
+	k.startInformers(nil)
+	go k.housekeeping()
 
 	for {
-		pods, err := k.client.ListPods()
-		if err != nil {
-			log.Printf("kubelet: failed to list pods: %v", err)
-			time.Sleep(k.PollInterval)
-			continue
+		key, ok := k.queue.Get()
+		if !ok {
+			return
 		}
+		k.sync(key)
+	}
+}
+
+// startInformers runs the pod informer until stop is closed and waits for its
+// cache to fill. Container state changes outside the API server, so the
+// informer resyncs every PollInterval to have our pods looked at again.
+func (k *Kubelet) startInformers(stop <-chan struct{}) {
+	k.podInformer.PollInterval = k.PollInterval
+	k.podInformer.ResyncPeriod = k.PollInterval
+
+	go k.podInformer.Run(stop)
+	<-k.podInformer.Synced()
+}
+
+// housekeeping does the periodic work that isn't triggered by pod changes.
+func (k *Kubelet) housekeeping() {
+	for {
+		k.cleanupOrphanedContainers()
+		k.updateHeartbeat()
+		time.Sleep(k.PollInterval)
+	}
+}
 
-		for _, pod := range pods {
-			// only reconcile pods assigned to this node
-			if pod.Spec.NodeName != k.name {
+func (k *Kubelet) sync(key string) {
+	defer k.queue.Done(key)
+
+	pod, ok := k.podInformer.Get(key)
+	if !ok {
+		k.prober.remove(key)
+		k.removeContainersFor(key)
+		if err := k.runtime.RemoveLogs(types.SplitKey(key)); err != nil {
+			log.Printf("kubelet: failed to remove logs of pod %s: %v", key, err)
+		}
+		k.queue.Forget(key)
+		return
+	}
+	// only reconcile pods assigned to this node
+	if pod.Spec.NodeName != k.name {
+		k.prober.remove(key)
+		k.queue.Forget(key)
+		return
+	}
+	if pod.Deleting() {
+		k.terminate(pod)
+		k.queue.Forget(key)
+		return
+	}
+
+	if err := k.reconcilePod(pod); err != nil {
+		log.Printf("kubelet: failed to reconcile pod %s: %v", pod.Spec.Name, err)
+		k.queue.AddRateLimited(key)
+		return
+	}
+	k.queue.Forget(key)
+
+	// a pod we just started is still Pending here, our write brings it back
+	// through the informer to get its probes started
+	k.prober.sync(pod)
+
+	// come back once the backoff is over rather than waiting for a resync
+	if pod.Status == types.PodStatusPending && time.Now().Before(pod.NextRetryAt) {
+		k.queue.AddAfter(key, time.Until(pod.NextRetryAt))
+	}
+}
+
+// terminate stops the containers of a deleted pod in the background, giving
+// them what is left of its grace period, and then confirms the deletion for
+// the API server to remove the pod. A pod already being terminated is left
+// alone.
+func (k *Kubelet) terminate(pod types.Pod) {
+	key := pod.Key()
+	if _, running := k.terminating.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	k.prober.remove(key)
+
+	go func() {
+		defer k.terminating.Delete(key)
+
+		grace := max(0, time.Until(pod.DeletionDeadline()))
+		log.Printf("kubelet: terminating pod %s within %s", key, grace.Round(time.Second))
+		containers, err := k.runtime.List()
+		if err != nil {
+			log.Printf("kubelet: failed to list containers: %v", err)
+			k.queue.AddRateLimited(key)
+			return
+		}
+		for _, container := range containers {
+			if container.PodKey() != key {
 				continue
 			}
-			if err := k.reconcilePod(pod); err != nil {
-				log.Printf("kubelet: failed to reconcile pod %s: %v", pod.Spec.Name, err)
+			if err := k.runtime.Stop(container.ID, grace); err != nil {
+				log.Printf("kubelet: failed to stop container %s: %v", container.ID, err)
+			}
+			if err := k.runtime.Remove(container.ID); err != nil {
+				log.Printf("kubelet: failed to remove container %s: %v", container.ID, err)
 			}
 		}
 
-		k.cleanupOrphanedContainers()
+		if err := k.client.DeletePod(pod.Spec.Namespace, pod.Spec.Name, client.GracePeriod(0)); err != nil {
+			log.Printf("kubelet: failed to confirm deletion of pod %s: %v", key, err)
+			k.queue.AddRateLimited(key)
+		}
+	}()
+}
 
-		// polling
-		k.updateHeartbeat()
-		time.Sleep(k.PollInterval)
+// findContainer returns the ID of the container running the pod with key
+// podKey, if any.
+func (k *Kubelet) findContainer(podKey string) string {
+	containers, err := k.runtime.List()
+	if err != nil {
+		log.Printf("kubelet: failed to list containers: %v", err)
+		return ""
+	}
+	for _, container := range containers {
+		if container.PodKey() == podKey {
+			return container.ID
+		}
+	}
+	return ""
+}
+
+// removeContainersFor removes the containers of a pod that was deleted.
+func (k *Kubelet) removeContainersFor(podKey string) {
+	containers, err := k.runtime.List()
+	if err != nil {
+		log.Printf("kubelet: failed to list containers: %v", err)
+		return
+	}
+	for _, container := range containers {
+		if container.PodKey() == podKey {
+			k.removeContainer(podKey, container.ID)
+		}
 	}
 }
 
 func (k *Kubelet) reconcilePod(pod types.Pod) error {
 	podStatus := pod.Status
+	containerID := pod.ContainerID
+
+	// the cache can lag behind a container we started on an earlier pass,
+	// adopt it rather than starting a second one
+	if pod.ContainerID == "" {
+		pod.ContainerID = k.findContainer(pod.Key())
+	}
 
 	var containerState *types.ContainerState
 	if pod.ContainerID != "" {
@@ -140,11 +333,19 @@ func (k *Kubelet) reconcilePod(pod types.Pod) error {
 	}
 
 	// terminal state, nothing to do
-	if podStatus == types.PodStatusFailed {
+	if pod.Finished() {
 		return nil
 	}
 
+	// a restart couldn't get rid of the previous container, try again
+	if podStatus == types.PodStatusPending && containerState != nil && containerState.Status == types.ContainerStatusExited {
+		k.discardContainer(pod.ContainerID)
+		pod.ContainerID = ""
+		containerState = nil
+	}
+
 	var updatedPod types.Pod
+	var converged bool
 	var err error
 	switch {
 	// pending but container not existing yet
@@ -161,10 +362,16 @@ func (k *Kubelet) reconcilePod(pod types.Pod) error {
 	// pending and created but should be running
 	case podStatus == types.PodStatusPending && containerState != nil && containerState.Status == types.ContainerStatusRunning:
 		updatedPod = k.updatePodStatus(pod, types.PodStatusRunning)
+		updatedPod.PodIP = containerState.IP
 
-	// happy flow, return
+	// happy flow, only readiness can change
 	case podStatus == types.PodStatusRunning && containerState != nil && containerState.Status == types.ContainerStatusRunning:
-		return nil
+		updatedPod, converged = pod, pod.ContainerID == containerID && pod.PodIP == containerState.IP
+		updatedPod.PodIP = containerState.IP
+
+	// expected running but exited, restart or finish per the restart policy
+	case podStatus == types.PodStatusRunning && containerState != nil && containerState.Status == types.ContainerStatusExited:
+		updatedPod = k.handleExitedContainer(pod, *containerState)
 
 	// expected running but not running, mark as failed
 	case podStatus == types.PodStatusRunning && containerState != nil && containerState.Status != types.ContainerStatusRunning:
@@ -178,7 +385,45 @@ func (k *Kubelet) reconcilePod(pod types.Pod) error {
 		return fmt.Errorf("unhandled state")
 	}
 
-	return k.client.UpdatePod(updatedPod.Spec.Name, updatedPod)
+	// the Ready condition follows the container and its probes
+	ready := types.ConditionFalse
+	reason := reasonContainersNotReady
+	if updatedPod.Status == types.PodStatusRunning && k.prober.ready(updatedPod) {
+		ready, reason = types.ConditionTrue, ""
+	}
+	if changed := updatedPod.SetCondition(types.PodReady, ready, reason); converged && !changed {
+		return nil
+	}
+
+	return k.updatePod(updatedPod)
+}
+
+// updatePod patches the fields the kubelet owns, each replaced as a whole,
+// so concurrent writes to the rest of the pod (e.g. the scheduler's
+// NodeName) survive. A pod deleted meanwhile is no error.
+func (k *Kubelet) updatePod(pod types.Pod) error {
+	patch, err := json.Marshal([]map[string]any{
+		{"op": "add", "path": "/status", "value": pod.Status},
+		{"op": "add", "path": "/containerId", "value": pod.ContainerID},
+		{"op": "add", "path": "/podIP", "value": pod.PodIP},
+		{"op": "add", "path": "/message", "value": pod.Message},
+		{"op": "add", "path": "/reason", "value": pod.Reason},
+		{"op": "add", "path": "/retry_count", "value": pod.RetryCount},
+		{"op": "add", "path": "/next_retry_at", "value": pod.NextRetryAt},
+		{"op": "add", "path": "/restartCount", "value": pod.RestartCount},
+		{"op": "add", "path": "/startedAt", "value": pod.StartedAt},
+		{"op": "add", "path": "/backoffCount", "value": pod.BackoffCount},
+		{"op": "add", "path": "/lastState", "value": pod.LastState},
+		{"op": "add", "path": "/conditions", "value": pod.Conditions},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = k.client.PatchPod(pod.Spec.Namespace, pod.Spec.Name, types.JSONPatchType, patch)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil
+	}
+	return err
 }
 
 // this function should backoff + retry when runtime.Run() fails.
@@ -197,11 +442,62 @@ func (k *Kubelet) createAndRun(pod types.Pod) (types.Pod, error) {
 	}
 
 	pod.ContainerID = cID
+	pod.PodIP = ""
+	if state, err := k.runtime.GetStatus(cID); err == nil && state != nil {
+		pod.PodIP = state.IP
+	}
 	pod.RetryCount = 0
+	pod.Reason = ""
 	pod.Status = types.PodStatusRunning
+	pod.StartedAt = time.Now()
 	return pod, nil
 }
 
+// handleExitedContainer applies the pod's restart policy to a container that
+// exited. A restarted container waits out an exponential backoff as a
+// Pending pod, and is then started again by createAndRun. The backoff starts
+// over once a container ran for stableRun.
+func (k *Kubelet) handleExitedContainer(pod types.Pod, state types.ContainerState) types.Pod {
+	if state.Reason == "" {
+		state.Reason = types.ContainerReasonError
+		if state.ExitCode == 0 {
+			state.Reason = types.ContainerReasonCompleted
+		}
+	}
+	state.ContainerID = pod.ContainerID
+	pod.LastState = &state
+
+	if !pod.Spec.RestartPolicy.ShouldRestart(state.ExitCode) {
+		pod.Status = types.PodStatusFailed
+		if state.ExitCode == 0 {
+			pod.Status = types.PodStatusSucceeded
+		}
+		pod.Message = fmt.Sprintf("container exited with code %d", state.ExitCode)
+		return pod
+	}
+
+	log.Printf("kubelet: restarting pod %s, container exited with code %d", pod.Spec.Name, state.ExitCode)
+	k.discardContainer(pod.ContainerID)
+	pod.ContainerID = ""
+	pod.PodIP = ""
+	pod.Status = types.PodStatusPending
+	pod.Reason = types.PodReasonCrashLoopBackOff
+	pod.RestartCount++
+	if time.Since(pod.StartedAt) >= stableRun {
+		pod.BackoffCount = 0
+	}
+	pod.NextRetryAt = time.Now().Add(backoff(pod.BackoffCount))
+	pod.BackoffCount++
+	return pod
+}
+
+// discardContainer removes the exited container of a pod being restarted.
+func (k *Kubelet) discardContainer(id string) {
+	if err := k.runtime.Remove(id); err != nil {
+		log.Printf("kubelet: failed to remove container %s: %v", id, err)
+	}
+}
+
 func (k *Kubelet) updatePodStatus(pod types.Pod, status types.PodStatus) types.Pod {
 	pod.Status = status
 	return pod
@@ -214,24 +510,35 @@ func (k *Kubelet) updatePodStatus(pod types.Pod, status types.PodStatus) types.P
 func (k *Kubelet) handleMissingContainer(pod types.Pod) types.Pod {
 	pod.Status = types.PodStatusPending
 	pod.ContainerID = "" // clear stale cID
+	pod.PodIP = ""
 	return pod
 }
 
 func calculateNextRetry(pod types.Pod) time.Time {
-	delay := min(maxDelay, baseDelay*time.Duration(1<<pod.RetryCount))
-	return time.Now().Add(delay)
+	return time.Now().Add(backoff(uint(pod.RetryCount)))
 }
 
+// backoff doubles from baseDelay with every attempt, up to maxDelay.
+func backoff(attempt uint) time.Duration {
+	return min(maxDelay, baseDelay*time.Duration(1<<min(attempt, 20)))
+}
+
+// updateHeartbeat patches what the kubelet reports about its node, each
+// field replaced as a whole, the rest of the node is left to others.
 func (k *Kubelet) updateHeartbeat() {
-	node, ok, err := k.client.GetNode(k.name)
+	patch, err := json.Marshal([]map[string]any{
+		{"op": "add", "path": "/time", "value": time.Now()},
+		{"op": "add", "path": "/capacity", "value": k.Capacity},
+		{"op": "add", "path": "/allocatable", "value": k.allocatable()},
+		{"op": "add", "path": "/address", "value": k.Address},
+		{"op": "add", "path": "/podCIDR", "value": k.PodCIDR},
+	})
 	if err != nil {
-		log.Printf("kubelet: failed to get node %s: %v", k.name, err)
+		log.Printf("kubelet: failed to encode heartbeat of node %s: %v", k.name, err)
 		return
 	}
-	if ok {
-		node.LastHeartbeat = time.Now()
-		if err := k.client.UpdateNode(k.name, node); err != nil {
-			log.Printf("kubelet: failed to update node %s: %v", k.name, err)
-		}
+	_, err = k.client.PatchNode(k.name, types.JSONPatchType, patch)
+	if err != nil && !errors.Is(err, client.ErrNotFound) {
+		log.Printf("kubelet: failed to update node %s: %v", k.name, err)
 	}
 }
@@ -1,21 +1,26 @@
 package kubelet
 
 import (
+	"context"
 	"errors"
+	"io"
 	"miniku/pkg/runtime"
 	"miniku/pkg/testutil"
 	"miniku/pkg/types"
 	"slices"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 type mockRuntime struct {
 	runFunc       func(types.PodSpec) (string, error)
-	stopFunc      func(string) error
+	stopFunc      func(string, time.Duration) error
 	removeFunc    func(string) error
 	getStatusFunc func(string) (*types.ContainerState, error)
 	listFunc      func() ([]runtime.ContainerInfo, error)
+	execFunc      func(string, runtime.ExecOptions) (int, error)
+	logsFunc      func(string, runtime.LogOptions, io.Writer) error
 }
 
 func (r *mockRuntime) Run(spec types.PodSpec) (string, error) {
@@ -25,9 +30,9 @@ func (r *mockRuntime) Run(spec types.PodSpec) (string, error) {
 	return "1", nil
 }
 
-func (r *mockRuntime) Stop(containerID string) error {
+func (r *mockRuntime) Stop(containerID string, gracePeriod time.Duration) error {
 	if r.stopFunc != nil {
-		return r.stopFunc(containerID)
+		return r.stopFunc(containerID, gracePeriod)
 	}
 	return nil
 }
@@ -53,6 +58,24 @@ func (r *mockRuntime) List() ([]runtime.ContainerInfo, error) {
 	return []runtime.ContainerInfo{}, nil
 }
 
+func (r *mockRuntime) Exec(_ context.Context, containerID string, opts runtime.ExecOptions) (int, error) {
+	if r.execFunc != nil {
+		return r.execFunc(containerID, opts)
+	}
+	return 0, nil
+}
+
+func (r *mockRuntime) Logs(_ context.Context, containerID string, opts runtime.LogOptions, w io.Writer) error {
+	if r.logsFunc != nil {
+		return r.logsFunc(containerID, opts, w)
+	}
+	return nil
+}
+
+func (r *mockRuntime) RemoveLogs(string, string) error {
+	return nil
+}
+
 func TestCreateAndRun(t *testing.T) {
 	tests := []struct {
 		name                string
@@ -165,6 +188,7 @@ func TestReconcilePod(t *testing.T) {
 		runFunc           func(types.PodSpec) (string, error)
 		expectedStatus    types.PodStatus
 		expectedContainer string
+		expectedIP        string
 		expectStoreUpdate bool
 	}{
 		{
@@ -190,9 +214,11 @@ func TestReconcilePod(t *testing.T) {
 			},
 			containerState: &types.ContainerState{
 				Status: types.ContainerStatusRunning,
+				IP:     "10.244.0.5",
 			},
 			expectedStatus:    types.PodStatusRunning,
 			expectedContainer: "existing-container",
+			expectedIP:        "10.244.0.5",
 			expectStoreUpdate: true,
 		},
 		{
@@ -210,10 +236,26 @@ func TestReconcilePod(t *testing.T) {
 			expectStoreUpdate: false,
 		},
 		{
-			name: "running + exited -> mark failed",
+			name: "running + running at another address -> update pod IP",
 			pod: types.Pod{
 				Spec:        types.PodSpec{Name: "test-pod", Image: "nginx"},
 				Status:      types.PodStatusRunning,
+				ContainerID: "running-container",
+			},
+			containerState: &types.ContainerState{
+				Status: types.ContainerStatusRunning,
+				IP:     "10.244.0.7",
+			},
+			expectedStatus:    types.PodStatusRunning,
+			expectedContainer: "running-container",
+			expectedIP:        "10.244.0.7",
+			expectStoreUpdate: true,
+		},
+		{
+			name: "running + exited + restart never -> mark failed",
+			pod: types.Pod{
+				Spec:        types.PodSpec{Name: "test-pod", Image: "nginx", RestartPolicy: types.RestartPolicyNever},
+				Status:      types.PodStatusRunning,
 				ContainerID: "exited-container",
 			},
 			containerState: &types.ContainerState{
@@ -224,12 +266,61 @@ func TestReconcilePod(t *testing.T) {
 			expectedContainer: "exited-container",
 			expectStoreUpdate: true,
 		},
+		{
+			name: "running + exited 0 + restart on failure -> mark succeeded",
+			pod: types.Pod{
+				Spec:        types.PodSpec{Name: "test-pod", Image: "nginx", RestartPolicy: types.RestartPolicyOnFailure},
+				Status:      types.PodStatusRunning,
+				ContainerID: "exited-container",
+			},
+			containerState: &types.ContainerState{
+				Status:   types.ContainerStatusExited,
+				ExitCode: 0,
+			},
+			expectedStatus:    types.PodStatusSucceeded,
+			expectedContainer: "exited-container",
+			expectStoreUpdate: true,
+		},
+		{
+			name: "running + exited + restart always -> back off and restart",
+			pod: types.Pod{
+				Spec:        types.PodSpec{Name: "test-pod", Image: "nginx"},
+				Status:      types.PodStatusRunning,
+				ContainerID: "exited-container",
+			},
+			containerState: &types.ContainerState{
+				Status:   types.ContainerStatusExited,
+				ExitCode: 0,
+			},
+			expectedStatus:    types.PodStatusPending,
+			expectedContainer: "", // cleared, restarted after the backoff
+			expectStoreUpdate: true,
+		},
+		{
+			name: "pending + leftover exited container -> start a new one",
+			pod: types.Pod{
+				Spec:        types.PodSpec{Name: "test-pod", Image: "nginx"},
+				Status:      types.PodStatusPending,
+				ContainerID: "exited-container",
+			},
+			containerState: &types.ContainerState{
+				Status:   types.ContainerStatusExited,
+				ExitCode: 1,
+			},
+			runFunc: func(spec types.PodSpec) (string, error) {
+				return "new-container-id", nil
+			},
+			expectedStatus:    types.PodStatusRunning,
+			expectedContainer: "new-container-id",
+			expectStoreUpdate: true,
+		},
 		{
 			name: "running + container missing -> reset to pending",
 			pod: types.Pod{
 				Spec:        types.PodSpec{Name: "test-pod", Image: "nginx"},
 				Status:      types.PodStatusRunning,
 				ContainerID: "vanished-container",
+				PodIP:       "10.244.0.5",
 			},
 			containerState:    nil,
 			expectedStatus:    types.PodStatusPending,
@@ -259,7 +350,7 @@ func TestReconcilePod(t *testing.T) {
 			env := testutil.NewTestEnv()
 			defer env.Close()
 
-			env.PodStore.Put(tt.pod.Spec.Name, tt.pod)
+			env.PodStore.Put(tt.pod.Key(), tt.pod)
 
 			k := New(env.Client, mockRT, "node-1")
 
@@ -267,7 +358,7 @@ func TestReconcilePod(t *testing.T) {
 				t.Fatalf("reconcilePod failed: %v", err)
 			}
 
-			storedPod, found := env.PodStore.Get(tt.pod.Spec.Name)
+			storedPod, found := env.PodStore.Get(tt.pod.Key())
 
 			if tt.expectStoreUpdate {
 				if !found {
@@ -279,6 +370,9 @@ func TestReconcilePod(t *testing.T) {
 				if storedPod.ContainerID != tt.expectedContainer {
 					t.Errorf("expected container ID %s, got %s", tt.expectedContainer, storedPod.ContainerID)
 				}
+				if storedPod.PodIP != tt.expectedIP {
+					t.Errorf("expected pod IP %q, got %q", tt.expectedIP, storedPod.PodIP)
+				}
 			} else {
 				// no-update cases
 				// just verify original pod is unchanged
@@ -290,6 +384,168 @@ func TestReconcilePod(t *testing.T) {
 	}
 }
 
+func TestCrashLoopBackOff(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	var removed []string
+	mockRT := &mockRuntime{
+		getStatusFunc: func(string) (*types.ContainerState, error) {
+			return &types.ContainerState{Status: types.ContainerStatusExited, ExitCode: 2}, nil
+		},
+		removeFunc: func(id string) error {
+			removed = append(removed, id)
+			return nil
+		},
+	}
+	k := New(env.Client, mockRT, "node-1")
+
+	pod := env.PodStore.Put("default/crashy", types.Pod{
+		Spec:         types.PodSpec{Name: "crashy", Image: "nginx", NodeName: "node-1"},
+		Status:       types.PodStatusRunning,
+		ContainerID:  "c1",
+		RestartCount: 2,
+		StartedAt:    time.Now().Add(-time.Second),
+		BackoffCount: 2,
+	})
+
+	before := time.Now()
+	if err := k.reconcilePod(pod); err != nil {
+		t.Fatalf("reconcilePod: %v", err)
+	}
+
+	got, _ := env.PodStore.Get("default/crashy")
+	if got.Reason != types.PodReasonCrashLoopBackOff {
+		t.Errorf("got reason %q, want %q", got.Reason, types.PodReasonCrashLoopBackOff)
+	}
+	if got.RestartCount != 3 || got.BackoffCount != 3 {
+		t.Errorf("got restart count %d and backoff count %d, want 3 and 3", got.RestartCount, got.BackoffCount)
+	}
+	if got.LastState == nil || got.LastState.ExitCode != 2 || got.LastState.Reason != types.ContainerReasonError || got.LastState.ContainerID != "c1" {
+		t.Errorf("got last state %+v, want c1 exited with code 2 and reason Error", got.LastState)
+	}
+	// third restart waits 1s << 2
+	if wait := got.NextRetryAt.Sub(before); wait < 4*time.Second || wait > 5*time.Second {
+		t.Errorf("got backoff %v, want about 4s", wait)
+	}
+	if !slices.Equal(removed, []string{"c1"}) {
+		t.Errorf("got removed %v, want the exited container c1", removed)
+	}
+
+	// the backoff holds the restart back
+	if err := k.reconcilePod(got); err != nil {
+		t.Fatalf("reconcilePod: %v", err)
+	}
+	if again, _ := env.PodStore.Get("default/crashy"); again.ResourceVersion != got.ResourceVersion {
+		t.Error("expected pod to be left alone during the backoff")
+	}
+
+	// a container that ran stably before exiting starts the backoff over
+	stable := env.PodStore.Put("default/stable", types.Pod{
+		Spec:         types.PodSpec{Name: "stable", Image: "nginx", NodeName: "node-1"},
+		Status:       types.PodStatusRunning,
+		ContainerID:  "c2",
+		RestartCount: 7,
+		StartedAt:    time.Now().Add(-stableRun),
+		BackoffCount: 7,
+	})
+	before = time.Now()
+	if err := k.reconcilePod(stable); err != nil {
+		t.Fatalf("reconcilePod: %v", err)
+	}
+	got, _ = env.PodStore.Get("default/stable")
+	if got.RestartCount != 8 || got.BackoffCount != 1 {
+		t.Errorf("got restart count %d and backoff count %d, want 8 and 1", got.RestartCount, got.BackoffCount)
+	}
+	if wait := got.NextRetryAt.Sub(before); wait < time.Second || wait > 2*time.Second {
+		t.Errorf("got backoff %v, want about 1s", wait)
+	}
+}
+
+func TestTerminatePod(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	stopped := make(chan time.Duration, 2)
+	release := make(chan struct{})
+	var removed atomic.Int32
+	mockRT := &mockRuntime{
+		listFunc: func() ([]runtime.ContainerInfo, error) {
+			return []runtime.ContainerInfo{
+				{ID: "c1", Name: "web", Namespace: "default"},
+				{ID: "c2", Name: "other", Namespace: "default"},
+			}, nil
+		},
+		stopFunc: func(id string, grace time.Duration) error {
+			if id != "c1" {
+				t.Errorf("stopped container %s of another pod", id)
+			}
+			stopped <- grace
+			<-release
+			return nil
+		},
+		removeFunc: func(string) error {
+			removed.Add(1)
+			return nil
+		},
+	}
+	k := New(env.Client, mockRT, "node-1")
+
+	now := time.Now()
+	grace := int64(5)
+	pod := env.PodStore.Put("default/web", types.Pod{
+		ObjectMeta:  types.ObjectMeta{DeletionTimestamp: &now, DeletionGracePeriodSeconds: &grace},
+		Spec:        types.PodSpec{Name: "web", Image: "nginx", Namespace: "default", NodeName: "node-1"},
+		Status:      types.PodStatusRunning,
+		ContainerID: "c1",
+	})
+
+	k.terminate(pod)
+	select {
+	case got := <-stopped:
+		if got <= 4*time.Second || got > 5*time.Second {
+			t.Errorf("got grace period %v, want what is left of 5s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the container to be stopped")
+	}
+	// the container is still stopping, a resync leaves it to the first call
+	k.terminate(pod)
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := env.PodStore.Get("default/web"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the kubelet to confirm the deletion")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(stopped) != 0 || removed.Load() != 1 {
+		t.Errorf("got %d more stops and %d removals, want the container stopped and removed once", len(stopped), removed.Load())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt uint
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{5, 32 * time.Second},
+		{6, maxDelay},
+		{200, maxDelay},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
 func TestSync(t *testing.T) {
 	tests := []struct {
 		name               string
@@ -302,14 +558,14 @@ func TestSync(t *testing.T) {
 		{
 			name: "link container to pod",
 			existingPods: []types.Pod{
-				{Spec: types.PodSpec{Name: "nginx-1", NodeName: "node-1"}, Status: types.PodStatusPending},
+				{Spec: types.PodSpec{Name: "nginx-1", Image: "nginx", NodeName: "node-1"}, Status: types.PodStatusPending},
 			},
 			existingContainers: []runtime.ContainerInfo{
 				{ID: "abc123", Name: "nginx-1"},
 			},
 			expectedPodState: map[string]types.Pod{
 				"nginx-1": {
-					Spec:        types.PodSpec{Name: "nginx-1", NodeName: "node-1"},
+					Spec:        types.PodSpec{Name: "nginx-1", Image: "nginx", NodeName: "node-1"},
 					Status:      types.PodStatusRunning,
 					ContainerID: "abc123",
 				},
@@ -320,14 +576,14 @@ func TestSync(t *testing.T) {
 		{
 			name: "container already linked",
 			existingPods: []types.Pod{
-				{Spec: types.PodSpec{Name: "nginx-1", NodeName: "node-1"}, Status: types.PodStatusRunning, ContainerID: "abc123"},
+				{Spec: types.PodSpec{Name: "nginx-1", Image: "nginx", NodeName: "node-1"}, Status: types.PodStatusRunning, ContainerID: "abc123"},
 			},
 			existingContainers: []runtime.ContainerInfo{
 				{ID: "abc123", Name: "nginx-1"},
 			},
 			expectedPodState: map[string]types.Pod{
 				"nginx-1": {
-					Spec:        types.PodSpec{Name: "nginx-1", NodeName: "node-1"},
+					Spec:        types.PodSpec{Name: "nginx-1", Image: "nginx", NodeName: "node-1"},
 					Status:      types.PodStatusRunning,
 					ContainerID: "abc123",
 				},
@@ -348,7 +604,7 @@ func TestSync(t *testing.T) {
 		{
 			name: "mixed - link one, remove orphan",
 			existingPods: []types.Pod{
-				{Spec: types.PodSpec{Name: "nginx-1", NodeName: "node-1"}, Status: types.PodStatusPending},
+				{Spec: types.PodSpec{Name: "nginx-1", Image: "nginx", NodeName: "node-1"}, Status: types.PodStatusPending},
 			},
 			existingContainers: []runtime.ContainerInfo{
 				{ID: "abc123", Name: "nginx-1"},
@@ -356,7 +612,7 @@ func TestSync(t *testing.T) {
 			},
 			expectedPodState: map[string]types.Pod{
 				"nginx-1": {
-					Spec:        types.PodSpec{Name: "nginx-1", NodeName: "node-1"},
+					Spec:        types.PodSpec{Name: "nginx-1", Image: "nginx", NodeName: "node-1"},
 					Status:      types.PodStatusRunning,
 					ContainerID: "abc123",
 				},
@@ -367,12 +623,12 @@ func TestSync(t *testing.T) {
 		{
 			name: "no containers",
 			existingPods: []types.Pod{
-				{Spec: types.PodSpec{Name: "nginx-1", NodeName: "node-1"}, Status: types.PodStatusPending},
+				{Spec: types.PodSpec{Name: "nginx-1", Image: "nginx", NodeName: "node-1"}, Status: types.PodStatusPending},
 			},
 			existingContainers: []runtime.ContainerInfo{},
 			expectedPodState: map[string]types.Pod{
 				"nginx-1": {
-					Spec:   types.PodSpec{Name: "nginx-1", NodeName: "node-1"},
+					Spec:   types.PodSpec{Name: "nginx-1", Image: "nginx", NodeName: "node-1"},
 					Status: types.PodStatusPending,
 				},
 			},
@@ -382,14 +638,14 @@ func TestSync(t *testing.T) {
 		{
 			name: "update stale container ID",
 			existingPods: []types.Pod{
-				{Spec: types.PodSpec{Name: "nginx-1", NodeName: "node-1"}, Status: types.PodStatusRunning, ContainerID: "old-id"},
+				{Spec: types.PodSpec{Name: "nginx-1", Image: "nginx", NodeName: "node-1"}, Status: types.PodStatusRunning, ContainerID: "old-id"},
 			},
 			existingContainers: []runtime.ContainerInfo{
 				{ID: "new-id", Name: "nginx-1"},
 			},
 			expectedPodState: map[string]types.Pod{
 				"nginx-1": {
-					Spec:        types.PodSpec{Name: "nginx-1", NodeName: "node-1"},
+					Spec:        types.PodSpec{Name: "nginx-1", Image: "nginx", NodeName: "node-1"},
 					Status:      types.PodStatusRunning,
 					ContainerID: "new-id",
 				},
@@ -400,14 +656,14 @@ func TestSync(t *testing.T) {
 		{
 			name: "skip pods assigned to other nodes",
 			existingPods: []types.Pod{
-				{Spec: types.PodSpec{Name: "nginx-1", NodeName: "node-2"}, Status: types.PodStatusPending},
+				{Spec: types.PodSpec{Name: "nginx-1", Image: "nginx", NodeName: "node-2"}, Status: types.PodStatusPending},
 			},
 			existingContainers: []runtime.ContainerInfo{
 				{ID: "abc123", Name: "nginx-1"},
 			},
 			expectedPodState: map[string]types.Pod{
 				"nginx-1": {
-					Spec:   types.PodSpec{Name: "nginx-1", NodeName: "node-2"},
+					Spec:   types.PodSpec{Name: "nginx-1", Image: "nginx", NodeName: "node-2"},
 					Status: types.PodStatusPending, // unchanged - not our pod
 				},
 			},
@@ -425,7 +681,7 @@ func TestSync(t *testing.T) {
 				listFunc: func() ([]runtime.ContainerInfo, error) {
 					return tt.existingContainers, nil
 				},
-				stopFunc: func(id string) error {
+				stopFunc: func(id string, _ time.Duration) error {
 					stoppedContainers = append(stoppedContainers, id)
 					return nil
 				},
@@ -439,14 +695,14 @@ func TestSync(t *testing.T) {
 			defer env.Close()
 
 			for _, pod := range tt.existingPods {
-				env.PodStore.Put(pod.Spec.Name, pod)
+				env.PodStore.Put(pod.Key(), pod)
 			}
 
 			k := New(env.Client, mockRT, "node-1")
 			k.Sync()
 
 			for name, expectedPod := range tt.expectedPodState {
-				actualPod, found := env.PodStore.Get(name)
+				actualPod, found := env.PodStore.Get(types.Key("", name))
 				if !found {
 					t.Errorf("expected pod %s to exist", name)
 					continue
@@ -482,6 +738,27 @@ func TestSync(t *testing.T) {
 	}
 }
 
+func TestRegister(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	k := New(env.Client, &mockRuntime{}, "node-1")
+	k.Capacity = types.ResourceList{CPU: 4000, Memory: 8 << 30, Pods: 20}
+	k.Reserved = types.ResourceList{CPU: 500, Memory: 1 << 30}
+	if err := k.Register(); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	node, found := env.NodeStore.Get("node-1")
+	if !found || node.Status != types.NodeStateReady {
+		t.Fatalf("got node %+v, want node-1 Ready", node)
+	}
+	want := types.ResourceList{CPU: 3500, Memory: 7 << 30, Pods: 20}
+	if node.Capacity != k.Capacity || node.Allocatable != want {
+		t.Errorf("got capacity %+v allocatable %+v, want %+v and %+v", node.Capacity, node.Allocatable, k.Capacity, want)
+	}
+}
+
 func TestUpdateHeartbeat(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -526,6 +803,9 @@ func TestUpdateHeartbeat(t *testing.T) {
 				if !node.LastHeartbeat.After(beforeCall) {
 					t.Errorf("expected LastHeartbeat to be updated, got %v", node.LastHeartbeat)
 				}
+				if node.Allocatable != k.Capacity {
+					t.Errorf("expected allocatable %+v to be reported, got %+v", k.Capacity, node.Allocatable)
+				}
 			}
 		})
 	}
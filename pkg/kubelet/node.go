@@ -0,0 +1,53 @@
+package kubelet
+
+import (
+	"log"
+	goruntime "runtime"
+
+	"miniku/pkg/types"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultMaxPods is how many pods a node takes unless told otherwise.
+const DefaultMaxPods = 110
+
+// Register creates or replaces this kubelet's node, Ready and with its
+// capacity.
+func (k *Kubelet) Register() error {
+	return k.client.CreateNode(types.Node{
+		Name:        k.name,
+		Status:      types.NodeStateReady,
+		Capacity:    k.Capacity,
+		Allocatable: k.allocatable(),
+		Address:     k.Address,
+		PodCIDR:     k.PodCIDR,
+		Labels:      k.Labels,
+	})
+}
+
+// allocatable is the capacity minus what is reserved for the system.
+func (k *Kubelet) allocatable() types.ResourceList {
+	return types.ResourceList{
+		CPU:    max(0, k.Capacity.CPU-k.Reserved.CPU),
+		Memory: max(0, k.Capacity.Memory-k.Reserved.Memory),
+		PIDs:   max(0, k.Capacity.PIDs-k.Reserved.PIDs),
+		Pods:   k.Capacity.Pods,
+	}
+}
+
+// hostCapacity reads what the machine has.
+func hostCapacity() types.ResourceList {
+	capacity := types.ResourceList{
+		CPU:  int64(goruntime.NumCPU()) * 1000,
+		Pods: DefaultMaxPods,
+	}
+
+	var info unix.Sysinfo_t
+	if err := unix.Sysinfo(&info); err != nil {
+		log.Printf("kubelet: failed to read memory capacity: %v", err)
+	} else {
+		capacity.Memory = int64(info.Totalram) * int64(info.Unit)
+	}
+	return capacity
+}
@@ -0,0 +1,291 @@
+package kubelet
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"miniku/pkg/runtime"
+	"miniku/pkg/types"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type probeKind string
+
+const (
+	liveness  probeKind = "liveness"
+	readiness probeKind = "readiness"
+	startup   probeKind = "startup"
+)
+
+// prober runs the probes of the pods on this node, one goroutine per probe
+// and container. Results are kept here and read back when the pod is
+// reconciled; changes re-queue the pod through onChange.
+type prober struct {
+	runtime runtime.Runtime
+	// onChange is called when a probe result flips
+	onChange func(podKey string)
+	// onFailure is called when a liveness or startup probe gives up on a
+	// container
+	onFailure func(podKey, containerID string, kind probeKind)
+
+	mu      sync.Mutex
+	workers map[string]map[probeKind]*probeWorker
+}
+
+type probeWorker struct {
+	prober      *prober
+	kind        probeKind
+	probe       *types.Probe
+	pod         types.Pod
+	containerID string
+	stop        chan struct{}
+
+	// guarded by prober.mu
+	result    bool
+	successes int
+	failures  int
+}
+
+func newProber(rt runtime.Runtime) *prober {
+	return &prober{
+		runtime:   rt,
+		onChange:  func(string) {},
+		onFailure: func(string, string, probeKind) {},
+		workers:   make(map[string]map[probeKind]*probeWorker),
+	}
+}
+
+// sync starts the probes of a running pod's container and stops those of a
+// container that is gone or moved to another address.
+func (p *prober) sync(pod types.Pod) {
+	if pod.Status != types.PodStatusRunning || pod.ContainerID == "" {
+		p.remove(pod.Key())
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	workers := p.workers[pod.Key()]
+	for _, w := range workers {
+		if w.containerID != pod.ContainerID || w.pod.PodIP != pod.PodIP {
+			close(w.stop)
+			delete(workers, w.kind)
+		}
+	}
+	for _, kind := range []probeKind{liveness, readiness, startup} {
+		probe := probeFor(pod.Spec, kind)
+		if probe == nil {
+			continue
+		}
+		if _, ok := workers[kind]; ok {
+			continue
+		}
+		if workers == nil {
+			workers = make(map[probeKind]*probeWorker)
+			p.workers[pod.Key()] = workers
+		}
+		w := &probeWorker{
+			prober:      p,
+			kind:        kind,
+			probe:       probe,
+			pod:         pod,
+			containerID: pod.ContainerID,
+			stop:        make(chan struct{}),
+			// a container is alive until proven otherwise
+			result: kind == liveness,
+		}
+		workers[kind] = w
+		go w.run()
+	}
+}
+
+// remove stops the probes of a pod.
+func (p *prober) remove(podKey string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, w := range p.workers[podKey] {
+		close(w.stop)
+	}
+	delete(p.workers, podKey)
+}
+
+// ready reports whether the pod's container passed its startup and
+// readiness probes.
+func (p *prober) ready(pod types.Pod) bool {
+	return p.passed(pod, startup) && p.passed(pod, readiness)
+}
+
+// passed reports whether the pod's container passed a probe. Probes the pod
+// doesn't define always pass, ones that haven't started running don't.
+func (p *prober) passed(pod types.Pod, kind probeKind) bool {
+	if probeFor(pod.Spec, kind) == nil {
+		return true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w, ok := p.workers[pod.Key()][kind]
+	return ok && w.containerID == pod.ContainerID && w.result
+}
+
+func probeFor(spec types.PodSpec, kind probeKind) *types.Probe {
+	switch kind {
+	case liveness:
+		return spec.LivenessProbe
+	case readiness:
+		return spec.ReadinessProbe
+	case startup:
+		return spec.StartupProbe
+	}
+	return nil
+}
+
+func (w *probeWorker) run() {
+	if !sleep(w.stop, w.probe.InitialDelay()) {
+		return
+	}
+
+	ticker := time.NewTicker(w.probe.Period())
+	defer ticker.Stop()
+
+	for {
+		if !w.doProbe() {
+			return
+		}
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// doProbe runs the probe once and records the result. It returns false once
+// the worker has nothing left to do.
+func (w *probeWorker) doProbe() bool {
+	p := w.prober
+	podKey := w.pod.Key()
+
+	// liveness and readiness wait for the startup probe to pass, which
+	// then has done its job
+	if started := p.passed(w.pod, startup); started == (w.kind == startup) {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.probe.Timeout())
+	err := runProbe(ctx, p.runtime, w.pod, w.containerID, w.probe)
+	cancel()
+
+	p.mu.Lock()
+	previous := w.result
+	if err == nil {
+		w.failures = 0
+		w.successes++
+		if w.successes >= w.probe.Successes() {
+			w.result = true
+		}
+	} else {
+		w.successes = 0
+		w.failures++
+		if w.failures >= w.probe.Failures() {
+			w.result = false
+		}
+	}
+	result := w.result
+	p.mu.Unlock()
+
+	if result == previous {
+		return true
+	}
+	if !result {
+		log.Printf("kubelet: %s probe of pod %s failed: %v", w.kind, podKey, err)
+	}
+	// the liveness result flipping back is of no interest to the pod
+	if w.kind != liveness {
+		p.onChange(podKey)
+	}
+	if !result && w.kind != readiness {
+		p.onFailure(podKey, w.containerID, w.kind)
+		return false
+	}
+	return true
+}
+
+// runProbe performs a single check, nil means healthy.
+func runProbe(ctx context.Context, rt runtime.Runtime, pod types.Pod, containerID string, probe *types.Probe) error {
+	switch {
+	case probe.Exec != nil:
+		code, err := rt.Exec(ctx, containerID, runtime.ExecOptions{Command: probe.Exec.Command})
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return fmt.Errorf("command exited with code %d", code)
+		}
+		return nil
+
+	case probe.HTTPGet != nil:
+		addr := net.JoinHostPort(probeHost(pod, probe.HTTPGet.Host), strconv.Itoa(probe.HTTPGet.Port))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+probe.HTTPGet.Path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("HTTP probe returned %d", resp.StatusCode)
+		}
+		return nil
+
+	case probe.TCPSocket != nil:
+		addr := net.JoinHostPort(probeHost(pod, probe.TCPSocket.Host), strconv.Itoa(probe.TCPSocket.Port))
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+
+	default:
+		return fmt.Errorf("probe has no action")
+	}
+}
+
+// probeHost is where HTTP and TCP probes connect to: the pod's IP unless the
+// probe says otherwise. A pod without one shares the node's network, so that
+// is the loopback address.
+func probeHost(pod types.Pod, host string) string {
+	if host != "" {
+		return host
+	}
+	if pod.PodIP != "" {
+		return pod.PodIP
+	}
+	return "127.0.0.1"
+}
+
+// sleep waits for d unless stop is closed first, in which case it returns
+// false.
+func sleep(stop <-chan struct{}, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,261 @@
+package kubelet
+
+import (
+	"context"
+	"miniku/pkg/runtime"
+	"miniku/pkg/testutil"
+	"miniku/pkg/types"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRunProbe(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer healthy.Close()
+	u, _ := url.Parse(healthy.URL)
+	httpPort, _ := strconv.Atoi(u.Port())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	tcpPort := listener.Addr().(*net.TCPAddr).Port
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		probe   types.Probe
+		wantErr bool
+	}{
+		{
+			name:  "exec exits 0",
+			probe: types.Probe{Exec: &types.ExecAction{Command: []string{"true"}}},
+		},
+		{
+			name:    "exec exits 1",
+			probe:   types.Probe{Exec: &types.ExecAction{Command: []string{"false"}}},
+			wantErr: true,
+		},
+		{
+			name:  "http 200",
+			probe: types.Probe{HTTPGet: &types.HTTPGetAction{Path: "/healthz", Port: httpPort}},
+		},
+		{
+			name:    "http 500",
+			probe:   types.Probe{HTTPGet: &types.HTTPGetAction{Path: "/broken", Port: httpPort}},
+			wantErr: true,
+		},
+		{
+			name:  "tcp open",
+			probe: types.Probe{TCPSocket: &types.TCPSocketAction{Port: tcpPort}},
+		},
+		{
+			name:    "tcp closed",
+			probe:   types.Probe{TCPSocket: &types.TCPSocketAction{Port: closedPort}},
+			wantErr: true,
+		},
+		{
+			name:    "no action",
+			probe:   types.Probe{},
+			wantErr: true,
+		},
+	}
+
+	rt := &mockRuntime{
+		execFunc: func(_ string, opts runtime.ExecOptions) (int, error) {
+			if opts.Command[0] == "false" {
+				return 1, nil
+			}
+			return 0, nil
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			err := runProbe(ctx, rt, types.Pod{}, "c1", &tt.probe)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runProbe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProbeHost(t *testing.T) {
+	tests := []struct {
+		name string
+		pod  types.Pod
+		host string
+		want string
+	}{
+		{name: "node network", want: "127.0.0.1"},
+		{name: "pod IP", pod: types.Pod{PodIP: "10.244.0.5"}, want: "10.244.0.5"},
+		{name: "probe host wins", pod: types.Pod{PodIP: "10.244.0.5"}, host: "10.0.0.1", want: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := probeHost(tt.pod, tt.host); got != tt.want {
+				t.Errorf("probeHost() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoProbe(t *testing.T) {
+	var exitCode int
+	rt := &mockRuntime{
+		execFunc: func(string, runtime.ExecOptions) (int, error) { return exitCode, nil },
+	}
+	p := newProber(rt)
+	var changed []string
+	var failed []probeKind
+	p.onChange = func(podKey string) { changed = append(changed, podKey) }
+	p.onFailure = func(_, _ string, kind probeKind) { failed = append(failed, kind) }
+
+	probe := &types.Probe{Exec: &types.ExecAction{Command: []string{"check"}}, FailureThreshold: 2}
+	pod := types.Pod{
+		Spec:        types.PodSpec{Name: "web", Image: "nginx", ReadinessProbe: probe, LivenessProbe: probe},
+		Status:      types.PodStatusRunning,
+		ContainerID: "c1",
+	}
+	readiness := &probeWorker{prober: p, kind: readiness, probe: probe, pod: pod, containerID: "c1"}
+	liveness := &probeWorker{prober: p, kind: liveness, probe: probe, pod: pod, containerID: "c1", result: true}
+	p.workers["default/web"] = map[probeKind]*probeWorker{readiness.kind: readiness, liveness.kind: liveness}
+
+	if p.ready(pod) {
+		t.Fatal("expected pod not to be ready before its readiness probe ran")
+	}
+
+	readiness.doProbe()
+	if !p.ready(pod) || !slices.Equal(changed, []string{"default/web"}) {
+		t.Fatalf("expected a passing probe to make the pod ready, changed %v", changed)
+	}
+
+	// one failure is below the threshold
+	exitCode = 1
+	readiness.doProbe()
+	liveness.doProbe()
+	if !p.ready(pod) || len(failed) != 0 {
+		t.Fatalf("expected a single failure to be tolerated, failed %v", failed)
+	}
+
+	readiness.doProbe()
+	if liveness.doProbe() {
+		t.Error("expected the liveness worker to stop once it gave up")
+	}
+	if p.ready(pod) {
+		t.Error("expected the pod to be unready after two failures")
+	}
+	if !slices.Equal(failed, []probeKind{liveness.kind}) {
+		t.Errorf("got failures %v, want only the liveness probe to kill the container", failed)
+	}
+}
+
+func TestStartupProbeGates(t *testing.T) {
+	var calls []string
+	rt := &mockRuntime{
+		execFunc: func(_ string, opts runtime.ExecOptions) (int, error) {
+			calls = append(calls, opts.Command[0])
+			return 0, nil
+		},
+	}
+	p := newProber(rt)
+
+	start := &types.Probe{Exec: &types.ExecAction{Command: []string{"startup"}}}
+	ready := &types.Probe{Exec: &types.ExecAction{Command: []string{"readiness"}}}
+	pod := types.Pod{
+		Spec:        types.PodSpec{Name: "web", Image: "nginx", StartupProbe: start, ReadinessProbe: ready},
+		Status:      types.PodStatusRunning,
+		ContainerID: "c1",
+	}
+	s := &probeWorker{prober: p, kind: startup, probe: start, pod: pod, containerID: "c1"}
+	r := &probeWorker{prober: p, kind: readiness, probe: ready, pod: pod, containerID: "c1"}
+	p.workers["default/web"] = map[probeKind]*probeWorker{startup: s, readiness: r}
+
+	r.doProbe()
+	s.doProbe()
+	s.doProbe()
+	r.doProbe()
+
+	want := []string{"startup", "readiness"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("got probes %v, want %v", calls, want)
+	}
+	if !p.ready(pod) {
+		t.Error("expected pod to be ready once both probes passed")
+	}
+}
+
+func TestReadyCondition(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      types.PodSpec
+		wantReady types.ConditionStatus
+	}{
+		{
+			name:      "no probes",
+			spec:      types.PodSpec{Name: "web", Image: "nginx"},
+			wantReady: types.ConditionTrue,
+		},
+		{
+			name: "readiness probe not run yet",
+			spec: types.PodSpec{
+				Name:           "web",
+				Image:          "nginx",
+				ReadinessProbe: &types.Probe{TCPSocket: &types.TCPSocketAction{Port: 80}, InitialDelaySeconds: 3600},
+			},
+			wantReady: types.ConditionFalse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := testutil.NewTestEnv()
+			defer env.Close()
+
+			mockRT := &mockRuntime{
+				getStatusFunc: func(string) (*types.ContainerState, error) {
+					return &types.ContainerState{Status: types.ContainerStatusRunning}, nil
+				},
+			}
+			k := New(env.Client, mockRT, "node-1")
+			defer k.prober.remove("web")
+
+			pod := env.PodStore.Put("default/web", types.Pod{Spec: tt.spec, Status: types.PodStatusRunning, ContainerID: "c1"})
+			k.prober.sync(pod)
+			if err := k.reconcilePod(pod); err != nil {
+				t.Fatalf("reconcilePod: %v", err)
+			}
+
+			got, _ := env.PodStore.Get("default/web")
+			c, ok := got.Condition(types.PodReady)
+			if !ok || c.Status != tt.wantReady {
+				t.Errorf("got Ready condition %+v, want %s", c, tt.wantReady)
+			}
+
+			// converged, nothing more to write
+			if err := k.reconcilePod(got); err != nil {
+				t.Fatalf("reconcilePod: %v", err)
+			}
+			if again, _ := env.PodStore.Get("default/web"); again.ResourceVersion != got.ResourceVersion {
+				t.Error("expected no update once the condition is set")
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package kubelet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"miniku/pkg/auth"
+	"miniku/pkg/remotecommand"
+	"miniku/pkg/runtime"
+	"miniku/pkg/types"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Handler serves what the API server proxies to the kubelet of a pod's node:
+//
+//	GET /namespaces/{namespace}/pods/{name}/log[?follow=true&tail=N&previous=true&timestamps=true]
+//	POST /namespaces/{namespace}/pods/{name}/exec?command=...[&stdin=true&tty=true]
+//
+// and the same without the namespace for pods in the default namespace.
+// Exec upgrades the connection to the remotecommand protocol. Over TLS only
+// the API server may ask, see authorizeAPIServer.
+func (k *Kubelet) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pods/{name}/log", k.handleLogs)
+	mux.HandleFunc("POST /pods/{name}/exec", k.handleExec)
+	mux.HandleFunc("GET /namespaces/{namespace}/pods/{name}/log", k.handleLogs)
+	mux.HandleFunc("POST /namespaces/{namespace}/pods/{name}/exec", k.handleExec)
+	return authorizeAPIServer(mux)
+}
+
+// authorizeAPIServer lets through requests over TLS only with the client
+// certificate of the API server, user auth.UserAPIServer or a member of
+// auth.GroupMasters. Other nodes have certificates from the same CA but no
+// business with this node's pods. Plain HTTP has no one to check.
+func authorizeAPIServer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			user, ok, err := auth.ClientCert{}.AuthenticateRequest(r)
+			if err != nil || !ok {
+				http.Error(w, "client certificate required", http.StatusUnauthorized)
+				return
+			}
+			if user.Name != auth.UserAPIServer && !slices.Contains(user.Groups, auth.GroupMasters) {
+				http.Error(w, "user "+user.Name+" may not use the kubelet API", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (k *Kubelet) handleLogs(w http.ResponseWriter, r *http.Request) {
+	name := types.Key(r.PathValue("namespace"), r.PathValue("name"))
+	pod, ok := k.podInformer.Get(name)
+	if !ok || pod.Spec.NodeName != k.name {
+		http.Error(w, "pod not found on this node", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := runtime.LogOptions{
+		Follow:     query.Get("follow") == "true",
+		Timestamps: query.Get("timestamps") == "true",
+	}
+	if tail := query.Get("tail"); tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid tail", http.StatusBadRequest)
+			return
+		}
+		opts.TailLines = n
+	}
+
+	containerID := pod.ContainerID
+	if query.Get("previous") == "true" {
+		containerID = ""
+		if pod.LastState != nil {
+			containerID = pod.LastState.ContainerID
+		}
+		// following a container that is gone makes no sense
+		opts.Follow = false
+	}
+	if containerID == "" {
+		http.Error(w, "container not found", http.StatusNotFound)
+		return
+	}
+
+	lw := &logResponseWriter{w: w, rc: http.NewResponseController(w)}
+	err := k.runtime.Logs(r.Context(), containerID, opts, lw)
+	switch {
+	case err == nil:
+		if !lw.started {
+			lw.start()
+		}
+	case lw.started:
+		// the status line is gone, all we can do is stop
+		log.Printf("kubelet: logs of pod %s: %v", name, err)
+	case errors.Is(err, runtime.ErrNoLogs):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (k *Kubelet) handleExec(w http.ResponseWriter, r *http.Request) {
+	name := types.Key(r.PathValue("namespace"), r.PathValue("name"))
+	pod, ok := k.podInformer.Get(name)
+	if !ok || pod.Spec.NodeName != k.name {
+		http.Error(w, "pod not found on this node", http.StatusNotFound)
+		return
+	}
+	if pod.ContainerID == "" || pod.Status != types.PodStatusRunning {
+		http.Error(w, "container is not running", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	command := query["command"]
+	if len(command) == 0 {
+		http.Error(w, "no command given", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := remotecommand.Upgrade(w, r)
+	if err != nil {
+		log.Printf("kubelet: exec in pod %s: %v", name, err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	opts := runtime.ExecOptions{
+		Command: command,
+		TTY:     query.Get("tty") == "true",
+		Stdout:  conn.Writer(remotecommand.StreamStdout),
+		Stderr:  conn.Writer(remotecommand.StreamStderr),
+	}
+	stdinR, stdinW := io.Pipe()
+	defer func() { _ = stdinR.Close() }()
+	if query.Get("stdin") == "true" {
+		opts.Stdin = stdinR
+	}
+	resize := make(chan runtime.TerminalSize, 1)
+	opts.Resize = resize
+
+	// the request's context doesn't end with a hijacked connection, the
+	// client going away does
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer cancel()
+		readExecInput(conn, stdinW, resize)
+	}()
+
+	code, err := k.runtime.Exec(ctx, pod.ContainerID, opts)
+	status := remotecommand.Status{ExitCode: code}
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		status.Error = err.Error()
+	}
+	if err := conn.WriteJSON(remotecommand.StreamStatus, status); err != nil {
+		log.Printf("kubelet: exec in pod %s: send status: %v", name, err)
+	}
+}
+
+// readExecInput feeds what the client sends to the command until the
+// connection closes.
+func readExecInput(conn *remotecommand.Conn, stdin *io.PipeWriter, resize chan runtime.TerminalSize) {
+	defer func() { _ = stdin.Close() }()
+	for {
+		stream, p, err := conn.ReadFrame()
+		if err != nil {
+			return
+		}
+		switch stream {
+		case remotecommand.StreamStdin:
+			if len(p) == 0 {
+				_ = stdin.Close()
+				continue
+			}
+			// once the command is done with stdin the rest is dropped
+			_, _ = stdin.Write(p)
+		case remotecommand.StreamResize:
+			var size runtime.TerminalSize
+			if json.Unmarshal(p, &size) != nil {
+				continue
+			}
+			// only the latest size matters
+			select {
+			case <-resize:
+			default:
+			}
+			resize <- size
+		}
+	}
+}
+
+// logResponseWriter sends the headers on the first write, so errors that
+// happen before can still change the status, and flushes every write to the
+// client so followed logs show up as they are written.
+type logResponseWriter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	started bool
+}
+
+func (lw *logResponseWriter) start() {
+	lw.started = true
+	lw.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	lw.w.WriteHeader(http.StatusOK)
+}
+
+func (lw *logResponseWriter) Write(p []byte) (int, error) {
+	if !lw.started {
+		lw.start()
+	}
+	n, err := lw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, lw.rc.Flush()
+}
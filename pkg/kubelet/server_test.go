@@ -0,0 +1,271 @@
+package kubelet
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"miniku/pkg/auth"
+	"miniku/pkg/client"
+	"miniku/pkg/pki"
+	"miniku/pkg/remotecommand"
+	"miniku/pkg/runtime"
+	"miniku/pkg/testutil"
+	"miniku/pkg/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPodLogs(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	logs := map[string]string{
+		"c1": "one\ntwo\nthree\n",
+		"c0": "crashed\n",
+	}
+	mockRT := &mockRuntime{
+		logsFunc: func(id string, opts runtime.LogOptions, w io.Writer) error {
+			out, ok := logs[id]
+			if !ok {
+				return fmt.Errorf("container %s: %w", id, runtime.ErrNoLogs)
+			}
+			if opts.TailLines > 0 {
+				lines := strings.SplitAfter(strings.TrimSuffix(out, "\n"), "\n")
+				out = strings.Join(lines[len(lines)-opts.TailLines:], "") + "\n"
+			}
+			_, err := io.WriteString(w, out)
+			return err
+		},
+	}
+
+	k := New(env.Client, mockRT, "node-1")
+	kubeletServer := httptest.NewServer(k.Handler())
+	defer kubeletServer.Close()
+	k.Address = strings.TrimPrefix(kubeletServer.URL, "http://")
+	if err := k.Register(); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	env.PodStore.Put("default/web", types.Pod{
+		Spec:        types.PodSpec{Name: "web", Image: "nginx", NodeName: "node-1"},
+		Status:      types.PodStatusRunning,
+		ContainerID: "c1",
+		LastState:   &types.ContainerState{Status: types.ContainerStatusExited, ContainerID: "c0"},
+	})
+	env.PodStore.Put("default/fresh", types.Pod{
+		Spec:        types.PodSpec{Name: "fresh", Image: "nginx", NodeName: "node-1"},
+		Status:      types.PodStatusRunning,
+		ContainerID: "c2",
+	})
+	env.PodStore.Put("default/pending", types.Pod{Spec: types.PodSpec{Name: "pending", Image: "nginx"}, Status: types.PodStatusPending})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	k.startInformers(stop)
+
+	tests := []struct {
+		name    string
+		pod     string
+		opts    client.LogOptions
+		want    string
+		wantErr string
+	}{
+		{name: "current container", pod: "web", want: "one\ntwo\nthree\n"},
+		{name: "tail", pod: "web", opts: client.LogOptions{TailLines: 1}, want: "three\n"},
+		{name: "previous container", pod: "web", opts: client.LogOptions{Previous: true}, want: "crashed\n"},
+		{name: "no previous container", pod: "fresh", opts: client.LogOptions{Previous: true}, wantErr: "status 404"},
+		{name: "no logs", pod: "fresh", wantErr: "status 404"},
+		{name: "not scheduled", pod: "pending", wantErr: "status 400"},
+		{name: "unknown pod", pod: "missing", wantErr: "status 404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := env.Client.PodLogs("default", tt.pod, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PodLogs: %v", err)
+			}
+			defer body.Close()
+
+			got, _ := io.ReadAll(body)
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodExec(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	mockRT := &mockRuntime{
+		execFunc: func(id string, opts runtime.ExecOptions) (int, error) {
+			switch opts.Command[0] {
+			case "cat":
+				if opts.Stdin == nil {
+					return -1, errors.New("no stdin")
+				}
+				_, err := io.Copy(opts.Stdout, opts.Stdin)
+				return 0, err
+			case "fail":
+				fmt.Fprint(opts.Stderr, "oops")
+				return 3, nil
+			case "size":
+				size := <-opts.Resize
+				fmt.Fprintf(opts.Stdout, "tty=%v %dx%d", opts.TTY, size.Width, size.Height)
+				return 0, nil
+			}
+			return -1, fmt.Errorf("%s: not found", opts.Command[0])
+		},
+	}
+
+	k := New(env.Client, mockRT, "node-1")
+	kubeletServer := httptest.NewServer(k.Handler())
+	defer kubeletServer.Close()
+	k.Address = strings.TrimPrefix(kubeletServer.URL, "http://")
+	if err := k.Register(); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	env.PodStore.Put("default/web", types.Pod{
+		Spec:        types.PodSpec{Name: "web", Image: "nginx", NodeName: "node-1"},
+		Status:      types.PodStatusRunning,
+		ContainerID: "c1",
+	})
+	env.PodStore.Put("default/done", types.Pod{
+		Spec:   types.PodSpec{Name: "done", Image: "nginx", NodeName: "node-1"},
+		Status: types.PodStatusSucceeded,
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	k.startInformers(stop)
+
+	resize := func(width, height uint16) <-chan remotecommand.TerminalSize {
+		ch := make(chan remotecommand.TerminalSize, 1)
+		ch <- remotecommand.TerminalSize{Width: width, Height: height}
+		return ch
+	}
+
+	tests := []struct {
+		name       string
+		pod        string
+		opts       client.ExecOptions
+		wantCode   int
+		wantStdout string
+		wantStderr string
+		wantErr    string
+	}{
+		{
+			name:       "stdin is streamed",
+			pod:        "web",
+			opts:       client.ExecOptions{Command: []string{"cat"}, Stdin: strings.NewReader("hello\n")},
+			wantStdout: "hello\n",
+		},
+		{
+			name:       "exit code and stderr",
+			pod:        "web",
+			opts:       client.ExecOptions{Command: []string{"fail"}},
+			wantCode:   3,
+			wantStderr: "oops",
+		},
+		{
+			name:       "tty and resize",
+			pod:        "web",
+			opts:       client.ExecOptions{Command: []string{"size"}, TTY: true, Resize: resize(80, 24)},
+			wantStdout: "tty=true 80x24",
+		},
+		{name: "command can't run", pod: "web", opts: client.ExecOptions{Command: []string{"nope"}}, wantErr: "nope: not found"},
+		{name: "container not running", pod: "done", opts: client.ExecOptions{Command: []string{"cat"}}, wantErr: "status 400"},
+		{name: "unknown pod", pod: "missing", opts: client.ExecOptions{Command: []string{"cat"}}, wantErr: "status 404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			tt.opts.Stdout, tt.opts.Stderr = &stdout, &stderr
+
+			code, err := env.Client.Exec("default", tt.pod, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exec: %v", err)
+			}
+			if code != tt.wantCode {
+				t.Errorf("got exit code %d, want %d", code, tt.wantCode)
+			}
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("got stdout %q, want %q", stdout.String(), tt.wantStdout)
+			}
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("got stderr %q, want %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestHandlerOnlyServesAPIServer(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+	ca, err := pki.NewCA("test-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	issue := func(name string, subject pkix.Name, hosts []string, usage x509.ExtKeyUsage) tls.Certificate {
+		cert, err := ca.LoadOrIssue(dir, name, subject, hosts, usage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	k := New(env.Client, &mockRuntime{}, "node-1")
+	kubeletServer := httptest.NewUnstartedServer(k.Handler())
+	kubeletServer.TLS = pki.ServerTLS(issue("kubelet", pkix.Name{CommonName: "node-1"}, []string{"127.0.0.1"}, x509.ExtKeyUsageServerAuth), ca.Pool())
+	kubeletServer.StartTLS()
+	defer kubeletServer.Close()
+
+	tests := []struct {
+		name       string
+		subject    *pkix.Name
+		wantStatus int
+	}{
+		// the pod isn't there, but the request got to the handler
+		{"api server", &pkix.Name{CommonName: auth.UserAPIServer, Organization: []string{auth.GroupMasters}}, http.StatusNotFound},
+		{"other node", &pkix.Name{CommonName: auth.NodeUserPrefix + "node-2", Organization: []string{auth.GroupNodes}}, http.StatusForbidden},
+		{"node itself", &pkix.Name{CommonName: auth.NodeUserPrefix + "node-1", Organization: []string{auth.GroupNodes}}, http.StatusForbidden},
+		{"no certificate", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &tls.Config{RootCAs: ca.Pool()}
+			if tt.subject != nil {
+				config.Certificates = []tls.Certificate{issue(tt.name, *tt.subject, nil, x509.ExtKeyUsageClientAuth)}
+			}
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+			resp, err := c.Get(kubeletServer.URL + "/pods/web/log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+// Package pki is the cluster's small certificate authority: it creates the
+// CA, issues certificates for the components and signs the certificate
+// requests of nodes joining the cluster.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	// CAValidity is how long a new CA is valid.
+	CAValidity = 10 * 365 * 24 * time.Hour
+	// CertValidity is how long the certificates it issues are valid.
+	CertValidity = 365 * 24 * time.Hour
+)
+
+// Names of the CA's files in a cert dir.
+const (
+	CACertFile = "ca.crt"
+	CAKeyFile  = "ca.key"
+)
+
+// CA issues and signs certificates.
+type CA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+	// CertPEM is Cert PEM encoded, what clients put in their CA bundle.
+	CertPEM []byte
+}
+
+// NewCA creates a self-signed CA named commonName.
+func NewCA(commonName string) (*CA, error) {
+	key, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(CAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Cert: cert, Key: key, CertPEM: encodeCert(der)}, nil
+}
+
+// LoadCA reads the CA from ca.crt and ca.key in dir.
+func LoadCA(dir string) (*CA, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, CACertFile))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, CAKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("load CA from %s: %w", dir, err)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("load CA from %s: key can't sign", dir)
+	}
+	if !pair.Leaf.IsCA {
+		return nil, fmt.Errorf("load CA from %s: %s is not a CA certificate", dir, CACertFile)
+	}
+	return &CA{Cert: pair.Leaf, Key: key, CertPEM: certPEM}, nil
+}
+
+// LoadOrCreateCA reads the CA in dir, creating one there first if there is
+// none yet.
+func LoadOrCreateCA(dir string) (*CA, error) {
+	ca, err := LoadCA(dir)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return ca, err
+	}
+	if ca, err = NewCA("miniku-ca"); err != nil {
+		return nil, err
+	}
+	keyPEM, err := EncodeKey(ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteKeyPair(dir, "ca", ca.CertPEM, keyPEM); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// Issue creates a key and a certificate for subject signed by the CA. hosts
+// are the DNS names and IPs a server certificate is valid for, usage is
+// x509.ExtKeyUsageServerAuth or x509.ExtKeyUsageClientAuth.
+func (ca *CA) Issue(subject pkix.Name, hosts []string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte, err error) {
+	key, err := NewKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{Subject: subject}
+	tmpl.DNSNames, tmpl.IPAddresses = splitHosts(hosts)
+	if certPEM, err = ca.sign(tmpl, key.Public(), usage); err != nil {
+		return nil, nil, err
+	}
+	if keyPEM, err = EncodeKey(key); err != nil {
+		return nil, nil, err
+	}
+	return certPEM, keyPEM, nil
+}
+
+// LoadOrIssue reads the key pair name in dir, issuing a new one there when
+// there is none or it expired.
+func (ca *CA) LoadOrIssue(dir, name string, subject pkix.Name, hosts []string, usage x509.ExtKeyUsage) (tls.Certificate, error) {
+	if cert, err := LoadKeyPair(dir, name); err == nil {
+		return cert, nil
+	}
+	certPEM, keyPEM, err := ca.Issue(subject, hosts, usage)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := WriteKeyPair(dir, name, certPEM, keyPEM); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// Sign signs a certificate request, keeping its subject and names.
+func (ca *CA) Sign(csr *x509.CertificateRequest, usage x509.ExtKeyUsage) ([]byte, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("bad request signature: %w", err)
+	}
+	tmpl := &x509.Certificate{
+		Subject:     csr.Subject,
+		DNSNames:    csr.DNSNames,
+		IPAddresses: csr.IPAddresses,
+	}
+	return ca.sign(tmpl, csr.PublicKey, usage)
+}
+
+func (ca *CA) sign(tmpl *x509.Certificate, pub crypto.PublicKey, usage x509.ExtKeyUsage) ([]byte, error) {
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = now.Add(-time.Minute)
+	tmpl.NotAfter = now.Add(CertValidity)
+	if tmpl.NotAfter.After(ca.Cert.NotAfter) {
+		tmpl.NotAfter = ca.Cert.NotAfter
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	tmpl.BasicConstraintsValid = true
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, pub, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	return encodeCert(der), nil
+}
+
+// Hosts are the DNS names and IPs cert is valid for.
+func Hosts(cert *x509.Certificate) []string {
+	hosts := slices.Clone(cert.DNSNames)
+	for _, ip := range cert.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	return hosts
+}
+
+// NewKey generates a private key for a certificate.
+func NewKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// NewCSR creates a PEM encoded certificate request for subject and hosts
+// signed with key.
+func NewCSR(key crypto.Signer, subject pkix.Name, hosts []string) ([]byte, error) {
+	tmpl := &x509.CertificateRequest{Subject: subject}
+	tmpl.DNSNames, tmpl.IPAddresses = splitHosts(hosts)
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// ParseCSR decodes a PEM encoded certificate request.
+func ParseCSR(data []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("no PEM encoded CERTIFICATE REQUEST")
+	}
+	return x509.ParseCertificateRequest(block.Bytes)
+}
+
+// EncodeKey PEM encodes a private key.
+func EncodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// KeyPairPaths are the files of key pair name in dir, name.crt and
+// name.key.
+func KeyPairPaths(dir, name string) (certFile, keyFile string) {
+	return filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+}
+
+// WriteKeyPair writes key pair name to dir, which is created if needed. The
+// key is only readable by its owner.
+func WriteKeyPair(dir, name string, certPEM, keyPEM []byte) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	certFile, keyFile := KeyPairPaths(dir, name)
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, certPEM, 0644)
+}
+
+// LoadKeyPair reads key pair name from dir. A certificate that expired is
+// an error.
+func LoadKeyPair(dir, name string) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(KeyPairPaths(dir, name))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		return tls.Certificate{}, fmt.Errorf("certificate %s in %s expired on %s", name, dir, cert.Leaf.NotAfter.Format(time.DateOnly))
+	}
+	return cert, nil
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", path)
+	}
+	return pool, nil
+}
+
+// splitHosts sorts hosts into DNS names and IPs.
+func splitHosts(hosts []string) (names []string, ips []net.IP) {
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			names = append(names, h)
+		}
+	}
+	return names, ips
+}
+
+func encodeCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+}
+
+// Pool is a cert pool of just the CA, to verify what it signed.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// ServerTLS is the TLS config of a server presenting cert. Clients that
+// send a certificate must have it signed by one of clientCAs, those that
+// must send one are up to the caller (tls.RequireAndVerifyClientCert).
+func ServerTLS(cert tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAs != nil {
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config
+}
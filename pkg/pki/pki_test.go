@@ -0,0 +1,113 @@
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"slices"
+	"testing"
+)
+
+func parseCert(t *testing.T, data []byte) *x509.Certificate {
+	t.Helper()
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatal("no PEM block")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestIssue(t *testing.T) {
+	ca, err := NewCA("test-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, _, err := ca.Issue(pkix.Name{CommonName: "apiserver"}, []string{"localhost", "127.0.0.1"}, x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := parseCert(t, certPEM)
+
+	opts := x509.VerifyOptions{Roots: ca.Pool(), DNSName: "localhost", KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	if _, err := cert.Verify(opts); err != nil {
+		t.Errorf("serving certificate doesn't verify for localhost: %v", err)
+	}
+	if err := cert.VerifyHostname("127.0.0.1"); err != nil {
+		t.Errorf("serving certificate isn't valid for its IP: %v", err)
+	}
+	opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	if _, err := cert.Verify(opts); err == nil {
+		t.Error("serving certificate verifies as a client certificate")
+	}
+
+	other, _ := NewCA("other-ca")
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: other.Pool(), DNSName: "localhost"}); err == nil {
+		t.Error("certificate verifies against another CA")
+	}
+}
+
+func TestSign(t *testing.T) {
+	ca, err := NewCA("test-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject := pkix.Name{CommonName: "system:node:node-1", Organization: []string{"system:nodes"}}
+	csrPEM, err := NewCSR(key, subject, []string{"node-1", "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := ParseCSR(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, err := ca.Sign(csr, x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := parseCert(t, certPEM)
+	if cert.Subject.CommonName != subject.CommonName || !slices.Equal(cert.Subject.Organization, subject.Organization) {
+		t.Errorf("got subject %v, want %v", cert.Subject, subject)
+	}
+	if !slices.Equal(cert.DNSNames, []string{"node-1"}) || len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "10.0.0.1" {
+		t.Errorf("got names %v and IPs %v, want those of the request", cert.DNSNames, cert.IPAddresses)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: ca.Pool(), DNSName: "node-1"}); err != nil {
+		t.Errorf("signed certificate doesn't verify: %v", err)
+	}
+}
+
+func TestLoadOrCreateCA(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Cert.Equal(ca.Cert) {
+		t.Fatal("got a new CA, want the one written before")
+	}
+
+	first, err := again.LoadOrIssue(dir, "admin", pkix.Name{CommonName: "admin"}, nil, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := again.LoadOrIssue(dir, "admin", pkix.Name{CommonName: "admin"}, nil, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Leaf.Equal(second.Leaf) {
+		t.Error("got a new key pair, want the one written before")
+	}
+}
@@ -0,0 +1,336 @@
+/*
+Package proxy forwards connections to services' cluster IPs to their ready
+pods. It runs on every node and follows Services and Endpoints through
+informers.
+
+It is a userspace proxy: the cluster IPs are put on a dummy interface of the
+node, so the proxy can listen on ClusterIP:Port itself, and every accepted
+connection is copied to and from an endpoint. Endpoints are picked round
+robin, one that refuses the connection is skipped for the next. Pods reach
+cluster IPs through their default route to the node.
+*/
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"miniku/pkg/client"
+	"miniku/pkg/types"
+)
+
+// DefaultInterface is the dummy interface cluster IPs are put on.
+const DefaultInterface = "miniku-svc0"
+
+type Proxy struct {
+	serviceInformer   *client.Informer[types.Service]
+	endpointsInformer *client.Informer[types.Endpoints]
+	queue             *client.WorkQueue
+	PollInterval      time.Duration
+	// Interface is the dummy interface cluster IPs are added to, created if
+	// missing. Empty leaves the addresses to someone else, the proxy then
+	// only listens.
+	Interface string
+	// DialTimeout bounds connecting to one endpoint before the next one is
+	// tried.
+	DialTimeout time.Duration
+
+	setupOnce sync.Once
+	setupErr  error
+
+	mu sync.Mutex
+	// services are the proxied services by name
+	services map[string]*serviceProxy
+}
+
+// serviceProxy is what the proxy runs for one service: a listener per port.
+type serviceProxy struct {
+	clusterIP string
+	// ports are keyed by port number, the listener is bound to it
+	ports map[int]*portProxy
+}
+
+type portProxy struct {
+	listener net.Listener
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	backends []string
+	next     int
+}
+
+func New(c *client.Client) *Proxy {
+	p := &Proxy{
+		serviceInformer:   c.ServiceInformer(),
+		endpointsInformer: c.EndpointsInformer(),
+		queue:             client.NewWorkQueue(),
+		PollInterval:      5 * time.Second,
+		Interface:         DefaultInterface,
+		DialTimeout:       time.Second,
+		services:          make(map[string]*serviceProxy),
+	}
+
+	p.serviceInformer.AddEventHandler(client.EventHandler[types.Service]{
+		OnAdd:    func(svc types.Service) { p.queue.Add(types.Key(svc.Namespace, svc.Name)) },
+		OnUpdate: func(_, svc types.Service) { p.queue.Add(types.Key(svc.Namespace, svc.Name)) },
+		OnDelete: func(svc types.Service) { p.queue.Add(types.Key(svc.Namespace, svc.Name)) },
+	})
+	p.endpointsInformer.AddEventHandler(client.EventHandler[types.Endpoints]{
+		OnAdd:    func(ep types.Endpoints) { p.queue.Add(types.Key(ep.Namespace, ep.Name)) },
+		OnUpdate: func(_, ep types.Endpoints) { p.queue.Add(types.Key(ep.Namespace, ep.Name)) },
+		OnDelete: func(ep types.Endpoints) { p.queue.Add(types.Key(ep.Namespace, ep.Name)) },
+	})
+
+	return p
+}
+
+func (p *Proxy) Run() {
+	p.startInformers(nil)
+
+	for {
+		key, ok := p.queue.Get()
+		if !ok {
+			return
+		}
+		p.sync(key)
+	}
+}
+
+// startInformers runs the informers until stop is closed and waits for their
+// caches to fill.
+func (p *Proxy) startInformers(stop <-chan struct{}) {
+	p.serviceInformer.PollInterval = p.PollInterval
+	p.serviceInformer.ResyncPeriod = p.PollInterval
+	p.endpointsInformer.PollInterval = p.PollInterval
+
+	go p.serviceInformer.Run(stop)
+	go p.endpointsInformer.Run(stop)
+	<-p.serviceInformer.Synced()
+	<-p.endpointsInformer.Synced()
+}
+
+func (p *Proxy) sync(key string) {
+	defer p.queue.Done(key)
+
+	if err := p.reconcile(key); err != nil {
+		log.Printf("proxy: failed to sync service %s: %v", key, err)
+		p.queue.AddRateLimited(key)
+		return
+	}
+	p.queue.Forget(key)
+}
+
+// reconcile brings the listeners of the service with key name in line with
+// the service and its endpoints.
+func (p *Proxy) reconcile(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	svc, ok := p.serviceInformer.Get(name)
+	current := p.services[name]
+	if current != nil && (!ok || svc.ClusterIP != current.clusterIP) {
+		p.stop(name, current)
+		current = nil
+	}
+	if !ok || svc.ClusterIP == "" {
+		return nil
+	}
+
+	if current == nil {
+		if err := p.addAddress(svc.ClusterIP); err != nil {
+			return err
+		}
+		current = &serviceProxy{clusterIP: svc.ClusterIP, ports: make(map[int]*portProxy)}
+		p.services[name] = current
+	}
+
+	ep, _ := p.endpointsInformer.Get(name)
+	wanted := make(map[int]bool, len(svc.Ports))
+	var errs []error
+	for _, port := range svc.Ports {
+		wanted[port.Port] = true
+		pp := current.ports[port.Port]
+		if pp == nil {
+			var err error
+			pp, err = p.listen(svc.ClusterIP, port)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			log.Printf("proxy: service %s listening on %s", name, pp.listener.Addr())
+			current.ports[port.Port] = pp
+		}
+		pp.setBackends(backends(ep, port.Name))
+	}
+	for number, pp := range current.ports {
+		if !wanted[number] {
+			_ = pp.listener.Close()
+			delete(current.ports, number)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stop closes the listeners of a service and takes its cluster IP off the
+// interface. Connections in flight carry on.
+func (p *Proxy) stop(name string, sp *serviceProxy) {
+	log.Printf("proxy: service %s stopped", name)
+	for _, pp := range sp.ports {
+		_ = pp.listener.Close()
+	}
+	p.removeAddress(sp.clusterIP)
+	delete(p.services, name)
+}
+
+func (p *Proxy) listen(clusterIP string, port types.ServicePort) (*portProxy, error) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(clusterIP, strconv.Itoa(port.Port)))
+	if err != nil {
+		return nil, err
+	}
+	pp := &portProxy{listener: ln, timeout: p.DialTimeout}
+	go pp.serve()
+	return pp, nil
+}
+
+// backends are the host:port pairs of the endpoints for a service port.
+func backends(ep types.Endpoints, portName string) []string {
+	var out []string
+	for _, port := range ep.Ports {
+		if port.Name != portName {
+			continue
+		}
+		for _, addr := range ep.Addresses {
+			out = append(out, net.JoinHostPort(addr.IP, strconv.Itoa(port.Port)))
+		}
+	}
+	return out
+}
+
+func (pp *portProxy) setBackends(backends []string) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.backends = backends
+}
+
+func (pp *portProxy) serve() {
+	for {
+		conn, err := pp.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("proxy: accept on %s: %v", pp.listener.Addr(), err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go pp.handle(conn)
+	}
+}
+
+// handle connects a client to the next endpoint that takes the connection
+// and copies between them until both sides are done.
+func (pp *portProxy) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	backend, err := pp.dial()
+	if err != nil {
+		log.Printf("proxy: %s: %v", pp.listener.Addr(), err)
+		return
+	}
+	defer func() { _ = backend.Close() }()
+
+	done := make(chan struct{})
+	go func() {
+		copyAndClose(backend, conn)
+		close(done)
+	}()
+	copyAndClose(conn, backend)
+	<-done
+}
+
+func (pp *portProxy) dial() (net.Conn, error) {
+	pp.mu.Lock()
+	backends := pp.backends
+	start := pp.next
+	pp.next++
+	pp.mu.Unlock()
+
+	if len(backends) == 0 {
+		return nil, errors.New("no endpoints")
+	}
+	var errs []error
+	for i := range backends {
+		addr := backends[(start+i)%len(backends)]
+		conn, err := net.DialTimeout("tcp", addr, pp.timeout)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// copyAndClose copies src to dst, then passes the end of the stream on as a
+// half close so the other direction can finish.
+func copyAndClose(dst, src net.Conn) {
+	_, _ = io.Copy(dst, src)
+	if tcp, ok := dst.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+	} else {
+		_ = dst.Close()
+	}
+}
+
+// setupInterface creates the dummy interface and drops the addresses a
+// previous run left on it, the services still there are added back.
+func (p *Proxy) setupInterface() error {
+	p.setupOnce.Do(func() {
+		if _, err := net.InterfaceByName(p.Interface); err != nil {
+			if err := ip("link", "add", p.Interface, "type", "dummy"); err != nil {
+				p.setupErr = err
+				return
+			}
+		}
+		p.setupErr = errors.Join(
+			ip("addr", "flush", "dev", p.Interface),
+			ip("link", "set", p.Interface, "up"),
+		)
+	})
+	return p.setupErr
+}
+
+func (p *Proxy) addAddress(clusterIP string) error {
+	if p.Interface == "" {
+		return nil
+	}
+	if err := p.setupInterface(); err != nil {
+		return fmt.Errorf("set up %s: %w", p.Interface, err)
+	}
+	return ip("addr", "replace", clusterIP+"/32", "dev", p.Interface)
+}
+
+func (p *Proxy) removeAddress(clusterIP string) {
+	if p.Interface == "" {
+		return
+	}
+	if err := ip("addr", "del", clusterIP+"/32", "dev", p.Interface); err != nil {
+		log.Printf("proxy: %v", err)
+	}
+}
+
+func ip(args ...string) error {
+	out, err := exec.Command("ip", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ip %s: %w: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"miniku/pkg/testutil"
+	"miniku/pkg/types"
+)
+
+// serveName listens on ip:port and answers every connection with name. Port
+// zero picks a free one, the port is returned.
+func serveName(t *testing.T, ip string, port int, name string) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = io.WriteString(conn, name)
+			_ = conn.Close()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// newTestProxy leaves the addresses alone, cluster IPs in tests are loopback
+// addresses the node already has.
+func newTestProxy(t *testing.T, env *testutil.TestEnv) *Proxy {
+	t.Helper()
+	p := New(env.Client)
+	p.Interface = ""
+	p.PollInterval = 20 * time.Millisecond
+	p.DialTimeout = 200 * time.Millisecond
+
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for name, sp := range p.services {
+			p.stop(name, sp)
+		}
+	})
+	p.startInformers(stop)
+	return p
+}
+
+func call(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("dial %s: %v", addr, err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	b, _ := io.ReadAll(conn)
+	return string(b)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	// the pods share a port on addresses of their own, nothing listens on
+	// 127.0.0.4 so it refuses
+	port := serveName(t, "127.0.0.2", 0, "a")
+	serveName(t, "127.0.0.3", port, "b")
+
+	svcPort := freePort(t)
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(svcPort))
+	env.ServiceStore.Put("default/web", types.Service{
+		Name:      "web",
+		ClusterIP: "127.0.0.1",
+		Ports:     []types.ServicePort{{Name: "http", Port: svcPort, TargetPort: port}},
+	})
+	env.EndpointsStore.Put("default/web", types.Endpoints{
+		Name:      "web",
+		Addresses: []types.EndpointAddress{{IP: "127.0.0.2"}, {IP: "127.0.0.3"}, {IP: "127.0.0.4"}},
+		Ports:     []types.EndpointPort{{Name: "http", Port: port}},
+	})
+
+	p := newTestProxy(t, env)
+	if err := p.reconcile("default/web"); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	seen := map[string]int{}
+	for range 6 {
+		seen[call(t, addr)]++
+	}
+	if seen["a"] == 0 || seen["b"] == 0 || seen["a"]+seen["b"] != 6 {
+		t.Errorf("expected every connection to reach a or b and both to be used, got %v", seen)
+	}
+
+	// without endpoints connections are accepted and closed
+	env.EndpointsStore.Put("default/web", types.Endpoints{Name: "web", Addresses: []types.EndpointAddress{}})
+	waitFor(t, "endpoints to be emptied", func() bool {
+		ep, _ := p.endpointsInformer.Get("default/web")
+		return len(ep.Addresses) == 0
+	})
+	if err := p.reconcile("default/web"); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if got := call(t, addr); got != "" {
+		t.Errorf("expected nothing without endpoints, got %q", got)
+	}
+
+	env.ServiceStore.Delete("default/web")
+	waitFor(t, "service to be deleted", func() bool {
+		_, ok := p.serviceInformer.Get("default/web")
+		return !ok
+	})
+	if err := p.reconcile("default/web"); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		_ = conn.Close()
+		t.Error("expected the listener of a deleted service to be closed")
+	}
+}
+
+func TestBackends(t *testing.T) {
+	ep := types.Endpoints{
+		Addresses: []types.EndpointAddress{{IP: "10.244.0.2"}, {IP: "10.244.0.3"}},
+		Ports:     []types.EndpointPort{{Name: "http", Port: 8080}, {Name: "admin", Port: 9000}},
+	}
+
+	tests := []struct {
+		portName string
+		want     []string
+	}{
+		{"http", []string{"10.244.0.2:8080", "10.244.0.3:8080"}},
+		{"admin", []string{"10.244.0.2:9000", "10.244.0.3:9000"}},
+		{"other", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.portName, func(t *testing.T) {
+			got := backends(ep, tt.portName)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Package remotecommand is the streaming protocol of exec. A client asks for
+// it with
+//
+//	POST /pods/{name}/exec?command=sh&command=-c&command=...[&stdin=true&tty=true]
+//	Connection: Upgrade
+//	Upgrade: miniku-exec
+//
+// and once the server answered 101 Switching Protocols both sides send
+// frames over the connection:
+//
+//	<1 byte stream> <4 byte big endian payload length> <payload>
+//
+// The client sends Stdin frames, an empty one closes stdin, and Resize frames
+// holding a JSON TerminalSize. The server sends Stdout and Stderr frames and
+// ends with a Status frame holding a JSON Status.
+package remotecommand
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Protocol is the Upgrade header value of an exec request.
+const Protocol = "miniku-exec"
+
+// maxFrameSize bounds what a peer can make us allocate.
+const maxFrameSize = 1 << 20
+
+type Stream byte
+
+const (
+	StreamStdin Stream = iota
+	StreamStdout
+	StreamStderr
+	StreamResize
+	StreamStatus
+)
+
+type TerminalSize struct {
+	Width  uint16 `json:"width"`
+	Height uint16 `json:"height"`
+}
+
+// Status is how the command ended. Error is set when it couldn't be run at
+// all, ExitCode is meaningless then.
+type Status struct {
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Conn sends and receives frames. Writes are safe for concurrent use, reads
+// are not.
+type Conn struct {
+	rwc io.ReadWriteCloser
+	r   *bufio.Reader
+	mu  sync.Mutex
+}
+
+// NewConn wraps a connection that switched to the exec protocol.
+func NewConn(rwc io.ReadWriteCloser) *Conn {
+	return &Conn{rwc: rwc, r: bufio.NewReader(rwc)}
+}
+
+func (c *Conn) WriteFrame(stream Stream, p []byte) error {
+	var header [5]byte
+	header[0] = byte(stream)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(p)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.rwc.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := c.rwc.Write(p)
+	return err
+}
+
+// WriteJSON sends v as the payload of a frame.
+func (c *Conn) WriteJSON(stream Stream, v any) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteFrame(stream, p)
+}
+
+func (c *Conn) ReadFrame() (Stream, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", size)
+	}
+	p := make([]byte, size)
+	if _, err := io.ReadFull(c.r, p); err != nil {
+		return 0, nil, err
+	}
+	return Stream(header[0]), p, nil
+}
+
+// Writer returns a writer that sends what is written to it as frames of
+// stream.
+func (c *Conn) Writer(stream Stream) io.Writer {
+	return streamWriter{c: c, stream: stream}
+}
+
+func (c *Conn) Close() error {
+	return c.rwc.Close()
+}
+
+type streamWriter struct {
+	c      *Conn
+	stream Stream
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	for rest := p; len(rest) > 0; {
+		n := min(len(rest), maxFrameSize)
+		if err := w.c.WriteFrame(w.stream, rest[:n]); err != nil {
+			return len(p) - len(rest), err
+		}
+		rest = rest[n:]
+	}
+	return len(p), nil
+}
+
+// isUpgrade reports whether r asks for the exec protocol.
+func isUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), Protocol)
+}
+
+// Upgrade switches the connection of r to the exec protocol. When that fails
+// an error response has been sent already.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if !isUpgrade(r) {
+		http.Error(w, "exec needs an upgrade to "+Protocol, http.StatusUpgradeRequired)
+		return nil, errors.New("not an upgrade request")
+	}
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "connection can't be upgraded", http.StatusInternalServerError)
+		return nil, fmt.Errorf("hijack: %w", err)
+	}
+	_, err = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + Protocol + "\r\n\r\n")
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	// the buffered reader may hold frames the client sent right away
+	return &Conn{rwc: conn, r: buf.Reader}, nil
+}
+
+// SetUpgradeHeaders makes req ask for the exec protocol.
+func SetUpgradeHeaders(req *http.Request) {
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", Protocol)
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
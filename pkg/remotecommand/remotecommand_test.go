@@ -0,0 +1,65 @@
+package remotecommand
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+type buffer struct {
+	bytes.Buffer
+}
+
+func (b *buffer) Close() error { return nil }
+
+func TestFrames(t *testing.T) {
+	var buf buffer
+	conn := NewConn(&buf)
+
+	large := strings.Repeat("x", maxFrameSize+10)
+	if _, err := io.WriteString(conn.Writer(StreamStdout), "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteFrame(StreamStdin, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(conn.Writer(StreamStderr), large); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON(StreamStatus, Status{ExitCode: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		stream Stream
+		size   int
+	}{
+		{StreamStdout, 5},
+		{StreamStdin, 0},
+		// writes over the frame size are split
+		{StreamStderr, maxFrameSize},
+		{StreamStderr, 10},
+		{StreamStatus, len(`{"exitCode":2}`)},
+	}
+	for i, w := range want {
+		stream, p, err := conn.ReadFrame()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if stream != w.stream || len(p) != w.size {
+			t.Errorf("frame %d: got stream %d with %d bytes, want %d with %d", i, stream, len(p), w.stream, w.size)
+		}
+	}
+	if _, _, err := conn.ReadFrame(); err != io.EOF {
+		t.Errorf("got %v after the last frame, want EOF", err)
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	var buf buffer
+	buf.Write([]byte{byte(StreamStdin), 0xff, 0xff, 0xff, 0xff})
+	if _, _, err := NewConn(&buf).ReadFrame(); err == nil {
+		t.Error("expected an error for an oversized frame")
+	}
+}
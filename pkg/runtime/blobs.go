@@ -0,0 +1,72 @@
+package runtime
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// blobStore keeps manifests, configs and layers by content under
+// <root>/blobs/sha256/<hex>. A blob only gets there once its content matched
+// its digest, so what's in the store can be trusted as is.
+type blobStore struct {
+	dir string
+}
+
+func newBlobStore(rootDir string) *blobStore {
+	return &blobStore{dir: filepath.Join(rootDir, "blobs")}
+}
+
+func (s *blobStore) path(digest string) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return filepath.Join(s.dir, algorithm, hex)
+}
+
+func (s *blobStore) has(digest string) bool {
+	_, err := os.Stat(s.path(digest))
+	return err == nil
+}
+
+func (s *blobStore) open(digest string) (*os.File, error) {
+	return os.Open(s.path(digest))
+}
+
+func (s *blobStore) read(digest string) ([]byte, error) {
+	return os.ReadFile(s.path(digest))
+}
+
+// write stores what r returns under digest, failing if the content doesn't
+// hash to it.
+func (s *blobStore) write(digest string, r io.Reader) error {
+	if err := validateDigest(digest); err != nil {
+		return err
+	}
+	target := s.path(digest)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".ingest-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != digest {
+		return fmt.Errorf("digest mismatch: got %s, want %s", got, digest)
+	}
+	return os.Rename(tmp.Name(), target)
+}
@@ -0,0 +1,214 @@
+package runtime
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"miniku/pkg/types"
+)
+
+const (
+	cgroupMount = "/sys/fs/cgroup"
+
+	// DefaultCgroupSlice is the cgroup, relative to the cgroup v2 mount, that
+	// container cgroups are created under.
+	DefaultCgroupSlice = "miniku.slice"
+
+	// cpuPeriod is the cpu.max period in microseconds.
+	cpuPeriod = 100000
+)
+
+// cgroupControllers are enabled on the slice for its container leaves.
+var cgroupControllers = []string{"cpu", "memory", "pids"}
+
+// cgroupManager creates one cgroup v2 leaf per container under a slice.
+type cgroupManager struct {
+	mount string
+	slice string
+
+	once sync.Once
+	// err is set when the slice couldn't be set up, containers then run
+	// without limits
+	err error
+}
+
+func newCgroupManager(mount, slice string) *cgroupManager {
+	return &cgroupManager{mount: mount, slice: slice}
+}
+
+// setup creates the slice and delegates the controllers to it. It only runs
+// once, later calls return the first result.
+func (m *cgroupManager) setup() error {
+	m.once.Do(func() {
+		if _, err := os.Stat(filepath.Join(m.mount, "cgroup.controllers")); err != nil {
+			m.err = fmt.Errorf("cgroup v2 not mounted at %s", m.mount)
+			return
+		}
+
+		slice := filepath.Join(m.mount, m.slice)
+		if err := os.MkdirAll(slice, 0755); err != nil {
+			m.err = fmt.Errorf("create slice: %w", err)
+			return
+		}
+
+		// every cgroup between the mount and the slice has to pass the
+		// controllers down
+		dir := m.mount
+		for _, part := range strings.Split(filepath.Clean(m.slice), string(filepath.Separator)) {
+			if err := enableControllers(dir); err != nil {
+				m.err = err
+				return
+			}
+			dir = filepath.Join(dir, part)
+		}
+		m.err = enableControllers(slice)
+	})
+	return m.err
+}
+
+func enableControllers(dir string) error {
+	for _, controller := range cgroupControllers {
+		if err := writeCgroupFile(dir, "cgroup.subtree_control", "+"+controller); err != nil {
+			return fmt.Errorf("enable %s controller in %s: %w", controller, dir, err)
+		}
+	}
+	return nil
+}
+
+// path is the cgroup directory of a container.
+func (m *cgroupManager) path(id string) string {
+	return filepath.Join(m.mount, m.slice, id)
+}
+
+// create makes the container's cgroup and applies its resources. It returns
+// an empty path when cgroups aren't available.
+func (m *cgroupManager) create(id string, resources types.ResourceRequirements) (string, error) {
+	if err := m.setup(); err != nil {
+		if resources != (types.ResourceRequirements{}) {
+			log.Printf("runtime: not enforcing resources of container %s: %v", id, err)
+		}
+		return "", nil
+	}
+
+	dir := m.path(id)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", fmt.Errorf("create cgroup: %w", err)
+	}
+	if err := applyResources(dir, resources); err != nil {
+		_ = os.Remove(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// applyResources writes the cgroup v2 interface files for the requests and
+// limits that are set.
+func applyResources(dir string, resources types.ResourceRequirements) error {
+	files := map[string]string{}
+	if cpu := resources.Limits.CPU; cpu > 0 {
+		files["cpu.max"] = fmt.Sprintf("%d %d", cpu*cpuPeriod/1000, cpuPeriod)
+	}
+	if cpu := resources.Requests.CPU; cpu > 0 {
+		files["cpu.weight"] = strconv.FormatInt(cpuWeight(cpu), 10)
+	}
+	if memory := resources.Limits.Memory; memory > 0 {
+		files["memory.max"] = strconv.FormatInt(memory, 10)
+		// don't let the container escape its limit into swap
+		files["memory.swap.max"] = "0"
+	}
+	if memory := resources.Requests.Memory; memory > 0 {
+		files["memory.low"] = strconv.FormatInt(memory, 10)
+	}
+	if pids := resources.Limits.PIDs; pids > 0 {
+		files["pids.max"] = strconv.FormatInt(pids, 10)
+	}
+
+	for name, value := range files {
+		err := writeCgroupFile(dir, name, value)
+		// swap accounting is often compiled out, that's fine
+		if name == "memory.swap.max" && errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("set %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// cpuWeight maps a CPU request onto cpu.weight (1-10000) the way cgroup v1
+// shares (1024 per core) convert to weights.
+func cpuWeight(millis int64) int64 {
+	shares := max(2, millis*1024/1000)
+	return min(10000, 1+(shares-2)*9999/262142)
+}
+
+// addProcess moves pid into the cgroup at dir.
+func addProcess(dir string, pid int) error {
+	return writeCgroupFile(dir, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// oomKilled reports whether the kernel killed a process of the cgroup at dir
+// for going over its memory limit.
+func oomKilled(dir string) bool {
+	if dir == "" {
+		return false
+	}
+	f, err := os.Open(filepath.Join(dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		if key == "oom_kill" {
+			n, _ := strconv.Atoi(value)
+			return n > 0
+		}
+	}
+	return false
+}
+
+// removeCgroup deletes a container cgroup. cgroupfs directories can't be
+// removed recursively, rmdir is all it takes once the processes are gone; the
+// kernel may take a moment to reap them after the container exited.
+func removeCgroup(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	var err error
+	for range 10 {
+		err = os.Remove(dir)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("remove cgroup: %w", err)
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(value); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,110 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"miniku/pkg/types"
+)
+
+// fakeCgroup creates a directory with the interface files cgroupfs would
+// have, so writes to them can be checked.
+func fakeCgroup(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestApplyResources(t *testing.T) {
+	dir := fakeCgroup(t, "cpu.max", "cpu.weight", "memory.max", "memory.low", "pids.max")
+
+	err := applyResources(dir, types.ResourceRequirements{
+		Requests: types.ResourceList{CPU: 250, Memory: 32 << 20},
+		Limits:   types.ResourceList{CPU: 500, Memory: 64 << 20, PIDs: 100},
+	})
+	if err != nil {
+		t.Fatalf("applyResources: %v", err)
+	}
+
+	want := map[string]string{
+		"cpu.max":    "50000 100000",
+		"cpu.weight": "10",
+		"memory.max": "67108864",
+		"memory.low": "33554432",
+		"pids.max":   "100",
+	}
+	for name, value := range want {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if string(got) != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestApplyResourcesUnset(t *testing.T) {
+	// nothing to write, so a cgroup without interface files is fine
+	if err := applyResources(t.TempDir(), types.ResourceRequirements{}); err != nil {
+		t.Fatalf("applyResources: %v", err)
+	}
+}
+
+func TestCPUWeight(t *testing.T) {
+	tests := []struct {
+		millis int64
+		want   int64
+	}{
+		{1, 1},
+		{1000, 39},
+		{1 << 20, 10000},
+	}
+	for _, tt := range tests {
+		if got := cpuWeight(tt.millis); got != tt.want {
+			t.Errorf("cpuWeight(%d) = %d, want %d", tt.millis, got, tt.want)
+		}
+	}
+}
+
+func TestOOMKilled(t *testing.T) {
+	tests := []struct {
+		name   string
+		events string
+		want   bool
+	}{
+		{"no kills", "low 0\nhigh 0\nmax 3\noom 1\noom_kill 0\n", false},
+		{"killed", "low 0\nhigh 0\nmax 12\noom 2\noom_kill 1\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "memory.events"), []byte(tt.events), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got := oomKilled(dir); got != tt.want {
+				t.Errorf("oomKilled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if oomKilled("") {
+		t.Error("expected a container without cgroup not to be OOM killed")
+	}
+}
+
+func TestCgroupSetupWithoutV2(t *testing.T) {
+	m := newCgroupManager(t.TempDir(), DefaultCgroupSlice)
+
+	// containers still run, just without limits
+	dir, err := m.create("abc", types.ResourceRequirements{Limits: types.ResourceList{Memory: 1 << 20}})
+	if err != nil || dir != "" {
+		t.Errorf("create() = %q, %v, want no cgroup and no error", dir, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
 )
 
@@ -14,9 +15,19 @@ type childConfig struct {
 	Hostname string            `json:"hostname"`
 	Command  []string          `json:"command"`
 	Env      map[string]string `json:"env"`
+	// WorkingDir and User come from the image config, empty means / and
+	// root
+	WorkingDir string `json:"working_dir,omitempty"`
+	User       string `json:"user,omitempty"`
 }
 
 func init() {
+	if config := os.Getenv("MINIKU_EXEC"); config != "" {
+		runExec(config)
+	}
+	if config := os.Getenv("MINIKU_LOGGER"); config != "" {
+		runLogger(config)
+	}
 	if os.Getenv("MINIKU_CHILD") != "1" {
 		return
 	}
@@ -55,6 +66,17 @@ func runChild() {
 		os.Exit(1)
 	}
 
+	if config.WorkingDir != "" {
+		if err := os.MkdirAll(config.WorkingDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "child: mkdir %s: %v\n", config.WorkingDir, err)
+			os.Exit(1)
+		}
+		if err := os.Chdir(config.WorkingDir); err != nil {
+			fmt.Fprintf(os.Stderr, "child: chdir: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// build environment
 	env := os.Environ()
 	for k, v := range config.Env {
@@ -62,12 +84,26 @@ func runChild() {
 	}
 
 	// resolve and exec the command
-	cmdPath, err := resolveCommand(config.Command[0])
+	cmdPath, err := resolveCommand(config.Command[0], config.Env["PATH"])
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "child: resolve command: %v\n", err)
 		os.Exit(1)
 	}
 
+	// the user is looked up in the container's own /etc/passwd, and
+	// switched to last since it takes our privileges
+	cred, err := lookupUser("/", config.User)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "child: %v\n", err)
+		os.Exit(1)
+	}
+	if cred != nil {
+		if err := setCredential(cred); err != nil {
+			fmt.Fprintf(os.Stderr, "child: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	if err := syscall.Exec(cmdPath, config.Command, env); err != nil {
 		fmt.Fprintf(os.Stderr, "child: exec %s: %v\n", cmdPath, err)
 		os.Exit(1)
@@ -101,17 +137,39 @@ func pivotRoot(rootfs string) error {
 	return os.RemoveAll("/.pivot_old")
 }
 
-func resolveCommand(cmd string) (string, error) {
-	// absolute path — use directly
-	if filepath.IsAbs(cmd) {
+// setCredential switches to the user, Go applies it to every thread.
+func setCredential(cred *syscall.Credential) error {
+	groups := make([]int, len(cred.Groups))
+	for i, g := range cred.Groups {
+		groups[i] = int(g)
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(int(cred.Gid)); err != nil {
+		return fmt.Errorf("setgid: %w", err)
+	}
+	if err := syscall.Setuid(int(cred.Uid)); err != nil {
+		return fmt.Errorf("setuid: %w", err)
+	}
+	return nil
+}
+
+// resolveCommand finds cmd in the PATH the container runs with, or the usual
+// directories when it has none.
+func resolveCommand(cmd, path string) (string, error) {
+	// a path — use directly
+	if strings.Contains(cmd, "/") {
 		if _, err := os.Stat(cmd); err == nil {
 			return cmd, nil
 		}
 		return "", fmt.Errorf("command not found: %s", cmd)
 	}
 
-	// search common directories
 	dirs := []string{"/bin", "/usr/bin", "/sbin", "/usr/sbin", "/usr/local/bin"}
+	if path != "" {
+		dirs = filepath.SplitList(path)
+	}
 	for _, dir := range dirs {
 		path := filepath.Join(dir, cmd)
 		if _, err := os.Stat(path); err == nil {
@@ -2,9 +2,6 @@ package runtime
 
 import (
 	"encoding/json"
-	"fmt"
-	"io"
-	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -12,21 +9,41 @@ import (
 )
 
 type containerProcess struct {
-	ID       string
-	Name     string
-	PID      int
-	RootFS   string
-	Cmd      *exec.Cmd
-	ExitCode int
-	Exited   bool
+	ID        string
+	Name      string
+	Namespace string
+	PID       int
+	RootFS    string
+	Env       map[string]string
+	// WorkingDir and User come from the image, exec runs with them too
+	WorkingDir string
+	User       string
+	// Cgroup is the container's cgroup directory, empty when cgroups
+	// aren't available
+	Cgroup string
+	// IP is the address of the container's network namespace, empty on
+	// the node's network
+	IP        string
+	LogPath   string
+	Cmd       *exec.Cmd
+	ExitCode  int
+	Exited    bool
+	OOMKilled bool
 }
 
 type containerMeta struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Image     string    `json:"image"`
-	PID       int       `json:"pid"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Namespace  string            `json:"namespace,omitempty"`
+	Image      string            `json:"image"`
+	PID        int               `json:"pid"`
+	Env        map[string]string `json:"env,omitempty"`
+	WorkingDir string            `json:"working_dir,omitempty"`
+	User       string            `json:"user,omitempty"`
+	Cgroup     string            `json:"cgroup,omitempty"`
+	IP         string            `json:"ip,omitempty"`
+	LogPath    string            `json:"log_path,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
 }
 
 func saveMeta(dir string, meta containerMeta) error {
@@ -48,51 +65,3 @@ func loadMeta(dir string) (containerMeta, error) {
 	}
 	return meta, nil
 }
-
-func copyDir(src, dst string) error {
-	return filepath.Walk(src, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		rel, err := filepath.Rel(src, path)
-		if err != nil {
-			return err
-		}
-		target := filepath.Join(dst, rel)
-
-		// handle symlinks (Walk uses Lstat, so ModeSymlink is set)
-		if info.Mode()&os.ModeSymlink != 0 {
-			link, err := os.Readlink(path)
-			if err != nil {
-				return err
-			}
-			return os.Symlink(link, target)
-		}
-
-		if info.IsDir() {
-			return os.MkdirAll(target, info.Mode())
-		}
-
-		return copyFile(path, target, info.Mode())
-	})
-}
-
-func copyFile(src, dst string, mode fs.FileMode) error {
-	sf, err := os.Open(src)
-	if err != nil {
-		return fmt.Errorf("open src %s: %w", src, err)
-	}
-	defer func() { _ = sf.Close() }()
-
-	df, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
-	if err != nil {
-		return fmt.Errorf("open dst %s: %w", dst, err)
-	}
-
-	if _, err = io.Copy(df, sf); err != nil {
-		_ = df.Close()
-		return err
-	}
-	return df.Close()
-}
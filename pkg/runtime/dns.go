@@ -0,0 +1,117 @@
+package runtime
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"miniku/pkg/types"
+)
+
+// hostResolvConf is copied into containers when there is no cluster DNS.
+const hostResolvConf = "/etc/resolv.conf"
+
+// nameservers are what a container's resolv.conf points at: ClusterDNS, or
+// with pod networking the bridge address, where the node's DNS server
+// answers. None means the node's resolv.conf is copied.
+func (nr *NamespaceRuntime) nameservers(network *networkManager) []string {
+	if len(nr.ClusterDNS) > 0 {
+		return nr.ClusterDNS
+	}
+	if network != nil {
+		return []string{network.ipam.gateway.String()}
+	}
+	return nil
+}
+
+// resolvConf is the resolv.conf of a container in namespace, searching the
+// services of its namespace, then those of all namespaces and then the
+// cluster domain itself.
+func resolvConf(nameservers []string, namespace, domain string) []byte {
+	var b strings.Builder
+	for _, ns := range nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", ns)
+	}
+	if domain != "" {
+		fmt.Fprintf(&b, "search %s.svc.%s svc.%s %s\n", namespace, domain, domain, domain)
+		b.WriteString("options ndots:5\n")
+	}
+	return []byte(b.String())
+}
+
+// hosts is the /etc/hosts of a container in namespace, its hostname
+// resolves to its pod IP, or to loopback without one.
+func hosts(hostname, namespace, podIP, domain string) []byte {
+	var b strings.Builder
+	b.WriteString("127.0.0.1\tlocalhost\n")
+	b.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	names := hostname
+	if domain != "" {
+		names = hostname + "." + namespace + ".pod." + domain + " " + hostname
+	}
+	if podIP != "" {
+		fmt.Fprintf(&b, "%s\t%s\n", podIP, names)
+	} else {
+		fmt.Fprintf(&b, "127.0.1.1\t%s\n", names)
+	}
+	return []byte(b.String())
+}
+
+// writeNetworkFiles puts /etc/resolv.conf and /etc/hosts into the root
+// filesystem of a container of a pod in namespace before it starts.
+func (nr *NamespaceRuntime) writeNetworkFiles(rootfs, hostname, namespace, podIP string, network *networkManager) error {
+	namespace = cmp.Or(namespace, types.DefaultNamespace)
+
+	var conf []byte
+	if nameservers := nr.nameservers(network); len(nameservers) > 0 {
+		conf = resolvConf(nameservers, namespace, nr.ClusterDomain)
+	} else {
+		host, err := os.ReadFile(hostResolvConf)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		conf = host
+	}
+
+	if err := writeEtcFile(rootfs, "resolv.conf", conf); err != nil {
+		return err
+	}
+	return writeEtcFile(rootfs, "hosts", hosts(hostname, namespace, podIP, nr.ClusterDomain))
+}
+
+// writeEtcFile replaces a file in the image's /etc. Whatever the image has
+// there is removed first, so a symlink doesn't get the write out of the
+// container's root.
+func writeEtcFile(rootfs, name string, data []byte) error {
+	etc := filepath.Join(rootfs, "etc")
+	info, err := os.Lstat(etc)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if err := os.Mkdir(etc, 0755); err != nil {
+			return fmt.Errorf("create /etc: %w", err)
+		}
+	case err != nil:
+		return err
+	case !info.IsDir():
+		return fmt.Errorf("write /etc/%s: /etc is not a directory", name)
+	}
+
+	path := filepath.Join(etc, name)
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("write /etc/%s: %w", name, err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0644)
+	if err != nil {
+		return fmt.Errorf("write /etc/%s: %w", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write /etc/%s: %w", name, err)
+	}
+	return f.Close()
+}
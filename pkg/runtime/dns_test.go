@@ -0,0 +1,103 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteNetworkFiles(t *testing.T) {
+	network, err := newNetworkManager(t.TempDir(), "10.244.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		clusterDNS  []string
+		network     *networkManager
+		podIP       string
+		wantResolv  string
+		wantHostsIP string
+	}{
+		{
+			name:        "node DNS on the bridge",
+			network:     network,
+			podIP:       "10.244.0.5",
+			wantResolv:  "nameserver 10.244.0.1\nsearch shop.svc.cluster.local svc.cluster.local cluster.local\noptions ndots:5\n",
+			wantHostsIP: "10.244.0.5",
+		},
+		{
+			name:        "cluster DNS given",
+			clusterDNS:  []string{"10.96.0.10", "10.96.0.11"},
+			network:     network,
+			podIP:       "10.244.0.5",
+			wantResolv:  "nameserver 10.96.0.10\nnameserver 10.96.0.11\nsearch shop.svc.cluster.local svc.cluster.local cluster.local\noptions ndots:5\n",
+			wantHostsIP: "10.244.0.5",
+		},
+		{
+			name:        "cluster DNS on the node's network",
+			clusterDNS:  []string{"127.0.0.1"},
+			wantResolv:  "nameserver 127.0.0.1\nsearch shop.svc.cluster.local svc.cluster.local cluster.local\noptions ndots:5\n",
+			wantHostsIP: "127.0.1.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootfs := t.TempDir()
+			nr := &NamespaceRuntime{ClusterDNS: tt.clusterDNS, ClusterDomain: "cluster.local"}
+			if err := nr.writeNetworkFiles(rootfs, "web-1", "shop", tt.podIP, tt.network); err != nil {
+				t.Fatal(err)
+			}
+
+			resolv, err := os.ReadFile(filepath.Join(rootfs, "etc", "resolv.conf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(resolv) != tt.wantResolv {
+				t.Errorf("resolv.conf:\n%s\nwant:\n%s", resolv, tt.wantResolv)
+			}
+
+			hostsFile, err := os.ReadFile(filepath.Join(rootfs, "etc", "hosts"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost ip6-loopback\n" +
+				tt.wantHostsIP + "\tweb-1.shop.pod.cluster.local web-1\n"
+			if string(hostsFile) != want {
+				t.Errorf("hosts:\n%s\nwant:\n%s", hostsFile, want)
+			}
+		})
+	}
+}
+
+func TestWriteEtcFileReplacesSymlink(t *testing.T) {
+	rootfs := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(outside, []byte("nameserver 192.168.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// an image pointing /etc/resolv.conf at the node's own
+	if err := os.Symlink(outside, filepath.Join(rootfs, "etc", "resolv.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeEtcFile(rootfs, "resolv.conf", []byte("nameserver 10.244.0.1\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := os.ReadFile(outside); string(got) != "nameserver 192.168.1.1\n" {
+		t.Errorf("file outside the rootfs was written: %q", got)
+	}
+	info, err := os.Lstat(filepath.Join(rootfs, "etc", "resolv.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Mode().IsRegular() {
+		t.Errorf("expected a regular file, got %v", info.Mode())
+	}
+}
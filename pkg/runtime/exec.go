@@ -0,0 +1,315 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// the namespaces an exec'd command joins
+var execNamespaces = []struct {
+	name string
+	flag int
+}{
+	{"uts", unix.CLONE_NEWUTS},
+	{"pid", unix.CLONE_NEWPID},
+	{"net", unix.CLONE_NEWNET},
+	// last, the others are opened through the node's /proc
+	{"mnt", unix.CLONE_NEWNS},
+}
+
+// how long a cancelled Exec waits for the helper to clean up
+const execKillDelay = 2 * time.Second
+
+type execConfig struct {
+	PID     int               `json:"pid"`
+	Command []string          `json:"command"`
+	Env     map[string]string `json:"env"`
+	TTY     bool              `json:"tty,omitempty"`
+	// WorkingDir and User are the container's
+	WorkingDir string `json:"working_dir,omitempty"`
+	User       string `json:"user,omitempty"`
+}
+
+func (nr *NamespaceRuntime) Exec(ctx context.Context, containerID string, opts ExecOptions) (int, error) {
+	if len(opts.Command) == 0 {
+		return -1, errors.New("no command given")
+	}
+
+	nr.mu.Lock()
+	cp, ok := nr.containers[containerID]
+	var pid int
+	var exited bool
+	var env map[string]string
+	var workingDir, user string
+	if ok {
+		pid, exited, env = cp.PID, cp.Exited, cp.Env
+		workingDir, user = cp.WorkingDir, cp.User
+	}
+	nr.mu.Unlock()
+
+	if !ok {
+		return -1, fmt.Errorf("container %s not found", containerID)
+	}
+	if exited {
+		return -1, fmt.Errorf("container %s is not running", containerID)
+	}
+
+	config, err := json.Marshal(execConfig{
+		PID:        pid,
+		Command:    opts.Command,
+		Env:        env,
+		TTY:        opts.TTY,
+		WorkingDir: workingDir,
+		User:       user,
+	})
+	if err != nil {
+		return -1, fmt.Errorf("marshal config: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe")
+	cmd.Env = []string{"MINIKU_EXEC=" + string(config)}
+	// let the helper take the command down with it, killing it outright
+	// would leave the command running in the container
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = execKillDelay
+
+	streams, err := setupExecStreams(cmd, opts)
+	if err != nil {
+		return -1, fmt.Errorf("exec in %s: %w", containerID, err)
+	}
+	err = cmd.Start()
+	streams.started()
+	if err == nil {
+		err = cmd.Wait()
+	}
+	streams.finished()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case ctx.Err() != nil:
+		return -1, ctx.Err()
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode(), nil
+	default:
+		return -1, fmt.Errorf("exec in %s: %w", containerID, err)
+	}
+}
+
+// execStreams connects the caller's streams to the helper. Stdin is fed
+// through a pipe of our own rather than left to os/exec, which would wait for
+// the caller's reader to hit EOF before Wait returns, and a terminal's output
+// has to be drained after the command exited.
+type execStreams struct {
+	// closed in the parent once the helper has its copies
+	childEnds []*os.File
+	stdin     *os.File
+	master    *os.File
+	output    chan struct{}
+	done      chan struct{}
+}
+
+func setupExecStreams(cmd *exec.Cmd, opts ExecOptions) (*execStreams, error) {
+	s := &execStreams{done: make(chan struct{})}
+	if opts.TTY {
+		return s, s.setupTTY(cmd, opts)
+	}
+
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	if opts.Stdin != nil {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stdin = r
+		s.childEnds = append(s.childEnds, r)
+		s.stdin = w
+		go func() {
+			_, _ = io.Copy(w, opts.Stdin)
+			_ = w.Close()
+		}()
+	}
+	return s, nil
+}
+
+func (s *execStreams) setupTTY(cmd *exec.Cmd, opts ExecOptions) error {
+	master, slave, err := openPTY()
+	if err != nil {
+		return err
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	s.childEnds = append(s.childEnds, slave)
+	s.master = master
+
+	if opts.Stdin != nil {
+		go func() { _, _ = io.Copy(master, opts.Stdin) }()
+	}
+
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	s.output = make(chan struct{})
+	go func() {
+		defer close(s.output)
+		// reading the master fails with EIO once the command and everything
+		// it started closed the terminal
+		_, _ = io.Copy(stdout, master)
+	}()
+
+	go func() {
+		for {
+			select {
+			case size, ok := <-opts.Resize:
+				if !ok {
+					return
+				}
+				_ = resizePTY(master, size)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *execStreams) started() {
+	for _, f := range s.childEnds {
+		_ = f.Close()
+	}
+	s.childEnds = nil
+}
+
+func (s *execStreams) finished() {
+	close(s.done)
+	if s.stdin != nil {
+		_ = s.stdin.Close()
+	}
+	if s.master != nil {
+		// a background process holding on to the terminal doesn't keep
+		// the exec open
+		select {
+		case <-s.output:
+		case <-time.After(execKillDelay):
+		}
+		_ = s.master.Close()
+	}
+}
+
+// runExec is the helper process started by Exec. It joins the container's
+// namespaces and runs the command as its child so it lands in the container's
+// PID namespace, then exits with the command's exit code.
+func runExec(configJSON string) {
+	runtime.LockOSThread()
+
+	var config execConfig
+	if err := json.Unmarshal([]byte(configJSON), &config); err != nil {
+		fmt.Fprintf(os.Stderr, "exec: decode config: %v\n", err)
+		os.Exit(126)
+	}
+
+	// a thread sharing its filesystem attributes with the others can't
+	// change its mount namespace, the command is forked from this thread
+	// so it inherits what we set up here
+	if err := unix.Unshare(unix.CLONE_FS); err != nil {
+		fmt.Fprintf(os.Stderr, "exec: unshare: %v\n", err)
+		os.Exit(126)
+	}
+
+	for _, ns := range execNamespaces {
+		if err := setns(config.PID, ns.name, ns.flag); err != nil {
+			fmt.Fprintf(os.Stderr, "exec: %v\n", err)
+			os.Exit(126)
+		}
+	}
+
+	workingDir := config.WorkingDir
+	if workingDir == "" {
+		workingDir = "/"
+	}
+	if err := os.Chdir(workingDir); err != nil {
+		fmt.Fprintf(os.Stderr, "exec: chdir: %v\n", err)
+		os.Exit(126)
+	}
+
+	cmdPath, err := resolveCommand(config.Command[0], config.Env["PATH"])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "exec: %v\n", err)
+		os.Exit(127)
+	}
+	cred, err := lookupUser("/", config.User)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "exec: %v\n", err)
+		os.Exit(126)
+	}
+
+	env := []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}
+	if config.TTY {
+		env = append(env, "TERM=xterm")
+	}
+	for k, v := range config.Env {
+		env = append(env, k+"="+v)
+	}
+
+	cmd := exec.Command(cmdPath, config.Command[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	if config.TTY {
+		// our stdin is the terminal, make it the command's controlling one
+		cmd.SysProcAttr.Setsid = true
+		cmd.SysProcAttr.Setctty = true
+	}
+
+	// Pdeathsig can't be used here, the child's parent is outside its PID
+	// namespace, so kill it ourselves when Exec gives up on us
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "exec: %v\n", err)
+		os.Exit(126)
+	}
+	go func() {
+		<-sigs
+		_ = cmd.Process.Kill()
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		fmt.Fprintf(os.Stderr, "exec: %v\n", err)
+		os.Exit(126)
+	}
+	os.Exit(0)
+}
+
+func setns(pid int, name string, flag int) error {
+	f, err := os.Open(filepath.Join("/proc", fmt.Sprint(pid), "ns", name))
+	if err != nil {
+		return fmt.Errorf("open %s namespace: %w", name, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := unix.Setns(int(f.Fd()), flag); err != nil {
+		return fmt.Errorf("setns %s: %w", name, err)
+	}
+	return nil
+}
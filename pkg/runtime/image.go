@@ -1,124 +1,252 @@
 package runtime
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
-const alpineURL = "https://dl-cdn.alpinelinux.org/alpine/v3.21/releases/x86_64/alpine-minirootfs-3.21.3-x86_64.tar.gz"
+// Images are pulled from their registry into the blob store, and their
+// layers unpacked into <root>/layers/<hex of the layer digest>, where images
+// sharing a layer share its directory. Containers get the layers as the lower
+// dirs of an overlay mount. <root>/images/refs.json remembers which manifest
+// a name resolved to, a name that's in there isn't pulled again.
+
+// imageConfig is what the image says about running it, the part of the OCI
+// image config we honor.
+type imageConfig struct {
+	User       string   `json:"User,omitempty"`
+	Env        []string `json:"Env,omitempty"`
+	Entrypoint []string `json:"Entrypoint,omitempty"`
+	Cmd        []string `json:"Cmd,omitempty"`
+	WorkingDir string   `json:"WorkingDir,omitempty"`
+}
+
+type image struct {
+	// ID is the hex of the config digest.
+	ID string
+	// Layers are the unpacked layer directories, the base layer first.
+	Layers []string
+	Config imageConfig
+}
 
 type imageManager struct {
 	imagesDir string
-}
+	layersDir string
+	blobs     *blobStore
+	registry  *registryClient
 
-func newImageManager(rootDir string) *imageManager {
-	return &imageManager{imagesDir: filepath.Join(rootDir, "images")}
+	// pulls run one at a time, two pods of the same image shouldn't both
+	// download it
+	mu sync.Mutex
 }
 
-// ensureImage returns the path to a ready rootfs for the given image.
-// Currently all images map to Alpine minirootfs.
-func (m *imageManager) ensureImage(image string) (string, error) {
-	rootfs := filepath.Join(m.imagesDir, "alpine")
-
-	// check if already extracted
-	if _, err := os.Stat(filepath.Join(rootfs, "bin", "sh")); err == nil {
-		return rootfs, nil
+func newImageManager(rootDir string, insecureRegistries []string) *imageManager {
+	return &imageManager{
+		imagesDir: filepath.Join(rootDir, "images"),
+		layersDir: filepath.Join(rootDir, "layers"),
+		blobs:     newBlobStore(rootDir),
+		registry:  newRegistryClient(insecureRegistries),
 	}
+}
 
-	if image != "alpine" {
-		log.Printf("runtime: image %q not recognized, using alpine", image)
+// ensureImage returns the unpacked image, pulling it unless it was pulled
+// before.
+func (m *imageManager) ensureImage(name string) (*image, error) {
+	ref, err := parseReference(name)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Printf("runtime: downloading alpine rootfs...")
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	if err := os.MkdirAll(rootfs, 0755); err != nil {
-		return "", fmt.Errorf("create image dir: %w", err)
+	refs, err := m.loadRefs()
+	if err != nil {
+		return nil, err
+	}
+	if manifestDigest, ok := refs[ref.String()]; ok {
+		if img, err := m.loadImage(manifestDigest); err == nil {
+			return img, nil
+		}
 	}
 
-	resp, err := http.Get(alpineURL)
+	log.Printf("runtime: pulling image %s...", ref)
+	manifestDigest, err := m.pull(ref)
 	if err != nil {
-		return "", fmt.Errorf("download image: %w", err)
+		return nil, fmt.Errorf("pull %s: %w", ref, err)
 	}
-	defer func() { _ = resp.Body.Close() }()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("download image: HTTP %d", resp.StatusCode)
+	img, err := m.loadImage(manifestDigest)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := extractTarGz(resp.Body, rootfs); err != nil {
-		_ = os.RemoveAll(rootfs)
-		return "", fmt.Errorf("extract image: %w", err)
+	refs[ref.String()] = manifestDigest
+	if err := m.saveRefs(refs); err != nil {
+		return nil, err
 	}
-
-	log.Printf("runtime: alpine rootfs ready at %s", rootfs)
-	return rootfs, nil
+	log.Printf("runtime: image %s ready (%s)", ref, img.ID[:12])
+	return img, nil
 }
 
-func extractTarGz(r io.Reader, dst string) error {
-	gz, err := gzip.NewReader(r)
+// pull fetches the manifest for our platform and the blobs it references,
+// then unpacks the layers. It returns the manifest's digest.
+func (m *imageManager) pull(ref reference) (string, error) {
+	data, mediaType, digest, err := m.registry.fetchManifest(ref, ref.version())
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer func() { _ = gz.Close() }()
-
-	tr := tar.NewReader(gz)
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			return nil
+	if isIndex(mediaType) {
+		var index manifest
+		if err := json.Unmarshal(data, &index); err != nil {
+			return "", fmt.Errorf("decode index: %w", err)
 		}
+		desc, err := selectPlatform(index)
 		if err != nil {
-			return err
+			return "", err
 		}
-
-		if err := extractTarEntry(hdr, tr, dst); err != nil {
-			return err
+		data, mediaType, digest, err = m.registry.fetchManifest(ref, desc.Digest)
+		if err != nil {
+			return "", err
 		}
 	}
-}
+	if mediaType != mediaTypeOCIManifest && mediaType != mediaTypeDockerManifest {
+		return "", fmt.Errorf("unsupported manifest type %q", mediaType)
+	}
 
-func extractTarEntry(hdr *tar.Header, tr io.Reader, dst string) error {
-	target := filepath.Join(dst, hdr.Name)
-
-	switch hdr.Typeflag {
-	case tar.TypeDir:
-		return os.MkdirAll(target, os.FileMode(hdr.Mode))
-	case tar.TypeReg:
-		return extractRegularFile(target, tr, os.FileMode(hdr.Mode))
-	case tar.TypeSymlink:
-		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
-			return err
+	var man manifest
+	if err := json.Unmarshal(data, &man); err != nil {
+		return "", fmt.Errorf("decode manifest: %w", err)
+	}
+	if err := m.blobs.write(digest, bytes.NewReader(data)); err != nil {
+		return "", fmt.Errorf("store manifest: %w", err)
+	}
+	for _, desc := range append([]descriptor{man.Config}, man.Layers...) {
+		if err := m.fetchBlob(ref, desc.Digest); err != nil {
+			return "", err
 		}
-		return os.Symlink(hdr.Linkname, target)
-	case tar.TypeLink:
-		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
-			return err
+	}
+
+	for _, layer := range man.Layers {
+		if err := m.unpackLayer(layer.Digest); err != nil {
+			return "", fmt.Errorf("unpack layer %s: %w", layer.Digest, err)
 		}
-		return os.Link(filepath.Join(dst, hdr.Linkname), target)
 	}
+	return digest, nil
+}
 
+func (m *imageManager) fetchBlob(ref reference, digest string) error {
+	if m.blobs.has(digest) {
+		return nil
+	}
+	body, err := m.registry.fetchBlob(ref, digest)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = body.Close() }()
+	if err := m.blobs.write(digest, body); err != nil {
+		return fmt.Errorf("blob %s: %w", digest, err)
+	}
 	return nil
 }
 
-func extractRegularFile(target string, r io.Reader, mode os.FileMode) error {
-	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
-		return err
+// unpackLayer unpacks a layer into its directory. The directory is only
+// moved into place once complete, so one that exists can be used.
+func (m *imageManager) unpackLayer(digest string) error {
+	dir := m.layerDir(digest)
+	if _, err := os.Stat(dir); err == nil {
+		return nil
 	}
 
-	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	f, err := m.blobs.open(digest)
 	if err != nil {
 		return err
 	}
+	defer func() { _ = f.Close() }()
 
-	if _, err := io.Copy(f, r); err != nil {
-		_ = f.Close()
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return fmt.Errorf("create layer dir: %w", err)
+	}
+	if err := unpackLayer(f, tmp); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+func (m *imageManager) layerDir(digest string) string {
+	return filepath.Join(m.layersDir, strings.TrimPrefix(digest, "sha256:"))
+}
+
+// loadImage reads a pulled image back from disk.
+func (m *imageManager) loadImage(manifestDigest string) (*image, error) {
+	data, err := m.blobs.read(manifestDigest)
+	if err != nil {
+		return nil, err
+	}
+	var man manifest
+	if err := json.Unmarshal(data, &man); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+
+	img := &image{ID: strings.TrimPrefix(man.Config.Digest, "sha256:")}
+	for _, layer := range man.Layers {
+		dir := m.layerDir(layer.Digest)
+		if _, err := os.Stat(dir); err != nil {
+			return nil, err
+		}
+		img.Layers = append(img.Layers, dir)
+	}
+
+	data, err = m.blobs.read(man.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Config imageConfig `json:"config"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("decode image config: %w", err)
+	}
+	img.Config = config.Config
+	return img, nil
+}
+
+func (m *imageManager) loadRefs() (map[string]string, error) {
+	refs := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(m.imagesDir, "refs.json"))
+	if os.IsNotExist(err) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return nil, fmt.Errorf("decode image refs: %w", err)
+	}
+	return refs, nil
+}
+
+func (m *imageManager) saveRefs(refs map[string]string) error {
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.imagesDir, 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(m.imagesDir, "refs.json.tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
 		return err
 	}
-	return f.Close()
+	return os.Rename(tmp, filepath.Join(m.imagesDir, "refs.json"))
 }
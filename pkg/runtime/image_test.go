@@ -0,0 +1,357 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"miniku/pkg/types"
+)
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	tests := []struct {
+		image   string
+		want    string
+		host    string
+		wantErr bool
+	}{
+		{image: "alpine", want: "docker.io/library/alpine:latest", host: dockerHubHost},
+		{image: "alpine:3.21", want: "docker.io/library/alpine:3.21", host: dockerHubHost},
+		{image: "team/app", want: "docker.io/team/app:latest", host: dockerHubHost},
+		{image: "localhost/app", want: "localhost/app:latest", host: "localhost"},
+		{image: "registry.example.com:5000/team/app:v1", want: "registry.example.com:5000/team/app:v1", host: "registry.example.com:5000"},
+		{image: "ghcr.io/team/app@" + digest, want: "ghcr.io/team/app@" + digest, host: "ghcr.io"},
+		{image: "app:v1@" + digest, want: "docker.io/library/app:v1@" + digest, host: dockerHubHost},
+		{image: "", wantErr: true},
+		{image: "app:", wantErr: true},
+		{image: "App", wantErr: true},
+		{image: "app@sha256:123", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			ref, err := parseReference(tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReference() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if ref.String() != tt.want {
+				t.Errorf("got %s, want %s", ref, tt.want)
+			}
+			if ref.host() != tt.host {
+				t.Errorf("got host %s, want %s", ref.host(), tt.host)
+			}
+		})
+	}
+}
+
+// pushLayeredImage pushes team/app:v1, an index with a two layer image for
+// our platform. It returns the image, its config and what the merged layers
+// should hold, an empty value for a file that mustn't be there.
+func pushLayeredImage(t *testing.T, reg *testRegistry) (descriptor, imageConfig, map[string]string) {
+	t.Helper()
+	config := imageConfig{
+		Entrypoint: []string{"/bin/app"},
+		Cmd:        []string{"--serve"},
+		Env:        []string{"PATH=/bin", "MODE=prod"},
+		WorkingDir: "/srv",
+		User:       "app",
+	}
+	image := reg.push("team/app", "", config,
+		gzipLayer(t,
+			tarEntry{name: "bin/"},
+			tarEntry{name: "bin/app", content: "v1"},
+			tarEntry{name: "etc/"},
+			tarEntry{name: "etc/old.conf", content: "old"},
+			tarEntry{name: "cache/"},
+			tarEntry{name: "cache/a", content: "a"},
+			tarEntry{name: "cache/b", content: "b"},
+		),
+		gzipLayer(t,
+			tarEntry{name: "bin/app", content: "v2"},
+			tarEntry{name: "etc/.wh.old.conf"},
+			tarEntry{name: "cache/"},
+			tarEntry{name: "cache/c", content: "c"},
+			tarEntry{name: "cache/.wh..wh..opq"},
+			tarEntry{name: "lib", link: "bin"},
+		),
+	)
+	// an index with an image for another platform first
+	other := reg.push("team/app", "", imageConfig{}, gzipLayer(t, tarEntry{name: "wrong", content: "x"}))
+	otherArch := "arm64"
+	if runtime.GOARCH == otherArch {
+		otherArch = "amd64"
+	}
+	other.Platform = &platform{OS: "linux", Architecture: otherArch}
+	image.Platform = &platform{OS: "linux", Architecture: runtime.GOARCH}
+	reg.addManifest("team/app", "v1", mediaTypeOCIIndex, manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIIndex,
+		Manifests:     []descriptor{other, image},
+	})
+
+	files := map[string]string{
+		"bin/app":      "v2",
+		"lib/app":      "v2",
+		"cache/c":      "c",
+		"etc/old.conf": "",
+		"cache/a":      "",
+		"wrong":        "",
+	}
+	return image, config, files
+}
+
+func checkFiles(t *testing.T, rootfs string, files map[string]string) {
+	t.Helper()
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(rootfs, name))
+		switch {
+		case want == "" && !os.IsNotExist(err):
+			t.Errorf("%s should be gone, got %q, %v", name, got, err)
+		case want != "" && string(got) != want:
+			t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestEnsureImage(t *testing.T) {
+	// whiteouts are devices and opaque dirs trusted xattrs, both need root
+	skipIfNotRoot(t)
+	reg := newTestRegistry(t)
+	image, config, files := pushLayeredImage(t, reg)
+
+	im := newImageManager(t.TempDir(), []string{reg.host()})
+	img, err := im.ensureImage(reg.host() + "/team/app:v1")
+	if err != nil {
+		t.Fatalf("ensureImage: %v", err)
+	}
+
+	if !reflect.DeepEqual(img.Config, config) {
+		t.Errorf("got config %+v, want %+v", img.Config, config)
+	}
+	if len(img.Layers) != 2 {
+		t.Fatalf("got %d layers, want 2", len(img.Layers))
+	}
+	rootfs := t.TempDir()
+	if err := copyLayers(img.Layers, rootfs); err != nil {
+		t.Fatalf("copyLayers: %v", err)
+	}
+	checkFiles(t, rootfs, files)
+
+	// the name is known now, it isn't resolved again
+	requests := reg.manifestRequests()
+	again, err := im.ensureImage(reg.host() + "/team/app:v1")
+	if err != nil {
+		t.Fatalf("ensureImage (cached): %v", err)
+	}
+	if !reflect.DeepEqual(again.Layers, img.Layers) {
+		t.Errorf("expected same layers, got %v vs %v", again.Layers, img.Layers)
+	}
+	if n := reg.manifestRequests(); n != requests {
+		t.Errorf("cached image fetched %d more manifests", n-requests)
+	}
+
+	// by digest it's the same image, whose blobs are all there already
+	byDigest, err := im.ensureImage(reg.host() + "/team/app@" + image.Digest)
+	if err != nil {
+		t.Fatalf("ensureImage by digest: %v", err)
+	}
+	if byDigest.ID != img.ID {
+		t.Errorf("got image %s by digest, want %s", byDigest.ID, img.ID)
+	}
+}
+
+func TestEnsureImageVerifiesDigests(t *testing.T) {
+	reg := newTestRegistry(t)
+	layer := gzipLayer(t, tarEntry{name: "file", content: "good"})
+	reg.push("app", "v1", imageConfig{}, layer)
+
+	// the registry serves something else than the manifest promised
+	reg.mu.Lock()
+	reg.blobs[sha256Digest(layer)] = gzipLayer(t, tarEntry{name: "file", content: "evil"})
+	reg.mu.Unlock()
+
+	root := t.TempDir()
+	im := newImageManager(root, []string{reg.host()})
+	_, err := im.ensureImage(reg.host() + "/app:v1")
+	if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("got %v, want a digest mismatch", err)
+	}
+	if im.blobs.has(sha256Digest(layer)) {
+		t.Error("a blob that didn't match its digest was stored")
+	}
+}
+
+func TestUnpackLayerStaysInRootfs(t *testing.T) {
+	outside := t.TempDir()
+	rootfs := t.TempDir()
+
+	layers := [][]byte{
+		gzipLayer(t,
+			tarEntry{name: "escape", link: outside},
+			tarEntry{name: "up", link: "../../../../" + outside},
+		),
+		gzipLayer(t,
+			tarEntry{name: "escape/abs", content: "x"},
+			tarEntry{name: "up/rel", content: "x"},
+			tarEntry{name: "../../dotdot", content: "x"},
+		),
+	}
+	for _, layer := range layers {
+		if err := unpackLayer(strings.NewReader(string(layer)), rootfs); err != nil {
+			t.Fatalf("unpackLayer: %v", err)
+		}
+	}
+
+	entries, _ := os.ReadDir(outside)
+	if len(entries) > 0 {
+		t.Fatalf("layer wrote outside the rootfs: %v", entries)
+	}
+	for _, name := range []string{outside + "/abs", outside + "/rel", "dotdot"} {
+		if _, err := os.Stat(filepath.Join(rootfs, name)); err != nil {
+			t.Errorf("%s not in rootfs: %v", name, err)
+		}
+	}
+}
+
+func TestProcessSpec(t *testing.T) {
+	config := imageConfig{
+		Entrypoint: []string{"/bin/app"},
+		Cmd:        []string{"--serve"},
+		Env:        []string{"PATH=/bin", "MODE=prod"},
+	}
+	tests := []struct {
+		name        string
+		pod         types.PodSpec
+		config      imageConfig
+		wantCommand []string
+		wantEnv     map[string]string
+	}{
+		{
+			name:        "image defaults",
+			config:      config,
+			wantCommand: []string{"/bin/app", "--serve"},
+			wantEnv:     map[string]string{"PATH": "/bin", "MODE": "prod"},
+		},
+		{
+			name:        "pod overrides",
+			pod:         types.PodSpec{Command: []string{"sh"}, Env: map[string]string{"MODE": "dev"}},
+			config:      config,
+			wantCommand: []string{"sh"},
+			wantEnv:     map[string]string{"PATH": "/bin", "MODE": "dev"},
+		},
+		{
+			name:        "nothing to run",
+			wantCommand: []string{"/bin/sh"},
+			wantEnv:     map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, env := processSpec(tt.pod, tt.config)
+			if !reflect.DeepEqual(command, tt.wantCommand) {
+				t.Errorf("got command %v, want %v", command, tt.wantCommand)
+			}
+			if !reflect.DeepEqual(env, tt.wantEnv) {
+				t.Errorf("got env %v, want %v", env, tt.wantEnv)
+			}
+		})
+	}
+}
+
+func TestLookupUser(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	passwd := "root:x:0:0:root:/root:/bin/sh\napp:x:1000:1000::/home/app:/bin/sh\n"
+	group := "root:x:0:\napp:x:1000:\nwheel:x:10:root,app\nstaff:x:50:app\n"
+	for name, content := range map[string]string{"passwd": passwd, "group": group} {
+		if err := os.WriteFile(filepath.Join(root, "etc", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		spec    string
+		uid     uint32
+		gid     uint32
+		groups  []uint32
+		wantErr bool
+	}{
+		{spec: "app", uid: 1000, gid: 1000, groups: []uint32{10, 50}},
+		{spec: "1000", uid: 1000, gid: 1000, groups: []uint32{10, 50}},
+		{spec: "app:staff", uid: 1000, gid: 50, groups: []uint32{10, 50}},
+		{spec: "2000", uid: 2000, gid: 0},
+		{spec: "2000:3000", uid: 2000, gid: 3000},
+		{spec: "nobody", wantErr: true},
+		{spec: "app:nogroup", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			cred, err := lookupUser(root, tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookupUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if cred.Uid != tt.uid || cred.Gid != tt.gid || !reflect.DeepEqual(cred.Groups, tt.groups) {
+				t.Errorf("got %d:%d %v, want %d:%d %v", cred.Uid, cred.Gid, cred.Groups, tt.uid, tt.gid, tt.groups)
+			}
+		})
+	}
+
+	if cred, err := lookupUser(root, ""); cred != nil || err != nil {
+		t.Errorf("empty user: got %+v, %v, want root", cred, err)
+	}
+}
+
+func TestSetupRootfs(t *testing.T) {
+	skipIfNotRoot(t)
+	reg := newTestRegistry(t)
+	_, _, files := pushLayeredImage(t, reg)
+	im := newImageManager(t.TempDir(), []string{reg.host()})
+	img, err := im.ensureImage(reg.host() + "/team/app:v1")
+	if err != nil {
+		t.Fatalf("ensureImage: %v", err)
+	}
+
+	containerDir := t.TempDir()
+	rootfs, err := setupRootfs(containerDir, img.Layers)
+	if err != nil {
+		t.Fatalf("setupRootfs: %v", err)
+	}
+	t.Cleanup(func() { _ = unmountRootfs(rootfs) })
+	checkFiles(t, rootfs, files)
+
+	// what the container writes stays out of the image
+	if err := os.WriteFile(filepath.Join(rootfs, "bin", "app"), []byte("changed"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.Remove(filepath.Join(rootfs, "cache", "c")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	fresh := t.TempDir()
+	if err := copyLayers(img.Layers, fresh); err != nil {
+		t.Fatalf("copyLayers: %v", err)
+	}
+	checkFiles(t, fresh, files)
+
+	if err := removeContainerDir(containerDir); err != nil {
+		t.Fatalf("removeContainerDir: %v", err)
+	}
+	if _, err := os.Stat(containerDir); !os.IsNotExist(err) {
+		t.Errorf("container dir still there: %v", err)
+	}
+	for _, layer := range img.Layers {
+		if _, err := os.Stat(layer); err != nil {
+			t.Errorf("layer %s gone with the container: %v", layer, err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ipam hands out the addresses of a pod CIDR. Every address in use is a file
+// named after it holding the ID of its container, so allocations survive a
+// restart of the kubelet:
+//
+//	<root>/network/<cidr>/10.244.0.2      "3f2a9c0e71bd"
+//	<root>/network/<cidr>/last_reserved   "10.244.0.2"
+//
+// Allocation goes round from the last address handed out, so one that was
+// just released isn't given to the next pod straight away.
+type ipam struct {
+	dir     string
+	subnet  *net.IPNet
+	gateway net.IP
+
+	mu sync.Mutex
+}
+
+func newIPAM(rootDir string, subnet *net.IPNet) (*ipam, error) {
+	if subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("pod CIDR %s: only IPv4 is supported", subnet)
+	}
+	if ones, bits := subnet.Mask.Size(); bits-ones < 2 {
+		return nil, fmt.Errorf("pod CIDR %s is too small", subnet)
+	}
+	dir := filepath.Join(rootDir, "network", strings.ReplaceAll(subnet.String(), "/", "-"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create ipam dir: %w", err)
+	}
+	return &ipam{dir: dir, subnet: subnet, gateway: nthIP(subnet, 1)}, nil
+}
+
+// allocate reserves an address for a container. A container that has one
+// already gets it back.
+func (a *ipam) allocate(id string) (net.IP, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	owned, err := a.owned()
+	if err != nil {
+		return nil, err
+	}
+	for ip, owner := range owned {
+		if owner == id {
+			return net.ParseIP(ip).To4(), nil
+		}
+	}
+
+	// the network address, the gateway and the broadcast address are
+	// never handed out
+	first, last := uint32(2), a.size()-2
+	start := first
+	if data, err := os.ReadFile(filepath.Join(a.dir, "last_reserved")); err == nil {
+		if ip := net.ParseIP(strings.TrimSpace(string(data))).To4(); ip != nil && a.subnet.Contains(ip) {
+			start = max(first, a.offset(ip)+1)
+		}
+	}
+	for i := range last - first + 1 {
+		n := first + (start-first+i)%(last-first+1)
+		ip := nthIP(a.subnet, n)
+		f, err := os.OpenFile(filepath.Join(a.dir, ip.String()), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reserve %s: %w", ip, err)
+		}
+		_, err = f.WriteString(id)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(f.Name())
+			return nil, fmt.Errorf("reserve %s: %w", ip, err)
+		}
+		_ = os.WriteFile(filepath.Join(a.dir, "last_reserved"), []byte(ip.String()), 0644)
+		return ip, nil
+	}
+	return nil, fmt.Errorf("no addresses left in %s", a.subnet)
+}
+
+// release frees the address of a container, if it has one.
+func (a *ipam) release(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	owned, err := a.owned()
+	if err != nil {
+		return err
+	}
+	for ip, owner := range owned {
+		if owner != id {
+			continue
+		}
+		if err := os.Remove(filepath.Join(a.dir, ip)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("release %s: %w", ip, err)
+		}
+	}
+	return nil
+}
+
+// releaseUnless frees the addresses of containers that keep doesn't know,
+// those left behind by containers removed while we weren't running.
+func (a *ipam) releaseUnless(keep func(id string) bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	owned, err := a.owned()
+	if err != nil {
+		return err
+	}
+	for ip, owner := range owned {
+		if keep(owner) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(a.dir, ip)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("release %s: %w", ip, err)
+		}
+	}
+	return nil
+}
+
+// owned maps the reserved addresses to their containers.
+func (a *ipam) owned() (map[string]string, error) {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, err
+	}
+	owned := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if net.ParseIP(entry.Name()) == nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(a.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		owned[entry.Name()] = strings.TrimSpace(string(data))
+	}
+	return owned, nil
+}
+
+func (a *ipam) size() uint32 {
+	ones, bits := a.subnet.Mask.Size()
+	return 1 << (bits - ones)
+}
+
+func (a *ipam) offset(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4()) - binary.BigEndian.Uint32(a.subnet.IP.To4())
+}
+
+// nthIP is the address n past the start of subnet.
+func nthIP(subnet *net.IPNet, n uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(subnet.IP.To4())+n)
+	return ip
+}
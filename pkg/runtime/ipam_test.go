@@ -0,0 +1,101 @@
+package runtime
+
+import (
+	"net"
+	"testing"
+)
+
+func newTestIPAM(t *testing.T, root, cidr string) *ipam {
+	t.Helper()
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := newIPAM(root, subnet)
+	if err != nil {
+		t.Fatalf("newIPAM: %v", err)
+	}
+	return a
+}
+
+func TestIPAM(t *testing.T) {
+	root := t.TempDir()
+	a := newTestIPAM(t, root, "10.244.0.0/29")
+
+	// .0 is the network, .1 the gateway and .7 the broadcast address
+	want := []string{"10.244.0.2", "10.244.0.3", "10.244.0.4", "10.244.0.5", "10.244.0.6"}
+	ids := []string{"a", "b", "c", "d", "e"}
+	for i, id := range ids {
+		ip, err := a.allocate(id)
+		if err != nil {
+			t.Fatalf("allocate %s: %v", id, err)
+		}
+		if ip.String() != want[i] {
+			t.Errorf("allocate %s = %s, want %s", id, ip, want[i])
+		}
+	}
+	if _, err := a.allocate("f"); err == nil {
+		t.Error("expected the range to be exhausted")
+	}
+
+	// a container asking again keeps its address
+	if ip, err := a.allocate("c"); err != nil || ip.String() != "10.244.0.4" {
+		t.Errorf("allocate c again = %s, %v, want 10.244.0.4", ip, err)
+	}
+
+	// allocations are on disk, and a released address is handed out again
+	a = newTestIPAM(t, root, "10.244.0.0/29")
+	if err := a.release("b"); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if ip, err := a.allocate("f"); err != nil || ip.String() != "10.244.0.3" {
+		t.Errorf("allocate f = %s, %v, want 10.244.0.3", ip, err)
+	}
+}
+
+func TestIPAMRoundRobin(t *testing.T) {
+	a := newTestIPAM(t, t.TempDir(), "10.244.0.0/24")
+	first, err := a.allocate("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.release("a"); err != nil {
+		t.Fatal(err)
+	}
+	// the next pod doesn't get the address of the one that just left
+	next, err := a.allocate("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Equal(first) {
+		t.Errorf("got %s again straight after releasing it", next)
+	}
+}
+
+func TestIPAMReleaseUnless(t *testing.T) {
+	a := newTestIPAM(t, t.TempDir(), "10.244.0.0/24")
+	for _, id := range []string{"live", "gone"} {
+		if _, err := a.allocate(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.releaseUnless(func(id string) bool { return id == "live" }); err != nil {
+		t.Fatalf("releaseUnless: %v", err)
+	}
+	owned, err := a.owned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owned) != 1 || owned["10.244.0.2"] != "live" {
+		t.Errorf("got %v, want only live's address", owned)
+	}
+}
+
+func TestNewIPAMRejects(t *testing.T) {
+	for _, cidr := range []string{"fd00::/64", "10.0.0.0/31"} {
+		_, subnet, _ := net.ParseCIDR(cidr)
+		if _, err := newIPAM(t.TempDir(), subnet); err == nil {
+			t.Errorf("%s: expected an error", cidr)
+		}
+	}
+}
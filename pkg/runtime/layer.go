@@ -0,0 +1,258 @@
+package runtime
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Layers delete what lower layers put there with whiteout files: .wh.<name>
+// removes <name> next to it, .wh..wh..opq hides everything lower layers had
+// in its directory. Every layer is unpacked into a directory of its own in
+// the format overlayfs understands, a whiteout becomes a 0/0 character device
+// and an opaque directory gets the opaque xattr.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+
+	overlayOpaqueXattr = "trusted.overlay.opaque"
+)
+
+// maxSymlinkHops bounds how many symlinks resolving a path inside a rootfs
+// follows.
+const maxSymlinkHops = 255
+
+// unpackLayer unpacks a layer tarball, gzipped or not, into the empty
+// directory dst.
+func unpackLayer(r io.Reader, dst string) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	var tr *tar.Reader
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = gz.Close() }()
+		tr = tar.NewReader(gz)
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return errors.New("zstd compressed layers are not supported")
+	default:
+		tr = tar.NewReader(br)
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		dir, base := path.Split(name)
+
+		switch {
+		case base == whiteoutOpaque:
+			err = markOpaque(dst, dir)
+		case strings.HasPrefix(base, whiteoutPrefix):
+			err = writeWhiteout(dst, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+		default:
+			err = extractTarEntry(hdr, tr, dst, name)
+		}
+		if err != nil {
+			return fmt.Errorf("extract %s: %w", name, err)
+		}
+	}
+}
+
+// markOpaque hides what lower layers have in dir.
+func markOpaque(dst, dir string) error {
+	target, err := securePath(dst, dir, true)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	return unix.Lsetxattr(target, overlayOpaqueXattr, []byte("y"), 0)
+}
+
+// writeWhiteout hides name of lower layers.
+func writeWhiteout(dst, name string) error {
+	target, err := securePath(dst, name, false)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	return unix.Mknod(target, unix.S_IFCHR, 0)
+}
+
+func isWhiteout(info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+func isOpaque(path string) bool {
+	buf := make([]byte, 1)
+	n, err := unix.Lgetxattr(path, overlayOpaqueXattr, buf)
+	return err == nil && n == 1 && buf[0] == 'y'
+}
+
+// extractTarEntry writes one entry of a layer to name inside dst.
+func extractTarEntry(hdr *tar.Header, r io.Reader, dst, name string) error {
+	switch hdr.Typeflag {
+	case tar.TypeDir, tar.TypeReg, tar.TypeSymlink, tar.TypeLink, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+	default:
+		return nil
+	}
+
+	target, err := securePath(dst, name, false)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	mode := hdr.FileInfo().Mode()
+	existing, err := os.Lstat(target)
+	switch {
+	case err == nil && existing.IsDir() && hdr.Typeflag == tar.TypeDir:
+		// directories merge with the ones below
+	case err == nil:
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+	case tar.TypeReg:
+		if err := extractRegularFile(target, r); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		return lchown(os.Symlink(hdr.Linkname, target), target, hdr)
+	case tar.TypeLink:
+		source, err := securePath(dst, path.Clean("/"+hdr.Linkname), true)
+		if err != nil {
+			return err
+		}
+		return os.Link(source, target)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		devMode := uint32(unix.S_IFIFO)
+		if hdr.Typeflag == tar.TypeChar {
+			devMode = unix.S_IFCHR
+		} else if hdr.Typeflag == tar.TypeBlock {
+			devMode = unix.S_IFBLK
+		}
+		dev := int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))
+		if err := unix.Mknod(target, devMode|uint32(mode.Perm()), dev); err != nil {
+			return err
+		}
+	}
+
+	if err := lchown(nil, target, hdr); err != nil {
+		return err
+	}
+	// after the chown, which clears setuid and setgid, and unaffected by
+	// the umask
+	return os.Chmod(target, mode.Perm()|mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+}
+
+// lchown gives target the entry's owner when we are allowed to, unless err
+// already failed creating it.
+func lchown(err error, target string, hdr *tar.Header) error {
+	if err != nil || os.Getuid() != 0 {
+		return err
+	}
+	return os.Lchown(target, hdr.Uid, hdr.Gid)
+}
+
+func extractRegularFile(target string, r io.Reader) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// securePath resolves name inside root the way it would resolve with root
+// as /, so symlinks a layer planted can't point a later entry outside of
+// it. The last element is only followed when followLast is set.
+func securePath(root, name string, followLast bool) (string, error) {
+	parts := strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/")
+	resolved := "/"
+	hops := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, part)
+		if len(parts) == 0 && !followLast {
+			resolved = next
+			break
+		}
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			// what doesn't exist yet can't be a symlink
+			resolved = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", fmt.Errorf("too many symlinks resolving %s", name)
+		}
+		link, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(link) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(link, "/"), parts...)
+	}
+	return filepath.Join(root, resolved), nil
+}
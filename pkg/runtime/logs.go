@@ -0,0 +1,413 @@
+package runtime
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"miniku/pkg/types"
+)
+
+// Container output goes through a pipe to a logger process, which writes it
+// to <root>/logs/<pod>/<container id>.log as lines of
+//
+//	<RFC3339Nano timestamp> <stdout|stderr> <line>
+//
+// and rotates the file to .log.1 once it grows over the max size. The logger
+// runs in its own session so it outlives a restart of the kubelet, just like
+// the container does. The log of a removed container is kept as the pod's
+// previous log until the pod's next container replaces it.
+
+// DefaultLogMaxSize is the size a log file rotates at.
+const DefaultLogMaxSize = 10 << 20
+
+const logFollowInterval = 100 * time.Millisecond
+
+type LogOptions struct {
+	// Follow keeps streaming new output until the container exits or the
+	// context is done.
+	Follow bool
+	// TailLines only returns that many of the last lines, 0 means all.
+	TailLines int
+	// Timestamps prefixes every line with the time it was written.
+	Timestamps bool
+}
+
+type loggerConfig struct {
+	Path    string `json:"path"`
+	MaxSize int64  `json:"max_size"`
+}
+
+// startLogger starts the logger process for a log file and returns the
+// write ends of the pipes for the container's stdout and stderr. The logger
+// exits once both are closed by everyone holding them.
+func startLogger(path string, maxSize int64) (stdout, stderr *os.File, err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, nil, fmt.Errorf("create log dir: %w", err)
+	}
+	// the log is there to be read as soon as the container runs, whether
+	// or not the logger got to it yet
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create log: %w", err)
+	}
+	_ = f.Close()
+
+	configJSON, err := json.Marshal(loggerConfig{Path: path, MaxSize: maxSize})
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal logger config: %w", err)
+	}
+
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		_ = outR.Close()
+		_ = outW.Close()
+		return nil, nil, err
+	}
+
+	cmd := exec.Command("/proc/self/exe")
+	cmd.Env = []string{"MINIKU_LOGGER=" + string(configJSON)}
+	cmd.ExtraFiles = []*os.File{outR, errR}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	// the logger has its own copies of the read ends now
+	_ = outR.Close()
+	_ = errR.Close()
+	if err != nil {
+		_ = outW.Close()
+		_ = errW.Close()
+		return nil, nil, fmt.Errorf("start logger: %w", err)
+	}
+	go func() { _ = cmd.Wait() }()
+
+	return outW, errW, nil
+}
+
+// runLogger is the logger process, it copies the container's stdout (fd 3)
+// and stderr (fd 4) into the log file.
+func runLogger(config string) {
+	var cfg loggerConfig
+	if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "logger: decode config: %v\n", err)
+		os.Exit(1)
+	}
+
+	lw, err := newLogWriter(cfg.Path, cfg.MaxSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "logger: %v\n", err)
+		os.Exit(1)
+	}
+
+	var wg sync.WaitGroup
+	for stream, fd := range map[string]uintptr{"stdout": 3, "stderr": 4} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = lw.copyFrom(stream, os.NewFile(fd, stream))
+		}()
+	}
+	wg.Wait()
+	_ = lw.Close()
+	os.Exit(0)
+}
+
+// logWriter writes timestamped lines to a log file, rotating it at maxSize.
+type logWriter struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	f       *os.File
+	size    int64
+}
+
+func newLogWriter(path string, maxSize int64) (*logWriter, error) {
+	lw := &logWriter{path: path, maxSize: maxSize}
+	if err := lw.open(); err != nil {
+		return nil, err
+	}
+	return lw, nil
+}
+
+func (lw *logWriter) open() error {
+	f, err := os.OpenFile(lw.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("stat log: %w", err)
+	}
+	lw.f, lw.size = f, info.Size()
+	return nil
+}
+
+// copyFrom writes r line by line until it hits EOF.
+func (lw *logWriter) copyFrom(stream string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			if werr := lw.writeLine(stream, strings.TrimSuffix(line, "\n")); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (lw *logWriter) writeLine(stream, line string) error {
+	entry := time.Now().UTC().Format(time.RFC3339Nano) + " " + stream + " " + line + "\n"
+
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	if lw.maxSize > 0 && lw.size > 0 && lw.size+int64(len(entry)) > lw.maxSize {
+		if err := lw.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := lw.f.WriteString(entry)
+	lw.size += int64(n)
+	return err
+}
+
+// rotate moves the current file to .1, replacing an older one.
+func (lw *logWriter) rotate() error {
+	if err := lw.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(lw.path, lw.path+".1"); err != nil {
+		return fmt.Errorf("rotate log: %w", err)
+	}
+	return lw.open()
+}
+
+func (lw *logWriter) Close() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.f.Close()
+}
+
+// readLog writes the log at path to w as opts ask. When following, exited
+// reports whether the container is done writing.
+func readLog(ctx context.Context, path string, opts LogOptions, w io.Writer, exited func() bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	// the rotated file comes first, then what is in the current one
+	var lines []string
+	if rotated, err := os.ReadFile(path + ".1"); err == nil {
+		lines = splitLines(string(rotated))
+	}
+	br := bufio.NewReader(f)
+	current, partial, err := readLines(br)
+	if err != nil {
+		return err
+	}
+	lines = append(lines, current...)
+
+	if opts.TailLines > 0 && len(lines) > opts.TailLines {
+		lines = lines[len(lines)-opts.TailLines:]
+	}
+	for _, line := range lines {
+		if err := writeLogLine(w, line, opts.Timestamps); err != nil {
+			return err
+		}
+	}
+	if !opts.Follow {
+		return nil
+	}
+
+	for {
+		done := exited()
+		more, rest, err := readLines(br)
+		if err != nil {
+			return err
+		}
+		if len(more) > 0 {
+			more[0] = partial + more[0]
+			partial = rest
+		} else {
+			partial += rest
+		}
+		for _, line := range more {
+			if err := writeLogLine(w, line, opts.Timestamps); err != nil {
+				return err
+			}
+		}
+		if len(more) > 0 {
+			continue
+		}
+
+		// the writer moved on to a new file, carry on there once this one
+		// is drained
+		if info, err := os.Stat(path); err == nil {
+			if current, err := f.Stat(); err == nil && !os.SameFile(info, current) {
+				next, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				_ = f.Close()
+				f, br, partial = next, bufio.NewReader(next), ""
+				continue
+			}
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(logFollowInterval):
+		}
+	}
+}
+
+// readLines reads the complete lines available from br, and what there is
+// of a line still being written.
+func readLines(br *bufio.Reader) (lines []string, partial string, err error) {
+	for {
+		line, err := br.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			return lines, line, nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// writeLogLine writes a stored line, without its timestamp unless asked for
+// and always without the stream.
+func writeLogLine(w io.Writer, line string, timestamps bool) error {
+	timestamp, rest, _ := strings.Cut(line, " ")
+	_, msg, _ := strings.Cut(rest, " ")
+	if timestamps {
+		msg = timestamp + " " + msg
+	}
+	_, err := io.WriteString(w, msg+"\n")
+	return err
+}
+
+// ErrNoLogs is returned by Logs for a container without a log.
+var ErrNoLogs = errors.New("no logs")
+
+func (nr *NamespaceRuntime) Logs(ctx context.Context, containerID string, opts LogOptions, w io.Writer) error {
+	nr.mu.Lock()
+	cp, ok := nr.containers[containerID]
+	var path string
+	if ok {
+		path = cp.LogPath
+	}
+	nr.mu.Unlock()
+
+	exited := func() bool { return true }
+	if ok {
+		exited = func() bool {
+			state, err := nr.GetStatus(containerID)
+			return err != nil || state.Status == types.ContainerStatusExited
+		}
+	} else {
+		// a removed container's log is kept as its pod's previous one
+		matches, _ := filepath.Glob(filepath.Join(nr.rootDir, "logs", "*", containerID+".log"))
+		if len(matches) > 0 {
+			path = matches[0]
+		}
+	}
+	if path == "" {
+		return fmt.Errorf("container %s: %w", containerID, ErrNoLogs)
+	}
+
+	err := readLog(ctx, path, opts, w, exited)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("container %s: %w", containerID, ErrNoLogs)
+	}
+	return err
+}
+
+// RemoveLogs deletes the logs of a pod that is gone.
+func (nr *NamespaceRuntime) RemoveLogs(namespace, podName string) error {
+	if podName == "" {
+		return nil
+	}
+	return os.RemoveAll(nr.podLogDir(namespace, podName, ""))
+}
+
+// podLogDir is where the logs of a pod's containers go, <namespace>_<pod>
+// under logs. A container without a pod gets one of its own.
+func (nr *NamespaceRuntime) podLogDir(namespace, podName, id string) string {
+	if podName == "" {
+		return filepath.Join(nr.rootDir, "logs", id)
+	}
+	return filepath.Join(nr.rootDir, "logs", cmp.Or(namespace, types.DefaultNamespace)+"_"+podName)
+}
+
+// pruneLogs deletes the logs of removed containers in a pod's log directory,
+// except for the most recent one which stays around as the previous log.
+func (nr *NamespaceRuntime) pruneLogs(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	nr.mu.Lock()
+	var removed []os.FileInfo
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".log")
+		if !ok {
+			continue
+		}
+		if _, live := nr.containers[id]; live {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			removed = append(removed, info)
+		}
+	}
+	nr.mu.Unlock()
+
+	slices.SortFunc(removed, func(a, b os.FileInfo) int { return b.ModTime().Compare(a.ModTime()) })
+	for i, info := range removed {
+		if i == 0 {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		_ = os.Remove(path)
+		_ = os.Remove(path + ".1")
+	}
+}
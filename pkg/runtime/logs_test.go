@@ -0,0 +1,130 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeLines(t *testing.T, lw *logWriter, stream string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if err := lw.writeLine(stream, line); err != nil {
+			t.Fatalf("writeLine: %v", err)
+		}
+	}
+}
+
+func TestReadLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c1.log")
+	lw, err := newLogWriter(path, 0)
+	if err != nil {
+		t.Fatalf("newLogWriter: %v", err)
+	}
+	writeLines(t, lw, "stdout", "one", "two")
+	writeLines(t, lw, "stderr", "oops")
+	writeLines(t, lw, "stdout", "three")
+	_ = lw.Close()
+
+	tests := []struct {
+		name string
+		opts LogOptions
+		want string
+	}{
+		{"everything", LogOptions{}, "one\ntwo\noops\nthree\n"},
+		{"tail", LogOptions{TailLines: 2}, "oops\nthree\n"},
+		{"tail more than there is", LogOptions{TailLines: 10}, "one\ntwo\noops\nthree\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := readLog(context.Background(), path, tt.opts, &buf, nil); err != nil {
+				t.Fatalf("readLog: %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := readLog(context.Background(), path, LogOptions{Timestamps: true, TailLines: 1}, &buf, nil); err != nil {
+		t.Fatalf("readLog: %v", err)
+	}
+	timestamp, msg, _ := strings.Cut(strings.TrimSpace(buf.String()), " ")
+	if _, err := time.Parse(time.RFC3339Nano, timestamp); err != nil || msg != "three" {
+		t.Errorf("got %q, want a timestamp followed by three", buf.String())
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c1.log")
+	// room for about two lines per file
+	lw, err := newLogWriter(path, 100)
+	if err != nil {
+		t.Fatalf("newLogWriter: %v", err)
+	}
+	writeLines(t, lw, "stdout", "line-1", "line-2", "line-3", "line-4", "line-5")
+	_ = lw.Close()
+
+	for _, p := range []string{path, path + ".1"} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("stat %s: %v", p, err)
+		}
+		if info.Size() > 100 {
+			t.Errorf("%s is %d bytes, want at most 100", p, info.Size())
+		}
+	}
+
+	// the oldest lines are rotated away, the rest read back in order
+	var buf bytes.Buffer
+	if err := readLog(context.Background(), path, LogOptions{}, &buf, nil); err != nil {
+		t.Fatalf("readLog: %v", err)
+	}
+	if got := buf.String(); !strings.HasSuffix(got, "line-4\nline-5\n") || strings.Contains(got, "line-1") {
+		t.Errorf("got %q, want the newest lines without line-1", got)
+	}
+}
+
+func TestFollowLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c1.log")
+	// two lines per file, so the follower sees the file rotate once
+	lw, err := newLogWriter(path, 250)
+	if err != nil {
+		t.Fatalf("newLogWriter: %v", err)
+	}
+	padding := strings.Repeat(".", 60)
+	writeLines(t, lw, "stdout", "before"+padding)
+
+	var exited atomic.Bool
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		writeLines(t, lw, "stdout", "after-1"+padding, "after-2"+padding, "after-3"+padding)
+		_ = lw.Close()
+		exited.Store(true)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var buf bytes.Buffer
+	if err := readLog(ctx, path, LogOptions{Follow: true}, &buf, exited.Load); err != nil {
+		t.Fatalf("readLog: %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("expected following to end when the container exited")
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Error("expected the log to have rotated while being followed")
+	}
+
+	want := strings.Join([]string{"before", "after-1", "after-2", "after-3"}, padding+"\n") + padding + "\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -19,8 +22,31 @@ import (
 type NamespaceRuntime struct {
 	mu         sync.Mutex
 	containers map[string]*containerProcess
-	rootDir    string
-	images     *imageManager
+	// starting are the containers Run is setting up, recovery must not
+	// take their half-made directories for leftovers
+	starting map[string]bool
+	rootDir  string
+	// InsecureRegistries are registry hosts (host[:port]) images are pulled
+	// from over plain HTTP. Set it before the first Run.
+	InsecureRegistries []string
+	images             *imageManager
+	// CgroupSlice is the cgroup v2 slice containers get their cgroup in,
+	// relative to /sys/fs/cgroup. Set it before the first Run.
+	CgroupSlice string
+	cgroups     *cgroupManager
+	// LogMaxSize is the size container logs rotate at.
+	LogMaxSize int64
+	// PodCIDR is the range pod IPs are allocated from, every pod then gets
+	// a network namespace of its own on BridgeName. Empty leaves pods on the
+	// node's network. Set it before the first Run.
+	PodCIDR string
+	network *networkManager
+	// ClusterDNS are the name servers containers resolve with, searching
+	// ClusterDomain. Without any, pods on the pod network use the node's
+	// DNS server on the bridge and the others get the node's resolv.conf.
+	// Set them before the first Run.
+	ClusterDNS    []string
+	ClusterDomain string
 }
 
 func NewNamespaceRuntime(rootDir string) (*NamespaceRuntime, error) {
@@ -30,14 +56,16 @@ func NewNamespaceRuntime(rootDir string) (*NamespaceRuntime, error) {
 	}
 
 	return &NamespaceRuntime{
-		containers: make(map[string]*containerProcess),
-		rootDir:    rootDir,
-		images:     newImageManager(rootDir),
+		containers:  make(map[string]*containerProcess),
+		starting:    make(map[string]bool),
+		rootDir:     rootDir,
+		CgroupSlice: DefaultCgroupSlice,
+		LogMaxSize:  DefaultLogMaxSize,
 	}, nil
 }
 
 func (nr *NamespaceRuntime) Run(pod types.PodSpec) (string, error) {
-	imgRootFS, err := nr.images.ensureImage(pod.Image)
+	img, err := nr.imageManager().ensureImage(pod.Image)
 	if err != nil {
 		return "", fmt.Errorf("ensure image: %w", err)
 	}
@@ -47,22 +75,51 @@ func (nr *NamespaceRuntime) Run(pod types.PodSpec) (string, error) {
 		return "", fmt.Errorf("generate id: %w", err)
 	}
 
-	containerDir := filepath.Join(nr.rootDir, "containers", id)
-	rootfs := filepath.Join(containerDir, "rootfs")
+	nr.mu.Lock()
+	nr.starting[id] = true
+	nr.mu.Unlock()
+	defer func() {
+		nr.mu.Lock()
+		delete(nr.starting, id)
+		nr.mu.Unlock()
+	}()
 
+	containerDir := filepath.Join(nr.rootDir, "containers", id)
 	if err := os.MkdirAll(containerDir, 0755); err != nil {
 		return "", fmt.Errorf("create container dir: %w", err)
 	}
 
-	log.Printf("runtime: copying rootfs for container %s...", id)
-	if err := copyDir(imgRootFS, rootfs); err != nil {
-		_ = os.RemoveAll(containerDir)
-		return "", fmt.Errorf("copy rootfs: %w", err)
+	rootfs, err := setupRootfs(containerDir, img.Layers)
+	if err != nil {
+		_ = removeContainerDir(containerDir)
+		return "", err
+	}
+
+	network, err := nr.networkManager()
+	if err != nil {
+		_ = removeContainerDir(containerDir)
+		return "", err
+	}
+
+	podLogDir := nr.podLogDir(pod.Namespace, pod.Name, id)
+	nr.pruneLogs(podLogDir)
+	logPath := filepath.Join(podLogDir, id+".log")
+	stdout, stderr, err := startLogger(logPath, nr.LogMaxSize)
+	if err != nil {
+		_ = removeContainerDir(containerDir)
+		return "", err
 	}
 
-	cp, err := nr.startChild(pod, id, containerDir, rootfs)
+	cp, err := nr.startChild(pod, img.Config, id, containerDir, rootfs, logPath, stdout, stderr, network)
+	// the child holds the pipes now, the logger stops when it is done
+	_ = stdout.Close()
+	_ = stderr.Close()
 	if err != nil {
-		_ = os.RemoveAll(containerDir)
+		if network != nil {
+			_ = network.detach(id)
+		}
+		_ = removeContainerDir(containerDir)
+		_ = os.Remove(logPath)
 		return "", err
 	}
 
@@ -74,33 +131,63 @@ func (nr *NamespaceRuntime) Run(pod types.PodSpec) (string, error) {
 	return id, nil
 }
 
-func (nr *NamespaceRuntime) startChild(pod types.PodSpec, id, containerDir, rootfs string) (*containerProcess, error) {
-	command := pod.Command
+// processSpec fills in what the pod leaves out from the image: the command
+// defaults to the image's entrypoint and cmd, and the pod's env goes on top
+// of the image's.
+func processSpec(pod types.PodSpec, config imageConfig) (command []string, env map[string]string) {
+	command = pod.Command
+	if len(command) == 0 {
+		command = append(slices.Clone(config.Entrypoint), config.Cmd...)
+	}
 	if len(command) == 0 {
 		command = []string{"/bin/sh"}
 	}
 
+	env = make(map[string]string, len(config.Env)+len(pod.Env))
+	for _, kv := range config.Env {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	maps.Copy(env, pod.Env)
+	return command, env
+}
+
+func (nr *NamespaceRuntime) startChild(pod types.PodSpec, config imageConfig, id, containerDir, rootfs, logPath string, stdout, stderr *os.File, network *networkManager) (*containerProcess, error) {
+	command, env := processSpec(pod, config)
+
 	hostname := pod.Name
 	if hostname == "" {
 		hostname = id
 	}
 
 	configJSON, err := json.Marshal(childConfig{
-		RootFS:   rootfs,
-		Hostname: hostname,
-		Command:  command,
-		Env:      pod.Env,
+		RootFS:     rootfs,
+		Hostname:   hostname,
+		Command:    command,
+		Env:        env,
+		WorkingDir: config.WorkingDir,
+		User:       config.User,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("marshal config: %w", err)
 	}
 
+	cgroup, err := nr.cgroupManager().create(id, pod.Resources)
+	if err != nil {
+		return nil, err
+	}
+
 	cmd := exec.Command("/proc/self/exe")
 	cmd.Env = []string{"MINIKU_CHILD=1"}
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Cloneflags: syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS,
 	}
-	cmd.Stderr = os.Stderr
+	if network != nil {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -108,9 +195,40 @@ func (nr *NamespaceRuntime) startChild(pod types.PodSpec, id, containerDir, root
 	}
 
 	if err := cmd.Start(); err != nil {
+		_ = removeCgroup(cgroup)
 		return nil, fmt.Errorf("start child: %w", err)
 	}
 
+	// the child waits for its config, so nothing runs before it is in
+	// its cgroup
+	if cgroup != "" {
+		if err := addProcess(cgroup, cmd.Process.Pid); err != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			_ = removeCgroup(cgroup)
+			return nil, fmt.Errorf("join cgroup: %w", err)
+		}
+	}
+
+	// nor before its network is up
+	var podIP string
+	if network != nil {
+		podIP, err = network.attach(id, cmd.Process.Pid)
+		if err != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			_ = removeCgroup(cgroup)
+			return nil, err
+		}
+	}
+
+	if err := nr.writeNetworkFiles(rootfs, hostname, pod.Namespace, podIP, network); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		_ = removeCgroup(cgroup)
+		return nil, err
+	}
+
 	if _, err := stdin.Write(configJSON); err != nil {
 		_ = cmd.Process.Kill()
 		return nil, fmt.Errorf("write config: %w", err)
@@ -118,19 +236,33 @@ func (nr *NamespaceRuntime) startChild(pod types.PodSpec, id, containerDir, root
 	_ = stdin.Close()
 
 	cp := &containerProcess{
-		ID:     id,
-		Name:   pod.Name,
-		PID:    cmd.Process.Pid,
-		RootFS: rootfs,
-		Cmd:    cmd,
+		ID:         id,
+		Name:       pod.Name,
+		Namespace:  pod.Namespace,
+		PID:        cmd.Process.Pid,
+		RootFS:     rootfs,
+		Env:        env,
+		WorkingDir: config.WorkingDir,
+		User:       config.User,
+		Cgroup:     cgroup,
+		IP:         podIP,
+		LogPath:    logPath,
+		Cmd:        cmd,
 	}
 
 	if err := saveMeta(containerDir, containerMeta{
-		ID:        id,
-		Name:      pod.Name,
-		Image:     pod.Image,
-		PID:       cmd.Process.Pid,
-		CreatedAt: time.Now(),
+		ID:         id,
+		Name:       pod.Name,
+		Namespace:  pod.Namespace,
+		Image:      pod.Image,
+		PID:        cmd.Process.Pid,
+		Env:        env,
+		WorkingDir: config.WorkingDir,
+		User:       config.User,
+		Cgroup:     cgroup,
+		IP:         podIP,
+		LogPath:    logPath,
+		CreatedAt:  time.Now(),
 	}); err != nil {
 		_ = cmd.Process.Kill()
 		return nil, fmt.Errorf("save meta: %w", err)
@@ -142,6 +274,7 @@ func (nr *NamespaceRuntime) startChild(pod types.PodSpec, id, containerDir, root
 		nr.mu.Lock()
 		defer nr.mu.Unlock()
 		cp.Exited = true
+		cp.OOMKilled = oomKilled(cgroup)
 		if waitErr != nil {
 			if exitErr, ok := waitErr.(*exec.ExitError); ok {
 				cp.ExitCode = exitErr.ExitCode()
@@ -154,7 +287,7 @@ func (nr *NamespaceRuntime) startChild(pod types.PodSpec, id, containerDir, root
 	return cp, nil
 }
 
-func (nr *NamespaceRuntime) Stop(containerID string) error {
+func (nr *NamespaceRuntime) Stop(containerID string, gracePeriod time.Duration) error {
 	nr.mu.Lock()
 	cp, ok := nr.containers[containerID]
 	nr.mu.Unlock()
@@ -175,8 +308,8 @@ func (nr *NamespaceRuntime) Stop(containerID string) error {
 		return fmt.Errorf("sigterm: %w", err)
 	}
 
-	// wait up to 10s for graceful shutdown
-	deadline := time.After(10 * time.Second)
+	// give it the grace period to shut down
+	deadline := time.After(gracePeriod)
 	tick := time.NewTicker(100 * time.Millisecond)
 	defer tick.Stop()
 
@@ -208,8 +341,28 @@ func (nr *NamespaceRuntime) Remove(containerID string) error {
 	delete(nr.containers, containerID)
 	nr.mu.Unlock()
 
-	containerDir := filepath.Join(nr.rootDir, "containers", containerID)
-	if err := os.RemoveAll(containerDir); err != nil {
+	if ok {
+		if err := removeCgroup(cp.Cgroup); err != nil {
+			return err
+		}
+	}
+	if network, err := nr.networkManager(); err == nil && network != nil {
+		if err := network.detach(containerID); err != nil {
+			return err
+		}
+	}
+
+	return removeContainerDir(filepath.Join(nr.rootDir, "containers", containerID))
+}
+
+// removeContainerDir unmounts a container's rootfs and deletes what's left of
+// it. Deleting through a mounted rootfs would only fill the upper dir with
+// whiteouts.
+func removeContainerDir(dir string) error {
+	if err := unmountRootfs(filepath.Join(dir, "rootfs")); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
 		return fmt.Errorf("remove container dir: %w", err)
 	}
 	return nil
@@ -225,28 +378,73 @@ func (nr *NamespaceRuntime) GetStatus(containerID string) (*types.ContainerState
 	}
 
 	if cp.Exited {
-		return &types.ContainerState{
-			Status:   types.ContainerStatusExited,
-			ExitCode: cp.ExitCode,
-		}, nil
+		return exitedState(cp.ExitCode, cp.OOMKilled), nil
 	}
 
 	// probe if process is still alive
 	if err := syscall.Kill(cp.PID, 0); err != nil {
 		nr.mu.Lock()
 		cp.Exited = true
+		cp.ExitCode = -1
+		cp.OOMKilled = oomKilled(cp.Cgroup)
 		nr.mu.Unlock()
-		return &types.ContainerState{
-			Status:   types.ContainerStatusExited,
-			ExitCode: -1,
-		}, nil
+		return exitedState(cp.ExitCode, cp.OOMKilled), nil
 	}
 
 	return &types.ContainerState{
 		Status: types.ContainerStatusRunning,
+		IP:     cp.IP,
 	}, nil
 }
 
+func exitedState(exitCode int, oomKilled bool) *types.ContainerState {
+	state := &types.ContainerState{
+		Status:   types.ContainerStatusExited,
+		ExitCode: exitCode,
+	}
+	if oomKilled {
+		state.Reason = types.ContainerReasonOOMKilled
+	}
+	return state
+}
+
+// imageManager returns the manager for InsecureRegistries, made on first use
+// like cgroupManager.
+func (nr *NamespaceRuntime) imageManager() *imageManager {
+	nr.mu.Lock()
+	defer nr.mu.Unlock()
+	if nr.images == nil {
+		nr.images = newImageManager(nr.rootDir, nr.InsecureRegistries)
+	}
+	return nr.images
+}
+
+// networkManager returns the manager for PodCIDR, made on first use like
+// cgroupManager. It is nil without a PodCIDR.
+func (nr *NamespaceRuntime) networkManager() (*networkManager, error) {
+	nr.mu.Lock()
+	defer nr.mu.Unlock()
+	if nr.network == nil && nr.PodCIDR != "" {
+		network, err := newNetworkManager(nr.rootDir, nr.PodCIDR)
+		if err != nil {
+			return nil, err
+		}
+		nr.network = network
+	}
+	return nr.network, nil
+}
+
+// cgroupManager returns the manager for CgroupSlice, made on first use so
+// the slice can be changed after NewNamespaceRuntime.
+func (nr *NamespaceRuntime) cgroupManager() *cgroupManager {
+	nr.mu.Lock()
+	defer nr.mu.Unlock()
+	if nr.cgroups == nil {
+		nr.cgroups = newCgroupManager(cgroupMount, nr.CgroupSlice)
+	}
+	return nr.cgroups
+}
+
 func (nr *NamespaceRuntime) List() ([]ContainerInfo, error) {
 	nr.mu.Lock()
 	populated := len(nr.containers) > 0
@@ -264,8 +462,9 @@ func (nr *NamespaceRuntime) List() ([]ContainerInfo, error) {
 	var result []ContainerInfo
 	for _, cp := range nr.containers {
 		result = append(result, ContainerInfo{
-			ID:   cp.ID,
-			Name: cp.Name,
+			ID:        cp.ID,
+			Name:      cp.Name,
+			Namespace: cp.Namespace,
 		})
 	}
 	return result, nil
@@ -280,6 +479,12 @@ func (nr *NamespaceRuntime) recoverFromDisk() error {
 		}
 		return err
 	}
+	// the manager is made on the first Run, which after a restart may not
+	// have happened yet
+	network, err := nr.networkManager()
+	if err != nil {
+		log.Printf("runtime: pod network: %v", err)
+	}
 
 	nr.mu.Lock()
 	defer nr.mu.Unlock()
@@ -290,24 +495,53 @@ func (nr *NamespaceRuntime) recoverFromDisk() error {
 		}
 
 		id := entry.Name()
-		if _, exists := nr.containers[id]; exists {
+		if _, exists := nr.containers[id]; exists || nr.starting[id] {
 			continue
 		}
 
 		meta, err := loadMeta(filepath.Join(containersDir, id))
 		if err != nil {
-			log.Printf("runtime: skip container %s: %v", id, err)
+			// Run didn't get to start it, nothing will ever remove it
+			log.Printf("runtime: removing unfinished container %s: %v", id, err)
+			if err := removeContainerDir(filepath.Join(containersDir, id)); err != nil {
+				log.Printf("runtime: remove container %s: %v", id, err)
+			}
 			continue
 		}
 
 		alive := syscall.Kill(meta.PID, 0) == nil
+		if !alive {
+			// the rootfs of a container that exited while we were gone is
+			// only in the way until it's removed
+			if err := unmountRootfs(filepath.Join(containersDir, id, "rootfs")); err != nil {
+				log.Printf("runtime: container %s: %v", id, err)
+			}
+		}
 
 		nr.containers[id] = &containerProcess{
-			ID:     meta.ID,
-			Name:   meta.Name,
-			PID:    meta.PID,
-			RootFS: filepath.Join(containersDir, id, "rootfs"),
-			Exited: !alive,
+			ID:         meta.ID,
+			Name:       meta.Name,
+			Namespace:  meta.Namespace,
+			PID:        meta.PID,
+			RootFS:     filepath.Join(containersDir, id, "rootfs"),
+			Env:        meta.Env,
+			WorkingDir: meta.WorkingDir,
+			User:       meta.User,
+			Cgroup:     meta.Cgroup,
+			IP:         meta.IP,
+			LogPath:    meta.LogPath,
+			Exited:     !alive,
+		}
+	}
+
+	// addresses of containers whose directory is gone were never released
+	if network != nil {
+		err := network.ipam.releaseUnless(func(id string) bool {
+			_, known := nr.containers[id]
+			return known || nr.starting[id]
+		})
+		if err != nil {
+			log.Printf("runtime: release pod addresses: %v", err)
 		}
 	}
 
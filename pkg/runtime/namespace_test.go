@@ -28,32 +28,6 @@ func newTestRuntime(t *testing.T) *NamespaceRuntime {
 	return rt
 }
 
-func TestEnsureImage(t *testing.T) {
-	skipIfNotRoot(t)
-
-	dir := t.TempDir()
-	im := newImageManager(dir)
-
-	rootfs, err := im.ensureImage("alpine")
-	if err != nil {
-		t.Fatalf("ensureImage: %v", err)
-	}
-
-	// verify /bin/sh exists
-	if _, err := os.Stat(filepath.Join(rootfs, "bin", "sh")); err != nil {
-		t.Fatalf("rootfs missing /bin/sh: %v", err)
-	}
-
-	// second call should be cached (no download)
-	rootfs2, err := im.ensureImage("alpine")
-	if err != nil {
-		t.Fatalf("ensureImage (cached): %v", err)
-	}
-	if rootfs != rootfs2 {
-		t.Fatalf("expected same path, got %s vs %s", rootfs, rootfs2)
-	}
-}
-
 func TestRunAndGetStatus(t *testing.T) {
 	rt := newTestRuntime(t)
 
@@ -78,7 +52,7 @@ func TestRunAndGetStatus(t *testing.T) {
 	}
 
 	// cleanup
-	_ = rt.Stop(id)
+	_ = rt.Stop(id, 10*time.Second)
 	_ = rt.Remove(id)
 }
 
@@ -96,7 +70,7 @@ func TestStopContainer(t *testing.T) {
 
 	time.Sleep(200 * time.Millisecond)
 
-	if err := rt.Stop(id); err != nil {
+	if err := rt.Stop(id, 10*time.Second); err != nil {
 		t.Fatalf("Stop: %v", err)
 	}
 
@@ -125,7 +99,7 @@ func TestRemoveContainer(t *testing.T) {
 	}
 
 	time.Sleep(200 * time.Millisecond)
-	_ = rt.Stop(id)
+	_ = rt.Stop(id, 10*time.Second)
 
 	if err := rt.Remove(id); err != nil {
 		t.Fatalf("Remove: %v", err)
@@ -168,8 +142,8 @@ func TestList(t *testing.T) {
 	}
 
 	// cleanup
-	_ = rt.Stop(id1)
-	_ = rt.Stop(id2)
+	_ = rt.Stop(id1, 10*time.Second)
+	_ = rt.Stop(id2, 10*time.Second)
 	_ = rt.Remove(id1)
 	_ = rt.Remove(id2)
 }
@@ -207,6 +181,41 @@ func TestListRecovery(t *testing.T) {
 	}
 
 	// cleanup using original runtime (it has the cmd reference)
-	_ = rt.Stop(id)
+	_ = rt.Stop(id, 10*time.Second)
 	_ = rt.Remove(id)
 }
+
+func TestListRecoveryReleasesAddresses(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestIPAM(t, dir, "10.244.0.0/24")
+	for _, id := range []string{"live", "gone"} {
+		if _, err := a.allocate(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only live's directory survived the restart
+	liveDir := filepath.Join(dir, "containers", "live")
+	if err := os.MkdirAll(liveDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveMeta(liveDir, containerMeta{ID: "live", Name: "live", PID: os.Getpid(), IP: "10.244.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := NewNamespaceRuntime(dir)
+	if err != nil {
+		t.Fatalf("NewNamespaceRuntime: %v", err)
+	}
+	rt.PodCIDR = "10.244.0.0/24"
+	if _, err := rt.List(); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+
+	owned, err := a.owned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owned) != 1 || owned["10.244.0.2"] != "live" {
+		t.Errorf("got %v, want only live's address", owned)
+	}
+}
@@ -0,0 +1,199 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// BridgeName is the host bridge the pods of a node are attached to.
+	BridgeName = "miniku0"
+
+	// DefaultPodCIDR is the pod IP range of a node unless told otherwise.
+	// Nodes sharing a network need ranges of their own.
+	DefaultPodCIDR = "10.244.0.0/24"
+)
+
+// Every pod gets its own network namespace with an eth0 that is one end of a
+// veth pair, the other end is on the bridge. The bridge has the first address
+// of the pod CIDR and is the pods' default route, traffic leaving the CIDR is
+// masqueraded behind the node's address. All of it is done with ip(8) and
+// iptables(8).
+
+// networkManager wires pods to the bridge of a node's pod CIDR.
+type networkManager struct {
+	subnet *net.IPNet
+	ipam   *ipam
+
+	once sync.Once
+	// err is set when the bridge couldn't be set up, pods can't be started
+	// then
+	err error
+}
+
+func newNetworkManager(rootDir, podCIDR string) (*networkManager, error) {
+	_, subnet, err := net.ParseCIDR(podCIDR)
+	if err != nil {
+		return nil, fmt.Errorf("pod CIDR: %w", err)
+	}
+	ipam, err := newIPAM(rootDir, subnet)
+	if err != nil {
+		return nil, err
+	}
+	return &networkManager{subnet: subnet, ipam: ipam}, nil
+}
+
+// setup creates the bridge, turns on forwarding and adds the masquerade
+// rule. It only runs once, later calls return the first result.
+func (m *networkManager) setup() error {
+	m.once.Do(func() {
+		m.err = m.setupBridge()
+	})
+	return m.err
+}
+
+func (m *networkManager) setupBridge() error {
+	if _, err := net.InterfaceByName(BridgeName); err != nil {
+		if err := ip("link", "add", BridgeName, "type", "bridge"); err != nil {
+			return err
+		}
+	}
+	gateway := m.gatewayCIDR()
+	if !hasAddress(BridgeName, gateway) {
+		if err := ip("addr", "add", gateway, "dev", BridgeName); err != nil {
+			return err
+		}
+	}
+	if err := ip("link", "set", BridgeName, "up"); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0644); err != nil {
+		return fmt.Errorf("enable forwarding: %w", err)
+	}
+
+	// pods can still reach each other and the node without NAT, so a
+	// missing iptables only costs them the outside world
+	rule := []string{"POSTROUTING", "-s", m.subnet.String(), "!", "-o", BridgeName, "-j", "MASQUERADE"}
+	if _, err := exec.LookPath("iptables"); err != nil {
+		log.Printf("runtime: iptables not found, pods in %s can't reach outside the node", m.subnet)
+		return nil
+	}
+	if iptables(append([]string{"-t", "nat", "-C"}, rule...)...) != nil {
+		if err := iptables(append([]string{"-t", "nat", "-A"}, rule...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attach gives the network namespace of pid an address and plugs it into
+// the bridge. It returns the address.
+func (m *networkManager) attach(id string, pid int) (string, error) {
+	if err := m.setup(); err != nil {
+		return "", fmt.Errorf("set up pod network: %w", err)
+	}
+
+	addr, err := m.ipam.allocate(id)
+	if err != nil {
+		return "", err
+	}
+
+	// the container end is made in its namespace, named like it is seen
+	// from in there
+	hostVeth := vethName(id)
+	if err := ip("link", "add", hostVeth, "type", "veth", "peer", "name", "eth0", "netns", fmt.Sprint(pid)); err != nil {
+		_ = m.ipam.release(id)
+		return "", err
+	}
+	err = errors.Join(
+		ip("link", "set", hostVeth, "master", BridgeName),
+		ip("link", "set", hostVeth, "up"),
+	)
+	if err == nil {
+		ones, _ := m.subnet.Mask.Size()
+		err = inNetns(pid, func() error {
+			return errors.Join(
+				ip("link", "set", "lo", "up"),
+				ip("addr", "add", fmt.Sprintf("%s/%d", addr, ones), "dev", "eth0"),
+				ip("link", "set", "eth0", "up"),
+				ip("route", "add", "default", "via", m.ipam.gateway.String()),
+			)
+		})
+	}
+	if err != nil {
+		_ = m.detach(id)
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// detach undoes attach. The veth pair goes away with the namespace on its
+// own, this only makes sure of it and releases the address.
+func (m *networkManager) detach(id string) error {
+	if _, err := net.InterfaceByName(vethName(id)); err == nil {
+		if err := ip("link", "del", vethName(id)); err != nil {
+			log.Printf("runtime: %v", err)
+		}
+	}
+	return m.ipam.release(id)
+}
+
+func (m *networkManager) gatewayCIDR() string {
+	ones, _ := m.subnet.Mask.Size()
+	return fmt.Sprintf("%s/%d", m.ipam.gateway, ones)
+}
+
+// vethName is the host end of a container's veth pair. Interface names are
+// at most 15 characters, the "mk" prefix and a container ID fit.
+func vethName(id string) string {
+	return "mk" + id
+}
+
+// inNetns runs fn in the network namespace of pid. Commands fn starts are
+// forked from the thread that joined the namespace, so they run in it too.
+func inNetns(pid int, fn func() error) error {
+	errc := make(chan error, 1)
+	go func() {
+		// the thread isn't unlocked, it goes away with the goroutine
+		// rather than being handed back in the wrong namespace
+		runtime.LockOSThread()
+		if err := setns(pid, "net", unix.CLONE_NEWNET); err != nil {
+			errc <- err
+			return
+		}
+		errc <- fn()
+	}()
+	return <-errc
+}
+
+func hasAddress(dev, cidr string) bool {
+	out, err := exec.Command("ip", "-o", "addr", "show", "dev", dev).Output()
+	return err == nil && bytes.Contains(out, []byte(" "+cidr+" "))
+}
+
+func ip(args ...string) error {
+	return runCommand("ip", args...)
+}
+
+func iptables(args ...string) error {
+	return runCommand("iptables", args...)
+}
+
+func runCommand(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultRegistry = "docker.io"
+	// docker.io is only the name, the API is served elsewhere
+	dockerHubHost = "registry-1.docker.io"
+	defaultTag    = "latest"
+)
+
+// reference is a parsed image name like
+// registry.example.com:5000/team/app:v1@sha256:....
+type reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseReference fills in what docker fills in: images without a registry
+// come from Docker Hub, official ones live under library/, and the tag is
+// latest unless a tag or digest is given.
+func parseReference(image string) (reference, error) {
+	if image == "" {
+		return reference{}, fmt.Errorf("empty image name")
+	}
+	var ref reference
+
+	name := image
+	if before, digest, ok := strings.Cut(name, "@"); ok {
+		if err := validateDigest(digest); err != nil {
+			return reference{}, fmt.Errorf("image %q: %w", image, err)
+		}
+		name, ref.Digest = before, digest
+	}
+
+	// a colon after the last slash separates the tag, one before it is a
+	// registry port
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+		if ref.Tag == "" {
+			return reference{}, fmt.Errorf("image %q: empty tag", image)
+		}
+	}
+
+	ref.Registry = defaultRegistry
+	if first, rest, ok := strings.Cut(name, "/"); ok && isRegistryHost(first) {
+		ref.Registry, name = first, rest
+	}
+	if ref.Registry == defaultRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if name == "" || strings.ToLower(name) != name {
+		return reference{}, fmt.Errorf("image %q: invalid repository name", image)
+	}
+	ref.Repository = name
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = defaultTag
+	}
+	return ref, nil
+}
+
+func isRegistryHost(s string) bool {
+	return strings.ContainsAny(s, ".:") || s == "localhost"
+}
+
+// String is the fully qualified name, the key images are stored under.
+func (r reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// version is what the manifest is fetched by, the digest wins over the tag.
+func (r reference) version() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// host is where the registry's API is served.
+func (r reference) host() string {
+	if r.Registry == defaultRegistry {
+		return dockerHubHost
+	}
+	return r.Registry
+}
+
+// validateDigest only accepts sha256 digests, which is all that's in use.
+func validateDigest(digest string) error {
+	hex, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(hex) != 64 || strings.Trim(hex, "0123456789abcdef") != "" {
+		return fmt.Errorf("invalid digest %q", digest)
+	}
+	return nil
+}
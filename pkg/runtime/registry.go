@@ -0,0 +1,263 @@
+package runtime
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Image manifests as served by a distribution v2 registry. An index (or
+// docker manifest list) points to one manifest per platform, a manifest to
+// the image config and the layers.
+const (
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+var manifestMediaTypes = []string{
+	mediaTypeOCIIndex,
+	mediaTypeDockerManifestList,
+	mediaTypeOCIManifest,
+	mediaTypeDockerManifest,
+}
+
+// maxManifestSize bounds what is read into memory for a manifest.
+const maxManifestSize = 4 << 20
+
+type descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *platform `json:"platform,omitempty"`
+}
+
+type platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// manifest is either an index, with Manifests set, or an image manifest.
+type manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        descriptor   `json:"config"`
+	Layers        []descriptor `json:"layers"`
+	Manifests     []descriptor `json:"manifests,omitempty"`
+}
+
+func isIndex(mediaType string) bool {
+	return mediaType == mediaTypeOCIIndex || mediaType == mediaTypeDockerManifestList
+}
+
+// selectPlatform picks the manifest for the platform we run on.
+func selectPlatform(index manifest) (descriptor, error) {
+	for _, desc := range index.Manifests {
+		if p := desc.Platform; p != nil && p.OS == "linux" && p.Architecture == runtime.GOARCH {
+			return desc, nil
+		}
+	}
+	return descriptor{}, fmt.Errorf("no image for linux/%s", runtime.GOARCH)
+}
+
+// registryClient talks to distribution v2 registries. Pulls are anonymous,
+// registries that want a bearer token (like Docker Hub) hand one out for the
+// asking.
+type registryClient struct {
+	http *http.Client
+	// insecure registries are spoken to over plain HTTP
+	insecure []string
+
+	mu sync.Mutex
+	// tokens by registry host and repository
+	tokens map[string]string
+}
+
+func newRegistryClient(insecure []string) *registryClient {
+	return &registryClient{
+		http:     &http.Client{},
+		insecure: insecure,
+		tokens:   make(map[string]string),
+	}
+}
+
+// fetchManifest gets the manifest of ref at version, a tag or a digest. It
+// returns the manifest's digest, which is checked against version when that
+// is one.
+func (c *registryClient) fetchManifest(ref reference, version string) (data []byte, mediaType, digest string, err error) {
+	resp, err := c.get(ref, "manifests/"+version, manifestMediaTypes)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err = io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("read manifest: %w", err)
+	}
+	if len(data) > maxManifestSize {
+		return nil, "", "", fmt.Errorf("manifest of %s is too large", ref)
+	}
+
+	sum := sha256.Sum256(data)
+	digest = "sha256:" + hex.EncodeToString(sum[:])
+	if strings.HasPrefix(version, "sha256:") && digest != version {
+		return nil, "", "", fmt.Errorf("manifest digest mismatch: got %s, want %s", digest, version)
+	}
+
+	mediaType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !slices.Contains(manifestMediaTypes, mediaType) {
+		// some registries answer with a generic type, the manifest knows
+		var m manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, "", "", fmt.Errorf("decode manifest: %w", err)
+		}
+		mediaType = m.MediaType
+		if mediaType == "" && len(m.Manifests) > 0 {
+			mediaType = mediaTypeOCIIndex
+		}
+	}
+	return data, mediaType, digest, nil
+}
+
+// fetchBlob streams a blob, the caller verifies its digest.
+func (c *registryClient) fetchBlob(ref reference, digest string) (io.ReadCloser, error) {
+	resp, err := c.get(ref, "blobs/"+digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *registryClient) get(ref reference, path string, accept []string) (*http.Response, error) {
+	scheme := "https"
+	if slices.Contains(c.insecure, ref.Registry) {
+		scheme = "http"
+	}
+	target := scheme + "://" + ref.host() + "/v2/" + ref.Repository + "/" + path
+	tokenKey := ref.host() + "/" + ref.Repository
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, mediaType := range accept {
+			req.Header.Add("Accept", mediaType)
+		}
+		c.mu.Lock()
+		token := c.tokens[tokenKey]
+		c.mu.Unlock()
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: %w", target, err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+
+		challenge := resp.Header.Get("WWW-Authenticate")
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 && strings.HasPrefix(challenge, "Bearer ") {
+			token, err := c.fetchToken(challenge)
+			if err != nil {
+				return nil, fmt.Errorf("GET %s: %w", target, err)
+			}
+			c.mu.Lock()
+			c.tokens[tokenKey] = token
+			c.mu.Unlock()
+			continue
+		}
+		return nil, fmt.Errorf("GET %s: status %d: %s", target, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+}
+
+// fetchToken answers a challenge like
+//
+//	Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/alpine:pull"
+//
+// with an anonymous token.
+func (c *registryClient) fetchToken(challenge string) (string, error) {
+	params := parseChallenge(strings.TrimPrefix(challenge, "Bearer "))
+	realm := params["realm"]
+	if realm == "" {
+		return "", errors.New("auth challenge without realm")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("auth realm: %w", err)
+	}
+	q := u.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			q.Set(key, params[key])
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	resp, err := c.http.Get(u.String())
+	if err != nil {
+		return "", fmt.Errorf("get token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get token: status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode token: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", errors.New("no token in auth response")
+}
+
+// parseChallenge splits key="value" pairs, values may contain commas.
+func parseChallenge(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		key, rest, ok := strings.Cut(strings.TrimLeft(s, ", "), "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.TrimSpace(key)] = value
+		s = rest
+	}
+	return params
+}
@@ -0,0 +1,183 @@
+package runtime
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testRegistry stands in for a distribution v2 registry, serving images
+// built in memory. It hands out a bearer token like Docker Hub does.
+type testRegistry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string]testManifest
+	// requests counts manifest and blob requests by path
+	requests map[string]int
+}
+
+type testManifest struct {
+	mediaType string
+	data      []byte
+}
+
+const testRegistryToken = "secret"
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	t.Helper()
+	r := &testRegistry{
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string]testManifest),
+		requests:  make(map[string]int),
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": testRegistryToken})
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer "+testRegistryToken {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.URL+`/token",service="test",scope="repository:x:pull"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests[req.URL.Path]++
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+		m, ok := r.manifests[path[:i]+"@"+path[i+len("/manifests/"):]]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		_, _ = w.Write(m.data)
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+		data, ok := r.blobs[path[i+len("/blobs/"):]]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write(data)
+		return
+	}
+	http.NotFound(w, req)
+}
+
+// host is what image names start with to come from this registry.
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+func (r *testRegistry) addBlob(mediaType string, data []byte) descriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	desc := descriptor{MediaType: mediaType, Digest: sha256Digest(data), Size: int64(len(data))}
+	r.blobs[desc.Digest] = data
+	return desc
+}
+
+func (r *testRegistry) addManifest(repo, tag, mediaType string, v any) descriptor {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	desc := descriptor{MediaType: mediaType, Digest: sha256Digest(data), Size: int64(len(data))}
+	r.manifests[repo+"@"+desc.Digest] = testManifest{mediaType: mediaType, data: data}
+	if tag != "" {
+		r.manifests[repo+"@"+tag] = testManifest{mediaType: mediaType, data: data}
+	}
+	return desc
+}
+
+// push adds an image manifest with its config and layers.
+func (r *testRegistry) push(repo, tag string, config imageConfig, layers ...[]byte) descriptor {
+	configJSON, err := json.Marshal(map[string]any{"architecture": "amd64", "os": "linux", "config": config})
+	if err != nil {
+		panic(err)
+	}
+	m := manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config:        r.addBlob("application/vnd.oci.image.config.v1+json", configJSON),
+	}
+	for _, layer := range layers {
+		m.Layers = append(m.Layers, r.addBlob("application/vnd.oci.image.layer.v1.tar+gzip", layer))
+	}
+	return r.addManifest(repo, tag, mediaTypeOCIManifest, m)
+}
+
+func (r *testRegistry) manifestRequests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for path, count := range r.requests {
+		if strings.Contains(path, "/manifests/") {
+			n += count
+		}
+	}
+	return n
+}
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// tarEntry is a file of a test layer, a directory when name ends in /, a
+// symlink when link is set.
+type tarEntry struct {
+	name    string
+	content string
+	link    string
+}
+
+func gzipLayer(t *testing.T, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		switch {
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		case strings.HasSuffix(e.name, "/"):
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,205 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// A container's root is an overlay of its image's layers, read-only, under a
+// writable upper dir of its own:
+//
+//	<container dir>/upper   what the container changed
+//	<container dir>/work    overlayfs' scratch space
+//	<container dir>/rootfs  the overlay mount
+//
+// Where overlayfs can't be mounted (not in the kernel, or too many layers for
+// the mount options) the layers are copied into rootfs instead.
+
+// setupRootfs prepares the root of a container in containerDir and returns
+// its path.
+func setupRootfs(containerDir string, layers []string) (string, error) {
+	rootfs := filepath.Join(containerDir, "rootfs")
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return "", err
+	}
+	if len(layers) == 0 {
+		return rootfs, nil
+	}
+
+	err := mountOverlay(containerDir, rootfs, layers)
+	if err == nil {
+		return rootfs, nil
+	}
+	log.Printf("runtime: overlay unavailable (%v), copying rootfs for %s...", err, filepath.Base(containerDir))
+	if err := copyLayers(layers, rootfs); err != nil {
+		return "", fmt.Errorf("copy rootfs: %w", err)
+	}
+	return rootfs, nil
+}
+
+func mountOverlay(containerDir, rootfs string, layers []string) error {
+	upper := filepath.Join(containerDir, "upper")
+	work := filepath.Join(containerDir, "work")
+	for _, dir := range []string{upper, work} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	// overlayfs wants the top layer first
+	lower := slices.Clone(layers)
+	slices.Reverse(lower)
+	options := "lowerdir=" + strings.Join(lower, ":") + ",upperdir=" + upper + ",workdir=" + work
+	if err := unix.Mount("overlay", rootfs, "overlay", 0, options); err != nil {
+		_ = os.RemoveAll(upper)
+		_ = os.RemoveAll(work)
+		return err
+	}
+	return nil
+}
+
+// unmountRootfs takes down the overlay of a container, a rootfs that isn't
+// mounted is fine.
+func unmountRootfs(rootfs string) error {
+	err := unix.Unmount(rootfs, 0)
+	if errors.Is(err, unix.EBUSY) {
+		err = unix.Unmount(rootfs, unix.MNT_DETACH)
+	}
+	if err == nil || errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	return fmt.Errorf("unmount rootfs: %w", err)
+}
+
+// copyLayers merges layer directories, the base layer first, into dst the
+// way the overlay would show them.
+func copyLayers(layers []string, dst string) error {
+	for _, layer := range layers {
+		if err := copyLayer(layer, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyLayer(layer, dst string) error {
+	return filepath.Walk(layer, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(layer, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if isWhiteout(info) {
+			return os.RemoveAll(target)
+		}
+
+		existing, err := os.Lstat(target)
+		switch {
+		case err == nil && existing.IsDir() && info.IsDir():
+			if isOpaque(path) {
+				if err := clearDir(target); err != nil {
+					return err
+				}
+			}
+		case err == nil:
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		case !os.IsNotExist(err):
+			return err
+		}
+		return copyEntry(path, target, info)
+	})
+}
+
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyEntry copies one file, directory, symlink or device with its owner
+// and mode.
+func copyEntry(src, dst string, info fs.FileInfo) error {
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+	case mode&os.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(link, dst); err != nil {
+			return err
+		}
+		return chownLike(dst, info)
+	case mode.IsRegular():
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	default:
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		if err := unix.Mknod(dst, stat.Mode, int(stat.Rdev)); err != nil {
+			return err
+		}
+	}
+
+	if err := chownLike(dst, info); err != nil {
+		return err
+	}
+	return os.Chmod(dst, mode.Perm()|mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+}
+
+func chownLike(dst string, info fs.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || os.Getuid() != 0 {
+		return nil
+	}
+	return os.Lchown(dst, int(stat.Uid), int(stat.Gid))
+}
+
+func copyFile(src, dst string) error {
+	sf, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open src %s: %w", src, err)
+	}
+	defer func() { _ = sf.Close() }()
+
+	df, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("open dst %s: %w", dst, err)
+	}
+
+	if _, err = io.Copy(df, sf); err != nil {
+		_ = df.Close()
+		return err
+	}
+	return df.Close()
+}
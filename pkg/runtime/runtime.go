@@ -5,22 +5,63 @@
 // - Remove a container
 // - Get status of a container
 // - List containers
+// - Exec a command in a running container
+// - Read a container's logs
 
 package runtime
 
 import (
+	"context"
+	"io"
+	"time"
+
 	"miniku/pkg/types"
 )
 
 type ContainerInfo struct {
-	ID   string
-	Name string
+	ID string
+	// Name and Namespace are those of the pod the container runs
+	Name      string
+	Namespace string
+}
+
+// PodKey is the types.Key of the container's pod.
+func (c ContainerInfo) PodKey() string {
+	return types.Key(c.Namespace, c.Name)
 }
 
 type Runtime interface {
 	Run(pod types.PodSpec) (containerID string, err error)
-	Stop(containerID string) error
+	// Stop asks the container to exit with SIGTERM and kills it if it
+	// hasn't after gracePeriod.
+	Stop(containerID string, gracePeriod time.Duration) error
 	Remove(containerID string) error
 	GetStatus(containerID string) (*types.ContainerState, error)
 	List() ([]ContainerInfo, error)
+	// Exec runs a command inside a running container and returns its exit
+	// code. err is only set when the command couldn't be run at all.
+	Exec(ctx context.Context, containerID string, opts ExecOptions) (exitCode int, err error)
+	// Logs writes what the container printed to w. Logs of a removed
+	// container stay readable until the pod's next container replaces them
+	// or RemoveLogs is called.
+	Logs(ctx context.Context, containerID string, opts LogOptions, w io.Writer) error
+	// RemoveLogs deletes the logs of a pod that is gone.
+	RemoveLogs(namespace, podName string) error
+}
+
+type ExecOptions struct {
+	Command []string
+	Stdin   io.Reader
+	Stdout  io.Writer
+	// Stderr isn't used with a TTY, the terminal carries both streams.
+	Stderr io.Writer
+	// TTY runs the command on a pseudo terminal.
+	TTY bool
+	// Resize sets the terminal's size while TTY is set.
+	Resize <-chan TerminalSize
+}
+
+type TerminalSize struct {
+	Width  uint16 `json:"width"`
+	Height uint16 `json:"height"`
 }
@@ -0,0 +1,129 @@
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lookupUser resolves the User of an image config, a name or uid with an
+// optional :group or :gid, against /etc/passwd and /etc/group under root. An
+// empty spec is root.
+func lookupUser(root, spec string) (*syscall.Credential, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	userPart, groupPart, hasGroup := strings.Cut(spec, ":")
+
+	cred := &syscall.Credential{}
+	var userName string
+	uid, err := strconv.ParseUint(userPart, 10, 32)
+	numeric := err == nil
+	found := false
+	// name:password:uid:gid:gecos:home:shell
+	err = readColonFile(filepath.Join(root, "etc", "passwd"), func(fields []string) bool {
+		if len(fields) < 4 || (numeric && fields[2] != userPart) || (!numeric && fields[0] != userPart) {
+			return false
+		}
+		u, uerr := strconv.ParseUint(fields[2], 10, 32)
+		g, gerr := strconv.ParseUint(fields[3], 10, 32)
+		if uerr != nil || gerr != nil {
+			return false
+		}
+		userName, cred.Uid, cred.Gid, found = fields[0], uint32(u), uint32(g), true
+		return true
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	switch {
+	case found:
+	case numeric:
+		// a uid doesn't need an entry, it runs with group 0 then
+		cred.Uid = uint32(uid)
+	default:
+		return nil, fmt.Errorf("user %q not found", userPart)
+	}
+
+	if hasGroup {
+		gid, err := lookupGroup(root, groupPart)
+		if err != nil {
+			return nil, err
+		}
+		cred.Gid = gid
+	}
+
+	// name:password:gid:member,member
+	if userName != "" {
+		err = readColonFile(filepath.Join(root, "etc", "group"), func(fields []string) bool {
+			if len(fields) < 4 {
+				return false
+			}
+			for _, member := range strings.Split(fields[3], ",") {
+				if member != userName {
+					continue
+				}
+				if g, err := strconv.ParseUint(fields[2], 10, 32); err == nil {
+					cred.Groups = append(cred.Groups, uint32(g))
+				}
+			}
+			return false
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return cred, nil
+}
+
+func lookupGroup(root, spec string) (uint32, error) {
+	if gid, err := strconv.ParseUint(spec, 10, 32); err == nil {
+		return uint32(gid), nil
+	}
+	var gid uint32
+	found := false
+	err := readColonFile(filepath.Join(root, "etc", "group"), func(fields []string) bool {
+		if len(fields) < 3 || fields[0] != spec {
+			return false
+		}
+		g, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return false
+		}
+		gid, found = uint32(g), true
+		return true
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if !found {
+		return 0, fmt.Errorf("group %q not found", spec)
+	}
+	return gid, nil
+}
+
+// readColonFile calls fn with the fields of every line of a passwd style
+// file until it returns true.
+func readColonFile(path string, fn func(fields []string) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fn(strings.Split(line, ":")) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
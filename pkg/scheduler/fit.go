@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"fmt"
+	"maps"
+	"miniku/pkg/types"
+	"slices"
+	"strings"
+)
+
+// ScoringStrategy decides which of the nodes a pod fits on is preferred.
+type ScoringStrategy string
+
+const (
+	// LeastAllocated spreads pods over the emptiest nodes.
+	LeastAllocated ScoringStrategy = "LeastAllocated"
+	// MostAllocated packs pods onto the fullest nodes, leaving others free.
+	MostAllocated ScoringStrategy = "MostAllocated"
+)
+
+const maxScore = 100
+
+// nodeUsage is what the pods on a node requested.
+type nodeUsage struct {
+	requested types.ResourceList
+	pods      int64
+}
+
+func (u *nodeUsage) add(pod types.Pod) {
+	r := pod.Spec.Resources.Requested()
+	u.requested.CPU += r.CPU
+	u.requested.Memory += r.Memory
+	u.pods++
+}
+
+// usage sums the requests of the pods bound to each node, including the ones
+// we bound that the cache hasn't caught up with yet.
+func (s *Scheduler) usage() map[string]*nodeUsage {
+	out := make(map[string]*nodeUsage)
+	add := func(nodeName string, pod types.Pod) {
+		u, ok := out[nodeName]
+		if !ok {
+			u = &nodeUsage{}
+			out[nodeName] = u
+		}
+		u.add(pod)
+	}
+
+	for _, pod := range s.podInformer.List() {
+		if pod.Spec.NodeName != "" && !pod.Finished() {
+			add(pod.Spec.NodeName, pod)
+		}
+	}
+	for key, assumed := range s.assumed {
+		cached, ok := s.podInformer.Get(key)
+		if !ok || cached.Spec.NodeName != "" {
+			delete(s.assumed, key)
+			continue
+		}
+		add(assumed.Spec.NodeName, assumed)
+	}
+	return out
+}
+
+// fits returns why the pod doesn't fit on the node, or "" if it does. A node
+// that didn't report a resource isn't limited in it.
+func fits(node types.Node, used *nodeUsage, pod types.Pod) string {
+	if used == nil {
+		used = &nodeUsage{}
+	}
+	request := pod.Spec.Resources.Requested()
+	allocatable := node.Allocatable
+
+	switch {
+	case allocatable.Pods > 0 && used.pods+1 > allocatable.Pods:
+		return "Too many pods"
+	case allocatable.CPU > 0 && used.requested.CPU+request.CPU > allocatable.CPU:
+		return "Insufficient cpu"
+	case allocatable.Memory > 0 && used.requested.Memory+request.Memory > allocatable.Memory:
+		return "Insufficient memory"
+	}
+	return ""
+}
+
+// score rates a node the pod fits on from 0 to maxScore, by how much of its
+// CPU and memory would be requested with the pod on it.
+func score(strategy ScoringStrategy, node types.Node, used *nodeUsage, pod types.Pod) int64 {
+	if used == nil {
+		used = &nodeUsage{}
+	}
+	request := pod.Spec.Resources.Requested()
+
+	var total, n int64
+	for _, r := range []struct{ requested, allocatable int64 }{
+		{used.requested.CPU + request.CPU, node.Allocatable.CPU},
+		{used.requested.Memory + request.Memory, node.Allocatable.Memory},
+	} {
+		if r.allocatable == 0 {
+			continue
+		}
+		n++
+		allocated := min(maxScore, r.requested*maxScore/r.allocatable)
+		if strategy == MostAllocated {
+			total += allocated
+		} else {
+			total += maxScore - allocated
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / n
+}
+
+// unschedulableMessage summarises why each node was ruled out, e.g.
+// "0/3 nodes are available: 1 Insufficient cpu, 2 node(s) not ready".
+func unschedulableMessage(total int, reasons map[string]int) string {
+	var parts []string
+	for _, reason := range slices.Sorted(maps.Keys(reasons)) {
+		parts = append(parts, fmt.Sprintf("%d %s", reasons[reason], reason))
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("0/%d nodes are available", total)
+	}
+	return fmt.Sprintf("0/%d nodes are available: %s", total, strings.Join(parts, ", "))
+}
@@ -0,0 +1,177 @@
+package scheduler
+
+import (
+	"miniku/pkg/testutil"
+	"miniku/pkg/types"
+	"testing"
+	"time"
+)
+
+func podRequesting(name string, cpu, memory int64) types.Pod {
+	return types.Pod{
+		Spec: types.PodSpec{
+			Name:      name,
+			Image:     "nginx",
+			Resources: types.ResourceRequirements{Requests: types.ResourceList{CPU: cpu, Memory: memory}},
+		},
+		Status: types.PodStatusPending,
+	}
+}
+
+func nodeWith(name string, cpu, memory, pods int64) types.Node {
+	return types.Node{
+		Name:        name,
+		Status:      types.NodeStateReady,
+		Allocatable: types.ResourceList{CPU: cpu, Memory: memory, Pods: pods},
+	}
+}
+
+func TestPickNodeResources(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy ScoringStrategy
+		nodes    []types.Node
+		// bound pods run on the first node
+		bound   []types.Pod
+		pod     types.Pod
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "filters nodes without enough cpu",
+			nodes: []types.Node{nodeWith("small", 500, 1<<30, 10), nodeWith("big", 4000, 1<<30, 10)},
+			pod:   podRequesting("web", 1000, 0),
+			want:  "big",
+		},
+		{
+			name:  "counts pods already on the node",
+			nodes: []types.Node{nodeWith("node-a", 2000, 1<<30, 10), nodeWith("node-b", 2000, 1<<30, 10)},
+			bound: []types.Pod{podRequesting("other", 1500, 0)},
+			pod:   podRequesting("web", 1000, 0),
+			want:  "node-b",
+		},
+		{
+			name:  "least allocated prefers the emptier node",
+			nodes: []types.Node{nodeWith("node-a", 4000, 1<<30, 10), nodeWith("node-b", 4000, 1<<30, 10)},
+			bound: []types.Pod{podRequesting("other", 1000, 0)},
+			pod:   podRequesting("web", 500, 0),
+			want:  "node-b",
+		},
+		{
+			name:     "most allocated packs the fuller node",
+			strategy: MostAllocated,
+			nodes:    []types.Node{nodeWith("node-a", 4000, 1<<30, 10), nodeWith("node-b", 4000, 1<<30, 10)},
+			bound:    []types.Pod{podRequesting("other", 1000, 0)},
+			pod:      podRequesting("web", 500, 0),
+			want:     "node-a",
+		},
+		{
+			name:  "limits stand in for missing requests",
+			nodes: []types.Node{nodeWith("node-a", 4000, 1<<20, 10), nodeWith("node-b", 4000, 1<<30, 10)},
+			pod: types.Pod{Spec: types.PodSpec{
+				Name:      "web",
+				Resources: types.ResourceRequirements{Limits: types.ResourceList{Memory: 1 << 25}},
+			}},
+			want: "node-b",
+		},
+		{
+			name: "nothing fits",
+			nodes: []types.Node{
+				nodeWith("node-c", 4000, 1<<30, 1),
+				nodeWith("node-a", 500, 1<<30, 10),
+				nodeWith("node-b", 4000, 1<<20, 10),
+				{Name: "node-d", Status: types.NodeStateNotReady},
+			},
+			bound:   []types.Pod{podRequesting("other", 0, 0)},
+			pod:     podRequesting("web", 1000, 1<<25),
+			wantErr: "0/4 nodes are available: 1 Insufficient cpu, 1 Insufficient memory, 1 Too many pods, 1 node(s) not ready",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := testutil.NewTestEnv()
+			defer env.Close()
+
+			for _, node := range tt.nodes {
+				env.NodeStore.Put(node.Name, node)
+			}
+			for _, pod := range tt.bound {
+				pod.Spec.NodeName = tt.nodes[0].Name
+				pod.Status = types.PodStatusRunning
+				env.PodStore.Put(pod.Key(), pod)
+			}
+
+			sched := New(env.Client)
+			if tt.strategy != "" {
+				sched.Strategy = tt.strategy
+			}
+			startInformers(t, sched)
+
+			node, err := sched.pickNode(tt.pod)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("pickNode: %v", err)
+			}
+			if node.Name != tt.want {
+				t.Errorf("got node %s, want %s", node.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleOneUnschedulable(t *testing.T) {
+	env := testutil.NewTestEnv()
+	defer env.Close()
+
+	env.NodeStore.Put("node-1", nodeWith("node-1", 1000, 1<<30, 10))
+	first := env.PodStore.Put("default/first", podRequesting("first", 800, 0))
+	second := env.PodStore.Put("default/second", podRequesting("second", 800, 0))
+
+	sched := New(env.Client)
+	startInformers(t, sched)
+
+	if err := sched.scheduleOne(first); err != nil {
+		t.Fatalf("scheduleOne: %v", err)
+	}
+	// the cache may not have seen the first binding yet, the scheduler
+	// still has to count it
+	if err := sched.scheduleOne(second); err == nil {
+		t.Fatal("expected the second pod not to fit")
+	}
+
+	got, _ := env.PodStore.Get("default/second")
+	if got.Spec.NodeName != "" || got.Reason != types.PodReasonUnschedulable {
+		t.Errorf("got node %q reason %q, want unbound and Unschedulable", got.Spec.NodeName, got.Reason)
+	}
+	if got.Message != "0/1 nodes are available: 1 Insufficient cpu" {
+		t.Errorf("got message %q", got.Message)
+	}
+
+	// room frees up once the first pod is gone
+	env.PodStore.Delete("default/first")
+	waitFor(t, func() bool { _, ok := sched.podInformer.Get("default/first"); return !ok })
+	if err := sched.scheduleOne(got); err != nil {
+		t.Fatalf("scheduleOne: %v", err)
+	}
+	got, _ = env.PodStore.Get("default/second")
+	if got.Spec.NodeName != "node-1" || got.Reason != "" || got.Message != "" {
+		t.Errorf("got node %q reason %q message %q, want bound with the reason cleared", got.Spec.NodeName, got.Reason, got.Message)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for range 200 {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for condition")
+}
@@ -2,7 +2,9 @@ package scheduler
 
 import (
 	"cmp"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"miniku/pkg/client"
 	"miniku/pkg/types"
@@ -12,75 +14,210 @@ import (
 
 type Scheduler struct {
 	client       *client.Client
+	podInformer  *client.Informer[types.Pod]
+	nodeInformer *client.Informer[types.Node]
+	queue        *client.WorkQueue
 	nextIndex    uint
+	// assumed are the pods we bound that the cache may not show bound yet,
+	// only touched by the sync loop
+	assumed      map[string]types.Pod
 	PollInterval time.Duration
+	// Strategy picks between the nodes a pod fits on, LeastAllocated by
+	// default.
+	Strategy ScoringStrategy
 }
 
-func New(client *client.Client) *Scheduler {
-	return &Scheduler{
-		client:       client,
+func New(c *client.Client) *Scheduler {
+	s := &Scheduler{
+		client:       c,
+		podInformer:  c.PodInformer(),
+		nodeInformer: c.NodeInformer(),
+		queue:        client.NewWorkQueue(),
+		assumed:      make(map[string]types.Pod),
 		PollInterval: 5 * time.Second,
+		Strategy:     LeastAllocated,
 	}
+
+	// pods going away free up room for the ones that didn't fit
+	s.podInformer.AddEventHandler(client.EventHandler[types.Pod]{
+		OnAdd: s.enqueueIfUnscheduled,
+		OnUpdate: func(oldPod, pod types.Pod) {
+			s.enqueueIfUnscheduled(pod)
+			if !oldPod.Finished() && pod.Finished() {
+				s.enqueueUnscheduled()
+			}
+		},
+		OnDelete: func(types.Pod) { s.enqueueUnscheduled() },
+	})
+	// a node becoming Ready or growing may unblock pods nothing else would
+	// retry soon
+	s.nodeInformer.AddEventHandler(client.EventHandler[types.Node]{
+		OnAdd: func(types.Node) { s.enqueueUnscheduled() },
+		OnUpdate: func(oldNode, node types.Node) {
+			if oldNode.Status != node.Status || oldNode.Allocatable != node.Allocatable {
+				s.enqueueUnscheduled()
+			}
+		},
+	})
+
+	return s
 }
 
 func (s *Scheduler) Run() {
+	s.startInformers(nil)
+
 	for {
-		pods, err := s.client.ListPods()
-		if err != nil {
-			log.Printf("scheduler: failed to list pods: %v", err)
-			time.Sleep(s.PollInterval)
-			continue
+		key, ok := s.queue.Get()
+		if !ok {
+			return
 		}
+		s.sync(key)
+	}
+}
 
-		for _, pod := range pods {
-			// pod is unscheduled
-			if pod.Spec.NodeName == "" {
-				err := s.scheduleOne(pod)
-				if err != nil {
-					log.Println(err)
-				}
-			}
-		}
+// startInformers runs the informers until stop is closed and waits for their
+// caches to fill.
+func (s *Scheduler) startInformers(stop <-chan struct{}) {
+	s.podInformer.PollInterval = s.PollInterval
+	s.podInformer.ResyncPeriod = s.PollInterval
+	s.nodeInformer.PollInterval = s.PollInterval
+
+	go s.podInformer.Run(stop)
+	go s.nodeInformer.Run(stop)
+	<-s.podInformer.Synced()
+	<-s.nodeInformer.Synced()
+}
 
-		time.Sleep(s.PollInterval)
+func (s *Scheduler) sync(key string) {
+	defer s.queue.Done(key)
+
+	pod, ok := s.podInformer.Get(key)
+	// pod is gone or already scheduled
+	if !ok || pod.Spec.NodeName != "" {
+		s.queue.Forget(key)
+		return
+	}
+
+	if err := s.scheduleOne(pod); err != nil {
+		log.Println(err)
+		s.queue.AddRateLimited(key)
+		return
+	}
+	s.queue.Forget(key)
+}
+
+func (s *Scheduler) enqueueIfUnscheduled(pod types.Pod) {
+	if pod.Spec.NodeName == "" {
+		s.queue.Add(pod.Key())
+	}
+}
+
+func (s *Scheduler) enqueueUnscheduled() {
+	for _, pod := range s.podInformer.List() {
+		s.enqueueIfUnscheduled(pod)
 	}
 }
 
 // schedule a single pod
 func (s *Scheduler) scheduleOne(pod types.Pod) error {
-	node, ok := s.pickNode()
-	if !ok {
-		return errors.New("no node available for scheduling")
+	node, err := s.pickNode(pod)
+	if err != nil {
+		if markErr := s.markUnschedulable(pod, err.Error()); markErr != nil {
+			log.Printf("scheduler: failed to update pod %s: %v", pod.Spec.Name, markErr)
+		}
+		return fmt.Errorf("scheduler: can't schedule pod %s: %w", pod.Spec.Name, err)
 	}
 
 	log.Printf("scheduler: assigning pod %s to node %s", pod.Spec.Name, node.Name)
-	pod.Spec.NodeName = node.Name
-	if err := s.client.UpdatePod(pod.Spec.Name, pod); err != nil {
+	if err := s.bind(pod, node.Name); err != nil {
 		return err
 	}
-
+	pod.Spec.NodeName = node.Name
+	s.assumed[pod.Key()] = pod
 	return nil
 }
 
-// we'll pick round-robin as the strategy
-func (s *Scheduler) pickNode() (types.Node, bool) {
+// bind patches the node assignment into the pod, on condition that it is
+// still unbound. A pod that was deleted or bound by someone else in the
+// meantime is left alone.
+func (s *Scheduler) bind(pod types.Pod, nodeName string) error {
+	ops := []map[string]any{
+		{"op": "test", "path": "/spec/node_name", "value": ""},
+		{"op": "replace", "path": "/spec/node_name", "value": nodeName},
+	}
+	if pod.Reason == types.PodReasonUnschedulable {
+		ops = append(ops,
+			map[string]any{"op": "add", "path": "/reason", "value": ""},
+			map[string]any{"op": "add", "path": "/message", "value": ""},
+		)
+	}
+	return s.patchUnbound(pod, ops)
+}
+
+// markUnschedulable records why the pod is still waiting for a node.
+func (s *Scheduler) markUnschedulable(pod types.Pod, message string) error {
+	if pod.Reason == types.PodReasonUnschedulable && pod.Message == message {
+		return nil
+	}
+	return s.patchUnbound(pod, []map[string]any{
+		{"op": "test", "path": "/spec/node_name", "value": ""},
+		{"op": "add", "path": "/reason", "value": types.PodReasonUnschedulable},
+		{"op": "add", "path": "/message", "value": message},
+	})
+}
+
+// patchUnbound sends a JSON patch whose first operation tests that the pod
+// is unbound, a pod that is bound or gone is no error.
+func (s *Scheduler) patchUnbound(pod types.Pod, ops []map[string]any) error {
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PatchPod(pod.Spec.Namespace, pod.Spec.Name, types.JSONPatchType, patch)
+	if errors.Is(err, client.ErrConflict) || errors.Is(err, client.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// pickNode filters out the nodes the pod doesn't fit on and picks the best
+// scoring of the rest, going round-robin between equally good ones.
+func (s *Scheduler) pickNode(pod types.Pod) (types.Node, error) {
 	availableNodes := s.getAvailableNodes()
-	if len(availableNodes) == 0 {
-		return types.Node{}, false
+	total := len(s.nodeInformer.List())
+
+	reasons := make(map[string]int)
+	if notReady := total - len(availableNodes); notReady > 0 {
+		reasons["node(s) not ready"] = notReady
 	}
 
-	node := availableNodes[s.nextIndex%uint(len(availableNodes))]
+	usage := s.usage()
+	var best []types.Node
+	var bestScore int64
+	for _, node := range availableNodes {
+		if reason := fits(node, usage[node.Name], pod); reason != "" {
+			reasons[reason]++
+			continue
+		}
+		switch nodeScore := score(s.Strategy, node, usage[node.Name], pod); {
+		case len(best) == 0 || nodeScore > bestScore:
+			best, bestScore = []types.Node{node}, nodeScore
+		case nodeScore == bestScore:
+			best = append(best, node)
+		}
+	}
+	if len(best) == 0 {
+		return types.Node{}, errors.New(unschedulableMessage(total, reasons))
+	}
+
+	node := best[s.nextIndex%uint(len(best))]
 	s.nextIndex++
-	return node, true
+	return node, nil
 }
 
 // filter Ready nodes
 func (s *Scheduler) getAvailableNodes() []types.Node {
-	nodes, err := s.client.ListNodes()
-	if err != nil {
-		log.Printf("scheduler: failed to list nodes: %v", err)
-		return nil
-	}
+	nodes := s.nodeInformer.List()
 
 	filteredNodes := make([]types.Node, 0, len(nodes))
 	for _, v := range nodes {
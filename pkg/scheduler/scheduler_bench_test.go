@@ -6,6 +6,7 @@ import (
 	"log"
 	"miniku/pkg/testutil"
 	"miniku/pkg/types"
+	"os"
 	"testing"
 )
 
@@ -25,10 +26,11 @@ func BenchmarkPickNode(b *testing.B) {
 			}
 
 			sched := New(env.Client)
+			startInformers(b, sched)
 
 			b.ResetTimer()
 			for i := 0; i < b.N; i++ {
-				sched.pickNode()
+				_, _ = sched.pickNode(types.Pod{})
 			}
 		})
 	}
@@ -36,7 +38,7 @@ func BenchmarkPickNode(b *testing.B) {
 
 func BenchmarkScheduleOne(b *testing.B) {
 	log.SetOutput(io.Discard)
-	defer log.SetOutput(nil)
+	defer log.SetOutput(os.Stderr)
 
 	env := testutil.NewTestEnv()
 	defer env.Close()
@@ -45,13 +47,14 @@ func BenchmarkScheduleOne(b *testing.B) {
 	env.NodeStore.Put("node-2", types.Node{Name: "node-2", Status: types.NodeStateReady})
 
 	sched := New(env.Client)
+	startInformers(b, sched)
 
 	for i := 0; b.Loop(); i++ {
 		pod := types.Pod{
 			Spec:   types.PodSpec{Name: fmt.Sprintf("pod-%d", i)},
 			Status: types.PodStatusPending,
 		}
-		env.PodStore.Put(pod.Spec.Name, pod)
+		env.PodStore.Put(pod.Key(), pod)
 		_ = sched.scheduleOne(pod)
 	}
 }
@@ -77,6 +80,7 @@ func BenchmarkGetAvailableNodes(b *testing.B) {
 			}
 
 			sched := New(env.Client)
+			startInformers(b, sched)
 
 			b.ResetTimer()
 			for i := 0; i < b.N; i++ {
@@ -16,7 +16,7 @@ func TestScheduleOne(t *testing.T) {
 		{
 			name: "assigns pod to available node",
 			pod: types.Pod{
-				Spec:   types.PodSpec{Name: "test-pod"},
+				Spec:   types.PodSpec{Name: "test-pod", Image: "nginx"},
 				Status: types.PodStatusPending,
 			},
 			nodes: []types.Node{
@@ -27,7 +27,7 @@ func TestScheduleOne(t *testing.T) {
 		{
 			name: "no assignment when no nodes",
 			pod: types.Pod{
-				Spec:   types.PodSpec{Name: "test-pod"},
+				Spec:   types.PodSpec{Name: "test-pod", Image: "nginx"},
 				Status: types.PodStatusPending,
 			},
 			nodes:          []types.Node{},
@@ -36,7 +36,7 @@ func TestScheduleOne(t *testing.T) {
 		{
 			name: "no assignment when all nodes NotReady",
 			pod: types.Pod{
-				Spec:   types.PodSpec{Name: "test-pod"},
+				Spec:   types.PodSpec{Name: "test-pod", Image: "nginx"},
 				Status: types.PodStatusPending,
 			},
 			nodes: []types.Node{
@@ -48,7 +48,7 @@ func TestScheduleOne(t *testing.T) {
 		{
 			name: "assigns to Ready node when mixed",
 			pod: types.Pod{
-				Spec:   types.PodSpec{Name: "test-pod"},
+				Spec:   types.PodSpec{Name: "test-pod", Image: "nginx"},
 				Status: types.PodStatusPending,
 			},
 			nodes: []types.Node{
@@ -64,15 +64,16 @@ func TestScheduleOne(t *testing.T) {
 			env := testutil.NewTestEnv()
 			defer env.Close()
 
-			env.PodStore.Put(tt.pod.Spec.Name, tt.pod)
+			env.PodStore.Put(tt.pod.Key(), tt.pod)
 			for _, node := range tt.nodes {
 				env.NodeStore.Put(node.Name, node)
 			}
 
 			sched := New(env.Client)
+			startInformers(t, sched)
 			err := sched.scheduleOne(tt.pod)
 
-			pod, _ := env.PodStore.Get(tt.pod.Spec.Name)
+			pod, _ := env.PodStore.Get(tt.pod.Key())
 
 			if tt.expectAssigned {
 				if err != nil {
@@ -141,6 +142,7 @@ func TestGetAvailableNodes(t *testing.T) {
 			}
 
 			sched := New(env.Client)
+			startInformers(t, sched)
 			available := sched.getAvailableNodes()
 
 			if len(available) != tt.expectedCount {
@@ -180,9 +182,10 @@ func TestPickNode(t *testing.T) {
 			}
 
 			sched := New(env.Client)
-			_, ok := sched.pickNode()
+			startInformers(t, sched)
+			_, err := sched.pickNode(types.Pod{})
 
-			if ok != tt.expectOk {
+			if ok := err == nil; ok != tt.expectOk {
 				t.Errorf("expected ok=%v, got %v", tt.expectOk, ok)
 			}
 		})
@@ -198,13 +201,14 @@ func TestPickNodeRoundRobin(t *testing.T) {
 	env.NodeStore.Put("node-c", types.Node{Name: "node-c", Status: types.NodeStateReady})
 
 	sched := New(env.Client)
+	startInformers(t, sched)
 
 	// pick 6 nodes, should round-robin through a, b, c twice
 	seen := make([]string, 6)
 	for i := range 6 {
-		node, ok := sched.pickNode()
-		if !ok {
-			t.Fatal("expected node to be picked")
+		node, err := sched.pickNode(types.Pod{})
+		if err != nil {
+			t.Fatalf("expected node to be picked: %v", err)
 		}
 		seen[i] = node.Name
 	}
@@ -224,31 +228,33 @@ func TestSchedulerSkipsAlreadyScheduledPods(t *testing.T) {
 
 	// pod alr assigned to node-1
 	pod := types.Pod{
-		Spec:   types.PodSpec{Name: "test-pod", NodeName: "node-1"},
+		Spec:   types.PodSpec{Name: "test-pod", Image: "nginx", NodeName: "node-1"},
 		Status: types.PodStatusPending,
 	}
-	env.PodStore.Put(pod.Spec.Name, pod)
+	env.PodStore.Put(pod.Key(), pod)
 
 	env.NodeStore.Put("node-2", types.Node{Name: "node-2", Status: types.NodeStateReady})
 
 	sched := New(env.Client)
+	startInformers(t, sched)
 
-	// manually call what `Run()` does for one iteration
-	pods, err := sched.client.ListPods()
-	if err != nil {
-		t.Fatalf("ListPods failed: %v", err)
-	}
-	for _, p := range pods {
-		if p.Spec.NodeName == "" {
-			if err := sched.scheduleOne(p); err != nil {
-				t.Fatalf("scheduleOne failed: %v", err)
-			}
-		}
-	}
+	// manually do what `Run()` does for one queued pod
+	sched.queue.Add(pod.Spec.Name)
+	key, _ := sched.queue.Get()
+	sched.sync(key)
 
 	// pod should still be on node-1
-	result, _ := env.PodStore.Get("test-pod")
+	result, _ := env.PodStore.Get("default/test-pod")
 	if result.Spec.NodeName != "node-1" {
 		t.Errorf("expected pod to stay on node-1, got %s", result.Spec.NodeName)
 	}
 }
+
+// startInformers fills the scheduler's caches from the test env and stops
+// the informers when the test ends.
+func startInformers(tb testing.TB, s *Scheduler) {
+	tb.Helper()
+	stop := make(chan struct{})
+	tb.Cleanup(func() { close(stop) })
+	s.startInformers(stop)
+}
@@ -0,0 +1,235 @@
+// Package selector parses and evaluates label and field selectors, e.g.
+//
+//	app=web,tier!=cache,env in (prod,staging),!canary,track
+//	spec.node_name=node-1,status=Running
+package selector
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+type Operator string
+
+const (
+	Equals       Operator = "="
+	NotEquals    Operator = "!="
+	In           Operator = "in"
+	NotIn        Operator = "notin"
+	Exists       Operator = "exists"
+	DoesNotExist Operator = "!"
+)
+
+// Requirement is a single comma-separated term of a selector.
+type Requirement struct {
+	Key    string
+	Op     Operator
+	Values []string
+}
+
+func (r Requirement) Matches(set map[string]string) bool {
+	value, ok := set[r.Key]
+	switch r.Op {
+	case Equals:
+		return ok && value == r.Values[0]
+	case NotEquals:
+		return !ok || value != r.Values[0]
+	case In:
+		return ok && slices.Contains(r.Values, value)
+	case NotIn:
+		return !ok || !slices.Contains(r.Values, value)
+	case Exists:
+		return ok
+	case DoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+func (r Requirement) String() string {
+	switch r.Op {
+	case Exists:
+		return r.Key
+	case DoesNotExist:
+		return "!" + r.Key
+	case In, NotIn:
+		return fmt.Sprintf("%s %s (%s)", r.Key, r.Op, strings.Join(r.Values, ","))
+	}
+	return r.Key + string(r.Op) + r.Values[0]
+}
+
+// Selector is a conjunction of requirements. The empty selector matches
+// everything.
+type Selector []Requirement
+
+func (s Selector) Matches(set map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(set) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Selector) Empty() bool {
+	return len(s) == 0
+}
+
+func (s Selector) String() string {
+	parts := make([]string, len(s))
+	for i, r := range s {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// FromSet builds the selector matching every key=value pair of set.
+func FromSet(set map[string]string) Selector {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := make(Selector, 0, len(keys))
+	for _, k := range keys {
+		s = append(s, Requirement{Key: k, Op: Equals, Values: []string{set[k]}})
+	}
+	return s
+}
+
+// ParseLabels parses a label selector supporting equality (=, ==, !=),
+// set-based (in, notin) and existence (key, !key) requirements.
+func ParseLabels(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range splitTerms(s) {
+		r, err := parseLabelTerm(term)
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// ParseFields parses a field selector, which only supports equality.
+func ParseFields(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range splitTerms(s) {
+		r, ok := parseEquality(term)
+		if !ok {
+			return nil, fmt.Errorf("invalid field selector term %q", term)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// splitTerms splits on commas that aren't inside a parenthesised value list.
+func splitTerms(s string) []string {
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	terms = append(terms, s[start:])
+
+	out := terms[:0]
+	for _, t := range terms {
+		if t = strings.TrimSpace(t); t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func parseLabelTerm(term string) (Requirement, error) {
+	if r, ok := parseEquality(term); ok {
+		return r, nil
+	}
+
+	if strings.HasSuffix(term, ")") {
+		return parseSetTerm(term)
+	}
+
+	if key, ok := strings.CutPrefix(term, "!"); ok {
+		key = strings.TrimSpace(key)
+		if !validKey(key) {
+			return Requirement{}, fmt.Errorf("invalid label selector term %q", term)
+		}
+		return Requirement{Key: key, Op: DoesNotExist}, nil
+	}
+
+	if !validKey(term) {
+		return Requirement{}, fmt.Errorf("invalid label selector term %q", term)
+	}
+	return Requirement{Key: term, Op: Exists}, nil
+}
+
+func parseEquality(term string) (Requirement, bool) {
+	for _, op := range []string{"!=", "==", "="} {
+		key, value, found := strings.Cut(term, op)
+		if !found {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !validKey(key) || strings.ContainsAny(value, "=!() ") {
+			return Requirement{}, false
+		}
+		if op == "!=" {
+			return Requirement{Key: key, Op: NotEquals, Values: []string{value}}, true
+		}
+		return Requirement{Key: key, Op: Equals, Values: []string{value}}, true
+	}
+	return Requirement{}, false
+}
+
+// parseSetTerm parses "key in (a,b)" and "key notin (a,b)".
+func parseSetTerm(term string) (Requirement, error) {
+	open := strings.Index(term, "(")
+	if open < 0 {
+		return Requirement{}, fmt.Errorf("invalid label selector term %q", term)
+	}
+
+	fields := strings.Fields(term[:open])
+	if len(fields) != 2 || !validKey(fields[0]) {
+		return Requirement{}, fmt.Errorf("invalid label selector term %q", term)
+	}
+
+	var op Operator
+	switch fields[1] {
+	case "in":
+		op = In
+	case "notin":
+		op = NotIn
+	default:
+		return Requirement{}, fmt.Errorf("unknown operator %q in %q", fields[1], term)
+	}
+
+	var values []string
+	for _, v := range strings.Split(term[open+1:len(term)-1], ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return Requirement{}, fmt.Errorf("empty value set in %q", term)
+	}
+	return Requirement{Key: fields[0], Op: op, Values: values}, nil
+}
+
+func validKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, "=!(), ")
+}
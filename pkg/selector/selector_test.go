@@ -0,0 +1,107 @@
+package selector
+
+import "testing"
+
+func TestParseLabels(t *testing.T) {
+	labels := map[string]string{"app": "web", "tier": "frontend", "env": "prod"}
+
+	tests := []struct {
+		selector  string
+		wantMatch bool
+		wantErr   bool
+	}{
+		{selector: "", wantMatch: true},
+		{selector: "app=web", wantMatch: true},
+		{selector: "app==web", wantMatch: true},
+		{selector: "app=api", wantMatch: false},
+		{selector: "app=web,tier!=cache", wantMatch: true},
+		{selector: "app=web,tier!=frontend", wantMatch: false},
+		{selector: "missing!=x", wantMatch: true},
+		{selector: "env in (prod, staging)", wantMatch: true},
+		{selector: "env in (dev)", wantMatch: false},
+		{selector: "env notin (dev,staging),app=web", wantMatch: true},
+		{selector: "env notin (prod)", wantMatch: false},
+		{selector: "app", wantMatch: true},
+		{selector: "canary", wantMatch: false},
+		{selector: "!canary", wantMatch: true},
+		{selector: "!app", wantMatch: false},
+		{selector: "env within (prod)", wantErr: true},
+		{selector: "env in ()", wantErr: true},
+		{selector: "=web", wantErr: true},
+		{selector: "app=we b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			sel, err := ParseLabels(tt.selector)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got selector %q", sel)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseLabels: %v", err)
+			}
+			if got := sel.Matches(labels); got != tt.wantMatch {
+				t.Errorf("Matches = %v, want %v", got, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	fields := map[string]string{"spec.node_name": "node-1", "status": "Running"}
+
+	tests := []struct {
+		selector  string
+		wantMatch bool
+		wantErr   bool
+	}{
+		{selector: "spec.node_name=node-1", wantMatch: true},
+		{selector: "spec.node_name=node-1,status=Running", wantMatch: true},
+		{selector: "spec.node_name=node-1,status!=Running", wantMatch: false},
+		{selector: "spec.node_name=", wantMatch: false},
+		{selector: "status in (Running)", wantErr: true},
+		{selector: "status", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			sel, err := ParseFields(tt.selector)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got selector %q", sel)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFields: %v", err)
+			}
+			if got := sel.Matches(fields); got != tt.wantMatch {
+				t.Errorf("Matches = %v, want %v", got, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	in := "app=web,tier!=cache,env in (prod,staging),!canary,track"
+	sel, err := ParseLabels(in)
+	if err != nil {
+		t.Fatalf("ParseLabels: %v", err)
+	}
+	if got := sel.String(); got != in {
+		t.Errorf("got %q, want %q", got, in)
+	}
+}
+
+func TestFromSet(t *testing.T) {
+	sel := FromSet(map[string]string{"b": "2", "a": "1"})
+	if got := sel.String(); got != "a=1,b=2" {
+		t.Errorf("got %q, want a=1,b=2", got)
+	}
+	if !sel.Matches(map[string]string{"a": "1", "b": "2", "c": "3"}) {
+		t.Error("expected superset to match")
+	}
+}
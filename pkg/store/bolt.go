@@ -1,37 +1,118 @@
 package store
 
 import (
+	"bytes"
 	"encoding/json"
 	"log"
 	"sync"
 
 	bolt "go.etcd.io/bbolt"
+
+	"miniku/pkg/selector"
+	"miniku/pkg/types"
 )
 
 type BoltStore[T any] struct {
 	mu     sync.RWMutex
 	db     *bolt.DB
 	bucket []byte
+	feed   *feed[T]
+	labels *labelIndex
 }
 
 func NewBoltStore[T any](db *bolt.DB, bucket string) *BoltStore[T] {
+	var last uint64
 	if err := db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
-		return err
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		last = b.Sequence()
+		return nil
 	}); err != nil {
 		log.Fatalf("bolt: failed to create bucket %q: %v", bucket, err)
 	}
 
-	return &BoltStore[T]{
+	s := &BoltStore[T]{
 		db:     db,
 		bucket: []byte(bucket),
+		feed:   newFeed[T](last),
+		labels: newLabelIndex(),
+	}
+	s.moveToNamespaces()
+	s.buildLabelIndex()
+	return s
+}
+
+// moveToNamespaces moves namespaced objects stored under their bare name,
+// from before there were namespaces, into the default namespace.
+func (s *BoltStore[T]) moveToNamespaces() {
+	var zero T
+	if _, ok := any(&zero).(Namespaced); !ok {
+		return
+	}
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		moved := make(map[string][]byte)
+		if err := b.ForEach(func(k, v []byte) error {
+			if bytes.IndexByte(k, '/') >= 0 {
+				return nil
+			}
+			var item T
+			if err := json.Unmarshal(v, &item); err != nil {
+				return err
+			}
+			obj := any(&item).(Namespaced)
+			obj.SetNamespace(types.DefaultNamespace)
+			data, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			moved[string(k)] = data
+			return nil
+		}); err != nil {
+			return err
+		}
+		for name, data := range moved {
+			if err := b.Delete([]byte(name)); err != nil {
+				return err
+			}
+			if err := b.Put([]byte(types.Key(types.DefaultNamespace, name)), data); err != nil {
+				return err
+			}
+		}
+		if len(moved) > 0 {
+			log.Printf("bolt: moved %d objects of %q into namespace %s", len(moved), s.bucket, types.DefaultNamespace)
+		}
+		return nil
+	}); err != nil {
+		log.Fatalf("bolt: failed to move %q into namespaces: %v", s.bucket, err)
+	}
+}
+
+// buildLabelIndex loads the label index, which lives in memory only.
+func (s *BoltStore[T]) buildLabelIndex() {
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).ForEach(func(k, v []byte) error {
+			var item T
+			if err := json.Unmarshal(v, &item); err != nil {
+				return err
+			}
+			s.labels.update(string(k), labelsOf(&item))
+			return nil
+		})
+	}); err != nil {
+		log.Printf("bolt: index %q: %v", s.bucket, err)
 	}
 }
 
 func (s *BoltStore[T]) List() []T {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.list()
+}
 
+func (s *BoltStore[T]) list() []T {
 	var out []T
 	if err := s.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket(s.bucket)
@@ -49,6 +130,32 @@ func (s *BoltStore[T]) List() []T {
 	return out
 }
 
+func (s *BoltStore[T]) Select(sel selector.Selector) []T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, indexed := s.labels.candidates(sel)
+	if !indexed {
+		return filterLabels(s.list(), sel)
+	}
+
+	out := make([]T, 0, len(names))
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		for _, name := range names {
+			var item T
+			if err := json.Unmarshal(b.Get([]byte(name)), &item); err != nil {
+				return err
+			}
+			out = append(out, item)
+		}
+		return nil
+	}); err != nil {
+		log.Printf("bolt: select %q: %v", s.bucket, err)
+	}
+	return filterLabels(out, sel)
+}
+
 func (s *BoltStore[T]) Get(name string) (T, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -72,30 +179,136 @@ func (s *BoltStore[T]) Get(name string) (T, bool) {
 	return item, found
 }
 
-func (s *BoltStore[T]) Put(name string, t T) {
+func (s *BoltStore[T]) Put(name string, t T) T {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	var prev *T
+	var seq uint64
 	if err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		prev, seq, err = s.write(tx.Bucket(s.bucket), name, &t)
+		return err
+	}); err != nil {
+		log.Printf("bolt: put %q/%s: %v", s.bucket, name, err)
+		return t
+	}
+	s.labels.update(name, labelsOf(&t))
+	s.feed.publish(seq, eventType(prev), t, prev)
+	return t
+}
+
+func (s *BoltStore[T]) Update(name string, t T) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var prev *T
+	var seq uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(s.bucket)
-		data, err := json.Marshal(t)
-		if err != nil {
+
+		var current T
+		v := b.Get([]byte(name))
+		if v != nil {
+			if err := json.Unmarshal(v, &current); err != nil {
+				return err
+			}
+		}
+		if err := checkVersion(&t, &current, v != nil); err != nil {
 			return err
 		}
-		return b.Put([]byte(name), data)
-	}); err != nil {
-		log.Printf("bolt: put %q/%s: %v", s.bucket, name, err)
+
+		var err error
+		prev, seq, err = s.write(b, name, &t)
+		return err
+	})
+	if err != nil {
+		var zero T
+		return zero, err
 	}
+	s.labels.update(name, labelsOf(&t))
+	s.feed.publish(seq, eventType(prev), t, prev)
+	return t, nil
+}
+
+// write stamps t with the bucket's next sequence number and stores it,
+// returning the object it replaced, nil if there was none.
+// The sequence is persisted by bolt, so versions keep increasing across
+// restarts.
+func (s *BoltStore[T]) write(b *bolt.Bucket, name string, t *T) (*T, uint64, error) {
+	var prev *T
+	if v := b.Get([]byte(name)); v != nil {
+		prev = new(T)
+		if err := json.Unmarshal(v, prev); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	seq, err := b.NextSequence()
+	if err != nil {
+		return nil, 0, err
+	}
+	setResourceVersion(t, seq)
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, 0, err
+	}
+	return prev, seq, b.Put([]byte(name), data)
+}
+
+func eventType[T any](prev *T) types.EventType {
+	if prev == nil {
+		return types.EventAdded
+	}
+	return types.EventModified
 }
 
 func (s *BoltStore[T]) Delete(name string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	var item T
+	var seq uint64
 	if err := s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(s.bucket)
+		v := b.Get([]byte(name))
+		if v == nil {
+			return nil
+		}
+		if err := json.Unmarshal(v, &item); err != nil {
+			return err
+		}
+
+		// deletes take a version too so watchers can resume past them
+		var err error
+		if seq, err = b.NextSequence(); err != nil {
+			return err
+		}
 		return b.Delete([]byte(name))
 	}); err != nil {
 		log.Printf("bolt: delete %q/%s: %v", s.bucket, name, err)
+		return
 	}
+
+	if seq != 0 {
+		s.labels.remove(name)
+		setResourceVersion(&item, seq)
+		s.feed.publish(seq, types.EventDeleted, item, nil)
+	}
+}
+
+func (s *BoltStore[T]) Watch(since uint64) (<-chan types.WatchEvent[T], func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var initial []T
+	if since == 0 {
+		initial = s.list()
+	}
+	return s.feed.watch(since, initial)
+}
+
+func (s *BoltStore[T]) Version() uint64 {
+	return s.feed.version()
 }
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"miniku/pkg/selector"
+)
+
+// Labeled is implemented by objects carrying labels. Stores keep an index of
+// their label pairs so Select can skip objects that cannot match.
+type Labeled interface {
+	GetLabels() map[string]string
+}
+
+func labelsOf[T any](t *T) map[string]string {
+	if l, ok := any(t).(Labeled); ok {
+		return l.GetLabels()
+	}
+	return nil
+}
+
+// labelIndex maps every "key=value" label pair to the names carrying it.
+// It is guarded by the owning store's lock.
+type labelIndex struct {
+	byPair map[string]map[string]struct{}
+	labels map[string]map[string]string
+}
+
+func newLabelIndex() *labelIndex {
+	return &labelIndex{
+		byPair: make(map[string]map[string]struct{}),
+		labels: make(map[string]map[string]string),
+	}
+}
+
+func (x *labelIndex) update(name string, labels map[string]string) {
+	x.remove(name)
+	if len(labels) == 0 {
+		return
+	}
+
+	x.labels[name] = labels
+	for k, v := range labels {
+		pair := k + "=" + v
+		names := x.byPair[pair]
+		if names == nil {
+			names = make(map[string]struct{})
+			x.byPair[pair] = names
+		}
+		names[name] = struct{}{}
+	}
+}
+
+func (x *labelIndex) remove(name string) {
+	for k, v := range x.labels[name] {
+		pair := k + "=" + v
+		delete(x.byPair[pair], name)
+		if len(x.byPair[pair]) == 0 {
+			delete(x.byPair, pair)
+		}
+	}
+	delete(x.labels, name)
+}
+
+// candidates narrows sel down to the names that can possibly match, using
+// its most selective = or in requirement. ok is false when sel has no such
+// requirement and every object has to be checked.
+func (x *labelIndex) candidates(sel selector.Selector) (names []string, ok bool) {
+	var best map[string]struct{}
+	for _, r := range sel {
+		if r.Op != selector.Equals && r.Op != selector.In {
+			continue
+		}
+
+		set := make(map[string]struct{})
+		for _, v := range r.Values {
+			for name := range x.byPair[r.Key+"="+v] {
+				set[name] = struct{}{}
+			}
+		}
+		if !ok || len(set) < len(best) {
+			best, ok = set, true
+		}
+	}
+
+	for name := range best {
+		names = append(names, name)
+	}
+	return names, ok
+}
+
+func filterLabels[T any](items []T, sel selector.Selector) []T {
+	if sel.Empty() {
+		return items
+	}
+	out := items[:0]
+	for _, item := range items {
+		if sel.Matches(labelsOf(&item)) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -2,22 +2,34 @@ package store
 
 import (
 	"sync"
+
+	"miniku/pkg/selector"
+	"miniku/pkg/types"
 )
 
 type MemStore[T any] struct {
-	mu   sync.RWMutex
-	data map[string]T
+	mu      sync.RWMutex
+	data    map[string]T
+	version uint64
+	feed    *feed[T]
+	labels  *labelIndex
 }
 
 func NewMemStore[T any]() *MemStore[T] {
 	return &MemStore[T]{
-		data: make(map[string]T),
+		data:   make(map[string]T),
+		feed:   newFeed[T](0),
+		labels: newLabelIndex(),
 	}
 }
 
 func (m *MemStore[T]) List() []T {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	return m.list()
+}
+
+func (m *MemStore[T]) list() []T {
 	out := make([]T, 0, len(m.data))
 	for _, item := range m.data {
 		out = append(out, item)
@@ -25,6 +37,22 @@ func (m *MemStore[T]) List() []T {
 	return out
 }
 
+func (m *MemStore[T]) Select(sel selector.Selector) []T {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names, indexed := m.labels.candidates(sel)
+	if !indexed {
+		return filterLabels(m.list(), sel)
+	}
+
+	out := make([]T, 0, len(names))
+	for _, name := range names {
+		out = append(out, m.data[name])
+	}
+	return filterLabels(out, sel)
+}
+
 func (m *MemStore[T]) Get(name string) (T, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -32,14 +60,66 @@ func (m *MemStore[T]) Get(name string) (T, bool) {
 	return item, ok
 }
 
-func (m *MemStore[T]) Put(name string, t T) {
+func (m *MemStore[T]) Put(name string, t T) T {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.write(name, t)
+}
+
+func (m *MemStore[T]) Update(name string, t T) (T, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	current, exists := m.data[name]
+	if err := checkVersion(&t, &current, exists); err != nil {
+		var zero T
+		return zero, err
+	}
+	return m.write(name, t), nil
+}
+
+// write stamps, stores and publishes t; the caller must hold m.mu.
+func (m *MemStore[T]) write(name string, t T) T {
+	eventType := types.EventAdded
+	var prev *T
+	if old, exists := m.data[name]; exists {
+		eventType = types.EventModified
+		prev = &old
+	}
+
+	m.version++
+	setResourceVersion(&t, m.version)
 	m.data[name] = t
+	m.labels.update(name, labelsOf(&t))
+	m.feed.publish(m.version, eventType, t, prev)
+	return t
 }
 
 func (m *MemStore[T]) Delete(name string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	item, exists := m.data[name]
+	if !exists {
+		return
+	}
 	delete(m.data, name)
+	m.labels.remove(name)
+
+	m.version++
+	setResourceVersion(&item, m.version)
+	m.feed.publish(m.version, types.EventDeleted, item, nil)
+}
+
+func (m *MemStore[T]) Watch(since uint64) (<-chan types.WatchEvent[T], func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var initial []T
+	if since == 0 {
+		initial = m.list()
+	}
+	return m.feed.watch(since, initial)
+}
+
+func (m *MemStore[T]) Version() uint64 {
+	return m.feed.version()
 }
@@ -1,14 +1,93 @@
 package store
 
-import "miniku/pkg/types"
+import (
+	"errors"
+	"miniku/pkg/selector"
+	"miniku/pkg/types"
+)
+
+var (
+	// ErrConflict is returned by Update when the object's resourceVersion
+	// no longer matches the stored one.
+	ErrConflict = errors.New("resource version conflict")
+	// ErrNotFound is returned by Update when a versioned write targets an
+	// object that does not exist.
+	ErrNotFound = errors.New("not found")
+)
 
 type Store[T any] interface {
 	List() []T
 	Get(name string) (T, bool)
-	Put(name string, t T)
+	// Select lists the objects whose labels match sel, using the label
+	// index to avoid scanning everything where it can.
+	Select(sel selector.Selector) []T
+	// Put writes t unconditionally and returns it as stored.
+	Put(name string, t T) T
+	// Update is a compare-and-swap Put: when t carries a non-zero
+	// resourceVersion it must match the stored object's, otherwise
+	// ErrConflict is returned and nothing is written.
+	Update(name string, t T) (T, error)
 	Delete(name string)
+	// Watch streams every change after resourceVersion since. With since
+	// zero it first sends the current contents as ADDED events. The channel
+	// is closed when the watcher falls too far behind; the returned func
+	// stops the watch.
+	Watch(since uint64) (<-chan types.WatchEvent[T], func(), error)
+	// Version is the resourceVersion of the latest write, a watch from it
+	// sees every change made after.
+	Version() uint64
+}
+
+// Versioned is implemented by objects carrying a resourceVersion. Stores
+// stamp every write of a Versioned object with a fresh version; other
+// objects are stored as-is and always overwrite.
+type Versioned interface {
+	GetResourceVersion() uint64
+	SetResourceVersion(v uint64)
+}
+
+// Namespaced is implemented by objects living in a namespace. They are
+// stored under types.Key(namespace, name).
+type Namespaced interface {
+	GetName() string
+	GetNamespace() string
+	SetNamespace(ns string)
 }
 
 type PodStore = Store[types.Pod]
 type NodeStore = Store[types.Node]
 type ReplicaSetStore = Store[types.ReplicaSet]
+type DeploymentStore = Store[types.Deployment]
+type ServiceStore = Store[types.Service]
+type EndpointsStore = Store[types.Endpoints]
+type NamespaceStore = Store[types.Namespace]
+type RoleStore = Store[types.Role]
+type RoleBindingStore = Store[types.RoleBinding]
+
+func resourceVersion[T any](t *T) uint64 {
+	if v, ok := any(t).(Versioned); ok {
+		return v.GetResourceVersion()
+	}
+	return 0
+}
+
+func setResourceVersion[T any](t *T, version uint64) {
+	if v, ok := any(t).(Versioned); ok {
+		v.SetResourceVersion(version)
+	}
+}
+
+// checkVersion decides whether a conditional write of t may replace current.
+func checkVersion[T any](t *T, current *T, exists bool) error {
+	want := resourceVersion(t)
+	if want == 0 {
+		return nil
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	if resourceVersion(current) != want {
+		return ErrConflict
+	}
+	return nil
+}
@@ -1,15 +1,26 @@
 package store
 
 import (
+	"errors"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	bolt "go.etcd.io/bbolt"
+
+	"miniku/pkg/selector"
+	"miniku/pkg/types"
 )
 
 type testItem struct {
-	Name  string
-	Value int
+	types.ObjectMeta
+	Name   string
+	Value  int
+	Labels map[string]string
+}
+
+func (i *testItem) GetLabels() map[string]string {
+	return i.Labels
 }
 
 // storeFactory creates a fresh store for testing.
@@ -113,6 +124,194 @@ func runStoreTests(t *testing.T, name string, factory storeFactory) {
 			}
 		})
 
+		t.Run("PutStampsIncreasingVersions", func(t *testing.T) {
+			s := factory(t)
+			first := s.Put("a", testItem{Name: "a", Value: 1})
+			second := s.Put("b", testItem{Name: "b", Value: 2})
+			third := s.Put("a", testItem{Name: "a", Value: 3})
+
+			if first.ResourceVersion == 0 {
+				t.Fatal("expected a non-zero resourceVersion")
+			}
+			if second.ResourceVersion <= first.ResourceVersion || third.ResourceVersion <= second.ResourceVersion {
+				t.Errorf("versions not increasing: %d, %d, %d",
+					first.ResourceVersion, second.ResourceVersion, third.ResourceVersion)
+			}
+
+			got, _ := s.Get("a")
+			if got.ResourceVersion != third.ResourceVersion {
+				t.Errorf("stored version %d, want %d", got.ResourceVersion, third.ResourceVersion)
+			}
+		})
+
+		t.Run("UpdateWithCurrentVersion", func(t *testing.T) {
+			s := factory(t)
+			stored := s.Put("a", testItem{Name: "a", Value: 1})
+
+			stored.Value = 2
+			updated, err := s.Update("a", stored)
+			if err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if updated.ResourceVersion <= stored.ResourceVersion {
+				t.Errorf("expected version to advance past %d, got %d", stored.ResourceVersion, updated.ResourceVersion)
+			}
+
+			got, _ := s.Get("a")
+			if got.Value != 2 {
+				t.Errorf("got Value=%d, want 2", got.Value)
+			}
+		})
+
+		t.Run("UpdateWithStaleVersion", func(t *testing.T) {
+			s := factory(t)
+			stale := s.Put("a", testItem{Name: "a", Value: 1})
+			s.Put("a", testItem{Name: "a", Value: 2})
+
+			stale.Value = 3
+			if _, err := s.Update("a", stale); !errors.Is(err, ErrConflict) {
+				t.Fatalf("got err %v, want ErrConflict", err)
+			}
+
+			got, _ := s.Get("a")
+			if got.Value != 2 {
+				t.Errorf("stale update was written: got Value=%d, want 2", got.Value)
+			}
+		})
+
+		t.Run("UpdateWithoutVersion", func(t *testing.T) {
+			s := factory(t)
+			s.Put("a", testItem{Name: "a", Value: 1})
+
+			if _, err := s.Update("a", testItem{Name: "a", Value: 2}); err != nil {
+				t.Fatalf("unconditional Update: %v", err)
+			}
+			if _, err := s.Update("b", testItem{Name: "b", Value: 1}); err != nil {
+				t.Fatalf("unconditional Update of missing item: %v", err)
+			}
+		})
+
+		t.Run("UpdateMissingWithVersion", func(t *testing.T) {
+			s := factory(t)
+			item := testItem{Name: "a", Value: 1}
+			item.ResourceVersion = 7
+
+			if _, err := s.Update("a", item); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("got err %v, want ErrNotFound", err)
+			}
+		})
+
+		t.Run("WatchFromZeroSendsCurrentState", func(t *testing.T) {
+			s := factory(t)
+			s.Put("a", testItem{Name: "a", Value: 1})
+
+			events, stop, err := s.Watch(0)
+			if err != nil {
+				t.Fatalf("Watch: %v", err)
+			}
+			defer stop()
+
+			s.Put("a", testItem{Name: "a", Value: 2})
+			s.Delete("a")
+
+			want := []types.EventType{types.EventAdded, types.EventModified, types.EventDeleted}
+			for i, wantType := range want {
+				ev := <-events
+				if ev.Type != wantType {
+					t.Errorf("event %d: got %s, want %s", i, ev.Type, wantType)
+				}
+			}
+		})
+
+		t.Run("WatchResumesFromVersion", func(t *testing.T) {
+			s := factory(t)
+			first := s.Put("a", testItem{Name: "a", Value: 1})
+			s.Put("b", testItem{Name: "b", Value: 2})
+			s.Delete("a")
+
+			events, stop, err := s.Watch(first.ResourceVersion)
+			if err != nil {
+				t.Fatalf("Watch: %v", err)
+			}
+			defer stop()
+
+			ev := <-events
+			if ev.Type != types.EventAdded || ev.Object.Name != "b" {
+				t.Errorf("got %s %s, want ADDED b", ev.Type, ev.Object.Name)
+			}
+			ev = <-events
+			if ev.Type != types.EventDeleted || ev.Object.Name != "a" {
+				t.Errorf("got %s %s, want DELETED a", ev.Type, ev.Object.Name)
+			}
+			if ev.Object.ResourceVersion <= first.ResourceVersion {
+				t.Errorf("delete event version %d not newer than %d", ev.Object.ResourceVersion, first.ResourceVersion)
+			}
+		})
+
+		t.Run("WatchTooOld", func(t *testing.T) {
+			s := factory(t)
+			first := s.Put("a", testItem{Name: "a"})
+			for i := range feedHistory + 1 {
+				s.Put("a", testItem{Name: "a", Value: i})
+			}
+
+			if _, _, err := s.Watch(first.ResourceVersion); !errors.Is(err, ErrVersionTooOld) {
+				t.Fatalf("got err %v, want ErrVersionTooOld", err)
+			}
+		})
+
+		t.Run("WatchStop", func(t *testing.T) {
+			s := factory(t)
+			events, stop, err := s.Watch(0)
+			if err != nil {
+				t.Fatalf("Watch: %v", err)
+			}
+			stop()
+			stop() // idempotent
+
+			s.Put("a", testItem{Name: "a"})
+			if _, ok := <-events; ok {
+				t.Error("expected channel to be closed after stop")
+			}
+		})
+
+		t.Run("Select", func(t *testing.T) {
+			s := factory(t)
+			s.Put("a", testItem{Name: "a", Labels: map[string]string{"app": "web", "tier": "frontend"}})
+			s.Put("b", testItem{Name: "b", Labels: map[string]string{"app": "web", "tier": "cache"}})
+			s.Put("c", testItem{Name: "c", Labels: map[string]string{"app": "api"}})
+			s.Put("d", testItem{Name: "d"})
+
+			tests := []struct {
+				selector string
+				want     []string
+			}{
+				{selector: "", want: []string{"a", "b", "c", "d"}},
+				{selector: "app=web", want: []string{"a", "b"}},
+				{selector: "app=web,tier!=cache", want: []string{"a"}},
+				{selector: "app in (web,api)", want: []string{"a", "b", "c"}},
+				{selector: "!app", want: []string{"d"}},
+				{selector: "app=nope", want: nil},
+			}
+			for _, tt := range tests {
+				sel, err := selector.ParseLabels(tt.selector)
+				if err != nil {
+					t.Fatalf("ParseLabels(%q): %v", tt.selector, err)
+				}
+				if got := names(s.Select(sel)); !slices.Equal(got, tt.want) {
+					t.Errorf("Select(%q) = %v, want %v", tt.selector, got, tt.want)
+				}
+			}
+
+			// the index follows relabels and deletes
+			s.Put("a", testItem{Name: "a", Labels: map[string]string{"app": "api"}})
+			s.Delete("b")
+			sel, _ := selector.ParseLabels("app=web")
+			if got := s.Select(sel); len(got) != 0 {
+				t.Errorf("Select(app=web) after relabel/delete = %v, want none", names(got))
+			}
+		})
+
 		t.Run("ListAfterDelete", func(t *testing.T) {
 			s := factory(t)
 			s.Put("a", testItem{Name: "a", Value: 1})
@@ -130,6 +329,15 @@ func runStoreTests(t *testing.T, name string, factory storeFactory) {
 	})
 }
 
+func names(items []testItem) []string {
+	var out []string
+	for _, item := range items {
+		out = append(out, item.Name)
+	}
+	slices.Sort(out)
+	return out
+}
+
 func TestMemStore(t *testing.T) {
 	runStoreTests(t, "MemStore", memFactory)
 }
@@ -137,3 +345,38 @@ func TestMemStore(t *testing.T) {
 func TestBoltStore(t *testing.T) {
 	runStoreTests(t, "BoltStore", boltFactory)
 }
+
+func TestBoltStoreMovesToNamespaces(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+
+	// objects as written before there were namespaces
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("services"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("web"), []byte(`{"name":"web","clusterIP":"10.96.0.1"}`)); err != nil {
+			return err
+		}
+		return b.Put([]byte("shop/api"), []byte(`{"name":"api","namespace":"shop"}`))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewBoltStore[types.Service](db, "services")
+
+	if _, ok := s.Get("web"); ok {
+		t.Error("expected nothing left under the bare name")
+	}
+	svc, ok := s.Get("default/web")
+	if !ok || svc.Namespace != types.DefaultNamespace || svc.ClusterIP != "10.96.0.1" {
+		t.Errorf("got %+v, want web moved into the default namespace", svc)
+	}
+	if _, ok := s.Get("shop/api"); !ok {
+		t.Error("expected the namespaced object to stay")
+	}
+}
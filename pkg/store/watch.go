@@ -0,0 +1,107 @@
+package store
+
+import (
+	"errors"
+	"sync"
+
+	"miniku/pkg/types"
+)
+
+// ErrVersionTooOld is returned by Watch when the requested resourceVersion
+// has already fallen out of the change history. Callers should list again
+// and watch from the fresh state.
+var ErrVersionTooOld = errors.New("resource version too old")
+
+const (
+	// number of past events kept so watchers can resume after a disconnect
+	feedHistory = 1000
+	// per-watcher buffer, a watcher that falls further behind is dropped
+	watchBuffer = 100
+)
+
+// feed is the change history and fan-out shared by the store implementations.
+// Stores call publish while holding their write lock, so events are delivered
+// in resourceVersion order. Every write, including deletes, takes exactly one
+// version, which keeps the history contiguous.
+type feed[T any] struct {
+	mu       sync.Mutex
+	last     uint64
+	history  []types.WatchEvent[T]
+	watchers map[chan types.WatchEvent[T]]struct{}
+}
+
+func newFeed[T any](last uint64) *feed[T] {
+	return &feed[T]{
+		last:     last,
+		watchers: make(map[chan types.WatchEvent[T]]struct{}),
+	}
+}
+
+// publish sends the write of obj, prev is what it replaced if anything.
+func (f *feed[T]) publish(version uint64, eventType types.EventType, obj T, prev *T) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ev := types.WatchEvent[T]{Type: eventType, Object: obj, Previous: prev}
+	f.last = version
+	f.history = append(f.history, ev)
+	if len(f.history) > feedHistory {
+		f.history = f.history[len(f.history)-feedHistory:]
+	}
+
+	for ch := range f.watchers {
+		select {
+		case ch <- ev:
+		default:
+			// too slow, the watcher resumes from its last seen version
+			delete(f.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (f *feed[T]) version() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.last
+}
+
+// watch registers a new watcher. With since == 0 the watcher first receives
+// initial as ADDED events, otherwise it receives the history after since.
+// The store must hold its write lock so no publish can slip in between.
+func (f *feed[T]) watch(since uint64, initial []T) (<-chan types.WatchEvent[T], func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var backlog []types.WatchEvent[T]
+	if since == 0 {
+		for _, obj := range initial {
+			backlog = append(backlog, types.WatchEvent[T]{Type: types.EventAdded, Object: obj})
+		}
+	} else if since < f.last {
+		oldest := f.last - uint64(len(f.history)) + 1
+		if since+1 < oldest {
+			return nil, nil, ErrVersionTooOld
+		}
+		backlog = f.history[len(f.history)-int(f.last-since):]
+	}
+
+	ch := make(chan types.WatchEvent[T], len(backlog)+watchBuffer)
+	for _, ev := range backlog {
+		ch <- ev
+	}
+	f.watchers[ch] = struct{}{}
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if _, ok := f.watchers[ch]; ok {
+				delete(f.watchers, ch)
+				close(ch)
+			}
+		})
+	}
+	return ch, stop, nil
+}
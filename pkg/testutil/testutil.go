@@ -14,14 +14,36 @@ type TestEnv struct {
 	PodStore  store.PodStore
 	RSStore   store.ReplicaSetStore
 	NodeStore store.NodeStore
+
+	DeploymentStore store.DeploymentStore
+	ServiceStore    store.ServiceStore
+	EndpointsStore  store.EndpointsStore
+	NamespaceStore  store.NamespaceStore
+
+	RoleBindingStore store.RoleBindingStore
 }
 
 func NewTestEnv() *TestEnv {
 	podStore := store.NewMemStore[types.Pod]()
 	rsStore := store.NewMemStore[types.ReplicaSet]()
 	nodeStore := store.NewMemStore[types.Node]()
+	deploymentStore := store.NewMemStore[types.Deployment]()
+	serviceStore := store.NewMemStore[types.Service]()
+	endpointsStore := store.NewMemStore[types.Endpoints]()
+	namespaceStore := store.NewMemStore[types.Namespace]()
+	roleBindingStore := store.NewMemStore[types.RoleBinding]()
 
-	srv := &api.Server{PodStore: podStore, RSStore: rsStore, NodeStore: nodeStore}
+	srv := &api.Server{
+		PodStore:         podStore,
+		RSStore:          rsStore,
+		NodeStore:        nodeStore,
+		DeploymentStore:  deploymentStore,
+		ServiceStore:     serviceStore,
+		EndpointsStore:   endpointsStore,
+		NamespaceStore:   namespaceStore,
+		RoleStore:        store.NewMemStore[types.Role](),
+		RoleBindingStore: roleBindingStore,
+	}
 	ts := httptest.NewServer(srv.Routes())
 
 	c := client.New(ts.URL)
@@ -32,9 +54,18 @@ func NewTestEnv() *TestEnv {
 		PodStore:  podStore,
 		RSStore:   rsStore,
 		NodeStore: nodeStore,
+
+		DeploymentStore: deploymentStore,
+		ServiceStore:    serviceStore,
+		EndpointsStore:  endpointsStore,
+		NamespaceStore:  namespaceStore,
+
+		RoleBindingStore: roleBindingStore,
 	}
 }
 
 func (e *TestEnv) Close() {
+	// informers hold watch requests open, drop them so Close doesn't block
+	e.Server.CloseClientConnections()
 	e.Server.Close()
 }
@@ -0,0 +1,44 @@
+package types
+
+import "encoding/json"
+
+// AdmissionReview is what the API server POSTs to an admission webhook, with
+// Request set, and what the webhook answers, with Response set.
+type AdmissionReview struct {
+	Request  *AdmissionRequest  `json:"request,omitempty"`
+	Response *AdmissionResponse `json:"response,omitempty"`
+}
+
+// AdmissionRequest describes the write being admitted.
+type AdmissionRequest struct {
+	// UID identifies the request, the response echoes it.
+	UID       string             `json:"uid"`
+	Operation AdmissionOperation `json:"operation"`
+	// Resource is the collection written to, e.g. pods.
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// UserInfo is who asked for the write.
+	UserInfo UserInfo `json:"userInfo"`
+	// Object is the object as it would be stored, empty on DELETE.
+	Object json.RawMessage `json:"object,omitempty"`
+	// OldObject is the stored object on UPDATE and DELETE.
+	OldObject json.RawMessage `json:"oldObject,omitempty"`
+}
+
+// AdmissionResponse is a webhook's verdict. A mutating webhook can send the
+// object back changed in Object, it replaces the one in the request.
+type AdmissionResponse struct {
+	UID     string          `json:"uid"`
+	Allowed bool            `json:"allowed"`
+	Status  *Status         `json:"status,omitempty"`
+	Object  json.RawMessage `json:"object,omitempty"`
+}
+
+type AdmissionOperation string
+
+const (
+	AdmissionCreate AdmissionOperation = "CREATE"
+	AdmissionUpdate AdmissionOperation = "UPDATE"
+	AdmissionDelete AdmissionOperation = "DELETE"
+)
@@ -0,0 +1,22 @@
+package types
+
+// CertificateSigningRequest asks the API server's CA for a certificate, the
+// answer carries it in Certificate.
+type CertificateSigningRequest struct {
+	// Request is a PEM encoded PKCS #10 certificate request.
+	Request string           `json:"request"`
+	Usage   CertificateUsage `json:"usage"`
+	// Certificate is the PEM encoded certificate the CA signed.
+	Certificate string `json:"certificate,omitempty"`
+	// CA is the PEM encoded certificate of the CA, for the CA bundle.
+	CA string `json:"ca,omitempty"`
+}
+
+// CertificateUsage is what a certificate is for, authenticating a client
+// or serving TLS.
+type CertificateUsage string
+
+const (
+	CertificateUsageClient CertificateUsage = "client"
+	CertificateUsageServer CertificateUsage = "server"
+)
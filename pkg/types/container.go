@@ -1,8 +1,15 @@
 package types
 
 type ContainerState struct {
-	Status   ContainerStatus
-	ExitCode int // if exited
+	Status   ContainerStatus `json:"status"`
+	ExitCode int             `json:"exitCode,omitempty"` // if exited
+	// Reason says why the container exited, e.g. ContainerReasonError.
+	Reason string `json:"reason,omitempty"`
+	// ContainerID is set on a pod's LastState, for reading its logs.
+	ContainerID string `json:"containerID,omitempty"`
+	// IP is the address of a running container's network namespace, empty
+	// when it shares the node's network.
+	IP string `json:"ip,omitempty"`
 }
 
 type ContainerStatus string
@@ -13,3 +20,11 @@ const (
 	ContainerStatusExited      ContainerStatus = "Exited"
 	ContainerStatusUnknown     ContainerStatus = "Unknown"
 )
+
+const (
+	ContainerReasonCompleted = "Completed"
+	ContainerReasonError     = "Error"
+	// ContainerReasonOOMKilled means the container ran out of its memory
+	// limit and was killed by the kernel.
+	ContainerReasonOOMKilled = "OOMKilled"
+)
@@ -0,0 +1,64 @@
+package types
+
+// PodTemplateHashLabel is added to the selector, labels and pod template of
+// every ReplicaSet a Deployment creates, so the pods of different revisions
+// never match each other's ReplicaSet.
+const PodTemplateHashLabel = "pod-template-hash"
+
+// DefaultRevisionHistoryLimit is how many scaled-down ReplicaSets a
+// Deployment keeps around for rollbacks unless told otherwise.
+const DefaultRevisionHistoryLimit = 10
+
+type Deployment struct {
+	ObjectMeta
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Replicas  uint              `json:"replicas"`
+	Selector  map[string]string `json:"selector"`
+	Template  PodSpec           `json:"template"`
+	Strategy  RollingUpdate     `json:"strategy"`
+	// Paused stops template changes from being rolled out until it is
+	// cleared again.
+	Paused bool `json:"paused,omitempty"`
+	// RevisionHistoryLimit caps the old ReplicaSets kept for rollbacks,
+	// nil means DefaultRevisionHistoryLimit.
+	RevisionHistoryLimit *uint            `json:"revisionHistoryLimit,omitempty"`
+	Status               DeploymentStatus `json:"status"`
+}
+
+// RollingUpdate bounds how far a rollout may stray from Replicas. Leaving
+// both at zero means a MaxSurge of one: a new pod is started before an old
+// one is stopped.
+type RollingUpdate struct {
+	// MaxSurge is how many pods above Replicas may exist during a rollout.
+	MaxSurge uint `json:"maxSurge"`
+	// MaxUnavailable is how many pods below Replicas may be not running
+	// during a rollout.
+	MaxUnavailable uint `json:"maxUnavailable"`
+}
+
+type DeploymentStatus struct {
+	// Revision is the revision of the current pod template.
+	Revision        uint64 `json:"revision"`
+	Replicas        uint   `json:"replicas"`
+	UpdatedReplicas uint   `json:"updatedReplicas"`
+	ReadyReplicas   uint   `json:"readyReplicas"`
+}
+
+// DeploymentRollback asks for the template of an earlier revision to be
+// rolled out again. A zero Revision means the one before the current.
+type DeploymentRollback struct {
+	Revision uint64 `json:"revision"`
+}
+
+func (d *Deployment) GetName() string {
+	return d.Name
+}
+
+func (d *Deployment) GetNamespace() string {
+	return d.Namespace
+}
+
+func (d *Deployment) SetNamespace(ns string) {
+	d.Namespace = ns
+}
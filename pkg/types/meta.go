@@ -0,0 +1,123 @@
+package types
+
+import "time"
+
+// ObjectMeta holds the bookkeeping fields shared by every stored object.
+// It is embedded so its fields are inlined in the JSON representation.
+type ObjectMeta struct {
+	// ResourceVersion is stamped by the store on every write. Sending it back
+	// on update makes the write conditional on nobody else having written in
+	// between; leaving it zero overwrites unconditionally.
+	ResourceVersion uint64 `json:"resourceVersion,omitempty"`
+	// OwnerReferences name the objects, in the same namespace, this one
+	// belongs to. Once all of them are gone the garbage collector deletes it.
+	OwnerReferences []OwnerReference `json:"ownerReferences,omitempty"`
+	// DeletionTimestamp is set when the object was deleted but a finalizer
+	// or grace period still holds it. It is removed once the finalizers are
+	// cleared and, for a pod, its kubelet confirmed the container stopped.
+	DeletionTimestamp *time.Time `json:"deletionTimestamp,omitempty"`
+	// DeletionGracePeriodSeconds is how long after DeletionTimestamp a pod's
+	// container is killed if it didn't stop by itself.
+	DeletionGracePeriodSeconds *int64 `json:"deletionGracePeriodSeconds,omitempty"`
+	// Finalizers each stand for cleanup still to be done before the object
+	// can go, whoever does it removes its own entry.
+	Finalizers []string `json:"finalizers,omitempty"`
+}
+
+// Object is implemented by every stored kind through its embedded
+// ObjectMeta.
+type Object interface {
+	GetObjectMeta() *ObjectMeta
+}
+
+// OwnerReference points from an object to one of its owners.
+type OwnerReference struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Controller marks the owner managing the object, there is at most one.
+	Controller bool `json:"controller,omitempty"`
+}
+
+// The kinds owner references point to.
+const (
+	KindDeployment = "Deployment"
+	KindReplicaSet = "ReplicaSet"
+	KindPod        = "Pod"
+)
+
+// DeletionPropagation says what happens to an object's dependents when it is
+// deleted.
+type DeletionPropagation string
+
+const (
+	// DeletePropagationBackground deletes the object right away and its
+	// dependents after it. It is the default.
+	DeletePropagationBackground DeletionPropagation = "Background"
+	// DeletePropagationForeground keeps the object, marked deleted, until
+	// its dependents are gone.
+	DeletePropagationForeground DeletionPropagation = "Foreground"
+	// DeletePropagationOrphan keeps the dependents, removing their owner
+	// reference to the object.
+	DeletePropagationOrphan DeletionPropagation = "Orphan"
+)
+
+// Finalizers the garbage collector works off before a deleted owner goes.
+const (
+	FinalizerForegroundDeletion = "foregroundDeletion"
+	FinalizerOrphan             = "orphan"
+)
+
+func (m *ObjectMeta) GetResourceVersion() uint64 {
+	return m.ResourceVersion
+}
+
+func (m *ObjectMeta) SetResourceVersion(v uint64) {
+	m.ResourceVersion = v
+}
+
+func (m *ObjectMeta) GetObjectMeta() *ObjectMeta {
+	return m
+}
+
+// ControllerRef returns the owner reference of the object's controller, nil
+// when nothing controls it.
+func (m *ObjectMeta) ControllerRef() *OwnerReference {
+	for i := range m.OwnerReferences {
+		if m.OwnerReferences[i].Controller {
+			return &m.OwnerReferences[i]
+		}
+	}
+	return nil
+}
+
+// IsControlledBy reports whether the object's controller is the kind and
+// name given.
+func (m *ObjectMeta) IsControlledBy(kind, name string) bool {
+	ref := m.ControllerRef()
+	return ref != nil && ref.Kind == kind && ref.Name == name
+}
+
+// Deleting reports whether the object was deleted and waits on finalizers
+// or its grace period.
+func (m *ObjectMeta) Deleting() bool {
+	return m.DeletionTimestamp != nil
+}
+
+// Finalized reports whether the object was deleted and nothing holds it
+// anymore.
+func (m *ObjectMeta) Finalized() bool {
+	return m.Deleting() && len(m.Finalizers) == 0 &&
+		(m.DeletionGracePeriodSeconds == nil || *m.DeletionGracePeriodSeconds == 0)
+}
+
+// DeletionDeadline is when the grace period of a deleted object runs out.
+func (m *ObjectMeta) DeletionDeadline() time.Time {
+	if m.DeletionTimestamp == nil {
+		return time.Time{}
+	}
+	var grace int64
+	if m.DeletionGracePeriodSeconds != nil {
+		grace = *m.DeletionGracePeriodSeconds
+	}
+	return m.DeletionTimestamp.Add(time.Duration(grace) * time.Second)
+}
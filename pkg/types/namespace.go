@@ -0,0 +1,48 @@
+package types
+
+import "strings"
+
+// DefaultNamespace is where objects go that don't name a namespace. It
+// always exists.
+const DefaultNamespace = "default"
+
+// Namespace scopes the names of pods, ReplicaSets, Deployments, Services and
+// Endpoints, two namespaces can each have a web ReplicaSet. Nodes and
+// namespaces themselves belong to the cluster.
+type Namespace struct {
+	ObjectMeta
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Phase is Terminating once deletion was asked for, its objects are
+	// deleted and nothing new can be created in it.
+	Phase NamespacePhase `json:"phase,omitempty"`
+}
+
+type NamespacePhase string
+
+const (
+	NamespaceActive      NamespacePhase = "Active"
+	NamespaceTerminating NamespacePhase = "Terminating"
+)
+
+func (ns *Namespace) GetLabels() map[string]string {
+	return ns.Labels
+}
+
+// Key is what a namespaced object is stored and cached under,
+// namespace/name. An empty namespace is DefaultNamespace.
+func Key(namespace, name string) string {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	return namespace + "/" + name
+}
+
+// SplitKey takes a Key apart. A bare name is in DefaultNamespace.
+func SplitKey(key string) (namespace, name string) {
+	namespace, name, ok := strings.Cut(key, "/")
+	if !ok {
+		return DefaultNamespace, key
+	}
+	return namespace, name
+}
@@ -3,9 +3,28 @@ package types
 import "time"
 
 type Node struct {
+	ObjectMeta
 	Name          string    `json:"name"`
 	Status        NodeState `json:"status"`
 	LastHeartbeat time.Time `json:"time"`
+	// Labels describe the node, e.g. the zone it is in.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Address is where the node's kubelet serves logs and exec, host:port.
+	Address string `json:"address,omitempty"`
+	// PodCIDR is the range the node's pods get their IPs from.
+	PodCIDR string `json:"podCIDR,omitempty"`
+	// Capacity is what the node has in total, Allocatable what of it is
+	// left for pods. Both are reported by the kubelet.
+	Capacity    ResourceList `json:"capacity,omitzero"`
+	Allocatable ResourceList `json:"allocatable,omitzero"`
+}
+
+// LabelZone is the node label naming the failure zone the node is in.
+const LabelZone = "topology.kubernetes.io/zone"
+
+// Zone is the failure zone of the node, empty when it doesn't say.
+func (n *Node) Zone() string {
+	return n.Labels[LabelZone]
 }
 
 type NodeState string
@@ -0,0 +1,15 @@
+package types
+
+// PatchType is the Content-Type of a PATCH request, it says how the body
+// changes the object.
+type PatchType string
+
+const (
+	// MergePatchType is an RFC 7386 JSON merge patch: the fields sent
+	// replace those of the object, objects are merged and null removes a
+	// field.
+	MergePatchType PatchType = "application/merge-patch+json"
+	// JSONPatchType is an RFC 6902 JSON patch, a list of operations on
+	// paths in the object whose test operations make it conditional.
+	JSONPatchType PatchType = "application/json-patch+json"
+)
@@ -1,34 +1,206 @@
 package types
 
 import (
+	"slices"
 	"time"
 )
 
 type PodSpec struct {
-	Name     string            `json:"name"`
-	Image    string            `json:"image"`
-	NodeName string            `json:"node_name"`
-	Command  []string          `json:"command,omitempty"`
-	Env      map[string]string `json:"env,omitempty"`
-	Labels   map[string]string `json:"labels,omitempty"`
+	Name string `json:"name"`
+	// Namespace is the pod's namespace, empty in templates, whose pods go
+	// into the namespace of their ReplicaSet.
+	Namespace string            `json:"namespace,omitempty"`
+	Image     string            `json:"image"`
+	NodeName  string            `json:"node_name"`
+	Command   []string          `json:"command,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	// RestartPolicy decides whether an exited container is started again,
+	// empty means RestartPolicyAlways.
+	RestartPolicy RestartPolicy `json:"restartPolicy,omitempty"`
+	// Resources are enforced by the runtime through cgroups.
+	Resources ResourceRequirements `json:"resources,omitzero"`
+
+	// LivenessProbe failing restarts the container.
+	LivenessProbe *Probe `json:"livenessProbe,omitempty"`
+	// ReadinessProbe decides the pod's Ready condition.
+	ReadinessProbe *Probe `json:"readinessProbe,omitempty"`
+	// StartupProbe holds the other probes off until it first succeeds,
+	// failing it restarts the container.
+	StartupProbe *Probe `json:"startupProbe,omitempty"`
+
+	// TerminationGracePeriodSeconds is how long the container gets to exit
+	// after SIGTERM before it is killed, nil means
+	// DefaultTerminationGracePeriodSeconds.
+	TerminationGracePeriodSeconds *int64 `json:"terminationGracePeriodSeconds,omitempty"`
+}
+
+// DefaultTerminationGracePeriodSeconds is the grace period of a pod that
+// doesn't set one.
+const DefaultTerminationGracePeriodSeconds = 30
+
+// GracePeriod is how long the container gets to exit after SIGTERM.
+func (s PodSpec) GracePeriod() time.Duration {
+	seconds := int64(DefaultTerminationGracePeriodSeconds)
+	if s.TerminationGracePeriodSeconds != nil {
+		seconds = *s.TerminationGracePeriodSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+type RestartPolicy string
+
+const (
+	RestartPolicyAlways    RestartPolicy = "Always"
+	RestartPolicyOnFailure RestartPolicy = "OnFailure"
+	RestartPolicyNever     RestartPolicy = "Never"
+)
+
+// ShouldRestart reports whether a container that exited with exitCode is
+// started again under the policy.
+func (p RestartPolicy) ShouldRestart(exitCode int) bool {
+	switch p {
+	case RestartPolicyNever:
+		return false
+	case RestartPolicyOnFailure:
+		return exitCode != 0
+	default:
+		return true
+	}
 }
 
 type PodStatus string
 
 const (
-	PodStatusPending PodStatus = "Pending"
-	PodStatusRunning PodStatus = "Running"
-	PodStatusFailed  PodStatus = "Failed"
-	PodStatusUnknown PodStatus = "Unknown"
+	PodStatusPending   PodStatus = "Pending"
+	PodStatusRunning   PodStatus = "Running"
+	PodStatusSucceeded PodStatus = "Succeeded"
+	PodStatusFailed    PodStatus = "Failed"
+	PodStatusUnknown   PodStatus = "Unknown"
 )
 
+// PodReasonCrashLoopBackOff is set while a restarting container waits out
+// its backoff.
+const PodReasonCrashLoopBackOff = "CrashLoopBackOff"
+
+// PodReasonUnschedulable is set while no node can take the pod, the message
+// says why.
+const PodReasonUnschedulable = "Unschedulable"
+
+// PodReasonNodeLost is set on a pod evicted from a node that stopped
+// responding, its status is Unknown since nobody can tell anymore.
+const PodReasonNodeLost = "NodeLost"
+
 type Pod struct {
+	ObjectMeta
 	Spec        PodSpec   `json:"spec"`
 	Status      PodStatus `json:"status"`
 	ContainerID string    `json:"containerId,omitempty"`
+	// PodIP is the address of the running pod on its node's pod network.
+	PodIP       string    `json:"podIP,omitempty"`
 	Message     string    `json:"message,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
 	RetryCount  uint8     `json:"retry_count"`
 	NextRetryAt time.Time `json:"next_retry_at"`
+	// RestartCount counts how often the container was restarted in place.
+	RestartCount uint `json:"restartCount"`
+	// StartedAt is when the current container was started.
+	StartedAt time.Time `json:"startedAt,omitzero"`
+	// BackoffCount counts the restarts since a container last ran stably,
+	// the CrashLoopBackOff delay grows with it.
+	BackoffCount uint `json:"backoffCount,omitempty"`
+	// LastState is how the previous container ended.
+	LastState  *ContainerState `json:"lastState,omitempty"`
+	Conditions []PodCondition  `json:"conditions,omitempty"`
+}
+
+type PodConditionType string
+
+// PodReady is True once the container runs and passes its readiness probe.
+const PodReady PodConditionType = "Ready"
+
+type ConditionStatus string
+
+const (
+	ConditionTrue  ConditionStatus = "True"
+	ConditionFalse ConditionStatus = "False"
+)
+
+type PodCondition struct {
+	Type               PodConditionType `json:"type"`
+	Status             ConditionStatus  `json:"status"`
+	LastTransitionTime time.Time        `json:"lastTransitionTime"`
+	Reason             string           `json:"reason,omitempty"`
+}
+
+// Condition returns the condition of type t, if the pod has one.
+func (p *Pod) Condition(t PodConditionType) (PodCondition, bool) {
+	for _, c := range p.Conditions {
+		if c.Type == t {
+			return c, true
+		}
+	}
+	return PodCondition{}, false
+}
+
+// SetCondition sets the status of a condition, stamping the transition time
+// when the status changes. It reports whether anything changed.
+func (p *Pod) SetCondition(t PodConditionType, status ConditionStatus, reason string) bool {
+	for i, c := range p.Conditions {
+		if c.Type != t {
+			continue
+		}
+		if c.Status == status && c.Reason == reason {
+			return false
+		}
+		// don't write through to a copy shared with e.g. an informer cache
+		p.Conditions = slices.Clone(p.Conditions)
+		if c.Status != status {
+			p.Conditions[i].LastTransitionTime = time.Now()
+		}
+		p.Conditions[i].Status = status
+		p.Conditions[i].Reason = reason
+		return true
+	}
+	p.Conditions = append(p.Conditions, PodCondition{
+		Type:               t,
+		Status:             status,
+		LastTransitionTime: time.Now(),
+		Reason:             reason,
+	})
+	return true
+}
+
+// IsReady reports whether the pod is running and passed its readiness probe.
+func (p *Pod) IsReady() bool {
+	c, ok := p.Condition(PodReady)
+	return p.Status == PodStatusRunning && ok && c.Status == ConditionTrue
+}
+
+// Finished reports whether the pod reached a terminal status.
+func (p *Pod) Finished() bool {
+	return p.Status == PodStatusSucceeded || p.Status == PodStatusFailed
+}
+
+func (p *Pod) GetLabels() map[string]string {
+	return p.Spec.Labels
+}
+
+func (p *Pod) GetName() string {
+	return p.Spec.Name
+}
+
+func (p *Pod) GetNamespace() string {
+	return p.Spec.Namespace
+}
+
+func (p *Pod) SetNamespace(ns string) {
+	p.Spec.Namespace = ns
+}
+
+// Key is what the pod is stored and cached under.
+func (p *Pod) Key() string {
+	return Key(p.Spec.Namespace, p.Spec.Name)
 }
 
 func NewPod(Spec PodSpec) Pod {
@@ -0,0 +1,68 @@
+package types
+
+import "time"
+
+// Probe is a periodic health check the kubelet runs against a pod's
+// container. Exactly one of Exec, HTTPGet and TCPSocket is set.
+type Probe struct {
+	Exec      *ExecAction      `json:"exec,omitempty"`
+	HTTPGet   *HTTPGetAction   `json:"httpGet,omitempty"`
+	TCPSocket *TCPSocketAction `json:"tcpSocket,omitempty"`
+
+	// zero values mean the defaults noted on each field
+	InitialDelaySeconds int `json:"initialDelaySeconds,omitempty"` // 0
+	PeriodSeconds       int `json:"periodSeconds,omitempty"`       // 10
+	TimeoutSeconds      int `json:"timeoutSeconds,omitempty"`      // 1
+	SuccessThreshold    int `json:"successThreshold,omitempty"`    // 1
+	FailureThreshold    int `json:"failureThreshold,omitempty"`    // 3
+}
+
+// ExecAction runs Command inside the container, exit code 0 is healthy.
+type ExecAction struct {
+	Command []string `json:"command"`
+}
+
+// HTTPGetAction is healthy on a 2xx or 3xx response.
+type HTTPGetAction struct {
+	Path string `json:"path,omitempty"`
+	Port int    `json:"port"`
+	// Host defaults to the pod's address.
+	Host string `json:"host,omitempty"`
+}
+
+// TCPSocketAction is healthy when the port accepts a connection.
+type TCPSocketAction struct {
+	Port int    `json:"port"`
+	Host string `json:"host,omitempty"`
+}
+
+func (p *Probe) InitialDelay() time.Duration {
+	return time.Duration(p.InitialDelaySeconds) * time.Second
+}
+
+func (p *Probe) Period() time.Duration {
+	return seconds(p.PeriodSeconds, 10)
+}
+
+func (p *Probe) Timeout() time.Duration {
+	return seconds(p.TimeoutSeconds, 1)
+}
+
+func (p *Probe) Successes() int {
+	return orDefault(p.SuccessThreshold, 1)
+}
+
+func (p *Probe) Failures() int {
+	return orDefault(p.FailureThreshold, 3)
+}
+
+func seconds(n, def int) time.Duration {
+	return time.Duration(orDefault(n, def)) * time.Second
+}
+
+func orDefault(n, def int) int {
+	if n <= 0 {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,64 @@
+package types
+
+// UserInfo is who the API server authenticated a request as.
+type UserInfo struct {
+	Name   string   `json:"name"`
+	UID    string   `json:"uid,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Role grants the verbs of its rules on the resources they name. A Role
+// grants nothing by itself, RoleBindings give it to users and groups.
+type Role struct {
+	ObjectMeta
+	Name  string       `json:"name"`
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyRule allows Verbs on Resources. "*" matches any verb or resource.
+type PolicyRule struct {
+	// Verbs are get, list, watch, create, update, patch and delete.
+	Verbs []string `json:"verbs"`
+	// Resources are collections, e.g. pods, or subresources, e.g. pods/log,
+	// pods/exec or deployments/rollback.
+	Resources []string `json:"resources"`
+	// ResourceNames narrows the rule down to objects of those names, empty
+	// means all of them.
+	ResourceNames []string `json:"resourceNames,omitempty"`
+}
+
+// RoleBinding grants the rules of Role to its subjects, in Namespace or, if
+// it is empty, everywhere including resources outside namespaces like
+// nodes.
+type RoleBinding struct {
+	ObjectMeta
+	Name      string    `json:"name"`
+	Namespace string    `json:"namespace,omitempty"`
+	Role      string    `json:"role"`
+	Subjects  []Subject `json:"subjects"`
+}
+
+// Subject is a user or group a RoleBinding grants its role to.
+type Subject struct {
+	Kind SubjectKind `json:"kind"`
+	Name string      `json:"name"`
+}
+
+type SubjectKind string
+
+const (
+	SubjectUser  SubjectKind = "User"
+	SubjectGroup SubjectKind = "Group"
+)
+
+// The verbs of PolicyRule.
+const (
+	VerbGet    = "get"
+	VerbList   = "list"
+	VerbWatch  = "watch"
+	VerbCreate = "create"
+	VerbUpdate = "update"
+	VerbPatch  = "patch"
+	VerbDelete = "delete"
+	VerbAll    = "*"
+)
@@ -1,9 +1,37 @@
 package types
 
 type ReplicaSet struct {
+	ObjectMeta
 	Name         string            `json:"name"`
+	Namespace    string            `json:"namespace,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
 	DesiredCount uint              `json:"desiredCount"`
 	CurrentCount uint              `json:"currentCount"`
-	Selector     map[string]string `json:"selector"`
-	Template     PodSpec           `json:"template"`
+	// ReadyCount is how many of the pods are running.
+	ReadyCount uint              `json:"readyCount"`
+	Selector   map[string]string `json:"selector"`
+	Template   PodSpec           `json:"template"`
+	// Revision is set on ReplicaSets managed by a Deployment, the
+	// Deployment's history is its ReplicaSets ordered by revision.
+	Revision uint64 `json:"revision,omitempty"`
+	// DeploymentTemplate is the Deployment's pod template the ReplicaSet was
+	// made from, without the labels the controller added to Template. A
+	// rollback to its revision restores it.
+	DeploymentTemplate *PodSpec `json:"deploymentTemplate,omitempty"`
+}
+
+func (rs *ReplicaSet) GetLabels() map[string]string {
+	return rs.Labels
+}
+
+func (rs *ReplicaSet) GetName() string {
+	return rs.Name
+}
+
+func (rs *ReplicaSet) GetNamespace() string {
+	return rs.Namespace
+}
+
+func (rs *ReplicaSet) SetNamespace(ns string) {
+	rs.Namespace = ns
 }
@@ -0,0 +1,34 @@
+package types
+
+// ResourceList is an amount of each resource a container uses. Zero means
+// unset.
+type ResourceList struct {
+	// CPU in millicores, 1000 is one core.
+	CPU int64 `json:"cpu,omitempty"`
+	// Memory in bytes.
+	Memory int64 `json:"memory,omitempty"`
+	// PIDs is the number of processes and threads.
+	PIDs int64 `json:"pids,omitempty"`
+	// Pods is the number of pods, only nodes have it.
+	Pods int64 `json:"pods,omitempty"`
+}
+
+// ResourceRequirements are what a container is guaranteed (Requests) and what
+// it may use at most (Limits).
+type ResourceRequirements struct {
+	Requests ResourceList `json:"requests,omitzero"`
+	Limits   ResourceList `json:"limits,omitzero"`
+}
+
+// Requested is what the scheduler reserves for a container: its requests,
+// falling back to the limit for resources without a request.
+func (r ResourceRequirements) Requested() ResourceList {
+	out := r.Requests
+	if out.CPU == 0 {
+		out.CPU = r.Limits.CPU
+	}
+	if out.Memory == 0 {
+		out.Memory = r.Limits.Memory
+	}
+	return out
+}
@@ -0,0 +1,90 @@
+package types
+
+// Service gives the pods matching its selector one stable virtual IP. The
+// proxy on every node forwards connections to ClusterIP:Port to the ready
+// pods, which are tracked in the Endpoints object of the same name.
+type Service struct {
+	ObjectMeta
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Selector  map[string]string `json:"selector"`
+	Ports     []ServicePort     `json:"ports"`
+	// ClusterIP is allocated from the API server's service range when left
+	// empty, and can't be changed afterwards.
+	ClusterIP string `json:"clusterIP,omitempty"`
+}
+
+type Protocol string
+
+const ProtocolTCP Protocol = "TCP"
+
+type ServicePort struct {
+	// Name tells the ports of a service apart, it may be empty when there
+	// is only one.
+	Name string `json:"name,omitempty"`
+	// Protocol is TCP, the only one supported. Empty means TCP.
+	Protocol Protocol `json:"protocol,omitempty"`
+	// Port is where the service listens on its ClusterIP.
+	Port int `json:"port"`
+	// TargetPort is the pods' port, zero means the same as Port.
+	TargetPort int `json:"targetPort,omitempty"`
+}
+
+// Target is the port connections are forwarded to on the pods.
+func (p ServicePort) Target() int {
+	if p.TargetPort == 0 {
+		return p.Port
+	}
+	return p.TargetPort
+}
+
+func (s *Service) GetLabels() map[string]string {
+	return s.Labels
+}
+
+func (s *Service) GetName() string {
+	return s.Name
+}
+
+func (s *Service) GetNamespace() string {
+	return s.Namespace
+}
+
+func (s *Service) SetNamespace(ns string) {
+	s.Namespace = ns
+}
+
+// Endpoints lists where a service's ready pods can be reached. It is written
+// by the endpoints controller and named after its service.
+type Endpoints struct {
+	ObjectMeta
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Addresses []EndpointAddress `json:"addresses"`
+	Ports     []EndpointPort    `json:"ports"`
+}
+
+type EndpointAddress struct {
+	IP       string `json:"ip"`
+	NodeName string `json:"nodeName,omitempty"`
+	PodName  string `json:"podName,omitempty"`
+}
+
+// EndpointPort is the pods' port for the service port of the same name.
+type EndpointPort struct {
+	Name string `json:"name,omitempty"`
+	Port int    `json:"port"`
+}
+
+func (ep *Endpoints) GetName() string {
+	return ep.Name
+}
+
+func (ep *Endpoints) GetNamespace() string {
+	return ep.Namespace
+}
+
+func (ep *Endpoints) SetNamespace(ns string) {
+	ep.Namespace = ns
+}
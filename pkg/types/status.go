@@ -0,0 +1,51 @@
+package types
+
+// Status is the body of a request the API server rejected because of what
+// was in it, e.g. 422 Unprocessable Entity for an invalid object or 403
+// Forbidden for one admission control denied. Causes name each field that
+// is wrong.
+type Status struct {
+	Code    int           `json:"code"`
+	Reason  StatusReason  `json:"reason"`
+	Message string        `json:"message"`
+	Causes  []StatusCause `json:"causes,omitempty"`
+}
+
+type StatusReason string
+
+const (
+	// StatusReasonInvalid rejects an object that failed validation.
+	StatusReasonInvalid StatusReason = "Invalid"
+	// StatusReasonUnauthorized rejects a request without valid credentials
+	// that would need them.
+	StatusReasonUnauthorized StatusReason = "Unauthorized"
+	// StatusReasonForbidden rejects a request RBAC or admission control
+	// denied.
+	StatusReasonForbidden StatusReason = "Forbidden"
+	StatusReasonNotFound  StatusReason = "NotFound"
+	// StatusReasonConflict rejects a write made against another version
+	// of the object, e.g. a JSON patch whose test failed.
+	StatusReasonConflict StatusReason = "Conflict"
+	// StatusReasonInternalError is a write that couldn't be admitted, e.g.
+	// because a webhook that must be asked didn't answer.
+	StatusReasonInternalError StatusReason = "InternalError"
+)
+
+// StatusCause is one invalid field. Field is its path in the object, e.g.
+// template.labels[app] or spec.command[0].
+type StatusCause struct {
+	Type    CauseType `json:"type"`
+	Field   string    `json:"field"`
+	Message string    `json:"message"`
+}
+
+type CauseType string
+
+const (
+	CauseTypeFieldValueRequired     CauseType = "FieldValueRequired"
+	CauseTypeFieldValueInvalid      CauseType = "FieldValueInvalid"
+	CauseTypeFieldValueNotSupported CauseType = "FieldValueNotSupported"
+	CauseTypeFieldValueDuplicate    CauseType = "FieldValueDuplicate"
+	// CauseTypeFieldValueImmutable is a field that can't change on update.
+	CauseTypeFieldValueImmutable CauseType = "FieldValueImmutable"
+)
@@ -0,0 +1,23 @@
+package types
+
+type EventType string
+
+const (
+	EventAdded    EventType = "ADDED"
+	EventModified EventType = "MODIFIED"
+	EventDeleted  EventType = "DELETED"
+	// EventBookmark only moves a filtered watch along, Object carries
+	// nothing but the resourceVersion reached
+	EventBookmark EventType = "BOOKMARK"
+)
+
+// WatchEvent is a single change streamed by a watch. For DELETED events
+// Object is the last state of the object, stamped with the version of the
+// deletion.
+type WatchEvent[T any] struct {
+	Type   EventType `json:"type"`
+	Object T         `json:"object"`
+	// Previous is the object a MODIFIED event replaced. Stores set it so a
+	// filtered watch can tell an object stopped matching, it isn't sent.
+	Previous *T `json:"-"`
+}
@@ -1,7 +1,9 @@
 package integration
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http/httptest"
 	"sync"
 	"sync/atomic"
@@ -25,9 +27,10 @@ type mockRuntime struct {
 }
 
 type mockContainer struct {
-	id     string
-	name   string
-	status types.ContainerStatus
+	id        string
+	name      string
+	namespace string
+	status    types.ContainerStatus
 }
 
 func newMockRuntime() *mockRuntime {
@@ -42,14 +45,15 @@ func (r *mockRuntime) Run(spec types.PodSpec) (string, error) {
 
 	id := fmt.Sprintf("ctr-%d", r.nextID.Add(1))
 	r.containers[id] = &mockContainer{
-		id:     id,
-		name:   spec.Name,
-		status: types.ContainerStatusRunning,
+		id:        id,
+		name:      spec.Name,
+		namespace: spec.Namespace,
+		status:    types.ContainerStatusRunning,
 	}
 	return id, nil
 }
 
-func (r *mockRuntime) Stop(containerID string) error {
+func (r *mockRuntime) Stop(containerID string, _ time.Duration) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -86,11 +90,35 @@ func (r *mockRuntime) List() ([]runtime.ContainerInfo, error) {
 
 	var out []runtime.ContainerInfo
 	for _, c := range r.containers {
-		out = append(out, runtime.ContainerInfo{ID: c.id, Name: c.name})
+		out = append(out, runtime.ContainerInfo{ID: c.id, Name: c.name, Namespace: c.namespace})
 	}
 	return out, nil
 }
 
+func (r *mockRuntime) Exec(_ context.Context, containerID string, _ runtime.ExecOptions) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.containers[containerID]; !ok {
+		return -1, fmt.Errorf("container %s not found", containerID)
+	}
+	return 0, nil
+}
+
+func (r *mockRuntime) Logs(_ context.Context, containerID string, _ runtime.LogOptions, _ io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.containers[containerID]; !ok {
+		return fmt.Errorf("container %s: %w", containerID, runtime.ErrNoLogs)
+	}
+	return nil
+}
+
+func (r *mockRuntime) RemoveLogs(string, string) error {
+	return nil
+}
+
 func (r *mockRuntime) crashContainer(containerID string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -123,7 +151,12 @@ func newCluster() *cluster {
 	nodeStore := store.NewMemStore[types.Node]()
 	rt := newMockRuntime()
 
-	srv := &api.Server{PodStore: podStore, RSStore: rsStore, NodeStore: nodeStore}
+	srv := &api.Server{
+		PodStore:       podStore,
+		RSStore:        rsStore,
+		NodeStore:      nodeStore,
+		NamespaceStore: store.NewMemStore[types.Namespace](),
+	}
 	ts := httptest.NewServer(srv.Routes())
 	c := client.New(ts.URL)
 
@@ -160,6 +193,12 @@ func newCluster() *cluster {
 	}
 }
 
+func (c *cluster) close() {
+	// components hold watch requests open, drop them so Close doesn't block
+	c.server.CloseClientConnections()
+	c.server.Close()
+}
+
 // poll a condition until it returns true or the timeout expires.
 func waitFor(t *testing.T, timeout time.Duration, desc string, cond func() bool) {
 	t.Helper()
@@ -175,9 +214,9 @@ func waitFor(t *testing.T, timeout time.Duration, desc string, cond func() bool)
 
 func TestReplicaSetCreatesPods(t *testing.T) {
 	c := newCluster()
-	defer c.server.Close()
+	defer c.close()
 
-	c.rsStore.Put("web", types.ReplicaSet{
+	c.rsStore.Put("default/web", types.ReplicaSet{
 		Name:         "web",
 		DesiredCount: 3,
 		Selector:     map[string]string{"app": "web"},
@@ -210,9 +249,9 @@ func TestReplicaSetCreatesPods(t *testing.T) {
 
 func TestScaleUp(t *testing.T) {
 	c := newCluster()
-	defer c.server.Close()
+	defer c.close()
 
-	c.rsStore.Put("web", types.ReplicaSet{
+	c.rsStore.Put("default/web", types.ReplicaSet{
 		Name:         "web",
 		DesiredCount: 2,
 		Selector:     map[string]string{"app": "web"},
@@ -230,9 +269,9 @@ func TestScaleUp(t *testing.T) {
 	})
 
 	// scale up to 5
-	rs, _ := c.rsStore.Get("web")
+	rs, _ := c.rsStore.Get("default/web")
 	rs.DesiredCount = 5
-	c.rsStore.Put("web", rs)
+	c.rsStore.Put("default/web", rs)
 
 	waitFor(t, 5*time.Second, "5 pods running", func() bool {
 		running := 0
@@ -251,9 +290,9 @@ func TestScaleUp(t *testing.T) {
 
 func TestScaleDown(t *testing.T) {
 	c := newCluster()
-	defer c.server.Close()
+	defer c.close()
 
-	c.rsStore.Put("web", types.ReplicaSet{
+	c.rsStore.Put("default/web", types.ReplicaSet{
 		Name:         "web",
 		DesiredCount: 4,
 		Selector:     map[string]string{"app": "web"},
@@ -271,9 +310,9 @@ func TestScaleDown(t *testing.T) {
 	})
 
 	// scale down to 1
-	rs, _ := c.rsStore.Get("web")
+	rs, _ := c.rsStore.Get("default/web")
 	rs.DesiredCount = 1
-	c.rsStore.Put("web", rs)
+	c.rsStore.Put("default/web", rs)
 
 	waitFor(t, 5*time.Second, "1 pod remaining", func() bool {
 		return len(c.podStore.List()) == 1
@@ -286,9 +325,9 @@ func TestScaleDown(t *testing.T) {
 
 func TestContainerCrashRecovery(t *testing.T) {
 	c := newCluster()
-	defer c.server.Close()
+	defer c.close()
 
-	c.rsStore.Put("web", types.ReplicaSet{
+	c.rsStore.Put("default/web", types.ReplicaSet{
 		Name:         "web",
 		DesiredCount: 1,
 		Selector:     map[string]string{"app": "web"},
@@ -324,16 +363,16 @@ func TestContainerCrashRecovery(t *testing.T) {
 
 func TestDeletePodCleansUpContainer(t *testing.T) {
 	c := newCluster()
-	defer c.server.Close()
+	defer c.close()
 
 	// create a single pod directly (not via RS)
-	c.podStore.Put("solo", types.Pod{
+	c.podStore.Put("default/solo", types.Pod{
 		Spec:   types.PodSpec{Name: "solo", Image: "nginx"},
 		Status: types.PodStatusPending,
 	})
 
 	waitFor(t, 5*time.Second, "pod running", func() bool {
-		pod, ok := c.podStore.Get("solo")
+		pod, ok := c.podStore.Get("default/solo")
 		return ok && pod.Status == types.PodStatusRunning
 	})
 
@@ -342,7 +381,7 @@ func TestDeletePodCleansUpContainer(t *testing.T) {
 	}
 
 	// delete the pod
-	c.podStore.Delete("solo")
+	c.podStore.Delete("default/solo")
 
 	// kubelet should clean up the orphaned container
 	waitFor(t, 5*time.Second, "container cleaned up", func() bool {
@@ -352,15 +391,15 @@ func TestDeletePodCleansUpContainer(t *testing.T) {
 
 func TestMultipleReplicaSets(t *testing.T) {
 	c := newCluster()
-	defer c.server.Close()
+	defer c.close()
 
-	c.rsStore.Put("web", types.ReplicaSet{
+	c.rsStore.Put("default/web", types.ReplicaSet{
 		Name:         "web",
 		DesiredCount: 2,
 		Selector:     map[string]string{"app": "web"},
 		Template:     types.PodSpec{Image: "nginx"},
 	})
-	c.rsStore.Put("api", types.ReplicaSet{
+	c.rsStore.Put("default/api", types.ReplicaSet{
 		Name:         "api",
 		DesiredCount: 3,
 		Selector:     map[string]string{"app": "api"},
@@ -401,9 +440,9 @@ func TestMultipleReplicaSets(t *testing.T) {
 
 func TestDeleteReplicaSetCleansUpPods(t *testing.T) {
 	c := newCluster()
-	defer c.server.Close()
+	defer c.close()
 
-	c.rsStore.Put("web", types.ReplicaSet{
+	c.rsStore.Put("default/web", types.ReplicaSet{
 		Name:         "web",
 		DesiredCount: 3,
 		Selector:     map[string]string{"app": "web"},
@@ -421,9 +460,9 @@ func TestDeleteReplicaSetCleansUpPods(t *testing.T) {
 	})
 
 	// delete the RS and scale to 0
-	rs, _ := c.rsStore.Get("web")
+	rs, _ := c.rsStore.Get("default/web")
 	rs.DesiredCount = 0
-	c.rsStore.Put("web", rs)
+	c.rsStore.Put("default/web", rs)
 
 	waitFor(t, 5*time.Second, "all pods removed", func() bool {
 		return len(c.podStore.List()) == 0